@@ -5,6 +5,9 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/isucon/isucandar/agent"
@@ -19,6 +22,8 @@ type ClientConfig struct {
 	TargetBaseURL         string
 	TargetAddr            string
 	ClientIdleConnTimeout time.Duration
+	// UseBearerAuth trueの場合、Cookieの代わりにAuthorizationヘッダーでアクセストークンを送る
+	UseBearerAuth bool
 }
 
 func NewClient(config ClientConfig, agentOptions ...agent.AgentOption) (*Client, error) {
@@ -44,14 +49,24 @@ func NewClient(config ClientConfig, agentOptions ...agent.AgentOption) (*Client,
 		return nil, err
 	}
 
-	return &Client{
+	c := &Client{
 		agent: ag,
 		requestModifiers: []func(*http.Request){func(req *http.Request) {
 			if req.Method == http.MethodPost && req.Header.Get("Content-Type") == "" {
 				req.Header.Add("Content-Type", "application/json; charset=utf-8")
 			}
 		}},
-	}, nil
+	}
+	if config.UseBearerAuth {
+		jar := &bearerTokenJar{tokens: map[string]string{}}
+		ag.HttpClient.Jar = jar
+		c.AddRequestModifier(func(req *http.Request) {
+			if token, ok := jar.token(req.URL.Path); ok {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		})
+	}
+	return c, nil
 }
 
 func (c *Client) AddRequestModifier(modifier func(*http.Request)) {
@@ -62,6 +77,47 @@ func (c *Client) SetCookie(cookie *http.Cookie) {
 	c.agent.HttpClient.Jar.SetCookies(c.agent.BaseURL, []*http.Cookie{cookie})
 }
 
+// bearerTokenJar レスポンスのCookieからアクセストークンを取り出して保持する。
+// リクエストにはCookieを付与せず、Authorizationヘッダーで送る
+type bearerTokenJar struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+var sessionCookieNames = map[string]string{
+	"/api/app/":   "app_session",
+	"/api/owner/": "owner_session",
+	"/api/chair/": "chair_session",
+}
+
+func (j *bearerTokenJar) SetCookies(_ *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, cookie := range cookies {
+		if cookie.MaxAge < 0 || cookie.Value == "" {
+			delete(j.tokens, cookie.Name)
+			continue
+		}
+		j.tokens[cookie.Name] = cookie.Value
+	}
+}
+
+func (j *bearerTokenJar) Cookies(_ *url.URL) []*http.Cookie {
+	return nil
+}
+
+func (j *bearerTokenJar) token(path string) (string, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	for prefix, name := range sessionCookieNames {
+		if strings.HasPrefix(path, prefix) {
+			token, ok := j.tokens[name]
+			return token, ok
+		}
+	}
+	return "", false
+}
+
 func closeBody(resp *http.Response) {
 	if resp.Body != nil {
 		_, _ = io.Copy(io.Discard, resp.Body)
@@ -0,0 +1,43 @@
+package webapp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yuta-otsubo/isucon-sutra/bench/benchmarker/webapp/api"
+)
+
+func TestClient_UseBearerAuth(t *testing.T) {
+	var gotAuth string
+	var gotCookies []*http.Cookie
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chair/chairs", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "chair_session", Value: "token", Path: "/", HttpOnly: true})
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":"chair","owner_id":"owner"}`))
+	})
+	mux.HandleFunc("POST /api/chair/coordinate", func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotCookies = r.Cookies()
+		_, _ = w.Write([]byte(`{"recorded_at":1}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewClient(ClientConfig{TargetBaseURL: server.URL, UseBearerAuth: true})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = client.ChairPostRegister(ctx, &api.ChairPostChairsReq{Name: "chair", Model: "model", ChairRegisterToken: "register"})
+	require.NoError(t, err)
+	_, err = client.ChairPostCoordinate(ctx, &api.Coordinate{Latitude: 0, Longitude: 0})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Bearer token", gotAuth)
+	assert.Empty(t, gotCookies)
+}
@@ -11,7 +11,7 @@ import {
   AppGetNotificationResponse,
   fetchAppGetNotification,
 } from '~/api/api-components';
-import { getCookieValue } from '~/utils/get-cookie-value';
+import { hasSession } from '~/utils/session';
 import { getUserId } from '~/utils/storage';
 
 function jsonFromSSEResponse<T>(value: string) {
@@ -147,11 +147,20 @@ export const ClientProvider = ({ children }: { children: ReactNode }) => {
   const data = useNotification();
 
   useEffect(() => {
-    const isRegistered =
-      typeof getCookieValue(document.cookie, 'app_session') !== 'undefined';
-    if (!isRegistered) {
-      navigate('/client/register');
-    }
+    const abortController = new AbortController();
+    hasSession('app', abortController.signal)
+      .then((ok) => {
+        if (!ok) {
+          navigate('/client/register');
+        }
+      })
+      .catch((error) => {
+        if (error instanceof DOMException && error.name === 'AbortError') {
+          return;
+        }
+        console.error(error);
+      });
+    return () => abortController.abort();
   }, [navigate]);
 
   return (
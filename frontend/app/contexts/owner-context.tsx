@@ -14,7 +14,7 @@ import {
   fetchOwnerGetSales,
 } from '~/api/api-components';
 import { OwnerChairs, OwnerSales } from '~/types';
-import { getCookieValue } from '~/utils/get-cookie-value';
+import { hasSession } from '~/utils/session';
 
 type DateString = `${number}-${number}-${number}`; // yyyy-mm-dd
 
@@ -92,11 +92,20 @@ export const OwnerProvider = ({ children }: { children: ReactNode }) => {
   }, [navigate, setChairs, setSales, since, until]);
 
   useEffect(() => {
-    const isRegistered =
-      typeof getCookieValue(document.cookie, 'owner_session') !== 'undefined';
-    if (!isRegistered) {
-      navigate('/owner/register');
-    }
+    const abortController = new AbortController();
+    hasSession('owner', abortController.signal)
+      .then((ok) => {
+        if (!ok) {
+          navigate('/owner/register');
+        }
+      })
+      .catch((error) => {
+        if (error instanceof DOMException && error.name === 'AbortError') {
+          return;
+        }
+        console.error(error);
+      });
+    return () => abortController.abort();
   }, [navigate]);
 
   return (
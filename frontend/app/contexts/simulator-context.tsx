@@ -16,6 +16,7 @@ import {
 } from '~/api/api-components';
 import { SimulatorChair } from '~/types';
 import { Message, MessageTypes } from '~/utils/post-message';
+import { login } from '~/utils/session';
 import {
   getSimulatorCurrentCoordinate,
   getSimulatorCurrentRideId,
@@ -173,7 +174,9 @@ const useNotification = (): ChairGetNotificationResponse['data'] => {
 export const SimulatorProvider = ({ children }: { children: ReactNode }) => {
   useEffect(() => {
     if (initialChair?.token) {
-      document.cookie = `chair_session=${initialChair.token}; path=/`;
+      login('chair', initialChair.token).catch((error) => {
+        console.error(error);
+      });
     }
   }, []);
 
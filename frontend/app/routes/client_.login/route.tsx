@@ -5,6 +5,7 @@ import { Button } from '~/components/primitives/button/button';
 import { TextInput } from '~/components/primitives/form/text-input';
 import { FormFrame } from '~/components/primitives/frame/form-frame';
 import { getUsers } from '~/utils/get-initial-data';
+import { login } from '~/utils/session';
 
 export const meta: MetaFunction = () => {
   return [
@@ -18,9 +19,16 @@ export default function ClientLogin() {
   const navigate = useNavigate();
   const presetUsers = getUsers();
 
-  const handleOnClick = () => {
-    document.cookie = `app_session=${sessionToken}; path=/`;
-    navigate('/client');
+  const handleOnClick = async () => {
+    if (!sessionToken) {
+      return;
+    }
+    try {
+      await login('app', sessionToken);
+      navigate('/client');
+    } catch (error) {
+      console.error(error);
+    }
   };
 
   return (
@@ -5,6 +5,7 @@ import { Button } from '~/components/primitives/button/button';
 import { TextInput } from '~/components/primitives/form/text-input';
 import { FormFrame } from '~/components/primitives/frame/form-frame';
 import { getOwners } from '~/utils/get-initial-data';
+import { login } from '~/utils/session';
 
 export const meta: MetaFunction = () => {
   return [
@@ -18,9 +19,16 @@ export default function OwnerLogin() {
   const navigate = useNavigate();
   const presetOwners = getOwners();
 
-  const handleOnClick = () => {
-    document.cookie = `owner_session=${sessionToken}; path=/`;
-    navigate('/owner');
+  const handleOnClick = async () => {
+    if (!sessionToken) {
+      return;
+    }
+    try {
+      await login('owner', sessionToken);
+      navigate('/owner');
+    } catch (error) {
+      console.error(error);
+    }
   };
 
   return (
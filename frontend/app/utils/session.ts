@@ -0,0 +1,31 @@
+import { apiBaseURL } from '~/api/api-base-url';
+
+type SessionRole = 'app' | 'owner' | 'chair';
+
+// セッションCookieはHttpOnlyのため、ブラウザからは読み書きできない。
+// ログインはサーバーにCookieを設定させ、ログイン済みかどうかは認証が必要なエンドポイントで確かめる
+const sessionCheckPaths = {
+  app: '/app/me',
+  owner: '/owner/chairs',
+} as const;
+
+export const login = async (role: SessionRole, token: string) => {
+  const response = await fetch(`${apiBaseURL}/${role}/login`, {
+    method: 'POST',
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!response.ok) {
+    throw new Error(`failed to login: ${response.status}`);
+  }
+};
+
+// 認証に失敗した場合のみfalseを返す。サーバーのエラーではログイン画面に移動させない
+export const hasSession = async (
+  role: keyof typeof sessionCheckPaths,
+  signal?: AbortSignal,
+): Promise<boolean> => {
+  const response = await fetch(`${apiBaseURL}${sessionCheckPaths[role]}`, {
+    signal,
+  });
+  return response.status !== 401;
+};
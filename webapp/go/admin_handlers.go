@@ -0,0 +1,568 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/ratelimit"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+// 運営スタッフ向けの管理API。管理用トークンで認証し、操作はすべて監査ログに残る
+
+const (
+	adminDefaultLimit = 50
+	adminMaxLimit     = 100
+)
+
+var rideStatuses = []string{"MATCHING", "ENROUTE", "PICKUP", "CARRYING", "ARRIVED", "COMPLETED", "CANCELED"}
+
+// 管理APIで変更できる設定と、その値の検証
+var adminSettingValidators = map[string]func(value string) error{
+	"payment_gateway_url":              validateURLSetting,
+	"chair_break_after_rides":          validateNonNegativeIntSetting,
+	"chair_break_after_distance":       validateNonNegativeIntSetting,
+	"chair_break_minutes":              validateNonNegativeIntSetting,
+	"ride_preference_timeout_seconds":  validateNonNegativeIntSetting,
+	"chair_location_retention_seconds": validateNonNegativeIntSetting,
+	"detour_flag_threshold_percent":    validateNonNegativeIntSetting,
+	"rate_limits":                      validateRateLimitsSetting,
+}
+
+func validateURLSetting(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", value)
+	}
+	return nil
+}
+
+func validateNonNegativeIntSetting(value string) error {
+	if i, err := strconv.Atoi(value); err != nil || i < 0 {
+		return fmt.Errorf("%q is not a non-negative integer", value)
+	}
+	return nil
+}
+
+func validateRateLimitsSetting(value string) error {
+	limits, err := parseRateLimits(value)
+	if err != nil {
+		return err
+	}
+	// 反映は保存した後に行うので、ここでは別のLimiterで検証だけする
+	return ratelimit.New().SetLimits(limits)
+}
+
+// 件数の指定が無い場合は adminDefaultLimit 件、最大で adminMaxLimit 件を返す
+func parseAdminLimit(r *http.Request) (int, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return adminDefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 || limit > adminMaxLimit {
+		return 0, fmt.Errorf("limit must be an integer between 1 and %d", adminMaxLimit)
+	}
+	return limit, nil
+}
+
+type adminUser struct {
+	ID             string `json:"id"`
+	Username       string `json:"username"`
+	Firstname      string `json:"firstname"`
+	Lastname       string `json:"lastname"`
+	DateOfBirth    string `json:"date_of_birth"`
+	InvitationCode string `json:"invitation_code"`
+	CreatedAt      int64  `json:"created_at"`
+}
+
+type adminGetUsersResponse struct {
+	Users []adminUser `json:"users"`
+}
+
+func adminGetUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limit, err := parseAdminLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	users, err := readStore().Users().Search(ctx, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := adminGetUsersResponse{Users: []adminUser{}}
+	for _, user := range users {
+		res.Users = append(res.Users, adminUser{
+			ID:             user.ID,
+			Username:       user.Username,
+			Firstname:      user.Firstname,
+			Lastname:       user.Lastname,
+			DateOfBirth:    user.DateOfBirth,
+			InvitationCode: user.InvitationCode,
+			CreatedAt:      user.CreatedAt.UnixMilli(),
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type adminOwner struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type adminGetOwnersResponse struct {
+	Owners []adminOwner `json:"owners"`
+}
+
+func adminGetOwners(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limit, err := parseAdminLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	owners, err := readStore().Owners().Search(ctx, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := adminGetOwnersResponse{Owners: []adminOwner{}}
+	for _, owner := range owners {
+		res.Owners = append(res.Owners, adminOwner{
+			ID:        owner.ID,
+			Name:      owner.Name,
+			CreatedAt: owner.CreatedAt.UnixMilli(),
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type adminChair struct {
+	ID        string `json:"id"`
+	OwnerID   string `json:"owner_id"`
+	Name      string `json:"name"`
+	Model     string `json:"model"`
+	Active    bool   `json:"active"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type adminGetChairsResponse struct {
+	Chairs []adminChair `json:"chairs"`
+}
+
+func adminGetChairs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limit, err := parseAdminLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	chairs, err := readStore().Chairs().Search(ctx, r.URL.Query().Get("q"), r.URL.Query().Get("owner_id"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := adminGetChairsResponse{Chairs: []adminChair{}}
+	for _, chair := range chairs {
+		res.Chairs = append(res.Chairs, adminChair{
+			ID:        chair.ID,
+			OwnerID:   chair.OwnerID,
+			Name:      chair.Name,
+			Model:     chair.Model,
+			Active:    chair.IsActive,
+			CreatedAt: chair.CreatedAt.UnixMilli(),
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type adminRide struct {
+	ID                    string     `json:"id"`
+	UserID                string     `json:"user_id"`
+	ChairID               string     `json:"chair_id,omitempty"`
+	PickupCoordinate      Coordinate `json:"pickup_coordinate"`
+	DestinationCoordinate Coordinate `json:"destination_coordinate"`
+	Status                string     `json:"status"`
+	Evaluation            *int       `json:"evaluation,omitempty"`
+	RequestedAt           int64      `json:"requested_at"`
+	UpdatedAt             int64      `json:"updated_at"`
+}
+
+func newAdminRide(ride *Ride, status string) adminRide {
+	return adminRide{
+		ID:                    ride.ID,
+		UserID:                ride.UserID,
+		ChairID:               ride.ChairID.String,
+		PickupCoordinate:      Coordinate{Latitude: ride.PickupLatitude, Longitude: ride.PickupLongitude},
+		DestinationCoordinate: Coordinate{Latitude: ride.DestinationLatitude, Longitude: ride.DestinationLongitude},
+		Status:                status,
+		Evaluation:            ride.Evaluation,
+		RequestedAt:           ride.CreatedAt.UnixMilli(),
+		UpdatedAt:             ride.UpdatedAt.UnixMilli(),
+	}
+}
+
+type adminGetRidesResponse struct {
+	Rides []adminRide `json:"rides"`
+}
+
+func adminGetRides(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limit, err := parseAdminLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter := store.RideFilter{
+		UserID:  r.URL.Query().Get("user_id"),
+		ChairID: r.URL.Query().Get("chair_id"),
+		Status:  r.URL.Query().Get("status"),
+	}
+	if filter.Status != "" && !slices.Contains(rideStatuses, filter.Status) {
+		writeError(w, http.StatusBadRequest, errInvalidRideStatus)
+		return
+	}
+
+	tx, err := readStore().BeginRead(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	rides, err := tx.Rides().Search(ctx, filter, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := adminGetRidesResponse{Rides: []adminRide{}}
+	for _, ride := range rides {
+		status, err := tx.Rides().GetLatestStatus(ctx, ride.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		res.Rides = append(res.Rides, newAdminRide(&ride, status))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type adminRideStatus struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	CreatedAt   int64  `json:"created_at"`
+	AppSentAt   *int64 `json:"app_sent_at,omitempty"`
+	ChairSentAt *int64 `json:"chair_sent_at,omitempty"`
+}
+
+type adminRideDetail struct {
+	adminRide
+	Timeline []adminRideStatus `json:"timeline"`
+}
+
+// getAdminRideDetail はライドと状態の変更履歴を返す
+func getAdminRideDetail(r *http.Request, q store.Queries, rideID string) (*adminRideDetail, error) {
+	ctx := r.Context()
+	ride, err := q.Rides().Get(ctx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := q.Rides().ListStatuses(ctx, rideID)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &adminRideDetail{Timeline: []adminRideStatus{}}
+	status := ""
+	for _, s := range statuses {
+		item := adminRideStatus{ID: s.ID, Status: s.Status, CreatedAt: s.CreatedAt.UnixMilli()}
+		if s.AppSentAt != nil {
+			item.AppSentAt = ptr(s.AppSentAt.UnixMilli())
+		}
+		if s.ChairSentAt != nil {
+			item.ChairSentAt = ptr(s.ChairSentAt.UnixMilli())
+		}
+		detail.Timeline = append(detail.Timeline, item)
+		status = s.Status
+	}
+	detail.adminRide = newAdminRide(ride, status)
+	return detail, nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func adminGetRide(w http.ResponseWriter, r *http.Request) {
+	detail, err := getAdminRideDetail(r, readStore(), r.PathValue("ride_id"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+type adminPostRideActionRequest struct {
+	// 監査ログに残す理由
+	Reason string `json:"reason"`
+}
+
+// 椅子が止まってしまったライドなどを運営が完了させる。決済は行わない
+func adminPostRideComplete(w http.ResponseWriter, r *http.Request) {
+	adminFinishRide(w, r, "COMPLETED")
+}
+
+// 運営がライドをキャンセルする。ユーザーと椅子にはキャンセルを通知する
+func adminPostRideCancel(w http.ResponseWriter, r *http.Request) {
+	adminFinishRide(w, r, "CANCELED")
+}
+
+func adminFinishRide(w http.ResponseWriter, r *http.Request, status string) {
+	ctx := r.Context()
+	rideID := r.PathValue("ride_id")
+
+	// 理由は省略できる
+	req := &adminPostRideActionRequest{}
+	if err := bindJSON(r, req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	ride, err := tx.Rides().GetForUpdate(ctx, rideID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	current, err := tx.Rides().GetLatestStatus(ctx, rideID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if isRideFinished(current) {
+		writeError(w, http.StatusBadRequest, errRideFinished)
+		return
+	}
+
+	if err := tx.Rides().CreateStatus(ctx, &RideStatus{ID: ulid.Make().String(), RideID: rideID, Status: status}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// 椅子が割り当てられていないライドは、通知するオーナーがいない
+	if status == "COMPLETED" && ride.ChairID.Valid {
+		if err := publishChairWebhookEvent(ctx, tx, ride.ChairID.String, webhookEventRideCompleted, &webhookRideCompletedData{
+			RideID:  ride.ID,
+			ChairID: ride.ChairID.String,
+		}); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	detail, err := getAdminRideDetail(r, tx, rideID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	recordRideStatus(ctx, rideID, status)
+	audit(ctx, auditAdminRideFinished, slog.String("ride_id", rideID), slog.String("from", current), slog.String("to", status), slog.String("reason", req.Reason))
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+type adminPostRideMatchRequest struct {
+	ChairID string `json:"chair_id"`
+	Reason  string `json:"reason"`
+}
+
+// 運営がマッチング待ちのライドに椅子を割り当てる。椅子が他のライドを担当している場合は割り当てない
+func adminPostRideMatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rideID := r.PathValue("ride_id")
+
+	req := &adminPostRideMatchRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.ChairID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("required field(chair_id) is empty"))
+		return
+	}
+
+	ride, err := dataStore.Rides().Get(ctx, rideID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	status, err := dataStore.Rides().GetLatestStatus(ctx, rideID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if isRideFinished(status) {
+		writeError(w, http.StatusBadRequest, errRideFinished)
+		return
+	}
+	if ride.ChairID.Valid {
+		writeError(w, http.StatusConflict, errRideAlreadyMatched)
+		return
+	}
+
+	chair, err := dataStore.Chairs().Get(ctx, req.ChairID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errChairNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !chair.IsActive {
+		writeError(w, http.StatusConflict, errChairBusy)
+		return
+	}
+
+	settled, err := assignChair(ctx, ride, chair.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !settled {
+		writeError(w, http.StatusConflict, errChairBusy)
+		return
+	}
+
+	detail, err := getAdminRideDetail(r, dataStore, rideID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// 確認の後に他のマッチングで割り当てられた場合
+	if detail.ChairID != chair.ID {
+		writeError(w, http.StatusConflict, errRideAlreadyMatched)
+		return
+	}
+	audit(ctx, auditAdminRideMatched, slog.String("ride_id", rideID), slog.String("chair_id", chair.ID), slog.String("reason", req.Reason))
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+type adminSetting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type adminGetSettingsResponse struct {
+	Settings []adminSetting `json:"settings"`
+}
+
+func adminGetSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := dataStore.Settings().List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := adminGetSettingsResponse{Settings: []adminSetting{}}
+	for _, setting := range settings {
+		res.Settings = append(res.Settings, adminSetting{Name: setting.Name, Value: setting.Value})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type adminPutSettingRequest struct {
+	Value string `json:"value"`
+}
+
+func adminPutSetting(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := r.PathValue("name")
+
+	validate, ok := adminSettingValidators[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, errSettingNotFound)
+		return
+	}
+	req := &adminPutSettingRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validate(req.Value); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	previous, err := tx.Settings().Get(ctx, name)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Settings().Set(ctx, name, req.Value); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 起動時に読み込む設定は、このインスタンスにはすぐに反映する。他のインスタンスには再起動で反映される
+	if name == "rate_limits" {
+		limits, _ := parseRateLimits(req.Value)
+		if err := rateLimiter.SetLimits(limits); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	audit(ctx, auditSettingUpdated, slog.String("name", name), slog.String("from", previous), slog.String("to", req.Value))
+
+	writeJSON(w, http.StatusOK, adminSetting{Name: name, Value: req.Value})
+}
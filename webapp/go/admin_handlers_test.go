@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveAsAdmin(t *testing.T, handler http.HandlerFunc, method, target string, pathValues map[string]string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveAs(t, handler, nil, method, target, pathValues, body)
+}
+
+func TestAdminGetUsers(t *testing.T) {
+	m := newTestStore(t)
+	for _, id := range []string{"alice1", "alice2", "bob"} {
+		addTestUser(t, m, id)
+	}
+
+	rec := serveAsAdmin(t, adminGetUsers, http.MethodGet, "/api/admin/users?q=alice&limit=1", nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	res := decodeBody[adminGetUsersResponse](t, rec)
+	if len(res.Users) != 1 || res.Users[0].ID != "alice2" {
+		t.Errorf("users = %+v, want newest alice only", res.Users)
+	}
+
+	for _, limit := range []string{"0", "101", "x"} {
+		rec := serveAsAdmin(t, adminGetUsers, http.MethodGet, "/api/admin/users?limit="+limit, nil, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s: status = %d, want %d", limit, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAdminPostRideCancel(t *testing.T) {
+	m := newTestStore(t)
+	captureLogs(t)
+	user := addTestUser(t, m, "user1")
+	ride := postTestRide(t, user)
+	target := "/api/admin/rides/" + ride.RideID + "/cancel"
+	pathValues := map[string]string{"ride_id": ride.RideID}
+
+	rec := serveAsAdmin(t, adminPostRideCancel, http.MethodPost, target, pathValues, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	detail := decodeBody[adminRideDetail](t, rec)
+	if detail.Status != "CANCELED" {
+		t.Errorf("status = %s, want CANCELED", detail.Status)
+	}
+	if len(detail.Timeline) != 2 || detail.Timeline[0].Status != "MATCHING" || detail.Timeline[1].Status != "CANCELED" {
+		t.Errorf("timeline = %+v, want MATCHING then CANCELED", detail.Timeline)
+	}
+
+	// キャンセル済みのライドは操作できない
+	rec = serveAsAdmin(t, adminPostRideComplete, http.MethodPost, target, pathValues, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("second action: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// キャンセルされたライドは進行中とみなさず、次のライドを要求できる
+	postTestRide(t, user)
+
+	rec = serveAsAdmin(t, adminPostRideCancel, http.MethodPost, "/api/admin/rides/unknown/cancel", map[string]string{"ride_id": "unknown"}, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown ride: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminPutSetting(t *testing.T) {
+	m := newTestStore(t)
+	_, audits := captureLogs(t)
+	if err := m.Settings().Set(context.Background(), "payment_gateway_url", "http://localhost:12345"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		setting    string
+		value      string
+		wantStatus int
+	}{
+		{name: "URLを変更できる", setting: "payment_gateway_url", value: "https://payment.example.com", wantStatus: http.StatusOK},
+		{name: "URLでない値は変更できない", setting: "payment_gateway_url", value: "localhost", wantStatus: http.StatusBadRequest},
+		{name: "負の値は変更できない", setting: "chair_break_minutes", value: "-1", wantStatus: http.StatusBadRequest},
+		{name: "位置情報の保持期間を変更できる", setting: "chair_location_retention_seconds", value: "86400", wantStatus: http.StatusOK},
+		{name: "遠回りと判定する割合を変更できる", setting: "detour_flag_threshold_percent", value: "30", wantStatus: http.StatusOK},
+		{name: "数値でない割合は変更できない", setting: "detour_flag_threshold_percent", value: "30%", wantStatus: http.StatusBadRequest},
+		{name: "不正なrate_limitsは変更できない", setting: "rate_limits", value: `{"GET /api/app/rides": {"rate": 0, "burst": 1}}`, wantStatus: http.StatusBadRequest},
+		{name: "管理APIで扱わない設定は変更できない", setting: "unknown", value: "1", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAsAdmin(t, adminPutSetting, http.MethodPut, "/api/admin/settings/"+tt.setting,
+				map[string]string{"name": tt.setting}, &adminPutSettingRequest{Value: tt.value})
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	value, err := m.Settings().Get(context.Background(), "payment_gateway_url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "https://payment.example.com" {
+		t.Errorf("payment_gateway_url = %q, want updated value", value)
+	}
+	// 変更に成功した設定ごとに監査ログを記録する
+	records := audits()
+	if len(records) != 3 || records[0]["msg"] != auditSettingUpdated || records[0]["from"] != "http://localhost:12345" {
+		t.Errorf("audit records = %v, want three setting.updated", records)
+	}
+}
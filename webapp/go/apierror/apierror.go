@@ -0,0 +1,182 @@
+// Package apierror はAPIのエラーレスポンスを表す型と、エラーコードを定義する。
+// エラーコードはクライアントが判別に使うため、一度定めたものは変更しない。
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+type Code string
+
+// 特定の原因を表すコードが無い場合に、HTTPステータスコードに応じて使うコード
+const (
+	CodeBadRequest       Code = "BAD_REQUEST"
+	CodeValidationFailed Code = "VALIDATION_FAILED"
+	CodeUnauthorized     Code = "UNAUTHORIZED"
+	CodeForbidden        Code = "FORBIDDEN"
+	CodeNotFound         Code = "NOT_FOUND"
+	CodeConflict         Code = "CONFLICT"
+	CodeTooManyRequests  Code = "TOO_MANY_REQUESTS"
+	CodeInternal         Code = "INTERNAL_ERROR"
+	CodeUpstream         Code = "UPSTREAM_ERROR"
+	CodeUnavailable      Code = "SERVICE_UNAVAILABLE"
+)
+
+const (
+	CodeInvalidAccessToken        Code = "INVALID_ACCESS_TOKEN"
+	CodeInvalidInvitationCode     Code = "INVALID_INVITATION_CODE"
+	CodeInvalidChairRegisterToken Code = "INVALID_CHAIR_REGISTER_TOKEN"
+	CodeRideAlreadyExists         Code = "RIDE_ALREADY_EXISTS"
+	CodeRideNotFound              Code = "RIDE_NOT_FOUND"
+	CodeRideNotArrived            Code = "RIDE_NOT_ARRIVED"
+	CodeRideNotAssigned           Code = "RIDE_NOT_ASSIGNED"
+	CodeInvalidRideStatus         Code = "INVALID_RIDE_STATUS"
+	CodeChairNotFound             Code = "CHAIR_NOT_FOUND"
+	CodeOutsideWorkingHours       Code = "OUTSIDE_WORKING_HOURS"
+	CodeChairOnBreak              Code = "CHAIR_ON_BREAK"
+	CodeOutOfServiceArea          Code = "OUT_OF_SERVICE_AREA"
+	CodePaymentTokenNotRegistered Code = "PAYMENT_TOKEN_NOT_REGISTERED"
+	CodeAPIKeyNotFound            Code = "API_KEY_NOT_FOUND"
+	CodeAPIKeyScopeMissing        Code = "API_KEY_SCOPE_MISSING"
+	CodeRideFinished              Code = "RIDE_FINISHED"
+	CodeRideAlreadyMatched        Code = "RIDE_ALREADY_MATCHED"
+	CodeChairBusy                 Code = "CHAIR_BUSY"
+	CodeSettingNotFound           Code = "SETTING_NOT_FOUND"
+	CodeDatasetNotFound           Code = "DATASET_NOT_FOUND"
+	CodeUsernameTaken             Code = "USERNAME_TAKEN"
+	CodeRideInProgress            Code = "RIDE_IN_PROGRESS"
+	CodePlaceNotFound             Code = "PLACE_NOT_FOUND"
+	CodePlaceAlreadyExists        Code = "PLACE_ALREADY_EXISTS"
+	CodeWebhookNotFound           Code = "WEBHOOK_NOT_FOUND"
+)
+
+// RequestIDHeader はリクエストIDを受け渡すヘッダー。
+// リクエストIDはミドルウェアでレスポンスヘッダーに設定され、エラーレスポンスにも含める
+const RequestIDHeader = "X-Request-Id"
+
+// FieldError はリクエストのうち不正だった箇所を表す
+type FieldError struct {
+	// body, path, query, header, cookie, response のいずれか
+	In string `json:"in"`
+	// 不正だったフィールドのパス (例: pickup_coordinate.latitude)
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Fields  []FieldError
+	// ログにだけ出力し、クライアントには返さない原因
+	Err error
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is はコードが同じエラーを同じエラーとみなす
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap は原因となったエラーを付与したコピーを返す
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+type response struct {
+	Code      Code         `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// CodeForStatus は特定のコードが無いエラーに使うコードを返す
+func CodeForStatus(status int) Code {
+	switch {
+	case status == http.StatusUnauthorized:
+		return CodeUnauthorized
+	case status == http.StatusForbidden:
+		return CodeForbidden
+	case status == http.StatusNotFound:
+		return CodeNotFound
+	case status == http.StatusConflict:
+		return CodeConflict
+	case status == http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case status == http.StatusBadGateway:
+		return CodeUpstream
+	case status == http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case status >= 500:
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// From はerrをErrorに変換する。
+// Errorでないエラーの場合、5xxではメッセージを伏せ、4xxではエラーのメッセージをそのまま使う
+func From(status int, err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		if apiErr != err {
+			apiErr = apiErr.Wrap(err)
+		}
+		if apiErr.Status == 0 {
+			withStatus := *apiErr
+			withStatus.Status = status
+			apiErr = &withStatus
+		}
+		return apiErr
+	}
+	if status >= 500 {
+		return &Error{Status: status, Code: CodeForStatus(status), Message: http.StatusText(status), Err: err}
+	}
+	return &Error{Status: status, Code: CodeForStatus(status), Message: err.Error()}
+}
+
+// Write はエラーレスポンスを書き込む。errがErrorの場合はErrorのステータスコードを優先する
+func Write(w http.ResponseWriter, status int, err error) {
+	apiErr := From(status, err)
+	requestID := w.Header().Get(RequestIDHeader)
+
+	if apiErr.Status >= 500 {
+		slog.Error("error response wrote", "request_id", requestID, "status", apiErr.Status, "code", apiErr.Code, "error", err)
+	} else {
+		slog.Info("error response wrote", "request_id", requestID, "status", apiErr.Status, "code", apiErr.Code, "error", err)
+	}
+
+	buf, marshalError := json.Marshal(response{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		RequestID: requestID,
+		Errors:    apiErr.Fields,
+	})
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	if marshalError != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"code":"INTERNAL_ERROR","message":"marshaling error failed"}`))
+		return
+	}
+	w.WriteHeader(apiErr.Status)
+	w.Write(buf)
+}
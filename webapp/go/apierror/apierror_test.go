@@ -0,0 +1,104 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var errRideAlreadyExists = New(http.StatusConflict, CodeRideAlreadyExists, "ride already exists")
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		err         error
+		wantStatus  int
+		wantCode    Code
+		wantMessage string
+	}{
+		{
+			name:        "coded error",
+			status:      http.StatusConflict,
+			err:         errRideAlreadyExists,
+			wantStatus:  http.StatusConflict,
+			wantCode:    CodeRideAlreadyExists,
+			wantMessage: "ride already exists",
+		},
+		{
+			name:        "wrapped coded error keeps its status and message",
+			status:      http.StatusInternalServerError,
+			err:         fmt.Errorf("failed to create ride: %w", errRideAlreadyExists),
+			wantStatus:  http.StatusConflict,
+			wantCode:    CodeRideAlreadyExists,
+			wantMessage: "ride already exists",
+		},
+		{
+			name:        "client error exposes its message",
+			status:      http.StatusBadRequest,
+			err:         errors.New("latitude is invalid"),
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    CodeBadRequest,
+			wantMessage: "latitude is invalid",
+		},
+		{
+			name:        "server error hides its details",
+			status:      http.StatusInternalServerError,
+			err:         errors.New("Error 1146 (42S02): Table 'isuride.rides' doesn't exist"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    CodeInternal,
+			wantMessage: "Internal Server Error",
+		},
+		{
+			name:        "upstream error",
+			status:      http.StatusBadGateway,
+			err:         errors.New("errored upstream"),
+			wantStatus:  http.StatusBadGateway,
+			wantCode:    CodeUpstream,
+			wantMessage: "Bad Gateway",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rec.Header().Set(RequestIDHeader, "request-1")
+			Write(rec, tt.status, tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			res := response{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", res.Code, tt.wantCode)
+			}
+			if res.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", res.Message, tt.wantMessage)
+			}
+			if res.RequestID != "request-1" {
+				t.Errorf("request_id = %q, want %q", res.RequestID, "request-1")
+			}
+			if strings.Contains(rec.Body.String(), "42S02") {
+				t.Errorf("internal details leaked: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestError_Is(t *testing.T) {
+	if !errors.Is(fmt.Errorf("wrapped: %w", errRideAlreadyExists), errRideAlreadyExists) {
+		t.Error("wrapped error should match by code")
+	}
+	if !errors.Is(errRideAlreadyExists.Wrap(errors.New("duplicate")), errRideAlreadyExists) {
+		t.Error("error with a cause should match by code")
+	}
+	if errors.Is(New(http.StatusNotFound, CodeRideNotFound, "ride not found"), errRideAlreadyExists) {
+		t.Error("errors with different codes should not match")
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+// usersテーブルの名前や生年月日の最大文字数
+const maxUserFieldLength = 30
+
+type appUserProfile struct {
+	ID             string `json:"id"`
+	Username       string `json:"username"`
+	Firstname      string `json:"firstname"`
+	Lastname       string `json:"lastname"`
+	DateOfBirth    string `json:"date_of_birth"`
+	InvitationCode string `json:"invitation_code"`
+	CreatedAt      int64  `json:"created_at"`
+}
+
+func newAppUserProfile(user *User) *appUserProfile {
+	return &appUserProfile{
+		ID:             user.ID,
+		Username:       user.Username,
+		Firstname:      user.Firstname,
+		Lastname:       user.Lastname,
+		DateOfBirth:    user.DateOfBirth,
+		InvitationCode: user.InvitationCode,
+		CreatedAt:      user.CreatedAt.UnixMilli(),
+	}
+}
+
+func appGetMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	// 認証で使うユーザーはキャッシュされていることがあるので、最新の情報を読み直す
+	current, err := dataStore.Users().Get(ctx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAppUserProfile(current))
+}
+
+type appPatchMeRequest struct {
+	Username    *string `json:"username"`
+	FirstName   *string `json:"firstname"`
+	LastName    *string `json:"lastname"`
+	DateOfBirth *string `json:"date_of_birth"`
+}
+
+func appPatchMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := &appPatchMeRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	current, err := tx.Users().Get(ctx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 指定されたフィールドだけを更新する
+	updated := []string{}
+	for _, field := range []struct {
+		name  string
+		value *string
+		dest  *string
+	}{
+		{"username", req.Username, &current.Username},
+		{"firstname", req.FirstName, &current.Firstname},
+		{"lastname", req.LastName, &current.Lastname},
+		{"date_of_birth", req.DateOfBirth, &current.DateOfBirth},
+	} {
+		if field.value == nil {
+			continue
+		}
+		if *field.value == "" || utf8.RuneCountInString(*field.value) > maxUserFieldLength {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s must be 1 to %d characters", field.name, maxUserFieldLength))
+			return
+		}
+		*field.dest = *field.value
+		updated = append(updated, field.name)
+	}
+
+	if err := tx.Users().Update(ctx, current); err != nil {
+		if errors.Is(err, store.ErrDuplicate) {
+			writeError(w, http.StatusConflict, errUsernameTaken)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sessions, err := tx.Sessions().ListActive(ctx, string(auth.RoleApp), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// キャッシュされた古いユーザー情報を使わないようにする
+	forgetUserSessions(current, sessions)
+	audit(ctx, auditUserUpdated, slog.Any("fields", updated))
+
+	writeJSON(w, http.StatusOK, newAppUserProfile(current))
+}
+
+// 退会したユーザーのユーザー名。一意にするため、ランダムな文字列を付ける
+func deletedUsername() string {
+	return "deleted_" + secureRandomStr(11)
+}
+
+// 退会するとユーザーの個人情報を消し、ログインできないようにする。
+// ライドはオーナーの売上や椅子の評価に使うので残し、ユーザーIDもライドとの関連を保つため変えない
+func appDeleteMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// 進行中のライドがある間は、椅子や決済が途中で止まらないよう退会させない
+	rides, err := tx.Rides().ListByUser(ctx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, ride := range rides {
+		status, err := tx.Rides().GetLatestStatus(ctx, ride.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !isRideFinished(status) {
+			writeError(w, http.StatusConflict, errRideInProgress)
+			return
+		}
+	}
+
+	current, err := tx.Users().Get(ctx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// アクセストークンと招待コードは誰にも知らせない値に変え、ログインや招待に使えないようにする
+	if err := tx.Users().Update(ctx, &User{
+		ID:             current.ID,
+		Username:       deletedUsername(),
+		AccessToken:    secureRandomStr(32),
+		InvitationCode: secureRandomStr(15),
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.PaymentTokens().Delete(ctx, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Coupons().DeleteUnused(ctx, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.SavedPlaces().DeleteByUser(ctx, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sessions, err := tx.Sessions().ListActive(ctx, string(auth.RoleApp), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, session := range sessions {
+		if err := tx.Sessions().Revoke(ctx, session.Token); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	forgetUserSessions(current, sessions)
+	audit(ctx, auditUserDeleted, slog.String("user_id", user.ID))
+
+	clearSessionCookie(w, r, auth.RoleApp)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forgetUserSessions はユーザーのアクセストークンをすべて認証のキャッシュから取り除く
+func forgetUserSessions(user *User, sessions []Session) {
+	authenticator.Forget(user.AccessToken)
+	for _, session := range sessions {
+		authenticator.Forget(session.Token)
+	}
+}
+
+type appGetMeExportResponse struct {
+	User     *appUserProfile    `json:"user"`
+	Rides    []appExportRide    `json:"rides"`
+	Payments []appExportPayment `json:"payments"`
+	// 決済トークンを登録した日時。トークン自体は含めない
+	PaymentTokenRegisteredAt *int64            `json:"payment_token_registered_at,omitempty"`
+	Coupons                  []appExportCoupon `json:"coupons"`
+	SavedPlaces              []appPlace        `json:"saved_places"`
+	ExportedAt               int64             `json:"exported_at"`
+}
+
+type appExportRide struct {
+	ID                    string                `json:"id"`
+	ChairID               string                `json:"chair_id,omitempty"`
+	PickupCoordinate      Coordinate            `json:"pickup_coordinate"`
+	DestinationCoordinate Coordinate            `json:"destination_coordinate"`
+	Status                string                `json:"status"`
+	Fare                  int                   `json:"fare"`
+	Evaluation            *int                  `json:"evaluation,omitempty"`
+	Statuses              []appExportRideStatus `json:"statuses"`
+	RequestedAt           int64                 `json:"requested_at"`
+	UpdatedAt             int64                 `json:"updated_at"`
+}
+
+type appExportRideStatus struct {
+	Status    string `json:"status"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type appExportPayment struct {
+	RideID string `json:"ride_id"`
+	Amount int    `json:"amount"`
+	PaidAt int64  `json:"paid_at"`
+}
+
+type appExportCoupon struct {
+	Code      string  `json:"code"`
+	Discount  int     `json:"discount"`
+	CreatedAt int64   `json:"created_at"`
+	UsedBy    *string `json:"used_by,omitempty"`
+}
+
+// ユーザーが自分のデータをまとめて取得する。ライドは要求日時の古い順に返す
+func appGetMeExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := readStore().BeginRead(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := exportUserData(ctx, tx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="isuride-export.json"`)
+	writeJSON(w, http.StatusOK, res)
+}
+
+func exportUserData(ctx context.Context, q store.Queries, userID string) (*appGetMeExportResponse, error) {
+	user, err := q.Users().Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := &appGetMeExportResponse{
+		User:        newAppUserProfile(user),
+		Rides:       []appExportRide{},
+		Payments:    []appExportPayment{},
+		Coupons:     []appExportCoupon{},
+		SavedPlaces: []appPlace{},
+		ExportedAt:  time.Now().UnixMilli(),
+	}
+
+	rides, err := q.Rides().ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ride := range rides {
+		statuses, err := q.Rides().ListStatuses(ctx, ride.ID)
+		if err != nil {
+			return nil, err
+		}
+		fare, err := calculateDiscountedFare(ctx, q, userID, &ride, 0, 0, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		item := appExportRide{
+			ID:                    ride.ID,
+			ChairID:               ride.ChairID.String,
+			PickupCoordinate:      Coordinate{Latitude: ride.PickupLatitude, Longitude: ride.PickupLongitude},
+			DestinationCoordinate: Coordinate{Latitude: ride.DestinationLatitude, Longitude: ride.DestinationLongitude},
+			Fare:                  fare,
+			Evaluation:            ride.Evaluation,
+			Statuses:              []appExportRideStatus{},
+			RequestedAt:           ride.CreatedAt.UnixMilli(),
+			UpdatedAt:             ride.UpdatedAt.UnixMilli(),
+		}
+		for _, status := range statuses {
+			item.Statuses = append(item.Statuses, appExportRideStatus{Status: status.Status, CreatedAt: status.CreatedAt.UnixMilli()})
+			item.Status = status.Status
+			// 評価と同時に決済し、決済に成功した時点でライドを完了にしている。
+			// 評価の無い完了したライドは運営が完了させたもので、決済していない
+			if status.Status == "COMPLETED" && ride.Evaluation != nil {
+				res.Payments = append(res.Payments, appExportPayment{RideID: ride.ID, Amount: fare, PaidAt: status.CreatedAt.UnixMilli()})
+			}
+		}
+		res.Rides = append(res.Rides, item)
+	}
+
+	token, err := q.PaymentTokens().Get(ctx, userID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if token != nil {
+		res.PaymentTokenRegisteredAt = ptr(token.CreatedAt.UnixMilli())
+	}
+
+	coupons, err := q.Coupons().ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, coupon := range coupons {
+		res.Coupons = append(res.Coupons, appExportCoupon{
+			Code:      coupon.Code,
+			Discount:  coupon.Discount,
+			CreatedAt: coupon.CreatedAt.UnixMilli(),
+			UsedBy:    coupon.UsedBy,
+		})
+	}
+
+	places, err := q.SavedPlaces().ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, place := range places {
+		res.SavedPlaces = append(res.SavedPlaces, newAppPlace(&place))
+	}
+	return res, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+func TestAppPatchMe(t *testing.T) {
+	m := newTestStore(t)
+	captureLogs(t)
+	user := addTestUser(t, m, "user1")
+	addTestUser(t, m, "user2")
+
+	rec := serveAsUser(t, appPatchMe, user, http.MethodPatch, "/api/app/me", map[string]string{"username": "renamed", "lastname": "椅子田"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	profile := decodeBody[appUserProfile](t, rec)
+	if profile.Username != "renamed" || profile.Lastname != "椅子田" || profile.Firstname != "first" {
+		t.Errorf("profile = %+v, want only username and lastname to be updated", profile)
+	}
+
+	rec = serveAsUser(t, appPatchMe, user, http.MethodPatch, "/api/app/me", map[string]string{"username": "user2"})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("duplicate username: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	rec = serveAsUser(t, appPatchMe, user, http.MethodPatch, "/api/app/me", map[string]string{"firstname": strings.Repeat("あ", maxUserFieldLength+1)})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("too long firstname: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = serveAsUser(t, appGetMe, user, http.MethodGet, "/api/app/me", nil)
+	if got := decodeBody[appUserProfile](t, rec); got.Username != "renamed" {
+		t.Errorf("username = %s, want renamed", got.Username)
+	}
+}
+
+func TestAppDeleteMe(t *testing.T) {
+	m := newTestStore(t)
+	_, audits := captureLogs(t)
+	ctx := context.Background()
+	user := addTestUser(t, m, "user1", Coupon{Code: "CP_USED", Discount: 1000}, Coupon{Code: "CP_UNUSED", Discount: 500})
+	if err := m.PaymentTokens().Create(ctx, &PaymentToken{UserID: user.ID, Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{Token: "session1", Role: string(auth.RoleApp), PrincipalID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := m.Sessions().Create(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SavedPlaces().Create(ctx, &SavedPlace{ID: "place1", UserID: user.ID, Kind: placeKindHome, Label: placeKindHome}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 進行中のライドがある間は退会できない
+	ride := postTestRide(t, user)
+	rec := serveAsUser(t, appDeleteMe, user, http.MethodDelete, "/api/app/me", nil)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("ride in progress: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	completeTestRide(t, m, ride.RideID)
+
+	rec = serveAsUser(t, appDeleteMe, user, http.MethodDelete, "/api/app/me", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+
+	deleted, err := m.Users().Get(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(deleted.Username, "deleted_") || deleted.Firstname != "" || deleted.Lastname != "" || deleted.AccessToken == user.AccessToken {
+		t.Errorf("user = %+v, want anonymized", deleted)
+	}
+	if _, err := m.PaymentTokens().Get(ctx, user.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("payment token: err = %v, want ErrNotFound", err)
+	}
+	// オーナーの売上に使うので、ライドと使用済みのクーポンは残す
+	if _, err := m.Rides().Get(ctx, ride.RideID); err != nil {
+		t.Errorf("ride was deleted: %v", err)
+	}
+	coupons, err := m.Coupons().ListByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coupons) != 1 || coupons[0].Code != usedCoupon(t, m, ride.RideID) {
+		t.Errorf("coupons = %+v, want only the used coupon", coupons)
+	}
+	if places, err := m.SavedPlaces().ListByUser(ctx, user.ID); err != nil || len(places) != 0 {
+		t.Errorf("saved places = %+v (err = %v), want none", places, err)
+	}
+	sessions, err := m.Sessions().ListActive(ctx, string(auth.RoleApp), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("active sessions = %d, want 0", len(sessions))
+	}
+
+	logs := audits()
+	if len(logs) == 0 || logs[len(logs)-1]["msg"] != auditUserDeleted {
+		t.Errorf("audit logs = %v, want %s at last", logs, auditUserDeleted)
+	}
+}
+
+func TestAppGetMeExport(t *testing.T) {
+	m := newTestStore(t)
+	captureLogs(t)
+	ctx := context.Background()
+	user := addTestUser(t, m, "user1", Coupon{Code: "CP_NEW2024", Discount: 3000})
+	if err := m.PaymentTokens().Create(ctx, &PaymentToken{UserID: user.ID, Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	completed := postTestRide(t, user)
+	completeTestRide(t, m, completed.RideID)
+	// 運営が完了させたライドは決済していない
+	adminCompleted := postTestRide(t, user)
+	if err := m.Rides().CreateStatus(ctx, &RideStatus{ID: adminCompleted.RideID + "-completed", RideID: adminCompleted.RideID, Status: "COMPLETED"}); err != nil {
+		t.Fatal(err)
+	}
+	inProgress := postTestRide(t, user)
+
+	rec := serveAsUser(t, appGetMeExport, user, http.MethodGet, "/api/app/me/export", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	res := decodeBody[appGetMeExportResponse](t, rec)
+	if res.User.ID != user.ID {
+		t.Errorf("user id = %s, want %s", res.User.ID, user.ID)
+	}
+	if len(res.Rides) != 3 || res.Rides[0].ID != completed.RideID || res.Rides[0].Status != "COMPLETED" || res.Rides[1].ID != adminCompleted.RideID || res.Rides[1].Status != "COMPLETED" || res.Rides[2].ID != inProgress.RideID || res.Rides[2].Status != "MATCHING" {
+		t.Fatalf("rides = %+v, want completed, admin completed and matching rides", res.Rides)
+	}
+	// 支払いは決済して完了したライドの分だけ
+	if len(res.Payments) != 1 || res.Payments[0].RideID != completed.RideID || res.Payments[0].Amount != res.Rides[0].Fare {
+		t.Errorf("payments = %+v, want a payment of the completed ride", res.Payments)
+	}
+	if res.PaymentTokenRegisteredAt == nil {
+		t.Error("payment_token_registered_at is missing")
+	}
+	if len(res.Coupons) != 1 || res.Coupons[0].UsedBy == nil || *res.Coupons[0].UsedBy != completed.RideID {
+		t.Errorf("coupons = %+v, want the coupon used by the completed ride", res.Coupons)
+	}
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
 )
 
 type appPostUsersRequest struct {
@@ -41,30 +45,28 @@ func appPostUsers(w http.ResponseWriter, r *http.Request) {
 	accessToken := secureRandomStr(32)
 	invitationCode := secureRandomStr(15)
 
-	tx, err := db.Beginx()
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	_, err = tx.ExecContext(
-		ctx,
-		"INSERT INTO users (id, username, firstname, lastname, date_of_birth, access_token, invitation_code) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		userID, req.Username, req.FirstName, req.LastName, req.DateOfBirth, accessToken, invitationCode,
-	)
-	if err != nil {
+	if err := tx.Users().Create(ctx, &User{
+		ID:             userID,
+		Username:       req.Username,
+		Firstname:      req.FirstName,
+		Lastname:       req.LastName,
+		DateOfBirth:    req.DateOfBirth,
+		AccessToken:    accessToken,
+		InvitationCode: invitationCode,
+	}); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
 	// 初回登録キャンペーンのクーポンを付与
-	_, err = tx.ExecContext(
-		ctx,
-		"INSERT INTO coupons (user_id, code, discount) VALUES (?, ?, ?)",
-		userID, "CP_NEW2024", 3000,
-	)
-	if err != nil {
+	if err := tx.Coupons().Create(ctx, &Coupon{UserID: userID, Code: "CP_NEW2024", Discount: 3000}); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -72,23 +74,21 @@ func appPostUsers(w http.ResponseWriter, r *http.Request) {
 	// 招待コードを使った登録
 	if req.InvitationCode != nil && *req.InvitationCode != "" {
 		// 招待する側の招待数をチェック
-		var coupons []Coupon
-		err = tx.SelectContext(ctx, &coupons, "SELECT * FROM coupons WHERE code = ? FOR UPDATE", "INV_"+*req.InvitationCode)
+		coupons, err := tx.Coupons().ListByCodeForUpdate(ctx, "INV_"+*req.InvitationCode)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 		if len(coupons) >= 3 {
-			writeError(w, http.StatusBadRequest, errors.New("この招待コードは使用できません。"))
+			writeError(w, http.StatusBadRequest, errInvalidInvitationCode)
 			return
 		}
 
 		// ユーザーチェック
-		var inviter User
-		err = tx.GetContext(ctx, &inviter, "SELECT * FROM users WHERE invitation_code = ?", *req.InvitationCode)
+		inviter, err := tx.Users().GetByInvitationCode(ctx, *req.InvitationCode)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				writeError(w, http.StatusBadRequest, errors.New("この招待コードは使用できません。"))
+			if errors.Is(err, store.ErrNotFound) {
+				writeError(w, http.StatusBadRequest, errInvalidInvitationCode)
 				return
 			}
 			writeError(w, http.StatusInternalServerError, err)
@@ -96,37 +96,33 @@ func appPostUsers(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 招待クーポン付与
-		_, err = tx.ExecContext(
-			ctx,
-			"INSERT INTO coupons (user_id, code, discount) VALUES (?, ?, ?)",
-			userID, "INV_"+*req.InvitationCode, 1500,
-		)
-		if err != nil {
+		if err := tx.Coupons().Create(ctx, &Coupon{UserID: userID, Code: "INV_" + *req.InvitationCode, Discount: 1500}); err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 		// 招待した人にもRewardを付与
-		_, err = tx.ExecContext(
-			ctx,
-			"INSERT INTO coupons (user_id, code, discount) VALUES (?, CONCAT(?, '_', FLOOR(UNIX_TIMESTAMP(NOW(3))*1000)), ?)",
-			inviter.ID, "RWD_"+*req.InvitationCode, 1000,
-		)
-		if err != nil {
+		if err := tx.Coupons().Create(ctx, &Coupon{
+			UserID:   inviter.ID,
+			Code:     fmt.Sprintf("RWD_%s_%d", *req.InvitationCode, time.Now().UnixMilli()),
+			Discount: 1000,
+		}); err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 	}
 
+	session, err := createSession(ctx, tx.Sessions(), accessToken, auth.RoleApp, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Path:  "/",
-		Name:  "app_session",
-		Value: accessToken,
-	})
+	setSessionCookie(w, r, session)
 
 	writeJSON(w, http.StatusCreated, &appPostUsersResponse{
 		ID:             userID,
@@ -150,15 +146,13 @@ func appPostPaymentMethods(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := ctx.Value("user").(*User)
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
 
-	_, err := db.ExecContext(
-		ctx,
-		`INSERT INTO payment_tokens (user_id, token) VALUES (?, ?)`,
-		user.ID,
-		req.Token,
-	)
-	if err != nil {
+	if err := dataStore.PaymentTokens().Create(ctx, &PaymentToken{UserID: user.ID, Token: req.Token}); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -190,29 +184,30 @@ type getAppRidesResponseItemChair struct {
 
 func appGetRides(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	user := ctx.Value("user").(*User)
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
 
-	tx, err := db.Beginx()
+	tx, err := readStore().BeginRead(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	rides := []Ride{}
-	if err := tx.SelectContext(
-		ctx,
-		&rides,
-		`SELECT * FROM rides WHERE user_id = ? ORDER BY created_at DESC`,
-		user.ID,
-	); err != nil {
+	rides, err := tx.Rides().ListByUser(ctx, user.ID)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	// 新しいライドから返す
+	slices.Reverse(rides)
 
 	items := []getAppRidesResponseItem{}
 	for _, ride := range rides {
-		status, err := getLatestRideStatus(ctx, tx, ride.ID)
+		status, err := tx.Rides().GetLatestStatus(ctx, ride.ID)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
@@ -239,8 +234,8 @@ func appGetRides(w http.ResponseWriter, r *http.Request) {
 
 		item.Chair = getAppRidesResponseItemChair{}
 
-		chair := &Chair{}
-		if err := tx.GetContext(ctx, chair, `SELECT * FROM chairs WHERE id = ?`, ride.ChairID); err != nil {
+		chair, err := tx.Chairs().Get(ctx, ride.ChairID.String)
+		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -248,8 +243,8 @@ func appGetRides(w http.ResponseWriter, r *http.Request) {
 		item.Chair.Name = chair.Name
 		item.Chair.Model = chair.Model
 
-		owner := &Owner{}
-		if err := tx.GetContext(ctx, owner, `SELECT * FROM owners WHERE id = ?`, chair.OwnerID); err != nil {
+		owner, err := tx.Owners().Get(ctx, chair.OwnerID)
+		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -269,8 +264,12 @@ func appGetRides(w http.ResponseWriter, r *http.Request) {
 }
 
 type appPostRidesRequest struct {
-	PickupCoordinate      *Coordinate `json:"pickup_coordinate"`
-	DestinationCoordinate *Coordinate `json:"destination_coordinate"`
+	PickupCoordinate      *Coordinate `json:"pickup_coordinate,omitempty"`
+	DestinationCoordinate *Coordinate `json:"destination_coordinate,omitempty"`
+	// 座標の代わりに保存した場所を指定できる
+	PickupPlaceID      *string                  `json:"pickup_place_id,omitempty"`
+	DestinationPlaceID *string                  `json:"destination_place_id,omitempty"`
+	ChairPreferences   *appRideChairPreferences `json:"chair_preferences,omitempty"`
 }
 
 type appPostRidesResponse struct {
@@ -278,150 +277,138 @@ type appPostRidesResponse struct {
 	Fare   int    `json:"fare"`
 }
 
-type executableGet interface {
-	Get(dest interface{}, query string, args ...interface{}) error
-	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
-}
-
-func getLatestRideStatus(ctx context.Context, tx executableGet, rideID string) (string, error) {
-	status := ""
-	if err := tx.GetContext(ctx, &status, `SELECT status FROM ride_statuses WHERE ride_id = ? ORDER BY created_at DESC LIMIT 1`, rideID); err != nil {
-		return "", err
-	}
-	return status, nil
+// isRideFinished はライドが完了したか、運営によってキャンセルされたかを返す
+func isRideFinished(status string) bool {
+	return status == "COMPLETED" || status == "CANCELED"
 }
 
 func appPostRides(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	// 停止処理中は進行中のライドの完了を待っているため、新しいライドは受け付けない
+	if draining.Load() {
+		writeError(w, http.StatusServiceUnavailable, errShuttingDown)
+		return
+	}
 	req := &appPostRidesRequest{}
 	if err := bindJSON(r, req); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.PickupCoordinate == nil || req.DestinationCoordinate == nil {
-		writeError(w, http.StatusBadRequest, errors.New("required fields(pickup_coordinate, destination_coordinate) are empty"))
+
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+	pickup, destination, err := resolveRideCoordinates(ctx, dataStore, user.ID, req.PickupCoordinate, req.PickupPlaceID, req.DestinationCoordinate, req.DestinationPlaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !isInServiceArea(pickup.Latitude, pickup.Longitude) {
+		writeError(w, http.StatusBadRequest, errOutOfServiceArea)
 		return
 	}
-
-	user := ctx.Value("user").(*User)
 	rideID := ulid.Make().String()
+	preference, err := newRideChairPreference(ctx, dataStore, rideID, req.ChairPreferences)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
 
-	tx, err := db.Beginx()
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	rides := []Ride{}
-	if err := tx.SelectContext(ctx, &rides, `SELECT * FROM rides WHERE user_id = ?`, user.ID); err != nil {
+	rides, err := tx.Rides().ListByUser(ctx, user.ID)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
 	continuingRideCount := 0
 	for _, ride := range rides {
-		status, err := getLatestRideStatus(ctx, tx, ride.ID)
+		status, err := tx.Rides().GetLatestStatus(ctx, ride.ID)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
-		if status != "COMPLETED" {
+		if !isRideFinished(status) {
 			continuingRideCount++
 		}
 	}
 
 	if continuingRideCount > 0 {
-		writeError(w, http.StatusConflict, errors.New("ride already exists"))
+		writeError(w, http.StatusConflict, errRideAlreadyExists)
 		return
 	}
 
-	if _, err := tx.ExecContext(
-		ctx,
-		`INSERT INTO rides (id, user_id, pickup_latitude, pickup_longitude, destination_latitude, destination_longitude)
-				  VALUES (?, ?, ?, ?, ?, ?)`,
-		rideID, user.ID, req.PickupCoordinate.Latitude, req.PickupCoordinate.Longitude, req.DestinationCoordinate.Latitude, req.DestinationCoordinate.Longitude,
-	); err != nil {
+	if err := tx.Rides().Create(ctx, &Ride{
+		ID:                   rideID,
+		UserID:               user.ID,
+		PickupLatitude:       pickup.Latitude,
+		PickupLongitude:      pickup.Longitude,
+		DestinationLatitude:  destination.Latitude,
+		DestinationLongitude: destination.Longitude,
+	}); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if _, err := tx.ExecContext(
-		ctx,
-		`INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)`,
-		ulid.Make().String(), rideID, "MATCHING",
-	); err != nil {
+	if err := tx.Rides().CreateStatus(ctx, &RideStatus{
+		ID:     ulid.Make().String(),
+		RideID: rideID,
+		Status: "MATCHING",
+	}); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	if preference != nil {
+		if err := tx.Rides().CreateChairPreference(ctx, preference); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
 
-	var rideCount int
-	if err := tx.GetContext(ctx, &rideCount, `SELECT COUNT(*) FROM rides WHERE user_id = ? `, user.ID); err != nil {
+	rideCount, err := tx.Rides().CountByUser(ctx, user.ID)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	var coupon Coupon
+	var coupon *Coupon
 	if rideCount == 1 {
 		// 初回利用で、初回利用クーポンがあれば必ず使う
-		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND code = 'CP_NEW2024' AND used_by IS NULL FOR UPDATE", user.ID); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-
+		coupon, err = tx.Coupons().GetUnusedForUpdate(ctx, user.ID, "CP_NEW2024")
+		if errors.Is(err, store.ErrNotFound) {
 			// 無ければ他のクーポンを付与された順番に使う
-			if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1 FOR UPDATE", user.ID); err != nil {
-				if !errors.Is(err, sql.ErrNoRows) {
-					writeError(w, http.StatusInternalServerError, err)
-					return
-				}
-			} else {
-				if _, err := tx.ExecContext(
-					ctx,
-					"UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = ?",
-					rideID, user.ID, coupon.Code,
-				); err != nil {
-					writeError(w, http.StatusInternalServerError, err)
-					return
-				}
-			}
-		} else {
-			if _, err := tx.ExecContext(
-				ctx,
-				"UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = 'CP_NEW2024'",
-				rideID, user.ID,
-			); err != nil {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
+			coupon, err = tx.Coupons().GetOldestUnusedForUpdate(ctx, user.ID)
 		}
 	} else {
 		// 他のクーポンを付与された順番に使う
-		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1 FOR UPDATE", user.ID); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-		} else {
-			if _, err := tx.ExecContext(
-				ctx,
-				"UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = ?",
-				rideID, user.ID, coupon.Code,
-			); err != nil {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
+		coupon, err = tx.Coupons().GetOldestUnusedForUpdate(ctx, user.ID)
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if coupon != nil {
+		if err := tx.Coupons().Use(ctx, user.ID, coupon.Code, rideID); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
 		}
 	}
 
-	ride := Ride{}
-	if err := tx.GetContext(ctx, &ride, "SELECT * FROM rides WHERE id = ?", rideID); err != nil {
+	ride, err := tx.Rides().Get(ctx, rideID)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	fare, err := calculateDiscountedFare(ctx, tx, user.ID, &ride, req.PickupCoordinate.Latitude, req.PickupCoordinate.Longitude, req.DestinationCoordinate.Latitude, req.DestinationCoordinate.Longitude)
+	fare, err := calculateDiscountedFare(ctx, tx, user.ID, ride, pickup.Latitude, pickup.Longitude, destination.Latitude, destination.Longitude)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -431,6 +418,11 @@ func appPostRides(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	audit(ctx, auditRideCreated, slog.String("ride_id", rideID), slog.String("user_id", user.ID), slog.Int("fare", fare))
+	recordRideStatus(ctx, rideID, "MATCHING")
+	if coupon != nil {
+		audit(ctx, auditCouponApplied, slog.String("ride_id", rideID), slog.String("user_id", user.ID), slog.String("code", coupon.Code), slog.Int("discount", coupon.Discount))
+	}
 
 	writeJSON(w, http.StatusAccepted, &appPostRidesResponse{
 		RideID: rideID,
@@ -439,8 +431,11 @@ func appPostRides(w http.ResponseWriter, r *http.Request) {
 }
 
 type appPostRidesEstimatedFareRequest struct {
-	PickupCoordinate      *Coordinate `json:"pickup_coordinate"`
-	DestinationCoordinate *Coordinate `json:"destination_coordinate"`
+	PickupCoordinate      *Coordinate `json:"pickup_coordinate,omitempty"`
+	DestinationCoordinate *Coordinate `json:"destination_coordinate,omitempty"`
+	// 座標の代わりに保存した場所を指定できる
+	PickupPlaceID      *string `json:"pickup_place_id,omitempty"`
+	DestinationPlaceID *string `json:"destination_place_id,omitempty"`
 }
 
 type appPostRidesEstimatedFareResponse struct {
@@ -455,21 +450,30 @@ func appPostRidesEstimatedFare(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.PickupCoordinate == nil || req.DestinationCoordinate == nil {
-		writeError(w, http.StatusBadRequest, errors.New("required fields(pickup_coordinate, destination_coordinate) are empty"))
+
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+	pickup, destination, err := resolveRideCoordinates(ctx, dataStore, user.ID, req.PickupCoordinate, req.PickupPlaceID, req.DestinationCoordinate, req.DestinationPlaceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !isInServiceArea(pickup.Latitude, pickup.Longitude) {
+		writeError(w, http.StatusBadRequest, errOutOfServiceArea)
 		return
 	}
 
-	user := ctx.Value("user").(*User)
-
-	tx, err := db.Beginx()
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	discounted, err := calculateDiscountedFare(ctx, tx, user.ID, nil, req.PickupCoordinate.Latitude, req.PickupCoordinate.Longitude, req.DestinationCoordinate.Latitude, req.DestinationCoordinate.Longitude)
+	discounted, err := calculateDiscountedFare(ctx, tx, user.ID, nil, pickup.Latitude, pickup.Longitude, destination.Latitude, destination.Longitude)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -482,7 +486,7 @@ func appPostRidesEstimatedFare(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, &appPostRidesEstimatedFareResponse{
 		Fare:     discounted,
-		Discount: calculateFare(req.PickupCoordinate.Latitude, req.PickupCoordinate.Longitude, req.DestinationCoordinate.Latitude, req.DestinationCoordinate.Longitude) - discounted,
+		Discount: calculateFare(pickup.Latitude, pickup.Longitude, destination.Latitude, destination.Longitude) - discounted,
 	})
 }
 
@@ -519,71 +523,65 @@ func appPostRideEvaluatation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx, err := db.Beginx()
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	ride := &Ride{}
-	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE id = ?`, rideID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusNotFound, errors.New("ride not found"))
+	ride, err := tx.Rides().Get(ctx, rideID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
 			return
 		}
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-	status, err := getLatestRideStatus(ctx, tx, ride.ID)
+	status, err := tx.Rides().GetLatestStatus(ctx, ride.ID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
 	if status != "ARRIVED" {
-		writeError(w, http.StatusBadRequest, errors.New("not arrived yet"))
+		writeError(w, http.StatusBadRequest, errRideNotArrived)
 		return
 	}
 
-	result, err := tx.ExecContext(
-		ctx,
-		`UPDATE rides SET evaluation = ? WHERE id = ?`,
-		req.Evaluation, rideID)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
-	}
-	if count, err := result.RowsAffected(); err != nil {
+	if err := tx.Rides().UpdateEvaluation(ctx, rideID, req.Evaluation); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
+			return
+		}
 		writeError(w, http.StatusInternalServerError, err)
 		return
-	} else if count == 0 {
-		writeError(w, http.StatusNotFound, errors.New("ride not found"))
-		return
 	}
 
-	_, err = tx.ExecContext(
-		ctx,
-		`INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)`,
-		ulid.Make().String(), rideID, "COMPLETED")
-	if err != nil {
+	if err := tx.Rides().CreateStatus(ctx, &RideStatus{
+		ID:     ulid.Make().String(),
+		RideID: rideID,
+		Status: "COMPLETED",
+	}); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE id = ?`, rideID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusNotFound, errors.New("ride not found"))
+	ride, err = tx.Rides().Get(ctx, rideID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
 			return
 		}
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	paymentToken := &PaymentToken{}
-	if err := tx.GetContext(ctx, paymentToken, `SELECT * FROM payment_tokens WHERE user_id = ?`, ride.UserID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusBadRequest, errors.New("payment token not registered"))
+	paymentToken, err := tx.PaymentTokens().Get(ctx, ride.UserID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusBadRequest, errPaymentTokenNotRegistered)
 			return
 		}
 		writeError(w, http.StatusInternalServerError, err)
@@ -599,19 +597,31 @@ func appPostRideEvaluatation(w http.ResponseWriter, r *http.Request) {
 		Amount: fare,
 	}
 
-	var paymentGatewayURL string
-	if err := tx.GetContext(ctx, &paymentGatewayURL, "SELECT value FROM settings WHERE name = 'payment_gateway_url'"); err != nil {
+	paymentGatewayURL, err := tx.Settings().Get(ctx, "payment_gateway_url")
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if err := requestPaymentGatewayPostPayment(ctx, paymentGatewayURL, paymentToken.Token, paymentGatewayRequest, func() ([]Ride, error) {
-		rides := []Ride{}
-		if err := tx.SelectContext(ctx, &rides, `SELECT * FROM rides WHERE user_id = ? ORDER BY created_at ASC`, ride.UserID); err != nil {
-			return nil, err
+	err = requestPaymentGatewayPostPayment(ctx, paymentGatewayURL, paymentToken.Token, paymentGatewayRequest, func() ([]Ride, error) {
+		return tx.Rides().ListByUser(ctx, ride.UserID)
+	})
+	// 決済は取り消せないので、この後のコミットに失敗した場合も記録する
+	paymentAttrs := []slog.Attr{slog.String("ride_id", ride.ID), slog.String("user_id", ride.UserID), slog.Int("amount", fare), slog.Bool("succeeded", err == nil)}
+	if err != nil {
+		paymentAttrs = append(paymentAttrs, slog.String("error", err.Error()))
+	}
+	audit(ctx, auditPaymentAttempted, paymentAttrs...)
+	if err != nil {
+		// ライドの完了は取り消すので、通知はトランザクションの外で登録する
+		_ = tx.Rollback()
+		if err := publishChairWebhookEvent(ctx, dataStore, ride.ChairID.String, webhookEventPaymentFailed, &webhookPaymentFailedData{
+			RideID:  ride.ID,
+			ChairID: ride.ChairID.String,
+			Amount:  fare,
+		}); err != nil {
+			slog.Error("failed to publish webhook event", "event_type", webhookEventPaymentFailed, "ride_id", ride.ID, "error", err)
 		}
-		return rides, nil
-	}); err != nil {
 		if errors.Is(err, erroredUpstream) {
 			writeError(w, http.StatusBadGateway, err)
 			return
@@ -620,10 +630,20 @@ func appPostRideEvaluatation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := publishChairWebhookEvent(ctx, tx, ride.ChairID.String, webhookEventRideCompleted, &webhookRideCompletedData{
+		RideID:  ride.ID,
+		ChairID: ride.ChairID.String,
+		Fare:    &fare,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	recordRideStatus(ctx, rideID, "COMPLETED")
 
 	writeJSON(w, http.StatusOK, &appPostRideEvaluationResponse{
 		CompletedAt: ride.UpdatedAt.UnixMilli(),
@@ -642,15 +662,18 @@ type appGetNotificationResponseData struct {
 	Fare                  int                              `json:"fare"`
 	Status                string                           `json:"status"`
 	Chair                 *appGetNotificationResponseChair `json:"chair,omitempty"`
+	EstimatedPickupAt     *int64                           `json:"estimated_pickup_at,omitempty"`
+	EstimatedArrivalAt    *int64                           `json:"estimated_arrival_at,omitempty"`
 	CreatedAt             int64                            `json:"created_at"`
 	UpdateAt              int64                            `json:"updated_at"`
 }
 
 type appGetNotificationResponseChair struct {
-	ID    string                               `json:"id"`
-	Name  string                               `json:"name"`
-	Model string                               `json:"model"`
-	Stats appGetNotificationResponseChairStats `json:"stats"`
+	ID                string                               `json:"id"`
+	Name              string                               `json:"name"`
+	Model             string                               `json:"model"`
+	Stats             appGetNotificationResponseChairStats `json:"stats"`
+	CurrentCoordinate *Coordinate                          `json:"current_coordinate,omitempty"`
 }
 
 type appGetNotificationResponseChairStats struct {
@@ -660,18 +683,22 @@ type appGetNotificationResponseChairStats struct {
 
 func appGetNotification(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	user := ctx.Value("user").(*User)
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
 
-	tx, err := db.Beginx()
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	ride := &Ride{}
-	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE user_id = ? ORDER BY created_at DESC LIMIT 1`, user.ID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	ride, err := tx.Rides().GetLatestByUser(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
 			writeJSON(w, http.StatusOK, &appGetNotificationResponse{
 				RetryAfterMs: 30,
 			})
@@ -681,16 +708,15 @@ func appGetNotification(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	yetSentRideStatus := RideStatus{}
 	status := ""
-	if err := tx.GetContext(ctx, &yetSentRideStatus, `SELECT * FROM ride_statuses WHERE ride_id = ? AND app_sent_at IS NULL ORDER BY created_at ASC LIMIT 1`, ride.ID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			status, err = getLatestRideStatus(ctx, tx, ride.ID)
-			if err != nil {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-		} else {
+	yetSentRideStatus, err := tx.Rides().GetUnsentAppStatus(ctx, ride.ID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		status, err = tx.Rides().GetLatestStatus(ctx, ride.ID)
+		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -724,8 +750,8 @@ func appGetNotification(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if ride.ChairID.Valid {
-		chair := &Chair{}
-		if err := tx.GetContext(ctx, chair, `SELECT * FROM chairs WHERE id = ?`, ride.ChairID); err != nil {
+		chair, err := tx.Chairs().Get(ctx, ride.ChairID.String)
+		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -742,11 +768,40 @@ func appGetNotification(w http.ResponseWriter, r *http.Request) {
 			Model: chair.Model,
 			Stats: stats,
 		}
-	}
 
-	if yetSentRideStatus.ID != "" {
-		_, err := tx.ExecContext(ctx, `UPDATE ride_statuses SET app_sent_at = CURRENT_TIMESTAMP(6) WHERE id = ?`, yetSentRideStatus.ID)
+		chairLocation, err := tx.Chairs().GetLatestLocation(ctx, chair.ID)
 		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		} else {
+			response.Data.Chair.CurrentCoordinate = &Coordinate{
+				Latitude:  chairLocation.Latitude,
+				Longitude: chairLocation.Longitude,
+			}
+
+			chairModel, err := tx.Chairs().GetModel(ctx, chair.Model)
+			if err != nil {
+				if !errors.Is(err, store.ErrNotFound) {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+			} else {
+				switch status {
+				case "ENROUTE":
+					estimated := estimateChairArrival(chairLocation, chairModel.Speed, ride.PickupLatitude, ride.PickupLongitude).UnixMilli()
+					response.Data.EstimatedPickupAt = &estimated
+				case "CARRYING":
+					estimated := estimateChairArrival(chairLocation, chairModel.Speed, ride.DestinationLatitude, ride.DestinationLongitude).UnixMilli()
+					response.Data.EstimatedArrivalAt = &estimated
+				}
+			}
+		}
+	}
+
+	if yetSentRideStatus != nil {
+		if err := tx.Rides().MarkStatusSentToApp(ctx, yetSentRideStatus.ID); err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -760,16 +815,23 @@ func appGetNotification(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, response)
 }
 
-func getChairStats(ctx context.Context, tx *sqlx.Tx, chairID string) (appGetNotificationResponseChairStats, error) {
+// 椅子が1回の移動でモデルの速度分の距離を進むのにかかる時間。起動時に設定の値で上書きされる
+var chairMoveInterval = config.Default().Chair.MoveInterval
+
+// 椅子が最後に記録した位置から、モデルの速度で目的の座標まで移動した場合の到着予定時刻
+func estimateChairArrival(location *ChairLocation, speed int, latitude, longitude int) time.Time {
+	if speed <= 0 {
+		return location.CreatedAt
+	}
+	distance := calculateDistance(location.Latitude, location.Longitude, latitude, longitude)
+	moves := (distance + speed - 1) / speed
+	return location.CreatedAt.Add(time.Duration(moves) * chairMoveInterval)
+}
+
+func getChairStats(ctx context.Context, q store.Queries, chairID string) (appGetNotificationResponseChairStats, error) {
 	stats := appGetNotificationResponseChairStats{}
 
-	rides := []Ride{}
-	err := tx.SelectContext(
-		ctx,
-		&rides,
-		`SELECT * FROM rides WHERE chair_id = ? ORDER BY updated_at DESC`,
-		chairID,
-	)
+	rides, err := q.Rides().ListByChair(ctx, chairID)
 	if err != nil {
 		return stats, err
 	}
@@ -777,13 +839,7 @@ func getChairStats(ctx context.Context, tx *sqlx.Tx, chairID string) (appGetNoti
 	totalRideCount := 0
 	totalEvaluation := 0.0
 	for _, ride := range rides {
-		rideStatuses := []RideStatus{}
-		err = tx.SelectContext(
-			ctx,
-			&rideStatuses,
-			`SELECT * FROM ride_statuses WHERE ride_id = ? ORDER BY created_at`,
-			ride.ID,
-		)
+		rideStatuses, err := q.Rides().ListStatuses(ctx, ride.ID)
 		if err != nil {
 			return stats, err
 		}
@@ -864,19 +920,14 @@ func appGetNearbyChairs(w http.ResponseWriter, r *http.Request) {
 
 	coordinate := Coordinate{Latitude: lat, Longitude: lon}
 
-	tx, err := db.Beginx()
+	tx, err := readStore().BeginRead(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	chairs := []Chair{}
-	err = tx.SelectContext(
-		ctx,
-		&chairs,
-		`SELECT * FROM chairs`,
-	)
+	chairs, err := tx.Chairs().List(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -888,8 +939,8 @@ func appGetNearbyChairs(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		rides := []*Ride{}
-		if err := tx.SelectContext(ctx, &rides, `SELECT * FROM rides WHERE chair_id = ? ORDER BY created_at DESC`, chair.ID); err != nil {
+		rides, err := tx.Rides().ListByChair(ctx, chair.ID)
+		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -897,12 +948,12 @@ func appGetNearbyChairs(w http.ResponseWriter, r *http.Request) {
 		skip := false
 		for _, ride := range rides {
 			// 過去にライドが存在し、かつ、それが完了していない場合はスキップ
-			status, err := getLatestRideStatus(ctx, tx, ride.ID)
+			status, err := tx.Rides().GetLatestStatus(ctx, ride.ID)
 			if err != nil {
 				writeError(w, http.StatusInternalServerError, err)
 				return
 			}
-			if status != "COMPLETED" {
+			if !isRideFinished(status) {
 				skip = true
 				break
 			}
@@ -912,15 +963,9 @@ func appGetNearbyChairs(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 最新の位置情報を取得
-		chairLocation := &ChairLocation{}
-		err = tx.GetContext(
-			ctx,
-			chairLocation,
-			`SELECT * FROM chair_locations WHERE chair_id = ? ORDER BY created_at DESC LIMIT 1`,
-			chair.ID,
-		)
+		chairLocation, err := tx.Chairs().GetLatestLocation(ctx, chair.ID)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
+			if errors.Is(err, store.ErrNotFound) {
 				continue
 			}
 			writeError(w, http.StatusInternalServerError, err)
@@ -940,12 +985,7 @@ func appGetNearbyChairs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	retrievedAt := &time.Time{}
-	err = tx.GetContext(
-		ctx,
-		retrievedAt,
-		`SELECT CURRENT_TIMESTAMP(6)`,
-	)
+	retrievedAt, err := tx.Now(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -958,13 +998,14 @@ func appGetNearbyChairs(w http.ResponseWriter, r *http.Request) {
 }
 
 func calculateFare(pickupLatitude, pickupLongitude, destLatitude, destLongitude int) int {
-	meteredFare := farePerDistance * calculateDistance(pickupLatitude, pickupLongitude, destLatitude, destLongitude)
-	return initialFare + meteredFare
+	baseFare, perDistance := fareRates(pickupLatitude, pickupLongitude)
+	meteredFare := perDistance * calculateDistance(pickupLatitude, pickupLongitude, destLatitude, destLongitude)
+	return baseFare + meteredFare
 }
 
-func calculateDiscountedFare(ctx context.Context, tx *sqlx.Tx, userID string, ride *Ride, pickupLatitude, pickupLongitude, destLatitude, destLongitude int) (int, error) {
-	var coupon Coupon
-	discount := 0
+func calculateDiscountedFare(ctx context.Context, q store.Queries, userID string, ride *Ride, pickupLatitude, pickupLongitude, destLatitude, destLongitude int) (int, error) {
+	var coupon *Coupon
+	var err error
 	if ride != nil {
 		destLatitude = ride.DestinationLatitude
 		destLongitude = ride.DestinationLongitude
@@ -972,35 +1013,61 @@ func calculateDiscountedFare(ctx context.Context, tx *sqlx.Tx, userID string, ri
 		pickupLongitude = ride.PickupLongitude
 
 		// すでにクーポンが紐づいているならそれの割引額を参照
-		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE used_by = ?", ride.ID); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				return 0, err
-			}
-		} else {
-			discount = coupon.Discount
-		}
+		coupon, err = q.Coupons().GetUsedBy(ctx, ride.ID)
 	} else {
 		// 初回利用クーポンを最優先で使う
-		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND code = 'CP_NEW2024' AND used_by IS NULL", userID); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				return 0, err
-			}
-
+		coupon, err = q.Coupons().GetUnused(ctx, userID, "CP_NEW2024")
+		if errors.Is(err, store.ErrNotFound) {
 			// 無いなら他のクーポンを付与された順番に使う
-			if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1", userID); err != nil {
-				if !errors.Is(err, sql.ErrNoRows) {
-					return 0, err
-				}
-			} else {
-				discount = coupon.Discount
-			}
-		} else {
-			discount = coupon.Discount
+			coupon, err = q.Coupons().GetOldestUnused(ctx, userID)
 		}
 	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return 0, err
+	}
+	discount := 0
+	if coupon != nil {
+		discount = coupon.Discount
+	}
 
-	meteredFare := farePerDistance * calculateDistance(pickupLatitude, pickupLongitude, destLatitude, destLongitude)
+	baseFare, perDistance := fareRates(pickupLatitude, pickupLongitude)
+	meteredFare := perDistance * calculateDistance(pickupLatitude, pickupLongitude, destLatitude, destLongitude)
 	discountedMeteredFare := max(meteredFare-discount, 0)
 
-	return initialFare + discountedMeteredFare, nil
+	return baseFare + discountedMeteredFare, nil
+}
+
+func appGetRideRoute(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+	rideID := r.PathValue("ride_id")
+
+	tx, err := dataStore.BeginRead(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	ride, err := tx.Rides().Get(ctx, rideID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil || ride.UserID != user.ID {
+		writeError(w, http.StatusNotFound, errRideNotFound)
+		return
+	}
+
+	res, err := buildRideRoute(ctx, tx, ride)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+func newTestStore(t *testing.T) *store.Memory {
+	t.Helper()
+	m := store.NewMemory()
+	prev := dataStore
+	dataStore = m
+	chairsWithRegion.Clear()
+	t.Cleanup(func() { dataStore = prev })
+	return m
+}
+
+func addTestUser(t *testing.T, m *store.Memory, id string, coupons ...Coupon) *User {
+	t.Helper()
+	ctx := context.Background()
+	user := &User{ID: id, Username: id, Firstname: "first", Lastname: "last", AccessToken: id, InvitationCode: id}
+	if err := m.Users().Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	for _, coupon := range coupons {
+		coupon.UserID = id
+		if err := m.Coupons().Create(ctx, &coupon); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// serveAs はハンドラーを直接呼び出す。ctxFnで認証済みの利用者をコンテキストに設定し、pathValuesでパスパラメーターを与える。
+// bodyがnilの場合は空のリクエストボディで呼び出す
+func serveAs(t *testing.T, handler http.HandlerFunc, ctxFn func(context.Context) context.Context, method, target string, pathValues map[string]string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf []byte
+	if body != nil {
+		var err error
+		if buf, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(buf))
+	for name, value := range pathValues {
+		req.SetPathValue(name, value)
+	}
+	if ctxFn != nil {
+		req = req.WithContext(ctxFn(req.Context()))
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func asUser(user *User) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context { return auth.WithUser(ctx, user) }
+}
+
+func serveAsUser(t *testing.T, handler http.HandlerFunc, user *User, method, target string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveAs(t, handler, asUser(user), method, target, nil, body)
+}
+
+func decodeBody[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(rec.Body).Decode(&v); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+// 距離50の移動。割引前の運賃は 500 + 100 * 50 = 5500
+var testRideRequest = &appPostRidesRequest{
+	PickupCoordinate:      &Coordinate{Latitude: 0, Longitude: 0},
+	DestinationCoordinate: &Coordinate{Latitude: 30, Longitude: 20},
+}
+
+func postTestRide(t *testing.T, user *User) appPostRidesResponse {
+	t.Helper()
+	rec := serveAsUser(t, appPostRides, user, http.MethodPost, "/api/app/rides", testRideRequest)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body.String())
+	}
+	return decodeBody[appPostRidesResponse](t, rec)
+}
+
+// 評価して決済したライドと同じ状態にする
+func completeTestRide(t *testing.T, m *store.Memory, rideID string) {
+	t.Helper()
+	if err := m.Rides().UpdateEvaluation(context.Background(), rideID, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Rides().CreateStatus(context.Background(), &RideStatus{ID: rideID + "-completed", RideID: rideID, Status: "COMPLETED"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func usedCoupon(t *testing.T, m *store.Memory, rideID string) string {
+	t.Helper()
+	coupon, err := m.Coupons().GetUsedBy(context.Background(), rideID)
+	if err != nil {
+		t.Fatalf("no coupon used by ride %s: %v", rideID, err)
+	}
+	return coupon.Code
+}
+
+func TestAppPostRidesCouponOrder(t *testing.T) {
+	t.Run("初回利用クーポンは付与された順番に関わらず最初のライドで使う", func(t *testing.T) {
+		m := newTestStore(t)
+		user := addTestUser(t, m, "user1",
+			Coupon{Code: "INV_friend", Discount: 1500},
+			Coupon{Code: "CP_NEW2024", Discount: 3000},
+		)
+
+		first := postTestRide(t, user)
+		if code := usedCoupon(t, m, first.RideID); code != "CP_NEW2024" {
+			t.Errorf("first ride used %s, want CP_NEW2024", code)
+		}
+		if first.Fare != 2500 {
+			t.Errorf("first ride fare = %d, want 2500", first.Fare)
+		}
+
+		completeTestRide(t, m, first.RideID)
+		second := postTestRide(t, user)
+		if code := usedCoupon(t, m, second.RideID); code != "INV_friend" {
+			t.Errorf("second ride used %s, want INV_friend", code)
+		}
+		if second.Fare != 4000 {
+			t.Errorf("second ride fare = %d, want 4000", second.Fare)
+		}
+	})
+
+	t.Run("初回利用クーポンが無ければ最も古いクーポンを使う", func(t *testing.T) {
+		m := newTestStore(t)
+		user := addTestUser(t, m, "user1",
+			Coupon{Code: "RWD_old", Discount: 1000},
+			Coupon{Code: "RWD_new", Discount: 1000},
+		)
+
+		ride := postTestRide(t, user)
+		if code := usedCoupon(t, m, ride.RideID); code != "RWD_old" {
+			t.Errorf("ride used %s, want RWD_old", code)
+		}
+	})
+
+	t.Run("2回目以降は初回利用クーポンを使わない", func(t *testing.T) {
+		m := newTestStore(t)
+		user := addTestUser(t, m, "user1")
+		first := postTestRide(t, user)
+		completeTestRide(t, m, first.RideID)
+		if err := m.Coupons().Create(context.Background(), &Coupon{UserID: user.ID, Code: "INV_friend", Discount: 1500}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Coupons().Create(context.Background(), &Coupon{UserID: user.ID, Code: "CP_NEW2024", Discount: 3000}); err != nil {
+			t.Fatal(err)
+		}
+
+		second := postTestRide(t, user)
+		if code := usedCoupon(t, m, second.RideID); code != "INV_friend" {
+			t.Errorf("second ride used %s, want INV_friend", code)
+		}
+	})
+
+	t.Run("クーポンが無ければ割引しない", func(t *testing.T) {
+		m := newTestStore(t)
+		user := addTestUser(t, m, "user1")
+
+		ride := postTestRide(t, user)
+		if _, err := m.Coupons().GetUsedBy(context.Background(), ride.RideID); err == nil {
+			t.Error("ride used a coupon, want none")
+		}
+		if ride.Fare != 5500 {
+			t.Errorf("fare = %d, want 5500", ride.Fare)
+		}
+	})
+}
+
+func TestAppPostRidesConflict(t *testing.T) {
+	m := newTestStore(t)
+	user := addTestUser(t, m, "user1")
+	postTestRide(t, user)
+
+	rec := serveAsUser(t, appPostRides, user, http.MethodPost, "/api/app/rides", testRideRequest)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestAppPostRidesEstimatedFare(t *testing.T) {
+	m := newTestStore(t)
+	user := addTestUser(t, m, "user1",
+		Coupon{Code: "INV_friend", Discount: 1500},
+		Coupon{Code: "CP_NEW2024", Discount: 3000},
+	)
+
+	rec := serveAsUser(t, appPostRidesEstimatedFare, user, http.MethodPost, "/api/app/rides/estimated-fare", &appPostRidesEstimatedFareRequest{
+		PickupCoordinate:      testRideRequest.PickupCoordinate,
+		DestinationCoordinate: testRideRequest.DestinationCoordinate,
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	res := decodeBody[appPostRidesEstimatedFareResponse](t, rec)
+	if res.Fare != 2500 || res.Discount != 3000 {
+		t.Errorf("fare = %d, discount = %d, want 2500, 3000", res.Fare, res.Discount)
+	}
+
+	// 見積もりではクーポンを使わない
+	if _, err := m.Coupons().GetUnused(context.Background(), user.ID, "CP_NEW2024"); err != nil {
+		t.Errorf("CP_NEW2024 was used by estimation: %v", err)
+	}
+}
+
+func TestEstimateChairArrival(t *testing.T) {
+	prev := chairMoveInterval
+	chairMoveInterval = 30 * time.Millisecond
+	t.Cleanup(func() { chairMoveInterval = prev })
+
+	createdAt := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	location := &ChairLocation{Latitude: 0, Longitude: 0, CreatedAt: createdAt}
+	tests := []struct {
+		speed     int
+		latitude  int
+		longitude int
+		want      time.Duration
+	}{
+		// 距離15を速度5で進むと3回
+		{5, 10, 5, 90 * time.Millisecond},
+		// 端数は1回分の移動に切り上げる
+		{4, 10, 5, 120 * time.Millisecond},
+		{5, 0, 0, 0},
+		// 速度が無いモデルは見積もれない
+		{0, 10, 5, 0},
+	}
+	for _, tt := range tests {
+		if got := estimateChairArrival(location, tt.speed, tt.latitude, tt.longitude).Sub(createdAt); got != tt.want {
+			t.Errorf("estimateChairArrival(speed=%d, to=(%d,%d)) = +%s, want +%s", tt.speed, tt.latitude, tt.longitude, got, tt.want)
+		}
+	}
+}
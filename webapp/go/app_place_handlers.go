@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+// 保存した場所の種類。自宅と職場はユーザーごとに1つだけ保存できる
+const (
+	placeKindHome   = "home"
+	placeKindWork   = "work"
+	placeKindCustom = "custom"
+)
+
+const maxPlaceLabelLength = 30
+
+const (
+	recentDestinationsDefaultLimit = 5
+	recentDestinationsMaxLimit     = 20
+)
+
+type appPlace struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Label      string     `json:"label"`
+	Coordinate Coordinate `json:"coordinate"`
+	CreatedAt  int64      `json:"created_at"`
+	UpdatedAt  int64      `json:"updated_at"`
+}
+
+func newAppPlace(place *SavedPlace) appPlace {
+	return appPlace{
+		ID:         place.ID,
+		Kind:       place.Kind,
+		Label:      place.Label,
+		Coordinate: Coordinate{Latitude: place.Latitude, Longitude: place.Longitude},
+		CreatedAt:  place.CreatedAt.UnixMilli(),
+		UpdatedAt:  place.UpdatedAt.UnixMilli(),
+	}
+}
+
+// placeLabel は場所の種類と指定された名前から保存する名前を返す。
+// 自宅と職場は種類を名前にして、同じ種類の場所を2つ保存できないようにする
+func placeLabel(kind, label string) (string, error) {
+	switch kind {
+	case placeKindHome, placeKindWork:
+		if label != "" && label != kind {
+			return "", fmt.Errorf("label cannot be specified for %s", kind)
+		}
+		return kind, nil
+	case placeKindCustom:
+		if label == "" || utf8.RuneCountInString(label) > maxPlaceLabelLength {
+			return "", fmt.Errorf("label must be 1 to %d characters", maxPlaceLabelLength)
+		}
+		if label == placeKindHome || label == placeKindWork {
+			return "", fmt.Errorf("label %q is reserved", label)
+		}
+		return label, nil
+	default:
+		return "", fmt.Errorf("invalid kind: %s", kind)
+	}
+}
+
+type appGetPlacesResponse struct {
+	Places []appPlace `json:"places"`
+}
+
+// 自宅、職場、その他の場所を登録した順に返す
+func appGetPlaces(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	places, err := dataStore.SavedPlaces().ListByUser(ctx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	kindOrder := []string{placeKindHome, placeKindWork, placeKindCustom}
+	slices.SortStableFunc(places, func(a, b SavedPlace) int {
+		return slices.Index(kindOrder, a.Kind) - slices.Index(kindOrder, b.Kind)
+	})
+
+	res := &appGetPlacesResponse{Places: []appPlace{}}
+	for _, place := range places {
+		res.Places = append(res.Places, newAppPlace(&place))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type appPostPlaceRequest struct {
+	Kind       string      `json:"kind"`
+	Label      string      `json:"label,omitempty"`
+	Coordinate *Coordinate `json:"coordinate"`
+}
+
+func appPostPlace(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := &appPostPlaceRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Coordinate == nil {
+		writeError(w, http.StatusBadRequest, errors.New("required fields(kind, coordinate) are empty"))
+		return
+	}
+	label, err := placeLabel(req.Kind, req.Label)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	placeID := ulid.Make().String()
+	if err := tx.SavedPlaces().Create(ctx, &SavedPlace{
+		ID:        placeID,
+		UserID:    user.ID,
+		Kind:      req.Kind,
+		Label:     label,
+		Latitude:  req.Coordinate.Latitude,
+		Longitude: req.Coordinate.Longitude,
+	}); err != nil {
+		if errors.Is(err, store.ErrDuplicate) {
+			writeError(w, http.StatusConflict, errPlaceAlreadyExists)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	place, err := tx.SavedPlaces().Get(ctx, user.ID, placeID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newAppPlace(place))
+}
+
+type appPutPlaceRequest struct {
+	Label      string      `json:"label,omitempty"`
+	Coordinate *Coordinate `json:"coordinate"`
+}
+
+// 場所の名前と座標を変更する。種類は変更できない
+func appPutPlace(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	placeID := r.PathValue("place_id")
+	req := &appPutPlaceRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Coordinate == nil {
+		writeError(w, http.StatusBadRequest, errors.New("required fields(coordinate) are empty"))
+		return
+	}
+
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	place, err := tx.SavedPlaces().Get(ctx, user.ID, placeID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errPlaceNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	label, err := placeLabel(place.Kind, req.Label)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	place.Label = label
+	place.Latitude = req.Coordinate.Latitude
+	place.Longitude = req.Coordinate.Longitude
+	if err := tx.SavedPlaces().Update(ctx, place); err != nil {
+		if errors.Is(err, store.ErrDuplicate) {
+			writeError(w, http.StatusConflict, errPlaceAlreadyExists)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	place, err = tx.SavedPlaces().Get(ctx, user.ID, placeID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAppPlace(place))
+}
+
+func appDeletePlace(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	placeID := r.PathValue("place_id")
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.SavedPlaces().Get(ctx, user.ID, placeID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errPlaceNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.SavedPlaces().Delete(ctx, user.ID, placeID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type appGetRecentDestinationsResponse struct {
+	Destinations []appRecentDestination `json:"destinations"`
+}
+
+type appRecentDestination struct {
+	Coordinate Coordinate `json:"coordinate"`
+	// 同じ座標の場所を保存している場合は、その場所のIDと名前
+	PlaceID *string `json:"place_id,omitempty"`
+	Label   *string `json:"label,omitempty"`
+	// この目的地へのライドを最後に要求した日時と、要求した回数
+	LastRequestedAt int64 `json:"last_requested_at"`
+	RideCount       int   `json:"ride_count"`
+}
+
+// 過去のライドの目的地を、最後に要求した日時の新しい順に重複を除いて返す
+func appGetRecentDestinations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limit := recentDestinationsDefaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > recentDestinationsMaxLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be an integer between 1 and %d", recentDestinationsMaxLimit))
+			return
+		}
+		limit = l
+	}
+
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := readStore().BeginRead(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	rides, err := tx.Rides().ListByUser(ctx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	places, err := tx.SavedPlaces().ListByUser(ctx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := &appGetRecentDestinationsResponse{Destinations: []appRecentDestination{}}
+	// ライドは古い順なので、後ろから見て最初に出てきた日時が最後に要求した日時になる
+	indexes := map[Coordinate]int{}
+	for i := len(rides) - 1; i >= 0; i-- {
+		ride := rides[i]
+		coordinate := Coordinate{Latitude: ride.DestinationLatitude, Longitude: ride.DestinationLongitude}
+		if index, ok := indexes[coordinate]; ok {
+			res.Destinations[index].RideCount++
+			continue
+		}
+		indexes[coordinate] = len(res.Destinations)
+		res.Destinations = append(res.Destinations, appRecentDestination{
+			Coordinate:      coordinate,
+			LastRequestedAt: ride.CreatedAt.UnixMilli(),
+			RideCount:       1,
+		})
+	}
+	res.Destinations = res.Destinations[:min(limit, len(res.Destinations))]
+
+	for i := range res.Destinations {
+		destination := &res.Destinations[i]
+		for _, place := range places {
+			if place.Latitude == destination.Coordinate.Latitude && place.Longitude == destination.Coordinate.Longitude {
+				destination.PlaceID = &place.ID
+				destination.Label = &place.Label
+				break
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// resolveCoordinate は座標か保存した場所のIDのどちらか一方から座標を求める。
+// どちらも指定されていない場合はnilを返す。nameはエラーメッセージに使うフィールド名の接頭辞
+func resolveCoordinate(ctx context.Context, q store.Queries, userID, name string, coordinate *Coordinate, placeID *string) (*Coordinate, error) {
+	if placeID == nil {
+		return coordinate, nil
+	}
+	if coordinate != nil {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, fmt.Sprintf("only one of %s_coordinate and %s_place_id can be specified", name, name))
+	}
+	place, err := q.SavedPlaces().Get(ctx, userID, *placeID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errPlaceNotFound
+		}
+		return nil, err
+	}
+	return &Coordinate{Latitude: place.Latitude, Longitude: place.Longitude}, nil
+}
+
+// resolveRideCoordinates はライドの配車位置と目的地を求める。どちらかが指定されていない場合はエラーを返す
+func resolveRideCoordinates(ctx context.Context, q store.Queries, userID string, pickup *Coordinate, pickupPlaceID *string, destination *Coordinate, destinationPlaceID *string) (*Coordinate, *Coordinate, error) {
+	pickup, err := resolveCoordinate(ctx, q, userID, "pickup", pickup, pickupPlaceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	destination, err = resolveCoordinate(ctx, q, userID, "destination", destination, destinationPlaceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pickup == nil || destination == nil {
+		return nil, nil, apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "required fields(pickup_coordinate or pickup_place_id, destination_coordinate or destination_place_id) are empty")
+	}
+	return pickup, destination, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func postTestPlace(t *testing.T, user *User, kind, label string, coordinate Coordinate) appPlace {
+	t.Helper()
+	rec := serveAsUser(t, appPostPlace, user, http.MethodPost, "/api/app/places", &appPostPlaceRequest{Kind: kind, Label: label, Coordinate: &coordinate})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	return decodeBody[appPlace](t, rec)
+}
+
+func TestAppPlaces(t *testing.T) {
+	m := newTestStore(t)
+	user := addTestUser(t, m, "user1")
+	other := addTestUser(t, m, "user2")
+
+	gym := postTestPlace(t, user, placeKindCustom, "ジム", Coordinate{Latitude: 10, Longitude: 10})
+	home := postTestPlace(t, user, placeKindHome, "", Coordinate{Latitude: 1, Longitude: 2})
+	if home.Label != placeKindHome {
+		t.Errorf("label = %s, want %s", home.Label, placeKindHome)
+	}
+
+	tests := []struct {
+		name       string
+		req        *appPostPlaceRequest
+		wantStatus int
+	}{
+		{"自宅は1つだけ", &appPostPlaceRequest{Kind: placeKindHome, Coordinate: &Coordinate{}}, http.StatusConflict},
+		{"同じ名前の場所", &appPostPlaceRequest{Kind: placeKindCustom, Label: "ジム", Coordinate: &Coordinate{}}, http.StatusConflict},
+		{"名前の無い場所", &appPostPlaceRequest{Kind: placeKindCustom, Coordinate: &Coordinate{}}, http.StatusBadRequest},
+		{"予約された名前", &appPostPlaceRequest{Kind: placeKindCustom, Label: placeKindWork, Coordinate: &Coordinate{}}, http.StatusBadRequest},
+		{"不明な種類", &appPostPlaceRequest{Kind: "school", Coordinate: &Coordinate{}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAsUser(t, appPostPlace, user, http.MethodPost, "/api/app/places", tt.req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+	// 他のユーザーは同じ名前の場所を保存できる
+	postTestPlace(t, other, placeKindCustom, "ジム", Coordinate{})
+
+	// 自宅が先に並ぶ
+	rec := serveAsUser(t, appGetPlaces, user, http.MethodGet, "/api/app/places", nil)
+	places := decodeBody[appGetPlacesResponse](t, rec).Places
+	if len(places) != 2 || places[0].ID != home.ID || places[1].ID != gym.ID {
+		t.Errorf("places = %+v, want home and gym", places)
+	}
+
+	target := "/api/app/places/" + gym.ID
+	rec = serveAs(t, appPutPlace, asUser(user), http.MethodPut, target, map[string]string{"place_id": gym.ID}, &appPutPlaceRequest{Label: "プール", Coordinate: &Coordinate{Latitude: 20, Longitude: 20}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put: status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if updated := decodeBody[appPlace](t, rec); updated.Label != "プール" || updated.Coordinate.Latitude != 20 || updated.Kind != placeKindCustom {
+		t.Errorf("updated place = %+v", updated)
+	}
+
+	rec = serveAs(t, appDeletePlace, asUser(other), http.MethodDelete, target, map[string]string{"place_id": gym.ID}, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("delete by other user: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec = serveAs(t, appDeletePlace, asUser(user), http.MethodDelete, target, map[string]string{"place_id": gym.ID}, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	rec = serveAs(t, appPutPlace, asUser(user), http.MethodPut, target, map[string]string{"place_id": gym.ID}, &appPutPlaceRequest{Label: "プール", Coordinate: &Coordinate{}})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("put deleted place: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAppPostRidesWithPlace(t *testing.T) {
+	m := newTestStore(t)
+	captureLogs(t)
+	user := addTestUser(t, m, "user1")
+	other := addTestUser(t, m, "user2")
+	work := postTestPlace(t, user, placeKindWork, "", *testRideRequest.DestinationCoordinate)
+	othersPlace := postTestPlace(t, other, placeKindHome, "", Coordinate{})
+
+	tests := []struct {
+		name       string
+		req        *appPostRidesRequest
+		wantStatus int
+	}{
+		{"座標と場所の両方を指定", &appPostRidesRequest{PickupCoordinate: testRideRequest.PickupCoordinate, DestinationCoordinate: testRideRequest.DestinationCoordinate, DestinationPlaceID: &work.ID}, http.StatusBadRequest},
+		{"目的地が無い", &appPostRidesRequest{PickupCoordinate: testRideRequest.PickupCoordinate}, http.StatusBadRequest},
+		{"他のユーザーの場所", &appPostRidesRequest{PickupCoordinate: testRideRequest.PickupCoordinate, DestinationPlaceID: &othersPlace.ID}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAsUser(t, appPostRides, user, http.MethodPost, "/api/app/rides", tt.req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	req := &appPostRidesRequest{PickupCoordinate: testRideRequest.PickupCoordinate, DestinationPlaceID: &work.ID}
+	estimated := serveAsUser(t, appPostRidesEstimatedFare, user, http.MethodPost, "/api/app/rides/estimated-fare", req)
+	if estimated.Code != http.StatusOK {
+		t.Fatalf("estimated fare: status = %d, want %d: %s", estimated.Code, http.StatusOK, estimated.Body.String())
+	}
+	rec := serveAsUser(t, appPostRides, user, http.MethodPost, "/api/app/rides", req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body.String())
+	}
+	res := decodeBody[appPostRidesResponse](t, rec)
+	if fare := decodeBody[appPostRidesEstimatedFareResponse](t, estimated).Fare; res.Fare != fare {
+		t.Errorf("fare = %d, want estimated fare %d", res.Fare, fare)
+	}
+	ride, err := m.Rides().Get(context.Background(), res.RideID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ride.DestinationLatitude != work.Coordinate.Latitude || ride.DestinationLongitude != work.Coordinate.Longitude {
+		t.Errorf("destination = (%d, %d), want %+v", ride.DestinationLatitude, ride.DestinationLongitude, work.Coordinate)
+	}
+}
+
+func TestAppGetRecentDestinations(t *testing.T) {
+	m := newTestStore(t)
+	captureLogs(t)
+	user := addTestUser(t, m, "user1")
+	home := postTestPlace(t, user, placeKindHome, "", Coordinate{Latitude: 30, Longitude: 20})
+
+	// (30, 20) → (10, 10) → (30, 20) の順に要求する
+	for _, destination := range []Coordinate{{Latitude: 30, Longitude: 20}, {Latitude: 10, Longitude: 10}, {Latitude: 30, Longitude: 20}} {
+		rec := serveAsUser(t, appPostRides, user, http.MethodPost, "/api/app/rides", &appPostRidesRequest{PickupCoordinate: &Coordinate{}, DestinationCoordinate: &destination})
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body.String())
+		}
+		completeTestRide(t, m, decodeBody[appPostRidesResponse](t, rec).RideID)
+	}
+
+	rec := serveAsUser(t, appGetRecentDestinations, user, http.MethodGet, "/api/app/recent-destinations", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	destinations := decodeBody[appGetRecentDestinationsResponse](t, rec).Destinations
+	if len(destinations) != 2 {
+		t.Fatalf("destinations = %+v, want 2 destinations", destinations)
+	}
+	if got := destinations[0]; got.Coordinate != home.Coordinate || got.RideCount != 2 || got.PlaceID == nil || *got.PlaceID != home.ID {
+		t.Errorf("first destination = %+v, want home requested twice", got)
+	}
+	if got := destinations[1]; got.Coordinate != (Coordinate{Latitude: 10, Longitude: 10}) || got.RideCount != 1 || got.PlaceID != nil {
+		t.Errorf("second destination = %+v, want (10, 10) requested once", got)
+	}
+
+	rec = serveAsUser(t, appGetRecentDestinations, user, http.MethodGet, "/api/app/recent-destinations?limit=1", nil)
+	if destinations := decodeBody[appGetRecentDestinationsResponse](t, rec).Destinations; len(destinations) != 1 {
+		t.Errorf("limit=1: destinations = %d, want 1", len(destinations))
+	}
+	rec = serveAsUser(t, appGetRecentDestinations, user, http.MethodGet, "/api/app/recent-destinations?limit=0", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("limit=0: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,261 @@
+// Package auth はユーザー・オーナー・椅子の認証と、認証済みの利用者をリクエストのコンテキストで受け渡す仕組みを提供する。
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+type Role string
+
+const (
+	RoleApp   Role = "app"
+	RoleOwner Role = "owner"
+	RoleChair Role = "chair"
+	// RoleAdmin は運営スタッフ。セッションではなく設定した管理用トークンで認証する
+	RoleAdmin Role = "admin"
+)
+
+const (
+	SessionTTL = 24 * time.Hour
+	// 複数台構成でも失効がいずれ反映されるよう、キャッシュの有効期間を短くしておく
+	tokenCacheTTL = 10 * time.Second
+	// 一度しか使われなかったアクセストークンのエントリが残り続けないよう、この間隔で古いエントリを削除する
+	tokenCacheSweepInterval = 1 * time.Minute
+	// APIキーはセッションのアクセストークンと区別できるように接頭辞をつけて発行する
+	OwnerAPIKeyPrefix = "isuride_ak_"
+)
+
+var (
+	ErrInvalidToken = apierror.New(http.StatusUnauthorized, apierror.CodeInvalidAccessToken, "invalid access token")
+	// Storeが対象のデータを見つけられなかった場合に返す
+	ErrNotFound = errors.New("not found")
+)
+
+var cookieNames = map[Role]string{
+	RoleApp:   "app_session",
+	RoleOwner: "owner_session",
+	RoleChair: "chair_session",
+}
+
+func CookieName(role Role) string {
+	return cookieNames[role]
+}
+
+// Store は認証に必要なデータの取得・保存を行う
+type Store interface {
+	GetSession(ctx context.Context, token string) (*model.Session, error)
+	// セッション導入前に発行されたアクセストークンの利用者IDを返す
+	GetLegacyPrincipalID(ctx context.Context, role Role, token string) (string, error)
+	// 同じアクセストークンのセッションが登録済みであれば、既存のセッションを返す
+	CreateSessionIfNotExists(ctx context.Context, session *model.Session) (*model.Session, error)
+	GetUser(ctx context.Context, id string) (*model.User, error)
+	GetOwner(ctx context.Context, id string) (*model.Owner, error)
+	GetChair(ctx context.Context, id string) (*model.Chair, error)
+	GetOwnerAPIKeyByHash(ctx context.Context, keyHash string) (*model.OwnerAPIKey, error)
+}
+
+type Authenticator struct {
+	store      Store
+	cache      *sessionCache
+	writeError ErrorWriter
+	now        func() time.Time
+}
+
+// New はAuthenticatorを作成する。writeErrorがnilの場合はapierror.Writeでエラーを返す
+func New(store Store, writeError ErrorWriter) *Authenticator {
+	if writeError == nil {
+		writeError = apierror.Write
+	}
+	return &Authenticator{
+		store:      store,
+		cache:      &sessionCache{entries: map[string]*cachedSession{}},
+		writeError: writeError,
+		now:        time.Now,
+	}
+}
+
+// Authenticate はアクセストークンに対応するユーザー・オーナー・椅子を返す
+func (a *Authenticator) Authenticate(ctx context.Context, role Role, token string) (any, error) {
+	if cached, ok := a.cache.get(token, a.now()); ok {
+		if Role(cached.session.Role) != role || !a.now().Before(cached.session.ExpiresAt) {
+			return nil, ErrInvalidToken
+		}
+		return cached.principal, nil
+	}
+
+	session, err := a.store.GetSession(ctx, token)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		session, err = a.migrateLegacyToken(ctx, role, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if Role(session.Role) != role || session.RevokedAt != nil || !a.now().Before(session.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	principal, err := a.getPrincipal(ctx, role, session.PrincipalID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	a.cache.set(token, &cachedSession{
+		session:   session,
+		principal: principal,
+		cachedAt:  a.now(),
+	})
+	return principal, nil
+}
+
+func (a *Authenticator) getPrincipal(ctx context.Context, role Role, principalID string) (any, error) {
+	switch role {
+	case RoleApp:
+		return a.store.GetUser(ctx, principalID)
+	case RoleOwner:
+		return a.store.GetOwner(ctx, principalID)
+	case RoleChair:
+		return a.store.GetChair(ctx, principalID)
+	}
+	return nil, ErrInvalidToken
+}
+
+// セッション導入前に発行されたアクセストークンは、初回利用時にセッションとして登録する
+func (a *Authenticator) migrateLegacyToken(ctx context.Context, role Role, token string) (*model.Session, error) {
+	principalID, err := a.store.GetLegacyPrincipalID(ctx, role, token)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return a.store.CreateSessionIfNotExists(ctx, &model.Session{
+		Token:       token,
+		Role:        string(role),
+		PrincipalID: principalID,
+		ExpiresAt:   a.now().Add(SessionTTL),
+	})
+}
+
+// AuthenticateOwnerAPIKey はAPIキーに対応するオーナーとAPIキーの情報を返す
+func (a *Authenticator) AuthenticateOwnerAPIKey(ctx context.Context, key string) (*model.Owner, *model.OwnerAPIKey, error) {
+	apiKey, err := a.store.GetOwnerAPIKeyByHash(ctx, HashOwnerAPIKey(key))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	owner, err := a.store.GetOwner(ctx, apiKey.OwnerID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+	return owner, apiKey, nil
+}
+
+// Forget はログアウトなどで失効したアクセストークンをキャッシュから取り除く
+func (a *Authenticator) Forget(token string) {
+	a.cache.delete(token)
+}
+
+func (a *Authenticator) Clear() {
+	a.cache.clear()
+}
+
+func IsOwnerAPIKey(token string) bool {
+	return strings.HasPrefix(token, OwnerAPIKeyPrefix)
+}
+
+// APIキーそのものは保存せず、ハッシュ値で照合する
+func HashOwnerAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type cachedSession struct {
+	session   *model.Session
+	principal any
+	cachedAt  time.Time
+}
+
+func (c *cachedSession) stale(now time.Time) bool {
+	return now.Sub(c.cachedAt) > tokenCacheTTL
+}
+
+type sessionCache struct {
+	mu        sync.RWMutex
+	entries   map[string]*cachedSession
+	lastSweep time.Time
+}
+
+func (c *sessionCache) get(token string, now time.Time) (*cachedSession, bool) {
+	c.mu.RLock()
+	cached, ok := c.entries[token]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if cached.stale(now) {
+		c.mu.Lock()
+		// 読み出してから作り直されたエントリは消さない
+		if c.entries[token] == cached {
+			delete(c.entries, token)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	return cached, true
+}
+
+func (c *sessionCache) set(token string, cached *cachedSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[token] = cached
+	if cached.cachedAt.Sub(c.lastSweep) > tokenCacheSweepInterval {
+		c.sweep(cached.cachedAt)
+	}
+}
+
+func (c *sessionCache) sweep(now time.Time) {
+	c.lastSweep = now
+	for token, cached := range c.entries {
+		if cached.stale(now) {
+			delete(c.entries, token)
+		}
+	}
+}
+
+func (c *sessionCache) delete(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, token)
+}
+
+func (c *sessionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*cachedSession{}
+}
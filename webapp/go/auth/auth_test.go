@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+func TestAuthenticate_Expiry(t *testing.T) {
+	ctx := context.Background()
+	a, store := newTestAuthenticator(t)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	store.AddSession(&model.Session{Token: "short-token", Role: "app", PrincipalID: "user1", ExpiresAt: now.Add(5 * time.Second)})
+
+	if _, err := a.Authenticate(ctx, RoleApp, "short-token"); err != nil {
+		t.Fatal(err)
+	}
+	// キャッシュの有効期間内でも、セッションの有効期限を過ぎたら認証しない
+	now = now.Add(5 * time.Second)
+	if _, err := a.Authenticate(ctx, RoleApp, "short-token"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate after expiry = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestAuthenticate_CachedRevocation(t *testing.T) {
+	ctx := context.Background()
+	a, store := newTestAuthenticator(t)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	if _, err := a.Authenticate(ctx, RoleApp, "user-token"); err != nil {
+		t.Fatal(err)
+	}
+	revokedAt := now
+	store.AddSession(&model.Session{Token: "user-token", Role: "app", PrincipalID: "user1", ExpiresAt: now.Add(time.Hour), RevokedAt: &revokedAt})
+
+	// 他のサーバーで失効した場合、キャッシュの有効期間が過ぎるまでは認証できてしまう
+	if _, err := a.Authenticate(ctx, RoleApp, "user-token"); err != nil {
+		t.Errorf("Authenticate within cache TTL = %v, want nil", err)
+	}
+	now = now.Add(tokenCacheTTL + time.Second)
+	if _, err := a.Authenticate(ctx, RoleApp, "user-token"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate after cache TTL = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestSessionCache(t *testing.T) {
+	now := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	c := &sessionCache{entries: map[string]*cachedSession{}, lastSweep: now}
+	c.set("old", &cachedSession{cachedAt: now})
+	c.set("stale", &cachedSession{cachedAt: now})
+
+	// 古くなったエントリは読み出した時点で削除する
+	now = now.Add(tokenCacheTTL + time.Second)
+	if _, ok := c.get("stale", now); ok {
+		t.Error("stale entry should not be returned")
+	}
+	if _, ok := c.entries["stale"]; ok {
+		t.Error("stale entry should be evicted on read")
+	}
+
+	// 読み出されないエントリも、一定間隔ごとの掃除で削除する
+	now = now.Add(tokenCacheSweepInterval)
+	c.set("fresh", &cachedSession{cachedAt: now})
+	if _, ok := c.entries["old"]; ok {
+		t.Error("old entry should be swept")
+	}
+	if _, ok := c.get("fresh", now); !ok {
+		t.Error("fresh entry should be kept")
+	}
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	ownerKey
+	chairKey
+	ownerAPIKeyKey
+	principalKey
+)
+
+// Principal は認証済みの利用者のロールとID
+type Principal struct {
+	Role Role
+	ID   string
+}
+
+// WithPrincipalRecorder は認証に成功したときに利用者をpに書き込むよう設定する。
+// アクセスログのように認証より外側で動くミドルウェアが、認証済みの利用者を知るために使う
+func WithPrincipalRecorder(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+func recordPrincipal(ctx context.Context, role Role, id string) {
+	if p, ok := ctx.Value(principalKey).(*Principal); ok {
+		p.Role = role
+		p.ID = id
+	}
+}
+
+func WithUser(ctx context.Context, user *model.User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// UserFrom は認証済みのユーザーを返す
+func UserFrom(ctx context.Context) (*model.User, bool) {
+	user, ok := ctx.Value(userKey).(*model.User)
+	return user, ok && user != nil
+}
+
+func WithOwner(ctx context.Context, owner *model.Owner) context.Context {
+	return context.WithValue(ctx, ownerKey, owner)
+}
+
+// OwnerFrom は認証済みのオーナーを返す
+func OwnerFrom(ctx context.Context) (*model.Owner, bool) {
+	owner, ok := ctx.Value(ownerKey).(*model.Owner)
+	return owner, ok && owner != nil
+}
+
+func WithChair(ctx context.Context, chair *model.Chair) context.Context {
+	return context.WithValue(ctx, chairKey, chair)
+}
+
+// ChairFrom は認証済みの椅子を返す
+func ChairFrom(ctx context.Context) (*model.Chair, bool) {
+	chair, ok := ctx.Value(chairKey).(*model.Chair)
+	return chair, ok && chair != nil
+}
+
+func WithOwnerAPIKey(ctx context.Context, apiKey *model.OwnerAPIKey) context.Context {
+	return context.WithValue(ctx, ownerAPIKeyKey, apiKey)
+}
+
+// OwnerAPIKeyFrom はオーナーがAPIキーで認証された場合に、そのAPIキーの情報を返す
+func OwnerAPIKeyFrom(ctx context.Context) (*model.OwnerAPIKey, bool) {
+	apiKey, ok := ctx.Value(ownerAPIKeyKey).(*model.OwnerAPIKey)
+	return apiKey, ok && apiKey != nil
+}
+
+func hasRole(ctx context.Context, role Role) bool {
+	switch role {
+	case RoleApp:
+		_, ok := UserFrom(ctx)
+		return ok
+	case RoleOwner:
+		_, ok := OwnerFrom(ctx)
+		return ok
+	case RoleChair:
+		_, ok := ChairFrom(ctx)
+		return ok
+	}
+	return false
+}
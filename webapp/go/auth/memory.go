@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"sync"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+// MemoryStore はデータベースを使わずにメモリ上でデータを保持するStoreで、テストで利用する
+type MemoryStore struct {
+	mu           sync.RWMutex
+	sessions     map[string]*model.Session
+	users        map[string]*model.User
+	owners       map[string]*model.Owner
+	chairs       map[string]*model.Chair
+	ownerAPIKeys map[string]*model.OwnerAPIKey
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:     map[string]*model.Session{},
+		users:        map[string]*model.User{},
+		owners:       map[string]*model.Owner{},
+		chairs:       map[string]*model.Chair{},
+		ownerAPIKeys: map[string]*model.OwnerAPIKey{},
+	}
+}
+
+func (s *MemoryStore) AddSession(session *model.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.Token] = session
+}
+
+func (s *MemoryStore) AddUser(user *model.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+func (s *MemoryStore) AddOwner(owner *model.Owner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners[owner.ID] = owner
+}
+
+func (s *MemoryStore) AddChair(chair *model.Chair) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chairs[chair.ID] = chair
+}
+
+func (s *MemoryStore) AddOwnerAPIKey(apiKey *model.OwnerAPIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ownerAPIKeys[apiKey.KeyHash] = apiKey
+}
+
+func (s *MemoryStore) GetSession(_ context.Context, token string) (*model.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *MemoryStore) GetLegacyPrincipalID(_ context.Context, role Role, token string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch role {
+	case RoleApp:
+		for _, user := range s.users {
+			if user.AccessToken == token {
+				return user.ID, nil
+			}
+		}
+	case RoleOwner:
+		for _, owner := range s.owners {
+			if owner.AccessToken == token {
+				return owner.ID, nil
+			}
+		}
+	case RoleChair:
+		for _, chair := range s.chairs {
+			if chair.AccessToken == token {
+				return chair.ID, nil
+			}
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (s *MemoryStore) CreateSessionIfNotExists(_ context.Context, session *model.Session) (*model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.sessions[session.Token]; ok {
+		return existing, nil
+	}
+	s.sessions[session.Token] = session
+	return session, nil
+}
+
+func (s *MemoryStore) GetUser(_ context.Context, id string) (*model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) GetOwner(_ context.Context, id string) (*model.Owner, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owner, ok := s.owners[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return owner, nil
+}
+
+func (s *MemoryStore) GetChair(_ context.Context, id string) (*model.Chair, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chair, ok := s.chairs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return chair, nil
+}
+
+func (s *MemoryStore) GetOwnerAPIKeyByHash(_ context.Context, keyHash string) (*model.OwnerAPIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	apiKey, ok := s.ownerAPIKeys[keyHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return apiKey, nil
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+// ErrorWriter は認証に失敗した場合のエラーレスポンスを書き込む
+type ErrorWriter func(w http.ResponseWriter, statusCode int, err error)
+
+// TokenFromRequest はAuthorizationヘッダーのトークンを優先し、無ければCookieのトークンを返す
+func TokenFromRequest(r *http.Request, role Role) (string, bool) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		token, ok := strings.CutPrefix(authorization, "Bearer ")
+		return token, ok && token != ""
+	}
+	c, err := r.Cookie(CookieName(role))
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	return c.Value, true
+}
+
+// Middleware は指定したロールで認証し、認証済みの利用者をコンテキストに設定する。
+// オーナーはアクセストークンの代わりにAPIキーでも認証できる
+func (a *Authenticator) Middleware(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			token, ok := TokenFromRequest(r, role)
+			if !ok {
+				a.writeError(w, http.StatusUnauthorized, fmt.Errorf("%s cookie or Authorization header is required", CookieName(role)))
+				return
+			}
+
+			if role == RoleOwner && IsOwnerAPIKey(token) {
+				owner, apiKey, err := a.AuthenticateOwnerAPIKey(ctx, token)
+				if err != nil {
+					a.writeAuthError(w, err)
+					return
+				}
+				ctx = WithOwnerAPIKey(WithOwner(ctx, owner), apiKey)
+				recordPrincipal(ctx, role, owner.ID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			principal, err := a.Authenticate(ctx, role, token)
+			if err != nil {
+				a.writeAuthError(w, err)
+				return
+			}
+			switch p := principal.(type) {
+			case *model.User:
+				ctx = WithUser(ctx, p)
+				recordPrincipal(ctx, role, p.ID)
+			case *model.Owner:
+				ctx = WithOwner(ctx, p)
+				recordPrincipal(ctx, role, p.ID)
+			case *model.Chair:
+				ctx = WithChair(ctx, p)
+				recordPrincipal(ctx, role, p.ID)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AdminMiddleware はAuthorizationヘッダーの管理用トークンを検証する。
+// 管理用トークンは設定で与えられ、セッションやCookieは使わない
+func (a *Authenticator) AdminMiddleware(adminToken string) func(http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(adminToken))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				a.writeError(w, http.StatusUnauthorized, errors.New("admin token is required in Authorization header"))
+				return
+			}
+			// 比較にかかる時間からトークンを推測されないよう、ハッシュ値を定数時間で比較する
+			got := sha256.Sum256([]byte(token))
+			if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+				a.writeError(w, http.StatusUnauthorized, ErrInvalidToken)
+				return
+			}
+			recordPrincipal(r.Context(), RoleAdmin, string(RoleAdmin))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (a *Authenticator) writeAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidToken) {
+		a.writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	a.writeError(w, http.StatusInternalServerError, err)
+}
+
+// Require は指定したロールで認証済みのリクエストだけを通す
+func (a *Authenticator) Require(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasRole(r.Context(), role) {
+				a.writeError(w, http.StatusForbidden, fmt.Errorf("%s role is required", role))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope はAPIキーで認証された場合に、指定した操作が許可されているかを確認する
+func (a *Authenticator) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey, ok := OwnerAPIKeyFrom(r.Context()); ok && !apiKey.HasScope(scope) {
+				a.writeError(w, http.StatusForbidden, apierror.New(http.StatusForbidden, apierror.CodeAPIKeyScopeMissing, fmt.Sprintf("api key does not have %s scope", scope)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession はAPIキーでの認証を拒否する。ログアウトやAPIキーの管理はセッションでのみ行える
+func (a *Authenticator) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := OwnerAPIKeyFrom(r.Context()); ok {
+			a.writeError(w, http.StatusForbidden, errors.New("this operation is not allowed with api key"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+func newTestAuthenticator(t *testing.T) (*Authenticator, *MemoryStore) {
+	t.Helper()
+	store := NewMemoryStore()
+	store.AddUser(&model.User{ID: "user1", Username: "user1", AccessToken: "legacy-user"})
+	store.AddOwner(&model.Owner{ID: "owner1", Name: "owner1", AccessToken: "legacy-owner"})
+	store.AddChair(&model.Chair{ID: "chair1", OwnerID: "owner1", Name: "chair1", AccessToken: "legacy-chair"})
+
+	expiresAt := time.Now().Add(time.Hour)
+	store.AddSession(&model.Session{Token: "user-token", Role: "app", PrincipalID: "user1", ExpiresAt: expiresAt})
+	store.AddSession(&model.Session{Token: "owner-token", Role: "owner", PrincipalID: "owner1", ExpiresAt: expiresAt})
+	store.AddSession(&model.Session{Token: "chair-token", Role: "chair", PrincipalID: "chair1", ExpiresAt: expiresAt})
+	store.AddSession(&model.Session{Token: "expired-token", Role: "app", PrincipalID: "user1", ExpiresAt: time.Now().Add(-time.Hour)})
+	revokedAt := time.Now()
+	store.AddSession(&model.Session{Token: "revoked-token", Role: "app", PrincipalID: "user1", ExpiresAt: expiresAt, RevokedAt: &revokedAt})
+
+	store.AddOwnerAPIKey(&model.OwnerAPIKey{ID: "key1", OwnerID: "owner1", KeyHash: HashOwnerAPIKey(OwnerAPIKeyPrefix + "sales"), Scopes: "sales:read"})
+
+	return New(store, nil), store
+}
+
+// 認証済みの利用者のIDを返すハンドラー
+func principalHandler(role Role) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var id string
+		var ok bool
+		switch role {
+		case RoleApp:
+			var user *model.User
+			user, ok = UserFrom(ctx)
+			if ok {
+				id = user.ID
+			}
+		case RoleOwner:
+			var owner *model.Owner
+			owner, ok = OwnerFrom(ctx)
+			if ok {
+				id = owner.ID
+			}
+		case RoleChair:
+			var chair *model.Chair
+			chair, ok = ChairFrom(ctx)
+			if ok {
+				id = chair.ID
+			}
+		}
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(id))
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       Role
+		cookie     string
+		bearer     string
+		wantStatus int
+		wantID     string
+	}{
+		{name: "user with cookie", role: RoleApp, cookie: "user-token", wantStatus: http.StatusOK, wantID: "user1"},
+		{name: "owner with cookie", role: RoleOwner, cookie: "owner-token", wantStatus: http.StatusOK, wantID: "owner1"},
+		{name: "chair with cookie", role: RoleChair, cookie: "chair-token", wantStatus: http.StatusOK, wantID: "chair1"},
+		{name: "user with bearer", role: RoleApp, bearer: "user-token", wantStatus: http.StatusOK, wantID: "user1"},
+		{name: "owner with bearer", role: RoleOwner, bearer: "owner-token", wantStatus: http.StatusOK, wantID: "owner1"},
+		{name: "chair with bearer", role: RoleChair, bearer: "chair-token", wantStatus: http.StatusOK, wantID: "chair1"},
+		{name: "bearer takes precedence over cookie", role: RoleChair, cookie: "unknown", bearer: "chair-token", wantStatus: http.StatusOK, wantID: "chair1"},
+		{name: "legacy user token", role: RoleApp, cookie: "legacy-user", wantStatus: http.StatusOK, wantID: "user1"},
+		{name: "legacy owner token", role: RoleOwner, cookie: "legacy-owner", wantStatus: http.StatusOK, wantID: "owner1"},
+		{name: "legacy chair token", role: RoleChair, bearer: "legacy-chair", wantStatus: http.StatusOK, wantID: "chair1"},
+		{name: "owner with api key", role: RoleOwner, bearer: OwnerAPIKeyPrefix + "sales", wantStatus: http.StatusOK, wantID: "owner1"},
+		{name: "no token", role: RoleApp, wantStatus: http.StatusUnauthorized},
+		{name: "unknown token", role: RoleApp, cookie: "unknown", wantStatus: http.StatusUnauthorized},
+		{name: "expired token", role: RoleApp, cookie: "expired-token", wantStatus: http.StatusUnauthorized},
+		{name: "revoked token", role: RoleApp, cookie: "revoked-token", wantStatus: http.StatusUnauthorized},
+		{name: "token of another role", role: RoleChair, cookie: "user-token", wantStatus: http.StatusUnauthorized},
+		{name: "api key is only for owners", role: RoleApp, bearer: OwnerAPIKeyPrefix + "sales", wantStatus: http.StatusUnauthorized},
+		{name: "unknown api key", role: RoleOwner, bearer: OwnerAPIKeyPrefix + "unknown", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAuthenticator(t)
+			handler := a.Middleware(tt.role)(principalHandler(tt.role))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			recorded := &Principal{}
+			req = req.WithContext(WithPrincipalRecorder(req.Context(), recorded))
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CookieName(tt.role), Value: tt.cookie})
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantID != "" && rec.Body.String() != tt.wantID {
+				t.Errorf("principal = %q, want %q", rec.Body.String(), tt.wantID)
+			}
+			// 認証に失敗した場合は何も記録しない
+			want := Principal{}
+			if tt.wantID != "" {
+				want = Principal{Role: tt.role, ID: tt.wantID}
+			}
+			if *recorded != want {
+				t.Errorf("recorded principal = %+v, want %+v", *recorded, want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_Forget(t *testing.T) {
+	a, store := newTestAuthenticator(t)
+	handler := a.Middleware(RoleApp)(principalHandler(RoleApp))
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer user-token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+
+	revokedAt := time.Now()
+	store.AddSession(&model.Session{Token: "user-token", Role: "app", PrincipalID: "user1", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &revokedAt})
+	a.Forget("user-token")
+
+	if code := serve(); code != http.StatusUnauthorized {
+		t.Fatalf("status after revocation = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestRouteGuards(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		guard      func(http.Handler) http.Handler
+		bearer     string
+		wantStatus int
+	}{
+		{name: "require owner with session", guard: a.Require(RoleOwner), bearer: "owner-token", wantStatus: http.StatusOK},
+		{name: "require chair with owner", guard: a.Require(RoleChair), bearer: "owner-token", wantStatus: http.StatusForbidden},
+		{name: "scope with session", guard: a.RequireScope("chairs:write"), bearer: "owner-token", wantStatus: http.StatusOK},
+		{name: "scope granted to api key", guard: a.RequireScope("sales:read"), bearer: OwnerAPIKeyPrefix + "sales", wantStatus: http.StatusOK},
+		{name: "scope not granted to api key", guard: a.RequireScope("chairs:read"), bearer: OwnerAPIKeyPrefix + "sales", wantStatus: http.StatusForbidden},
+		{name: "session only with session", guard: a.RequireSession, bearer: "owner-token", wantStatus: http.StatusOK},
+		{name: "session only with api key", guard: a.RequireSession, bearer: OwnerAPIKeyPrefix + "sales", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := a.Middleware(RoleOwner)(tt.guard(ok))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	handler := a.AdminMiddleware("admin-secret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "admin token", authorization: "Bearer admin-secret", wantStatus: http.StatusOK},
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer admin-secreT", wantStatus: http.StatusUnauthorized},
+		{name: "session of another role", authorization: "Bearer owner-token", wantStatus: http.StatusUnauthorized},
+		{name: "not bearer", authorization: "admin-secret", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			recorded := &Principal{}
+			req = req.WithContext(WithPrincipalRecorder(req.Context(), recorded))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && recorded.Role != RoleAdmin {
+				t.Errorf("recorded principal = %+v, want admin", *recorded)
+			}
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
 )
 
 type chairPostChairsRequest struct {
@@ -32,10 +34,10 @@ func chairPostChairs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	owner := &Owner{}
-	if err := db.GetContext(ctx, owner, "SELECT * FROM owners WHERE chair_register_token = ?", req.ChairRegisterToken); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusUnauthorized, errors.New("invalid chair_register_token"))
+	owner, err := dataStore.Owners().GetByChairRegisterToken(ctx, req.ChairRegisterToken)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusUnauthorized, errInvalidChairRegisterToken)
 			return
 		}
 		writeError(w, http.StatusInternalServerError, err)
@@ -45,21 +47,37 @@ func chairPostChairs(w http.ResponseWriter, r *http.Request) {
 	chairID := ulid.Make().String()
 	accessToken := secureRandomStr(32)
 
-	_, err := db.ExecContext(
-		ctx,
-		"INSERT INTO chairs (id, owner_id, name, model, is_active, access_token) VALUES (?, ?, ?, ?, ?, ?)",
-		chairID, owner.ID, req.Name, req.Model, false, accessToken,
-	)
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	defer tx.Rollback()
 
-	http.SetCookie(w, &http.Cookie{
-		Path:  "/",
-		Name:  "chair_session",
-		Value: accessToken,
-	})
+	if err := tx.Chairs().Create(ctx, &Chair{
+		ID:          chairID,
+		OwnerID:     owner.ID,
+		Name:        req.Name,
+		Model:       req.Model,
+		IsActive:    false,
+		AccessToken: accessToken,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	session, err := createSession(ctx, tx.Sessions(), accessToken, auth.RoleChair, chairID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	setSessionCookie(w, r, session)
 
 	writeJSON(w, http.StatusCreated, &chairPostChairsResponse{
 		ID:      chairID,
@@ -73,7 +91,11 @@ type postChairActivityRequest struct {
 
 func chairPostActivity(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	chair := ctx.Value("chair").(*Chair)
+	chair, ok := auth.ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
 
 	req := &postChairActivityRequest{}
 	if err := bindJSON(r, req); err != nil {
@@ -81,11 +103,51 @@ func chairPostActivity(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.ExecContext(ctx, "UPDATE chairs SET is_active = ? WHERE id = ?", req.IsActive, chair.ID)
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	defer tx.Rollback()
+
+	if req.IsActive {
+		now := time.Now()
+
+		schedules, err := tx.Chairs().ListSchedules(ctx, chair.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if _, inShift := currentChairShiftStart(schedules, now); len(schedules) > 0 && !inShift {
+			writeError(w, http.StatusBadRequest, errOutsideWorkingHours)
+			return
+		}
+
+		policy, err := getChairBreakPolicy(ctx, tx)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		period, err := getLatestChairActivityPeriod(ctx, tx, chair.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if until, onBreak := chairBreakUntil(period, policy, now); onBreak {
+			writeError(w, http.StatusBadRequest, errChairOnBreak(until))
+			return
+		}
+	}
+
+	if err := setChairActivity(ctx, tx, chair.ID, req.IsActive, "CHAIR"); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -102,53 +164,95 @@ func chairPostCoordinate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chair := ctx.Value("chair").(*Chair)
+	chair, ok := auth.ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
 
-	tx, err := db.Beginx()
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
+	prevLocation, err := tx.Chairs().GetLatestLocation(ctx, chair.ID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		prevLocation = nil
+	}
+
 	chairLocationID := ulid.Make().String()
-	if _, err := tx.ExecContext(
-		ctx,
-		`INSERT INTO chair_locations (id, chair_id, latitude, longitude) VALUES (?, ?, ?, ?)`,
-		chairLocationID, chair.ID, req.Latitude, req.Longitude,
-	); err != nil {
+	if err := tx.Chairs().CreateLocation(ctx, &ChairLocation{
+		ID:        chairLocationID,
+		ChairID:   chair.ID,
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+	}); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	location := &ChairLocation{}
-	if err := tx.GetContext(ctx, location, `SELECT * FROM chair_locations WHERE id = ?`, chairLocationID); err != nil {
+	location, err := tx.Chairs().GetLocation(ctx, chairLocationID)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	ride := &Ride{}
-	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE chair_id = ? ORDER BY updated_at DESC LIMIT 1`, chair.ID); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
+	// 不審な移動は記録のみ行い、位置情報は受け付ける
+	if prevLocation != nil {
+		if err := checkChairMovement(ctx, tx, chair, prevLocation, location); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	// 最初にサービス提供地域内で記録された位置の地域を椅子の拠点とする
+	regionSet := false
+	if _, ok := chairsWithRegion.Load(chair.ID); !ok {
+		if region, ok := findRegion(req.Latitude, req.Longitude); ok {
+			if err := tx.Chairs().SetRegion(ctx, chair.ID, region.ID); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			regionSet = true
+		}
+	}
+
+	// 椅子が移動したことで変わったライドの状態
+	newStatus := ""
+	ride, err := tx.Rides().GetLatestByChair(ctx, chair.ID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 	} else {
-		status, err := getLatestRideStatus(ctx, tx, ride.ID)
+		status, err := tx.Rides().GetLatestStatus(ctx, ride.ID)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
-		if status != "COMPLETED" && status != "CANCELED" {
+		if !isRideFinished(status) {
 			if req.Latitude == ride.PickupLatitude && req.Longitude == ride.PickupLongitude && status == "ENROUTE" {
-				if _, err := tx.ExecContext(ctx, "INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)", ulid.Make().String(), ride.ID, "PICKUP"); err != nil {
+				if err := tx.Rides().CreateStatus(ctx, &RideStatus{ID: ulid.Make().String(), RideID: ride.ID, Status: "PICKUP"}); err != nil {
 					writeError(w, http.StatusInternalServerError, err)
 					return
 				}
+				newStatus = "PICKUP"
 			}
 
 			if req.Latitude == ride.DestinationLatitude && req.Longitude == ride.DestinationLongitude && status == "CARRYING" {
-				if _, err := tx.ExecContext(ctx, "INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)", ulid.Make().String(), ride.ID, "ARRIVED"); err != nil {
+				if err := tx.Rides().CreateStatus(ctx, &RideStatus{ID: ulid.Make().String(), RideID: ride.ID, Status: "ARRIVED"}); err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				newStatus = "ARRIVED"
+				if err := checkRideDetour(ctx, tx, ride); err != nil {
 					writeError(w, http.StatusInternalServerError, err)
 					return
 				}
@@ -160,6 +264,12 @@ func chairPostCoordinate(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	if regionSet {
+		chairsWithRegion.Store(chair.ID, struct{}{})
+	}
+	if newStatus != "" {
+		recordRideStatus(ctx, ride.ID, newStatus)
+	}
 
 	writeJSON(w, http.StatusOK, &chairPostCoordinateResponse{
 		RecordedAt: location.CreatedAt.UnixMilli(),
@@ -186,20 +296,23 @@ type chairGetNotificationResponseData struct {
 
 func chairGetNotification(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	chair := ctx.Value("chair").(*Chair)
+	chair, ok := auth.ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
 
-	tx, err := db.Beginx()
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
-	ride := &Ride{}
-	yetSentRideStatus := RideStatus{}
 	status := ""
 
-	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE chair_id = ? ORDER BY updated_at DESC LIMIT 1`, chair.ID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	ride, err := tx.Rides().GetLatestByChair(ctx, chair.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
 			writeJSON(w, http.StatusOK, &chairGetNotificationResponse{
 				RetryAfterMs: 30,
 			})
@@ -209,14 +322,14 @@ func chairGetNotification(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := tx.GetContext(ctx, &yetSentRideStatus, `SELECT * FROM ride_statuses WHERE ride_id = ? AND chair_sent_at IS NULL ORDER BY created_at ASC LIMIT 1`, ride.ID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			status, err = getLatestRideStatus(ctx, tx, ride.ID)
-			if err != nil {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-		} else {
+	yetSentRideStatus, err := tx.Rides().GetUnsentChairStatus(ctx, ride.ID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		status, err = tx.Rides().GetLatestStatus(ctx, ride.ID)
+		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -224,16 +337,14 @@ func chairGetNotification(w http.ResponseWriter, r *http.Request) {
 		status = yetSentRideStatus.Status
 	}
 
-	user := &User{}
-	err = tx.GetContext(ctx, user, "SELECT * FROM users WHERE id = ? FOR SHARE", ride.UserID)
+	user, err := tx.Users().Get(ctx, ride.UserID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if yetSentRideStatus.ID != "" {
-		_, err := tx.ExecContext(ctx, `UPDATE ride_statuses SET chair_sent_at = CURRENT_TIMESTAMP(6) WHERE id = ?`, yetSentRideStatus.ID)
-		if err != nil {
+	if yetSentRideStatus != nil {
+		if err := tx.Rides().MarkStatusSentToChair(ctx, yetSentRideStatus.ID); err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -273,7 +384,11 @@ func chairPostRideStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	rideID := r.PathValue("ride_id")
 
-	chair := ctx.Value("chair").(*Chair)
+	chair, ok := auth.ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
 
 	req := &postChairRidesRideIDStatusRequest{}
 	if err := bindJSON(r, req); err != nil {
@@ -281,17 +396,17 @@ func chairPostRideStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx, err := db.Beginx()
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	ride := &Ride{}
-	if err := tx.GetContext(ctx, ride, "SELECT * FROM rides WHERE id = ? FOR UPDATE", rideID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusNotFound, errors.New("ride not found"))
+	ride, err := tx.Rides().GetForUpdate(ctx, rideID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
 			return
 		}
 		writeError(w, http.StatusInternalServerError, err)
@@ -299,40 +414,48 @@ func chairPostRideStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if ride.ChairID.String != chair.ID {
-		writeError(w, http.StatusBadRequest, errors.New("not assigned to this ride"))
+		writeError(w, http.StatusBadRequest, errRideNotAssigned)
+		return
+	}
+
+	status, err := tx.Rides().GetLatestStatus(ctx, ride.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// 運営が完了・キャンセルさせたライドは進められない
+	if isRideFinished(status) {
+		writeError(w, http.StatusBadRequest, errRideFinished)
 		return
 	}
 
 	switch req.Status {
 	// Acknowledge the ride
 	case "ENROUTE":
-		if _, err := tx.ExecContext(ctx, "INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)", ulid.Make().String(), ride.ID, "ENROUTE"); err != nil {
+		if err := tx.Rides().CreateStatus(ctx, &RideStatus{ID: ulid.Make().String(), RideID: ride.ID, Status: "ENROUTE"}); err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 	// After Picking up user
 	case "CARRYING":
-		status, err := getLatestRideStatus(ctx, tx, ride.ID)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
-			return
-		}
 		if status != "PICKUP" {
-			writeError(w, http.StatusBadRequest, errors.New("chair has not arrived yet"))
+			writeError(w, http.StatusBadRequest, errChairNotArrived)
 			return
 		}
-		if _, err := tx.ExecContext(ctx, "INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)", ulid.Make().String(), ride.ID, "CARRYING"); err != nil {
+		if err := tx.Rides().CreateStatus(ctx, &RideStatus{ID: ulid.Make().String(), RideID: ride.ID, Status: "CARRYING"}); err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 	default:
-		writeError(w, http.StatusBadRequest, errors.New("invalid status"))
+		writeError(w, http.StatusBadRequest, errInvalidRideStatus)
+		return
 	}
 
 	if err := tx.Commit(); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	recordRideStatus(ctx, ride.ID, req.Status)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+)
+
+func serveAsChair(t *testing.T, handler http.HandlerFunc, chair *Chair, method, target string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveAs(t, handler, func(ctx context.Context) context.Context { return auth.WithChair(ctx, chair) }, method, target, nil, body)
+}
+
+func TestChairPostCoordinate(t *testing.T) {
+	ctx := context.Background()
+	m := newTestStore(t)
+	m.AddChairModel(ChairModel{Name: "リラックスシート NEO", Speed: 5})
+	addTestOwner(t, m, "owner1", "chair1")
+	chair, err := m.Chairs().Get(ctx, "chair1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := addTestUser(t, m, "user1")
+	if err := m.Rides().Create(ctx, &Ride{ID: "ride1", UserID: user.ID, PickupLatitude: 100, PickupLongitude: 100, DestinationLatitude: 110, DestinationLongitude: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AssignChair("ride1", chair.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Rides().CreateStatus(ctx, &RideStatus{ID: "status1", RideID: "ride1", Status: "ENROUTE"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, coordinate := range []Coordinate{{Latitude: 0, Longitude: 0}, {Latitude: 100, Longitude: 100}} {
+		rec := serveAsChair(t, chairPostCoordinate, chair, http.MethodPost, "/api/chair/coordinate", &coordinate)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+
+	latest, err := m.Chairs().GetLatestLocation(ctx, chair.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Latitude != 100 || latest.Longitude != 100 {
+		t.Errorf("latest location = (%d, %d), want (100, 100)", latest.Latitude, latest.Longitude)
+	}
+
+	// 乗車位置に着いたので、ライドは乗車待ちになる
+	if status, err := m.Rides().GetLatestStatus(ctx, "ride1"); err != nil || status != "PICKUP" {
+		t.Errorf("ride status = %q, %v, want PICKUP", status, err)
+	}
+
+	// 距離200を一度に移動したので、瞬間移動として記録する
+	flags, err := m.Chairs().ListMovementFlags(ctx, chair.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 1 || flags[0].Kind != "TELEPORT" || flags[0].Distance != 200 {
+		t.Errorf("flags = %+v, want one TELEPORT flag with distance 200", flags)
+	}
+}
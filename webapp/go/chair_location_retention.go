@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+const chairLocationCompactionInterval = 1 * time.Minute
+
+// 保持期間を過ぎた椅子の位置情報を定期的に集計・削除する
+func runChairLocationCompactor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			retention, err := getIntSetting(ctx, dataStore, "chair_location_retention_seconds")
+			if err != nil {
+				slog.Error("failed to get chair location retention", "error", err)
+				continue
+			}
+			// 0以下の場合は位置情報をすべて保持する
+			if retention <= 0 {
+				continue
+			}
+			if err := compactChairLocations(ctx, time.Now().Add(-time.Duration(retention)*time.Second)); err != nil {
+				slog.Error("failed to compact chair locations", "error", err)
+			}
+		}
+	}
+}
+
+func compactChairLocations(ctx context.Context, cutoff time.Time) error {
+	chairIDs, err := dataStore.Chairs().ListIDsWithLocationsBefore(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+
+	for _, chairID := range chairIDs {
+		if err := compactChairLocationsOf(ctx, chairID, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cutoffより前の位置情報のうち最後の1件だけを残して削除する。
+// 削除した区間の移動距離は椅子ごとの集計に、ライド中の位置はライドごとの移動経路に移す。
+// 最後の1件を残しておくことで、次に記録された位置との間の移動距離が失われない。
+func compactChairLocationsOf(ctx context.Context, chairID string, cutoff time.Time) error {
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	locations, err := tx.Chairs().ListLocationsBeforeForUpdate(ctx, chairID, cutoff)
+	if err != nil {
+		return err
+	}
+	if len(locations) <= 1 {
+		return nil
+	}
+	pruned := locations[:len(locations)-1]
+	boundary := locations[len(locations)-1]
+
+	distance := 0
+	for i := 1; i < len(locations); i++ {
+		distance += calculateDistance(locations[i-1].Latitude, locations[i-1].Longitude, locations[i].Latitude, locations[i].Longitude)
+	}
+	if err := tx.Chairs().AddTotalDistance(ctx, chairID, distance); err != nil {
+		return err
+	}
+
+	rides, err := tx.Rides().ListByChairCreatedBefore(ctx, chairID, cutoff)
+	if err != nil {
+		return err
+	}
+	for _, ride := range rides {
+		start, end, err := getRideTravelPeriod(ctx, tx, ride.ID)
+		if err != nil {
+			return err
+		}
+		if start == nil {
+			continue
+		}
+
+		points := []Coordinate{}
+		for _, location := range pruned {
+			if location.CreatedAt.Before(*start) || (end != nil && location.CreatedAt.After(*end)) {
+				continue
+			}
+			points = append(points, Coordinate{Latitude: location.Latitude, Longitude: location.Longitude})
+		}
+		if len(points) == 0 {
+			continue
+		}
+		if err := appendRideRoute(ctx, tx, ride.ID, chairID, points); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Chairs().DeleteLocationsBefore(ctx, chairID, cutoff, boundary.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// 椅子がライドの乗車位置に向かい始めてから目的地に到着するまでの期間を返す
+func getRideTravelPeriod(ctx context.Context, tx store.Queries, rideID string) (*time.Time, *time.Time, error) {
+	rideStatuses, err := tx.Rides().ListStatuses(ctx, rideID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var start, end *time.Time
+	for i := range rideStatuses {
+		switch rideStatuses[i].Status {
+		case "ENROUTE":
+			start = &rideStatuses[i].CreatedAt
+		case "ARRIVED":
+			end = &rideStatuses[i].CreatedAt
+		}
+	}
+	return start, end, nil
+}
+
+func getRideRoute(ctx context.Context, tx store.Queries, rideID string) ([]Coordinate, error) {
+	route, err := tx.Rides().GetRoute(ctx, rideID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return []Coordinate{}, nil
+		}
+		return nil, err
+	}
+
+	points := []Coordinate{}
+	if err := json.Unmarshal([]byte(route.Polyline), &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+func appendRideRoute(ctx context.Context, tx store.Queries, rideID, chairID string, points []Coordinate) error {
+	route, err := getRideRoute(ctx, tx, rideID)
+	if err != nil {
+		return err
+	}
+	route = append(route, points...)
+
+	polyline, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+
+	return tx.Rides().SaveRoute(ctx, &RideRoute{
+		RideID:   rideID,
+		ChairID:  chairID,
+		Polyline: string(polyline),
+		Distance: calculatePathDistance(route),
+	})
+}
+
+func calculatePathDistance(points []Coordinate) int {
+	distance := 0
+	for i := 1; i < len(points); i++ {
+		distance += calculateDistance(points[i-1].Latitude, points[i-1].Longitude, points[i].Latitude, points[i].Longitude)
+	}
+	return distance
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+const chairSchedulerInterval = 10 * time.Second
+
+// 稼働スケジュールの曜日と時刻は、サーバーのタイムゾーンによらず日本時間として扱う
+var chairScheduleLocation = time.FixedZone("JST", 9*60*60)
+
+type chairBreakPolicy struct {
+	AfterRides    int
+	AfterDistance int
+	Duration      time.Duration
+}
+
+func (p chairBreakPolicy) enabled() bool {
+	return p.AfterRides > 0 || p.AfterDistance > 0
+}
+
+func getChairBreakPolicy(ctx context.Context, tx store.Queries) (chairBreakPolicy, error) {
+	afterRides, err := getIntSetting(ctx, tx, "chair_break_after_rides")
+	if err != nil {
+		return chairBreakPolicy{}, err
+	}
+	afterDistance, err := getIntSetting(ctx, tx, "chair_break_after_distance")
+	if err != nil {
+		return chairBreakPolicy{}, err
+	}
+	minutes, err := getIntSetting(ctx, tx, "chair_break_minutes")
+	if err != nil {
+		return chairBreakPolicy{}, err
+	}
+	return chairBreakPolicy{
+		AfterRides:    afterRides,
+		AfterDistance: afterDistance,
+		Duration:      time.Duration(minutes) * time.Minute,
+	}, nil
+}
+
+// 設定が存在しない場合は0として扱う
+func getIntSetting(ctx context.Context, q store.Queries, name string) (int, error) {
+	value, err := q.Settings().Get(ctx, name)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// 現在時刻を含むスケジュールの稼働開始時刻を返す
+func currentChairShiftStart(schedules []ChairSchedule, now time.Time) (time.Time, bool) {
+	now = now.In(chairScheduleLocation)
+	minute := now.Hour()*60 + now.Minute()
+	for _, s := range schedules {
+		if s.DayOfWeek != int(now.Weekday()) {
+			continue
+		}
+		if s.StartMinute <= minute && minute < s.EndMinute {
+			year, month, day := now.Date()
+			return time.Date(year, month, day, 0, s.StartMinute, 0, 0, now.Location()), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 配車受付期間の履歴が無い場合はnilを返す
+func getLatestChairActivityPeriod(ctx context.Context, tx store.Queries, chairID string) (*ChairActivityPeriod, error) {
+	period, err := tx.Chairs().GetLatestActivityPeriod(ctx, chairID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return period, nil
+}
+
+// 休憩中であれば休憩終了時刻を返す
+func chairBreakUntil(period *ChairActivityPeriod, policy chairBreakPolicy, now time.Time) (time.Time, bool) {
+	if period == nil || period.EndedAt == nil || period.EndReason == nil || *period.EndReason != "BREAK" {
+		return time.Time{}, false
+	}
+	until := period.EndedAt.Add(policy.Duration)
+	return until, now.Before(until)
+}
+
+// 配車受付を開始してから連続で完了したライドの数・距離が上限に達していれば休憩が必要
+func needsChairBreak(ctx context.Context, tx store.Queries, chairID string, period *ChairActivityPeriod, policy chairBreakPolicy) (bool, error) {
+	if !policy.enabled() || period == nil || period.EndedAt != nil {
+		return false, nil
+	}
+
+	rides, err := tx.Rides().ListCompletedByChairSince(ctx, chairID, period.StartedAt)
+	if err != nil {
+		return false, err
+	}
+
+	distance := 0
+	for _, ride := range rides {
+		distance += calculateDistance(ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude)
+	}
+
+	if policy.AfterRides > 0 && len(rides) >= policy.AfterRides {
+		return true, nil
+	}
+	if policy.AfterDistance > 0 && distance >= policy.AfterDistance {
+		return true, nil
+	}
+	return false, nil
+}
+
+// 椅子の配車受付状態を更新し、配車受付期間の履歴を記録する。受付を停止した場合はオーナーに通知する
+func setChairActivity(ctx context.Context, tx store.Queries, chairID string, isActive bool, reason string) error {
+	changed, err := tx.Chairs().SetActive(ctx, chairID, isActive)
+	if err != nil {
+		return err
+	}
+	if !isActive && changed {
+		if err := publishChairWebhookEvent(ctx, tx, chairID, webhookEventChairDeactivated, &webhookChairDeactivatedData{
+			ChairID: chairID,
+			Reason:  reason,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if isActive {
+		return tx.Chairs().StartActivityPeriod(ctx, ulid.Make().String(), chairID)
+	}
+	return tx.Chairs().EndActivityPeriod(ctx, chairID, reason)
+}
+
+// スケジュールと休憩ルールに従って椅子の配車受付状態を定期的に切り替える
+func runChairScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := runChairSchedulerOnce(ctx, time.Now()); err != nil {
+				slog.Error("failed to run chair scheduler", "error", err)
+			}
+		}
+	}
+}
+
+func runChairSchedulerOnce(ctx context.Context, now time.Time) error {
+	chairs, err := dataStore.Chairs().List(ctx)
+	if err != nil {
+		return err
+	}
+	policy, err := getChairBreakPolicy(ctx, dataStore)
+	if err != nil {
+		return err
+	}
+	scheduledIDs, err := dataStore.Chairs().ListIDsWithSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	scheduled := map[string]bool{}
+	for _, chairID := range scheduledIDs {
+		scheduled[chairID] = true
+	}
+
+	for _, chair := range chairs {
+		if !policy.enabled() && !scheduled[chair.ID] {
+			skip, err := canSkipChairScheduling(ctx, &chair)
+			if err != nil {
+				slog.Error("failed to schedule chair", "chair_id", chair.ID, "error", err)
+				continue
+			}
+			if skip {
+				continue
+			}
+		}
+		// 1台の椅子で失敗しても、他の椅子の切り替えは続ける
+		if err := scheduleChair(ctx, chair.ID, now); err != nil {
+			slog.Error("failed to schedule chair", "chair_id", chair.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// 稼働スケジュールが無く休憩ルールも無効な椅子で切り替えが必要になるのは休憩明けの再開だけなので、
+// それ以外の椅子はロックを取らずに読み飛ばす
+func canSkipChairScheduling(ctx context.Context, chair *Chair) (bool, error) {
+	if chair.IsActive {
+		return true, nil
+	}
+	period, err := getLatestChairActivityPeriod(ctx, dataStore, chair.ID)
+	if err != nil {
+		return false, err
+	}
+	return period == nil || period.EndReason == nil || *period.EndReason != "BREAK", nil
+}
+
+func scheduleChair(ctx context.Context, chairID string, now time.Time) error {
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	chair, err := tx.Chairs().GetForUpdate(ctx, chairID)
+	if err != nil {
+		return err
+	}
+
+	policy, err := getChairBreakPolicy(ctx, tx)
+	if err != nil {
+		return err
+	}
+	schedules, err := tx.Chairs().ListSchedules(ctx, chair.ID)
+	if err != nil {
+		return err
+	}
+	period, err := getLatestChairActivityPeriod(ctx, tx, chair.ID)
+	if err != nil {
+		return err
+	}
+
+	shiftStart, inShift := currentChairShiftStart(schedules, now)
+	hasSchedule := len(schedules) > 0
+
+	if chair.IsActive {
+		switch {
+		case hasSchedule && !inShift:
+			if err := setChairActivity(ctx, tx, chair.ID, false, "SCHEDULE"); err != nil {
+				return err
+			}
+		case period == nil || period.EndedAt != nil:
+			// 履歴が無いまま受付中になっている椅子は、ここから期間の記録を始める
+			if err := setChairActivity(ctx, tx, chair.ID, true, ""); err != nil {
+				return err
+			}
+		default:
+			needsBreak, err := needsChairBreak(ctx, tx, chair.ID, period, policy)
+			if err != nil {
+				return err
+			}
+			if needsBreak {
+				if err := setChairActivity(ctx, tx, chair.ID, false, "BREAK"); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Commit()
+	}
+
+	if _, onBreak := chairBreakUntil(period, policy, now); onBreak {
+		return tx.Commit()
+	}
+
+	activate := false
+	if hasSchedule && inShift {
+		// 手動で受付を停止した椅子は、次の稼働時間帯まで自動で再開しない
+		activate = period == nil || period.EndedAt == nil || period.EndedAt.Before(shiftStart) ||
+			period.EndReason == nil || *period.EndReason != "CHAIR"
+	} else if !hasSchedule && period != nil && period.EndReason != nil && *period.EndReason == "BREAK" {
+		activate = true
+	}
+	if activate {
+		if err := setChairActivity(ctx, tx, chair.ID, true, ""); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCurrentChairShiftStart(t *testing.T) {
+	// 月曜日の9:00-18:00 (日本時間)
+	schedules := []ChairSchedule{{DayOfWeek: 1, StartMinute: 9 * 60, EndMinute: 18 * 60}}
+	shiftStart := time.Date(2024, 12, 2, 9, 0, 0, 0, chairScheduleLocation)
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		want    time.Time
+		inShift bool
+	}{
+		{name: "start of shift", now: shiftStart, want: shiftStart, inShift: true},
+		{name: "utc time is converted to jst", now: time.Date(2024, 12, 2, 3, 30, 0, 0, time.UTC), want: shiftStart, inShift: true},
+		{name: "end of shift is excluded", now: time.Date(2024, 12, 2, 18, 0, 0, 0, chairScheduleLocation), inShift: false},
+		{name: "monday in utc is tuesday in jst", now: time.Date(2024, 12, 2, 23, 0, 0, 0, time.UTC), inShift: false},
+		{name: "another day of week", now: time.Date(2024, 12, 3, 10, 0, 0, 0, chairScheduleLocation), inShift: false},
+	}
+	for _, tt := range tests {
+		got, inShift := currentChairShiftStart(schedules, tt.now)
+		if inShift != tt.inShift || !got.Equal(tt.want) {
+			t.Errorf("%s: currentChairShiftStart = (%s, %t), want (%s, %t)", tt.name, got, inShift, tt.want, tt.inShift)
+		}
+	}
+}
+
+func TestChairBreakUntil(t *testing.T) {
+	now := time.Date(2024, 12, 2, 12, 0, 0, 0, chairScheduleLocation)
+	policy := chairBreakPolicy{AfterRides: 3, Duration: 30 * time.Minute}
+	endedPeriod := func(endedAt time.Time, reason string) *ChairActivityPeriod {
+		return &ChairActivityPeriod{StartedAt: endedAt.Add(-time.Hour), EndedAt: &endedAt, EndReason: &reason}
+	}
+
+	tests := []struct {
+		name    string
+		period  *ChairActivityPeriod
+		want    time.Time
+		onBreak bool
+	}{
+		{name: "no period", period: nil},
+		{name: "active period", period: &ChairActivityPeriod{StartedAt: now.Add(-time.Hour)}},
+		{name: "stopped by chair", period: endedPeriod(now.Add(-10*time.Minute), "CHAIR")},
+		{name: "on break", period: endedPeriod(now.Add(-10*time.Minute), "BREAK"), want: now.Add(20 * time.Minute), onBreak: true},
+		{name: "break is over", period: endedPeriod(now.Add(-30*time.Minute), "BREAK"), want: now},
+	}
+	for _, tt := range tests {
+		got, onBreak := chairBreakUntil(tt.period, policy, now)
+		if onBreak != tt.onBreak || !got.Equal(tt.want) {
+			t.Errorf("%s: chairBreakUntil = (%s, %t), want (%s, %t)", tt.name, got, onBreak, tt.want, tt.onBreak)
+		}
+	}
+}
+
+func TestNeedsChairBreak(t *testing.T) {
+	ctx := context.Background()
+	m := newTestStore(t)
+	addTestOwner(t, m, "owner1", "chair1")
+	user := addTestUser(t, m, "user1")
+
+	completeRide := func(id string, destinationLatitude, destinationLongitude int) {
+		t.Helper()
+		if err := m.Rides().Create(ctx, &Ride{ID: id, UserID: user.ID, DestinationLatitude: destinationLatitude, DestinationLongitude: destinationLongitude}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.AssignChair(id, "chair1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Rides().CreateStatus(ctx, &RideStatus{ID: id + "-COMPLETED", RideID: id, Status: "COMPLETED"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 配車受付を開始する前に完了したライドは数えない
+	completeRide("before", 100, 100)
+	period := &ChairActivityPeriod{ChairID: "chair1", StartedAt: time.Now()}
+	completeRide("ride1", 10, 0)
+	completeRide("ride2", 0, 5)
+
+	endedAt := time.Now()
+	tests := []struct {
+		name   string
+		period *ChairActivityPeriod
+		policy chairBreakPolicy
+		want   bool
+	}{
+		{name: "policy disabled", period: period, policy: chairBreakPolicy{}, want: false},
+		{name: "ride count reached", period: period, policy: chairBreakPolicy{AfterRides: 2}, want: true},
+		{name: "ride count not reached", period: period, policy: chairBreakPolicy{AfterRides: 3}, want: false},
+		{name: "distance reached", period: period, policy: chairBreakPolicy{AfterDistance: 15}, want: true},
+		{name: "distance not reached", period: period, policy: chairBreakPolicy{AfterDistance: 16}, want: false},
+		{name: "no active period", period: nil, policy: chairBreakPolicy{AfterRides: 1}, want: false},
+		{name: "period already ended", period: &ChairActivityPeriod{ChairID: "chair1", StartedAt: period.StartedAt, EndedAt: &endedAt}, policy: chairBreakPolicy{AfterRides: 1}, want: false},
+	}
+	for _, tt := range tests {
+		got, err := needsChairBreak(ctx, m, "chair1", tt.period, tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: needsChairBreak = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunChairSchedulerOnce(t *testing.T) {
+	ctx := context.Background()
+	m := newTestStore(t)
+	addTestOwner(t, m, "owner1", "scheduled", "unscheduled")
+	for _, chairID := range []string{"scheduled", "unscheduled"} {
+		if _, err := m.Chairs().SetActive(ctx, chairID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 月曜日の9:00-18:00のみ稼働する
+	if err := m.Chairs().ReplaceSchedules(ctx, "scheduled", []ChairSchedule{{ID: "schedule1", DayOfWeek: 1, StartMinute: 9 * 60, EndMinute: 18 * 60}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 火曜日の10:00 (日本時間)
+	if err := runChairSchedulerOnce(ctx, time.Date(2024, 12, 3, 1, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduled, err := m.Chairs().Get(ctx, "scheduled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheduled.IsActive {
+		t.Error("chair out of its schedule should be deactivated")
+	}
+	// 休憩ルールが無効でスケジュールも無い椅子は読み飛ばし、配車受付期間の記録も始めない
+	unscheduled, err := m.Chairs().Get(ctx, "unscheduled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unscheduled.IsActive {
+		t.Error("chair without schedules should stay active")
+	}
+	if period, err := getLatestChairActivityPeriod(ctx, m, "unscheduled"); err != nil || period != nil {
+		t.Errorf("unscheduled chair period = %+v, %v; want nil", period, err)
+	}
+}
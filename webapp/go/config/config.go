@@ -0,0 +1,601 @@
+// Package config はアプリケーションの設定を読み込む。
+// 設定はデフォルト値、ISUCON_CONFIG_FILE で指定したYAMLファイル、環境変数の順に上書きされる。
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/ratelimit"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/sqlload"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/telemetry"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/validation"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv は設定ファイルのパスを指定する環境変数
+const FileEnv = "ISUCON_CONFIG_FILE"
+
+const redacted = "[REDACTED]"
+
+type Config struct {
+	ListenAddr string `yaml:"listen_addr"`
+	// debug, info, warn, error のいずれか
+	LogLevel string `yaml:"log_level"`
+	// アプリケーションのログの形式。json または text。アクセスログと監査ログは常にJSONで出力する
+	LogFormat string `yaml:"log_format"`
+	// アクセスログと監査ログの出力先のファイル。空の場合は標準出力に出力する
+	AccessLogPath string          `yaml:"access_log_path"`
+	AuditLogPath  string          `yaml:"audit_log_path"`
+	Server        ServerConfig    `yaml:"server"`
+	DB            DBConfig        `yaml:"db"`
+	Payment       PaymentConfig   `yaml:"payment"`
+	Matching      MatchingConfig  `yaml:"matching"`
+	Chair         ChairConfig     `yaml:"chair"`
+	Webhook       WebhookConfig   `yaml:"webhook"`
+	RateLimit     RateLimitConfig `yaml:"rate_limit"`
+	// SIGTERMを受け取ってから進行中のライドの完了を待つ最大時間
+	DrainTimeout time.Duration    `yaml:"drain_timeout"`
+	OpenAPI      OpenAPIConfig    `yaml:"openapi"`
+	Telemetry    TelemetryConfig  `yaml:"telemetry"`
+	Admin        AdminConfig      `yaml:"admin"`
+	Initialize   InitializeConfig `yaml:"initialize"`
+}
+
+// ServerConfig はHTTPサーバーのタイムアウト。0の場合はタイムアウトしない
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// 停止時に処理中のリクエストの完了を待つ時間。過ぎた場合は接続を強制的に閉じる
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type DBConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+
+	// 参照系のクエリに使うリードレプリカのDSN。空の場合はプライマリを使う
+	ReplicaDSN string `yaml:"replica_dsn"`
+	// レプリカの遅延がこれを超えている間はプライマリを使う
+	ReplicaMaxLag time.Duration `yaml:"replica_max_lag"`
+}
+
+type PaymentConfig struct {
+	// 1回のリクエストのタイムアウト
+	Timeout       time.Duration `yaml:"timeout"`
+	MaxRetries    int           `yaml:"max_retries"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+type MatchingConfig struct {
+	// アプリケーション内でマッチングを行う間隔。0の場合は /api/internal/matching を外部から叩かせる
+	Interval time.Duration `yaml:"interval"`
+}
+
+type ChairConfig struct {
+	// 椅子が1回の移動でモデルの速度分の距離を進む間隔。到着予定時刻の見積もりと瞬間移動の検知に使う
+	MoveInterval time.Duration `yaml:"move_interval"`
+}
+
+type WebhookConfig struct {
+	// 未配送のWebhookを確認して送信する間隔
+	Interval time.Duration `yaml:"interval"`
+	// 1回の送信のタイムアウト
+	Timeout time.Duration `yaml:"timeout"`
+	// 送信に失敗し続けた場合に諦めるまでの送信回数
+	MaxAttempts int `yaml:"max_attempts"`
+	// 最初の再送までの間隔。再送するたびに倍にし、MaxRetryIntervalで頭打ちにする
+	RetryInterval    time.Duration `yaml:"retry_interval"`
+	MaxRetryInterval time.Duration `yaml:"max_retry_interval"`
+}
+
+type RateLimitConfig struct {
+	// X-Real-IP と X-Forwarded-For を信用するプロキシのIPアドレスまたはCIDR。
+	// 他からの接続ではヘッダーを無視し、接続元のアドレスでクライアントを識別する
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type OpenAPIConfig struct {
+	// 空の場合はバイナリに埋め込んだ仕様を使う
+	Path       string          `yaml:"path"`
+	Validation validation.Mode `yaml:"validation"`
+}
+
+type TelemetryConfig struct {
+	ServiceName string             `yaml:"service_name"`
+	Exporter    telemetry.Exporter `yaml:"exporter"`
+}
+
+type AdminConfig struct {
+	// 管理APIの認証に使うトークン。空の場合は管理APIを提供しない
+	Token string `yaml:"token"`
+}
+
+type InitializeConfig struct {
+	// スキーマと初期データのSQLファイルを置いたディレクトリ
+	SQLDir string `yaml:"sql_dir"`
+	// POST /api/initialize でデータセットが指定されなかった場合に読み込むデータセット
+	Dataset string `yaml:"dataset"`
+}
+
+// 推測されにくいよう、管理用トークンにはこの長さ以上を求める
+const minAdminTokenLength = 16
+
+func Default() *Config {
+	return &Config{
+		ListenAddr: ":8080",
+		LogLevel:   "info",
+		LogFormat:  "json",
+		Server: ServerConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       10 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   10 * time.Second,
+		},
+		DB: DBConfig{
+			Host:            "127.0.0.1",
+			Port:            3306,
+			User:            "isucon",
+			Password:        "isucon",
+			Name:            "isuride",
+			MaxOpenConns:    64,
+			MaxIdleConns:    64,
+			ConnMaxLifetime: 5 * time.Minute,
+			ConnMaxIdleTime: 1 * time.Minute,
+			ReplicaMaxLag:   1 * time.Second,
+		},
+		Payment: PaymentConfig{
+			Timeout:       5 * time.Second,
+			MaxRetries:    5,
+			RetryInterval: 100 * time.Millisecond,
+		},
+		Chair: ChairConfig{
+			MoveInterval: 30 * time.Millisecond,
+		},
+		Webhook: WebhookConfig{
+			Interval:         1 * time.Second,
+			Timeout:          5 * time.Second,
+			MaxAttempts:      8,
+			RetryInterval:    10 * time.Second,
+			MaxRetryInterval: 1 * time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			TrustedProxies: []string{"127.0.0.1", "::1"},
+		},
+		DrainTimeout: 30 * time.Second,
+		OpenAPI: OpenAPIConfig{
+			Validation: validation.ModeRequest,
+		},
+		Telemetry: TelemetryConfig{
+			ServiceName: "isuride-go",
+			Exporter:    telemetry.ExporterAuto,
+		},
+		Initialize: InitializeConfig{
+			SQLDir:  "../sql",
+			Dataset: sqlload.DefaultDataset,
+		},
+	}
+}
+
+// Load は設定を読み込み、検証する
+func Load() (*Config, error) {
+	return load(os.LookupEnv)
+}
+
+func load(lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+	if path, ok := lookupEnv(FileEnv); ok && path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	// 設定項目の書き間違いに気付けるよう、存在しない項目はエラーにする
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+type envVar struct {
+	name string
+	set  func(value string) error
+}
+
+func (c *Config) envVars() []envVar {
+	return []envVar{
+		{"ISUCON_LISTEN_ADDR", setString(&c.ListenAddr)},
+		{"ISUCON_LOG_LEVEL", setString(&c.LogLevel)},
+		{"ISUCON_LOG_FORMAT", setString(&c.LogFormat)},
+		{"ISUCON_ACCESS_LOG_PATH", setString(&c.AccessLogPath)},
+		{"ISUCON_AUDIT_LOG_PATH", setString(&c.AuditLogPath)},
+		{"ISUCON_SERVER_READ_HEADER_TIMEOUT", setDuration(&c.Server.ReadHeaderTimeout)},
+		{"ISUCON_SERVER_READ_TIMEOUT", setDuration(&c.Server.ReadTimeout)},
+		{"ISUCON_SERVER_WRITE_TIMEOUT", setDuration(&c.Server.WriteTimeout)},
+		{"ISUCON_SERVER_IDLE_TIMEOUT", setDuration(&c.Server.IdleTimeout)},
+		{"ISUCON_SERVER_SHUTDOWN_TIMEOUT", setDuration(&c.Server.ShutdownTimeout)},
+		{"ISUCON_DB_HOST", setString(&c.DB.Host)},
+		{"ISUCON_DB_PORT", setInt(&c.DB.Port)},
+		{"ISUCON_DB_USER", setString(&c.DB.User)},
+		{"ISUCON_DB_PASSWORD", setString(&c.DB.Password)},
+		{"ISUCON_DB_NAME", setString(&c.DB.Name)},
+		{"ISUCON_DB_MAX_OPEN_CONNS", setInt(&c.DB.MaxOpenConns)},
+		{"ISUCON_DB_MAX_IDLE_CONNS", setInt(&c.DB.MaxIdleConns)},
+		{"ISUCON_DB_CONN_MAX_LIFETIME", setDuration(&c.DB.ConnMaxLifetime)},
+		{"ISUCON_DB_CONN_MAX_IDLE_TIME", setDuration(&c.DB.ConnMaxIdleTime)},
+		{"ISUCON_DB_REPLICA_DSN", setString(&c.DB.ReplicaDSN)},
+		{"ISUCON_DB_REPLICA_MAX_LAG", setDuration(&c.DB.ReplicaMaxLag)},
+		{"ISUCON_PAYMENT_TIMEOUT", setDuration(&c.Payment.Timeout)},
+		{"ISUCON_PAYMENT_MAX_RETRIES", setInt(&c.Payment.MaxRetries)},
+		{"ISUCON_PAYMENT_RETRY_INTERVAL", setDuration(&c.Payment.RetryInterval)},
+		{"ISUCON_MATCHING_INTERVAL", setDuration(&c.Matching.Interval)},
+		{"ISUCON_CHAIR_MOVE_INTERVAL", setDuration(&c.Chair.MoveInterval)},
+		{"ISUCON_WEBHOOK_INTERVAL", setDuration(&c.Webhook.Interval)},
+		{"ISUCON_WEBHOOK_TIMEOUT", setDuration(&c.Webhook.Timeout)},
+		{"ISUCON_WEBHOOK_MAX_ATTEMPTS", setInt(&c.Webhook.MaxAttempts)},
+		{"ISUCON_WEBHOOK_RETRY_INTERVAL", setDuration(&c.Webhook.RetryInterval)},
+		{"ISUCON_WEBHOOK_MAX_RETRY_INTERVAL", setDuration(&c.Webhook.MaxRetryInterval)},
+		{"ISUCON_RATE_LIMIT_TRUSTED_PROXIES", setStringList(&c.RateLimit.TrustedProxies)},
+		{"ISUCON_DRAIN_TIMEOUT", setDuration(&c.DrainTimeout)},
+		{"ISUCON_OPENAPI_PATH", setString(&c.OpenAPI.Path)},
+		{"ISUCON_OPENAPI_VALIDATION", func(value string) error {
+			c.OpenAPI.Validation = validation.Mode(value)
+			return nil
+		}},
+		{"ISUCON_ADMIN_TOKEN", setString(&c.Admin.Token)},
+		{"ISUCON_INIT_SQL_DIR", setString(&c.Initialize.SQLDir)},
+		{"ISUCON_INIT_DATASET", setString(&c.Initialize.Dataset)},
+		{"ISUCON_TELEMETRY_SERVICE_NAME", setString(&c.Telemetry.ServiceName)},
+		{"ISUCON_TELEMETRY_EXPORTER", func(value string) error {
+			c.Telemetry.Exporter = telemetry.Exporter(value)
+			return nil
+		}},
+	}
+}
+
+// 空文字列の環境変数は未設定として扱う
+func (c *Config) loadEnv(lookupEnv func(string) (string, bool)) error {
+	var errs []error
+	for _, v := range c.envVars() {
+		value, ok := lookupEnv(v.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setString(p *string) func(string) error {
+	return func(value string) error {
+		*p = value
+		return nil
+	}
+}
+
+// カンマ区切りの値を一覧として扱う
+func setStringList(p *[]string) func(string) error {
+	return func(value string) error {
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*p = list
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*p = i
+		return nil
+	}
+}
+
+func setDuration(p *time.Duration) func(string) error {
+	return func(value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration (e.g. 500ms, 30s)", value)
+		}
+		*p = d
+		return nil
+	}
+}
+
+// Validate は設定値を検証し、不正な項目をすべてまとめたエラーを返す
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		invalid("listen_addr", "%q is not a host:port address", c.ListenAddr)
+	}
+	if _, err := c.SlogLevel(); err != nil {
+		invalid("log_level", "%q must be one of debug, info, warn, error", c.LogLevel)
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		invalid("log_format", "%q must be one of json, text", c.LogFormat)
+	}
+
+	if c.Server.ReadHeaderTimeout < 0 {
+		invalid("server.read_header_timeout", "must not be negative")
+	}
+	if c.Server.ReadTimeout < 0 {
+		invalid("server.read_timeout", "must not be negative")
+	}
+	if c.Server.WriteTimeout < 0 {
+		invalid("server.write_timeout", "must not be negative")
+	}
+	if c.Server.IdleTimeout < 0 {
+		invalid("server.idle_timeout", "must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdown_timeout", "must be positive")
+	}
+
+	if c.DB.Host == "" {
+		invalid("db.host", "must not be empty")
+	}
+	if c.DB.Port <= 0 || c.DB.Port > 65535 {
+		invalid("db.port", "%d is out of range", c.DB.Port)
+	}
+	if c.DB.User == "" {
+		invalid("db.user", "must not be empty")
+	}
+	if c.DB.Name == "" {
+		invalid("db.name", "must not be empty")
+	}
+	if c.DB.MaxOpenConns < 0 {
+		invalid("db.max_open_conns", "must not be negative")
+	}
+	if c.DB.MaxIdleConns < 0 {
+		invalid("db.max_idle_conns", "must not be negative")
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		invalid("db.max_idle_conns", "%d must not exceed db.max_open_conns (%d)", c.DB.MaxIdleConns, c.DB.MaxOpenConns)
+	}
+	if c.DB.ConnMaxLifetime < 0 {
+		invalid("db.conn_max_lifetime", "must not be negative")
+	}
+	if c.DB.ConnMaxIdleTime < 0 {
+		invalid("db.conn_max_idle_time", "must not be negative")
+	}
+	if c.DB.ReplicaDSN != "" {
+		if _, err := mysql.ParseDSN(c.DB.ReplicaDSN); err != nil {
+			// DSNにはパスワードが含まれるので、値はエラーに含めない
+			invalid("db.replica_dsn", "invalid DSN")
+		}
+	}
+	if c.DB.ReplicaMaxLag < 0 {
+		invalid("db.replica_max_lag", "must not be negative")
+	}
+
+	if c.Payment.Timeout <= 0 {
+		invalid("payment.timeout", "must be positive")
+	}
+	if c.Payment.MaxRetries < 0 {
+		invalid("payment.max_retries", "must not be negative")
+	}
+	if c.Payment.RetryInterval < 0 {
+		invalid("payment.retry_interval", "must not be negative")
+	}
+
+	if c.Matching.Interval < 0 {
+		invalid("matching.interval", "must not be negative")
+	}
+	if c.Chair.MoveInterval <= 0 {
+		invalid("chair.move_interval", "must be positive")
+	}
+	if c.Webhook.Interval <= 0 {
+		invalid("webhook.interval", "must be positive")
+	}
+	if c.Webhook.Timeout <= 0 {
+		invalid("webhook.timeout", "must be positive")
+	}
+	if c.Webhook.MaxAttempts <= 0 {
+		invalid("webhook.max_attempts", "must be positive")
+	}
+	if c.Webhook.RetryInterval < 0 {
+		invalid("webhook.retry_interval", "must not be negative")
+	}
+	if c.Webhook.MaxRetryInterval < c.Webhook.RetryInterval {
+		invalid("webhook.max_retry_interval", "%s must not be less than webhook.retry_interval (%s)", c.Webhook.MaxRetryInterval, c.Webhook.RetryInterval)
+	}
+	if _, err := ratelimit.ParseTrustedProxies(c.RateLimit.TrustedProxies); err != nil {
+		invalid("rate_limit.trusted_proxies", "%s", err)
+	}
+	if c.DrainTimeout < 0 {
+		invalid("drain_timeout", "must not be negative")
+	}
+
+	if _, err := validation.ParseMode(string(c.OpenAPI.Validation)); err != nil {
+		invalid("openapi.validation", "%q must be one of off, request, strict", c.OpenAPI.Validation)
+	}
+
+	if c.Admin.Token != "" && len(c.Admin.Token) < minAdminTokenLength {
+		// トークンは値を含めずに報告する
+		invalid("admin.token", "must be at least %d characters", minAdminTokenLength)
+	}
+
+	if c.Initialize.SQLDir == "" {
+		invalid("initialize.sql_dir", "must not be empty")
+	}
+	if !sqlload.ValidDatasetName(c.Initialize.Dataset) {
+		invalid("initialize.dataset", "%q must consist of letters, digits, - and _", c.Initialize.Dataset)
+	}
+
+	if c.Telemetry.ServiceName == "" {
+		invalid("telemetry.service_name", "must not be empty")
+	}
+	if _, err := telemetry.ParseExporter(string(c.Telemetry.Exporter)); err != nil {
+		invalid("telemetry.exporter", "%q must be one of auto, otlp, stdout, none", c.Telemetry.Exporter)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (c *Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.ToUpper(c.LogLevel)))
+	return level, err
+}
+
+// DSN はプライマリのDSNを返す
+func (c *DBConfig) DSN() string {
+	dbConfig := mysql.NewConfig()
+	dbConfig.User = c.User
+	dbConfig.Passwd = c.Password
+	dbConfig.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	dbConfig.Net = "tcp"
+	dbConfig.DBName = c.Name
+	dbConfig.ParseTime = true
+	return dbConfig.FormatDSN()
+}
+
+// ReplicaDSNWithOptions はプライマリと同じオプションを付与したレプリカのDSNを返す
+func (c *DBConfig) ReplicaDSNWithOptions() (string, error) {
+	dbConfig, err := mysql.ParseDSN(c.ReplicaDSN)
+	if err != nil {
+		return "", err
+	}
+	dbConfig.ParseTime = true
+	return dbConfig.FormatDSN(), nil
+}
+
+// LogValue はパスワードを伏せた設定をログに出力する
+func (c *Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("listen_addr", c.ListenAddr),
+		slog.String("log_level", c.LogLevel),
+		slog.String("log_format", c.LogFormat),
+		slog.String("access_log_path", c.AccessLogPath),
+		slog.String("audit_log_path", c.AuditLogPath),
+		slog.Group("server",
+			slog.Duration("read_header_timeout", c.Server.ReadHeaderTimeout),
+			slog.Duration("read_timeout", c.Server.ReadTimeout),
+			slog.Duration("write_timeout", c.Server.WriteTimeout),
+			slog.Duration("idle_timeout", c.Server.IdleTimeout),
+			slog.Duration("shutdown_timeout", c.Server.ShutdownTimeout),
+		),
+		slog.Group("db",
+			slog.String("host", c.DB.Host),
+			slog.Int("port", c.DB.Port),
+			slog.String("user", c.DB.User),
+			slog.String("password", redactString(c.DB.Password)),
+			slog.String("name", c.DB.Name),
+			slog.Int("max_open_conns", c.DB.MaxOpenConns),
+			slog.Int("max_idle_conns", c.DB.MaxIdleConns),
+			slog.Duration("conn_max_lifetime", c.DB.ConnMaxLifetime),
+			slog.Duration("conn_max_idle_time", c.DB.ConnMaxIdleTime),
+			slog.String("replica_dsn", redactDSN(c.DB.ReplicaDSN)),
+			slog.Duration("replica_max_lag", c.DB.ReplicaMaxLag),
+		),
+		slog.Group("payment",
+			slog.Duration("timeout", c.Payment.Timeout),
+			slog.Int("max_retries", c.Payment.MaxRetries),
+			slog.Duration("retry_interval", c.Payment.RetryInterval),
+		),
+		slog.Group("matching",
+			slog.Duration("interval", c.Matching.Interval),
+		),
+		slog.Group("chair",
+			slog.Duration("move_interval", c.Chair.MoveInterval),
+		),
+		slog.Group("webhook",
+			slog.Duration("interval", c.Webhook.Interval),
+			slog.Duration("timeout", c.Webhook.Timeout),
+			slog.Int("max_attempts", c.Webhook.MaxAttempts),
+			slog.Duration("retry_interval", c.Webhook.RetryInterval),
+			slog.Duration("max_retry_interval", c.Webhook.MaxRetryInterval),
+		),
+		slog.Group("rate_limit",
+			slog.Any("trusted_proxies", c.RateLimit.TrustedProxies),
+		),
+		slog.Duration("drain_timeout", c.DrainTimeout),
+		slog.Group("openapi",
+			slog.String("path", c.OpenAPI.Path),
+			slog.String("validation", string(c.OpenAPI.Validation)),
+		),
+		slog.Group("admin",
+			slog.String("token", redactString(c.Admin.Token)),
+		),
+		slog.Group("initialize",
+			slog.String("sql_dir", c.Initialize.SQLDir),
+			slog.String("dataset", c.Initialize.Dataset),
+		),
+		slog.Group("telemetry",
+			slog.String("service_name", c.Telemetry.ServiceName),
+			slog.String("exporter", string(c.Telemetry.Exporter)),
+		),
+	)
+}
+
+func redactString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	dbConfig, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return redacted
+	}
+	if dbConfig.Passwd != "" {
+		dbConfig.Passwd = redacted
+	}
+	return dbConfig.FormatDSN()
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/validation"
+)
+
+func envFrom(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Default(t *testing.T) {
+	cfg, err := load(envFrom(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB.DSN() != "isucon:isucon@tcp(127.0.0.1:3306)/isuride?parseTime=true" {
+		t.Errorf("DSN = %q", cfg.DB.DSN())
+	}
+	if cfg.OpenAPI.Validation != validation.ModeRequest {
+		t.Errorf("openapi.validation = %q, want %q", cfg.OpenAPI.Validation, validation.ModeRequest)
+	}
+}
+
+func TestLoad_FileAndEnv(t *testing.T) {
+	path := writeFile(t, `
+db:
+  host: db
+  port: 13306
+  max_open_conns: 10
+  max_idle_conns: 5
+payment:
+  timeout: 2s
+matching:
+  interval: 500ms
+rate_limit:
+  trusted_proxies: [127.0.0.1]
+server:
+  write_timeout: 0s
+`)
+	cfg, err := load(envFrom(map[string]string{
+		FileEnv:                             path,
+		"ISUCON_DB_PORT":                    "3306",
+		"ISUCON_LOG_LEVEL":                  "debug",
+		"ISUCON_RATE_LIMIT_TRUSTED_PROXIES": "10.0.0.0/8, 192.0.2.1",
+		"ISUCON_SERVER_IDLE_TIMEOUT":        "1m",
+		// 空の環境変数は設定ファイルの値を上書きしない
+		"ISUCON_DB_HOST": "",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB.Host != "db" {
+		t.Errorf("db.host = %q, want %q", cfg.DB.Host, "db")
+	}
+	if cfg.DB.Port != 3306 {
+		t.Errorf("db.port = %d, want env value 3306", cfg.DB.Port)
+	}
+	if cfg.DB.MaxOpenConns != 10 || cfg.DB.MaxIdleConns != 5 {
+		t.Errorf("db pool = %d/%d, want 10/5", cfg.DB.MaxOpenConns, cfg.DB.MaxIdleConns)
+	}
+	if cfg.Payment.Timeout != 2*time.Second {
+		t.Errorf("payment.timeout = %s, want 2s", cfg.Payment.Timeout)
+	}
+	// 設定ファイルに無い項目はデフォルト値のまま
+	if cfg.Payment.MaxRetries != 5 {
+		t.Errorf("payment.max_retries = %d, want 5", cfg.Payment.MaxRetries)
+	}
+	if !slices.Equal(cfg.RateLimit.TrustedProxies, []string{"10.0.0.0/8", "192.0.2.1"}) {
+		t.Errorf("rate_limit.trusted_proxies = %v, want env value", cfg.RateLimit.TrustedProxies)
+	}
+	if cfg.Server.WriteTimeout != 0 || cfg.Server.IdleTimeout != time.Minute || cfg.Server.ReadTimeout != 10*time.Second {
+		t.Errorf("server timeouts = %+v, want write 0s, idle 1m and default read", cfg.Server)
+	}
+	if cfg.Matching.Interval != 500*time.Millisecond {
+		t.Errorf("matching.interval = %s, want 500ms", cfg.Matching.Interval)
+	}
+	if level, _ := cfg.SlogLevel(); level != slog.LevelDebug {
+		t.Errorf("log level = %s, want DEBUG", level)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "unknown field in file",
+			file: "db:\n  hots: db\n",
+			want: []string{"hots"},
+		},
+		{
+			name: "malformed env values",
+			env:  map[string]string{"ISUCON_DB_PORT": "mysql", "ISUCON_DRAIN_TIMEOUT": "30"},
+			want: []string{"ISUCON_DB_PORT", "ISUCON_DRAIN_TIMEOUT"},
+		},
+		{
+			name: "invalid values are all reported",
+			env: map[string]string{
+				"ISUCON_DB_MAX_OPEN_CONNS":          "10",
+				"ISUCON_DB_MAX_IDLE_CONNS":          "20",
+				"ISUCON_PAYMENT_TIMEOUT":            "0s",
+				"ISUCON_OPENAPI_VALIDATION":         "on",
+				"ISUCON_LOG_LEVEL":                  "verbose",
+				"ISUCON_TELEMETRY_EXPORTER":         "jaeger",
+				"ISUCON_LOG_FORMAT":                 "ltsv",
+				"ISUCON_ADMIN_TOKEN":                "short",
+				"ISUCON_INIT_DATASET":               "../data",
+				"ISUCON_WEBHOOK_MAX_ATTEMPTS":       "0",
+				"ISUCON_WEBHOOK_MAX_RETRY_INTERVAL": "1s",
+				"ISUCON_CHAIR_MOVE_INTERVAL":        "0s",
+				"ISUCON_RATE_LIMIT_TRUSTED_PROXIES": "nginx",
+				"ISUCON_SERVER_READ_TIMEOUT":        "-1s",
+				"ISUCON_SERVER_SHUTDOWN_TIMEOUT":    "0s",
+			},
+			want: []string{"server.read_timeout", "server.shutdown_timeout", "rate_limit.trusted_proxies", "db.max_idle_conns", "payment.timeout", "openapi.validation", "log_level", "telemetry.exporter", "log_format", "admin.token", "initialize.dataset", "webhook.max_attempts", "webhook.max_retry_interval", "chair.move_interval"},
+		},
+		{
+			name: "invalid replica dsn does not leak its value",
+			env:  map[string]string{"ISUCON_DB_REPLICA_DSN": "isucon:secret@replica"},
+			want: []string{"db.replica_dsn"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			if tt.file != "" {
+				env[FileEnv] = writeFile(t, tt.file)
+			}
+			_, err := load(envFrom(env))
+			if err == nil {
+				t.Fatal("load should fail")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q should mention %q", err, want)
+				}
+			}
+			if strings.Contains(err.Error(), "secret") {
+				t.Errorf("error leaked a secret: %q", err)
+			}
+		})
+	}
+}
+
+func TestConfig_LogValue(t *testing.T) {
+	cfg, err := load(envFrom(map[string]string{
+		"ISUCON_DB_PASSWORD":    "primary-secret",
+		"ISUCON_DB_REPLICA_DSN": "isucon:replica-secret@tcp(replica:3306)/isuride",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	slog.New(slog.NewTextHandler(buf, nil)).Info("configuration loaded", "config", cfg)
+	out := buf.String()
+	if strings.Contains(out, "secret") {
+		t.Errorf("log leaked a secret: %s", out)
+	}
+	if !strings.Contains(out, "replica:3306") {
+		t.Errorf("log should keep the replica address: %s", out)
+	}
+}
+
+func TestDBConfig_ReplicaDSNWithOptions(t *testing.T) {
+	c := &DBConfig{ReplicaDSN: "isucon:isucon@tcp(replica:3306)/isuride"}
+	dsn, err := c.ReplicaDSNWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "parseTime=true") {
+		t.Errorf("DSN = %q, want parseTime=true", dsn)
+	}
+}
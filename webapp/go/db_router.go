@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/telemetry"
+)
+
+const replicaLagCheckInterval = 1 * time.Second
+
+var (
+	// ハンドラーが使うストア。ハンドラーのテストではインメモリの実装に差し替える
+	dataStore store.Store
+	// 参照系のハンドラーが使うリードレプリカのストア。未設定の場合はnil
+	replicaStore store.Store
+
+	// 参照系のハンドラーが使うリードレプリカ。未設定の場合はnil
+	replicaDB *sqlx.DB
+	// レプリカの遅延が許容範囲内であることを確認できている間だけtrueになる
+	replicaAvailable atomic.Bool
+	// 最後に確認したレプリカの遅延秒数。確認できなかった場合は-1
+	replicaLagSeconds atomic.Int64
+)
+
+func init() {
+	replicaLagSeconds.Store(-1)
+	expvar.Publish("db_replica", expvar.Func(func() any {
+		return map[string]any{
+			"enabled":     replicaDB != nil,
+			"available":   replicaAvailable.Load(),
+			"lag_seconds": replicaLagSeconds.Load(),
+		}
+	}))
+}
+
+// readStore は参照系のクエリに使うストアを返す。
+// 書き込み直後の状態を読む必要があるハンドラー (通知など) では使わず、常にプライマリのdataStoreを使うこと
+func readStore() store.Store {
+	if replicaStore != nil && replicaAvailable.Load() {
+		return replicaStore
+	}
+	return dataStore
+}
+
+func connectReplica(ctx context.Context, cfg *config.DBConfig) error {
+	if cfg.ReplicaDSN == "" {
+		return nil
+	}
+	dsn, err := cfg.ReplicaDSNWithOptions()
+	if err != nil {
+		return fmt.Errorf("failed to parse replica DSN: %w", err)
+	}
+	sqlDB, err := telemetry.OpenDB("mysql", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to connect to replica: %w", err)
+	}
+	_replica := sqlx.NewDb(sqlDB, "mysql")
+	if err := _replica.PingContext(ctx); err != nil {
+		_replica.Close()
+		return fmt.Errorf("failed to connect to replica: %w", err)
+	}
+	_replica.SetMaxOpenConns(cfg.MaxOpenConns)
+	_replica.SetMaxIdleConns(cfg.MaxIdleConns)
+	_replica.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	_replica.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	replicaDB = _replica
+	replicaStore = store.NewMySQL(_replica)
+
+	// 最初のリクエストからレプリカを使えるよう、起動時に一度確認しておく
+	checkReplicaLag(ctx, cfg.ReplicaMaxLag)
+	return nil
+}
+
+// レプリカの遅延を定期的に確認し、maxLagを超えている間や確認できない間はプライマリに振り分ける
+func runReplicaLagMonitor(ctx context.Context, interval, maxLag time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkReplicaLag(ctx, maxLag)
+		}
+	}
+}
+
+func checkReplicaLag(ctx context.Context, maxLag time.Duration) {
+	lag, err := getReplicaLag(ctx)
+	if err != nil {
+		replicaLagSeconds.Store(-1)
+		if replicaAvailable.Swap(false) {
+			slog.Warn("replica is unavailable, falling back to primary", "error", err)
+		}
+		return
+	}
+
+	replicaLagSeconds.Store(int64(lag / time.Second))
+	if lag > maxLag {
+		if replicaAvailable.Swap(false) {
+			slog.Warn("replica is lagging, falling back to primary", "lag", lag)
+		}
+		return
+	}
+	if !replicaAvailable.Swap(true) {
+		slog.Info("replica is available", "lag", lag)
+	}
+}
+
+func getReplicaLag(ctx context.Context) (time.Duration, error) {
+	rows, err := replicaDB.QueryxContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("replication is not configured")
+	}
+	status := map[string]any{}
+	if err := rows.MapScan(status); err != nil {
+		return 0, err
+	}
+
+	// レプリケーションが停止している場合はNULLになる
+	value, ok := status["Seconds_Behind_Source"].([]byte)
+	if !ok {
+		return 0, errors.New("replication is not running")
+	}
+	seconds, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse Seconds_Behind_Source: %w", err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+)
+
+var (
+	errInvalidInvitationCode     = apierror.New(http.StatusBadRequest, apierror.CodeInvalidInvitationCode, "この招待コードは使用できません。")
+	errInvalidChairRegisterToken = apierror.New(http.StatusUnauthorized, apierror.CodeInvalidChairRegisterToken, "invalid chair_register_token")
+	errRideAlreadyExists         = apierror.New(http.StatusConflict, apierror.CodeRideAlreadyExists, "ride already exists")
+	errRideNotFound              = apierror.New(http.StatusNotFound, apierror.CodeRideNotFound, "ride not found")
+	errRideNotArrived            = apierror.New(http.StatusBadRequest, apierror.CodeRideNotArrived, "not arrived yet")
+	errChairNotArrived           = apierror.New(http.StatusBadRequest, apierror.CodeRideNotArrived, "chair has not arrived yet")
+	errRideNotAssigned           = apierror.New(http.StatusBadRequest, apierror.CodeRideNotAssigned, "not assigned to this ride")
+	errInvalidRideStatus         = apierror.New(http.StatusBadRequest, apierror.CodeInvalidRideStatus, "invalid status")
+	errChairNotFound             = apierror.New(http.StatusNotFound, apierror.CodeChairNotFound, "chair not found")
+	errOutsideWorkingHours       = apierror.New(http.StatusBadRequest, apierror.CodeOutsideWorkingHours, "outside of working hours")
+	errOutOfServiceArea          = apierror.New(http.StatusBadRequest, apierror.CodeOutOfServiceArea, "pickup coordinate is out of service area")
+	errPaymentTokenNotRegistered = apierror.New(http.StatusBadRequest, apierror.CodePaymentTokenNotRegistered, "payment token not registered")
+	errAPIKeyNotFound            = apierror.New(http.StatusNotFound, apierror.CodeAPIKeyNotFound, "api key not found")
+	errShuttingDown              = apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "server is shutting down")
+	errRideFinished              = apierror.New(http.StatusBadRequest, apierror.CodeRideFinished, "ride has already been completed or canceled")
+	errRideAlreadyMatched        = apierror.New(http.StatusConflict, apierror.CodeRideAlreadyMatched, "ride has already been matched")
+	errChairBusy                 = apierror.New(http.StatusConflict, apierror.CodeChairBusy, "chair is not available")
+	errSettingNotFound           = apierror.New(http.StatusNotFound, apierror.CodeSettingNotFound, "setting not found")
+	errDatasetNotFound           = apierror.New(http.StatusBadRequest, apierror.CodeDatasetNotFound, "dataset not found")
+	errUsernameTaken             = apierror.New(http.StatusConflict, apierror.CodeUsernameTaken, "username is already taken")
+	errRideInProgress            = apierror.New(http.StatusConflict, apierror.CodeRideInProgress, "ride is in progress")
+	errPlaceNotFound             = apierror.New(http.StatusNotFound, apierror.CodePlaceNotFound, "place not found")
+	errPlaceAlreadyExists        = apierror.New(http.StatusConflict, apierror.CodePlaceAlreadyExists, "place with the same label already exists")
+	errWebhookNotFound           = apierror.New(http.StatusNotFound, apierror.CodeWebhookNotFound, "webhook not found")
+)
+
+func errChairOnBreak(until time.Time) error {
+	return apierror.New(http.StatusBadRequest, apierror.CodeChairOnBreak, fmt.Sprintf("chair is on a break until %s", until.Format(time.RFC3339)))
+}
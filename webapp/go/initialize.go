@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/sqlload"
+)
+
+var initializeConfig = config.Default().Initialize
+
+// 初期化の途中で別の初期化が始まるとテーブルを作り直している最中に読み込んでしまうので、同時には1つだけ実行する
+var initializeMu sync.Mutex
+
+// initializeTimings は初期化の各段階にかかった時間 (ミリ秒)
+type initializeTimings struct {
+	Schema     int64 `json:"schema_ms"`
+	MasterData int64 `json:"master_data_ms"`
+	Dataset    int64 `json:"dataset_ms"`
+	Caches     int64 `json:"caches_ms"`
+	Total      int64 `json:"total_ms"`
+}
+
+// initializeData はテーブルを作り直し、マスターデータとデータセットを読み込んでから、メモリ上のキャッシュを作り直す
+func initializeData(ctx context.Context, dataset, paymentServer string) (*initializeTimings, error) {
+	// テーブルを消す前にデータセットが存在することを確認する
+	datasetPath, err := sqlload.DatasetPath(initializeConfig.SQLDir, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	initializeMu.Lock()
+	defer initializeMu.Unlock()
+
+	// 途中で止めるとテーブルが中途半端な状態で残るので、リクエストが切断されても最後まで読み込む
+	ctx = context.WithoutCancel(ctx)
+	start := time.Now()
+	timings := &initializeTimings{}
+
+	// SQLファイルはセッション変数を書き換えるので1つのコネクションで実行する
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 書き換えたセッション変数が他のリクエストに影響しないよう、コネクションはプールに戻さずに閉じる
+	defer conn.Raw(func(any) error { return driver.ErrBadConn })
+
+	measure := func(d *int64, f func() error) error {
+		stepStart := time.Now()
+		err := f()
+		*d = time.Since(stepStart).Milliseconds()
+		return err
+	}
+	execFile := func(path string) func() error {
+		return func() error {
+			_, err := sqlload.ExecFile(ctx, conn, path)
+			return err
+		}
+	}
+
+	if err := measure(&timings.Schema, execFile(filepath.Join(initializeConfig.SQLDir, "1-schema.sql"))); err != nil {
+		return nil, err
+	}
+	if err := measure(&timings.MasterData, execFile(filepath.Join(initializeConfig.SQLDir, "2-master-data.sql"))); err != nil {
+		return nil, err
+	}
+	if err := measure(&timings.Dataset, execFile(datasetPath)); err != nil {
+		return nil, err
+	}
+	if err := dataStore.Settings().Set(ctx, "payment_gateway_url", paymentServer); err != nil {
+		return nil, err
+	}
+
+	if err := measure(&timings.Caches, func() error {
+		if err := loadRegions(ctx); err != nil {
+			return err
+		}
+		if err := loadRateLimits(ctx); err != nil {
+			return err
+		}
+		authenticator.Clear()
+		chairsWithRegion.Clear()
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	timings.Total = time.Since(start).Milliseconds()
+	slog.Info("initialized",
+		"dataset", dataset,
+		"schema_ms", timings.Schema,
+		"master_data_ms", timings.MasterData,
+		"dataset_ms", timings.Dataset,
+		"caches_ms", timings.Caches,
+		"total_ms", timings.Total,
+	)
+	return timings, nil
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/validation"
+)
+
+// 結合テストはMySQLを使う。ISUCON_TEST_DB_HOSTが設定されていればそのサーバーを使い、
+// 無ければdockerでMySQLのコンテナを起動する。どちらも使えない場合はスキップするが、
+// ISUCON_TEST_DB_HOSTを指定した場合は接続できなければ失敗する。
+// テストのたびにISUCON_TEST_DB_NAMEのデータベース (既定はisuride_test) を作り直すので、開発用のデータベースは指定しないこと
+const testMySQLImage = "public.ecr.aws/docker/library/mysql:8"
+
+var testMySQL struct {
+	once        sync.Once
+	cfg         *config.DBConfig
+	containerID string
+	err         error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testMySQL.containerID != "" {
+		if out, err := exec.Command("docker", "rm", "-f", testMySQL.containerID).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove mysql container: %s: %v\n", out, err)
+		}
+	}
+	os.Exit(code)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// startTestMySQL は結合テストで使うMySQLの接続先を返す
+func startTestMySQL() (*config.DBConfig, string, error) {
+	cfg := config.Default().DB
+	cfg.Name = envOr("ISUCON_TEST_DB_NAME", "isuride_test")
+
+	if host := os.Getenv("ISUCON_TEST_DB_HOST"); host != "" {
+		port, err := strconv.Atoi(envOr("ISUCON_TEST_DB_PORT", "3306"))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid ISUCON_TEST_DB_PORT: %w", err)
+		}
+		cfg.Host = host
+		cfg.Port = port
+		cfg.User = envOr("ISUCON_TEST_DB_USER", "isucon")
+		cfg.Password = envOr("ISUCON_TEST_DB_PASSWORD", "isucon")
+		return &cfg, "", nil
+	}
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		return nil, "", fmt.Errorf("neither ISUCON_TEST_DB_HOST nor docker is available")
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "MYSQL_ROOT_PASSWORD=isucon",
+		"-p", "127.0.0.1::3306",
+		testMySQLImage,
+	).Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start mysql container: %w", err)
+	}
+	containerID := strings.TrimSpace(string(out))
+
+	out, err = exec.Command("docker", "port", containerID, "3306/tcp").Output()
+	if err != nil {
+		return nil, containerID, fmt.Errorf("failed to get mysql port: %w", err)
+	}
+	// IPv4とIPv6の両方が返る場合があるので最初の行を使う
+	addr, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, containerID, fmt.Errorf("unexpected docker port output %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, containerID, fmt.Errorf("unexpected docker port output %q: %w", addr, err)
+	}
+	cfg.Host = host
+	cfg.Port = port
+	cfg.User = "root"
+	cfg.Password = "isucon"
+	return &cfg, containerID, nil
+}
+
+// adminDSN はデータベースを指定せず、複数のクエリをまとめて実行できる接続先を返す
+func adminDSN(cfg *config.DBConfig) string {
+	dbConfig := mysql.NewConfig()
+	dbConfig.User = cfg.User
+	dbConfig.Passwd = cfg.Password
+	dbConfig.Net = "tcp"
+	dbConfig.Addr = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dbConfig.MultiStatements = true
+	return dbConfig.FormatDSN()
+}
+
+// コンテナの起動直後は初期化のために一度再起動するので、接続できるまで待つ
+func waitTestMySQL(cfg *config.DBConfig, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := sqlx.Open("mysql", adminDSN(cfg))
+		if err != nil {
+			return err
+		}
+		err = conn.Ping()
+		conn.Close()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("mysql is not ready: %w", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+var useDatabasePattern = regexp.MustCompile(`(?m)^USE isuride;$`)
+
+// resetTestDatabase はスキーマとマスターデータを適用し直す。初期データは投入しない
+func resetTestDatabase(cfg *config.DBConfig) error {
+	conn, err := sqlx.Connect("mysql", adminDSN(cfg))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS `%s`; CREATE DATABASE `%s` DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci", cfg.Name, cfg.Name)); err != nil {
+		return err
+	}
+	for _, file := range []string{"../sql/1-schema.sql", "../sql/2-master-data.sql"} {
+		query, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Exec(useDatabasePattern.ReplaceAllString(string(query), fmt.Sprintf("USE `%s`;", cfg.Name))); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// testPaymentGateway はpayment_mockと同じAPIを持つ決済サーバー。
+// failNextに設定した回数だけ、決済を記録したかどうかに関わらず500を返す
+type testPaymentGateway struct {
+	mu       sync.Mutex
+	payments map[string][]int
+	// 失敗させるときに決済を記録するか
+	recordOnFailure bool
+	failNext        int
+	postCount       int
+}
+
+func (g *testPaymentGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/payments":
+		req := &paymentGatewayPostPaymentRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Amount <= 0 || req.Amount > 1_000_000 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		g.postCount++
+		if g.failNext > 0 {
+			g.failNext--
+			if g.recordOnFailure {
+				g.payments[token] = append(g.payments[token], req.Amount)
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		g.payments[token] = append(g.payments[token], req.Amount)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/payments":
+		res := []paymentGatewayGetPaymentsResponseOne{}
+		for _, amount := range g.payments[token] {
+			res = append(res, paymentGatewayGetPaymentsResponseOne{Amount: amount, Status: "成功"})
+		}
+		writeJSON(w, http.StatusOK, res)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// failNextPayments は次のn回の決済リクエストを失敗させる
+func (g *testPaymentGateway) failNextPayments(n int, recordOnFailure bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failNext = n
+	g.recordOnFailure = recordOnFailure
+}
+
+func (g *testPaymentGateway) posts() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.postCount
+}
+
+func (g *testPaymentGateway) amounts(token string) []int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]int{}, g.payments[token]...)
+}
+
+type testEnv struct {
+	t        *testing.T
+	db       *sqlx.DB
+	server   *httptest.Server
+	payments *testPaymentGateway
+}
+
+// newTestEnv はデータベースを初期化し、全てのルートを登録したサーバーを起動する
+const testAdminToken = "test-admin-token-0123456789"
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	testMySQL.once.Do(func() {
+		testMySQL.cfg, testMySQL.containerID, testMySQL.err = startTestMySQL()
+		if testMySQL.err == nil {
+			testMySQL.err = waitTestMySQL(testMySQL.cfg, 2*time.Minute)
+		}
+	})
+	if testMySQL.err != nil {
+		// 接続先を指定した場合はスキップせず、CIで結合テストが実行されないまま通らないようにする
+		if os.Getenv("ISUCON_TEST_DB_HOST") != "" {
+			t.Fatalf("mysql is not available: %v", testMySQL.err)
+		}
+		t.Skipf("mysql is not available: %v", testMySQL.err)
+	}
+	if err := resetTestDatabase(testMySQL.cfg); err != nil {
+		t.Fatalf("failed to reset database: %v", err)
+	}
+
+	payments := &testPaymentGateway{payments: map[string][]int{}}
+	paymentServer := httptest.NewServer(payments)
+	t.Cleanup(paymentServer.Close)
+
+	cfg := config.Default()
+	cfg.DB = *testMySQL.cfg
+	cfg.OpenAPI.Validation = validation.ModeStrict
+	cfg.Payment.RetryInterval = 10 * time.Millisecond
+	cfg.Admin.Token = testAdminToken
+	prevStore := dataStore
+	handler := setup(cfg)
+	authenticator.Clear()
+	t.Cleanup(func() {
+		db.Close()
+		dataStore = prevStore
+	})
+
+	if _, err := db.Exec("UPDATE settings SET value = ? WHERE name = 'payment_gateway_url'", paymentServer.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return &testEnv{t: t, db: db, server: server, payments: payments}
+}
+
+// testClient はCookieでセッションを保持する利用者、オーナー、椅子のクライアント
+type testClient struct {
+	env    *testEnv
+	client *http.Client
+	// 設定されていればAuthorizationヘッダーで送るトークン
+	bearer string
+}
+
+func (e *testEnv) newClient() *testClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return &testClient{env: e, client: &http.Client{Jar: jar}}
+}
+
+// do はリクエストを送り、レスポンスのステータスコードがwantStatusであることを確認してから、bodyをoutにデコードする
+func (c *testClient) do(method, path string, body any, wantStatus int, out any) {
+	t := c.env.t
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, c.env.server.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearer)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	buf, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != wantStatus {
+		t.Fatalf("%s %s: status = %d, want %d: %s", method, path, res.StatusCode, wantStatus, buf)
+	}
+	if out != nil {
+		if err := json.Unmarshal(buf, out); err != nil {
+			t.Fatalf("%s %s: failed to decode response %q: %v", method, path, buf, err)
+		}
+	}
+}
+
+// status はレスポンスのステータスコードだけを返す。並行して呼び出すテストで使う
+func (c *testClient) status(method, path string, body any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, c.env.server.URL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearer)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	return res.StatusCode, nil
+}
+
+type testUser struct {
+	*testClient
+	ID             string
+	InvitationCode string
+	PaymentToken   string
+}
+
+// registerUser は利用者を登録し、決済トークンも登録する
+func (e *testEnv) registerUser(name string, invitationCode *string) *testUser {
+	e.t.Helper()
+	c := e.newClient()
+	res := &appPostUsersResponse{}
+	c.do(http.MethodPost, "/api/app/users", &appPostUsersRequest{
+		Username:       name,
+		FirstName:      name,
+		LastName:       "test",
+		DateOfBirth:    "2000-01-01",
+		InvitationCode: invitationCode,
+	}, http.StatusCreated, res)
+
+	token := "token-" + name
+	c.do(http.MethodPost, "/api/app/payment-methods", &appPostPaymentMethodsRequest{Token: token}, http.StatusNoContent, nil)
+	return &testUser{testClient: c, ID: res.ID, InvitationCode: res.InvitationCode, PaymentToken: token}
+}
+
+type testOwner struct {
+	*testClient
+	ID                 string
+	ChairRegisterToken string
+}
+
+func (e *testEnv) registerOwner(name string) *testOwner {
+	e.t.Helper()
+	c := e.newClient()
+	res := &ownerPostOwnersResponse{}
+	c.do(http.MethodPost, "/api/owner/owners", &ownerPostOwnersRequest{Name: name}, http.StatusCreated, res)
+	return &testOwner{testClient: c, ID: res.ID, ChairRegisterToken: res.ChairRegisterToken}
+}
+
+type testChair struct {
+	*testClient
+	ID string
+}
+
+// registerChair は椅子を登録し、指定した座標で稼働を開始する
+func (e *testEnv) registerChair(owner *testOwner, name string, location Coordinate) *testChair {
+	e.t.Helper()
+	c := e.newClient()
+	res := &chairPostChairsResponse{}
+	c.do(http.MethodPost, "/api/chair/chairs", &chairPostChairsRequest{
+		Name:               name,
+		Model:              "リラックスシート NEO",
+		ChairRegisterToken: owner.ChairRegisterToken,
+	}, http.StatusCreated, res)
+
+	chair := &testChair{testClient: c, ID: res.ID}
+	chair.moveTo(location)
+	c.do(http.MethodPost, "/api/chair/activity", &postChairActivityRequest{IsActive: true}, http.StatusNoContent, nil)
+	return chair
+}
+
+func (c *testChair) moveTo(location Coordinate) {
+	c.env.t.Helper()
+	c.do(http.MethodPost, "/api/chair/coordinate", &location, http.StatusOK, &chairPostCoordinateResponse{})
+}
+
+// notification は椅子への通知を1件受け取る
+func (c *testChair) notification() *chairGetNotificationResponseData {
+	c.env.t.Helper()
+	res := &chairGetNotificationResponse{}
+	c.do(http.MethodGet, "/api/chair/notification", nil, http.StatusOK, res)
+	return res.Data
+}
+
+// expectNotification は通知を受け取り、ライドと状態が期待通りであることを確認する
+func (c *testChair) expectNotification(rideID, status string) {
+	t := c.env.t
+	t.Helper()
+	data := c.notification()
+	if data == nil {
+		t.Fatalf("chair %s: no notification, want %s of ride %s", c.ID, status, rideID)
+	}
+	if data.RideID != rideID || data.Status != status {
+		t.Fatalf("chair %s: notification = %s of ride %s, want %s of ride %s", c.ID, data.Status, data.RideID, status, rideID)
+	}
+}
+
+// waitStatus は利用者への通知を指定した状態になるまで受け取る
+func (u *testUser) waitStatus(rideID, status string) *appGetNotificationResponseData {
+	t := u.env.t
+	t.Helper()
+	// 状態は変更された順に1件ずつ通知される
+	for range 10 {
+		res := &appGetNotificationResponse{}
+		u.do(http.MethodGet, "/api/app/notification", nil, http.StatusOK, res)
+		if res.Data != nil && res.Data.RideID == rideID && res.Data.Status == status {
+			return res.Data
+		}
+	}
+	t.Fatalf("user %s: ride %s did not become %s", u.ID, rideID, status)
+	return nil
+}
+
+func (e *testEnv) match() {
+	e.t.Helper()
+	e.newClient().do(http.MethodGet, "/api/internal/matching", nil, http.StatusNoContent, nil)
+}
+
+// requestRide は利用者がライドを要求する
+func (u *testUser) requestRide(pickup, destination Coordinate) *appPostRidesResponse {
+	u.env.t.Helper()
+	res := &appPostRidesResponse{}
+	u.do(http.MethodPost, "/api/app/rides", &appPostRidesRequest{
+		PickupCoordinate:      &pickup,
+		DestinationCoordinate: &destination,
+	}, http.StatusAccepted, res)
+	return res
+}
+
+// driveToArrival はマッチング済みのライドを、椅子が目的地に到着するまで進める
+func (c *testChair) driveToArrival(rideID string, pickup, destination Coordinate) {
+	c.env.t.Helper()
+	c.expectNotification(rideID, "MATCHING")
+	c.do(http.MethodPost, "/api/chair/rides/"+rideID+"/status", &postChairRidesRideIDStatusRequest{Status: "ENROUTE"}, http.StatusNoContent, nil)
+	c.expectNotification(rideID, "ENROUTE")
+	c.moveTo(pickup)
+	c.expectNotification(rideID, "PICKUP")
+	c.do(http.MethodPost, "/api/chair/rides/"+rideID+"/status", &postChairRidesRideIDStatusRequest{Status: "CARRYING"}, http.StatusNoContent, nil)
+	c.expectNotification(rideID, "CARRYING")
+	c.moveTo(destination)
+	c.expectNotification(rideID, "ARRIVED")
+}
+
+// assignedChairID はライドに割り当てられた椅子のIDを返す。未割り当ての場合は空文字列
+func (e *testEnv) assignedChairID(rideID string) string {
+	e.t.Helper()
+	chairID := ""
+	if err := e.db.Get(&chairID, "SELECT IFNULL(chair_id, '') FROM rides WHERE id = ?", rideID); err != nil {
+		e.t.Fatal(err)
+	}
+	return chairID
+}
+
+func (e *testEnv) couponCodes(userID string) []string {
+	e.t.Helper()
+	codes := []string{}
+	if err := e.db.Select(&codes, "SELECT code FROM coupons WHERE user_id = ? ORDER BY created_at, code", userID); err != nil {
+		e.t.Fatal(err)
+	}
+	return codes
+}
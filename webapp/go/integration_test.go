@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 椅子の初期位置、乗車位置、目的地。どれもチェアタウン (初乗り500、距離あたり100) の中にある
+var (
+	testChairLocation = Coordinate{Latitude: 0, Longitude: 0}
+	testPickup        = Coordinate{Latitude: 10, Longitude: 10}
+	testDestination   = Coordinate{Latitude: 20, Longitude: 30}
+)
+
+// 乗車位置から目的地までの距離は30なので、割引前の運賃は 500 + 100 * 30
+const testFullFare = 3500
+
+// arrivedRide は椅子が目的地に到着し、評価を待っているライド
+type arrivedRide struct {
+	owner  *testOwner
+	chair  *testChair
+	user   *testUser
+	rideID string
+	fare   int
+}
+
+func newArrivedRide(e *testEnv) *arrivedRide {
+	e.t.Helper()
+	owner := e.registerOwner("owner1")
+	chair := e.registerChair(owner, "chair1", testChairLocation)
+	user := e.registerUser("user1", nil)
+
+	ride := user.requestRide(testPickup, testDestination)
+	e.match()
+	if chairID := e.assignedChairID(ride.RideID); chairID != chair.ID {
+		e.t.Fatalf("ride was matched with %q, want %q", chairID, chair.ID)
+	}
+	chair.driveToArrival(ride.RideID, testPickup, testDestination)
+	user.waitStatus(ride.RideID, "ARRIVED")
+
+	return &arrivedRide{owner: owner, chair: chair, user: user, rideID: ride.RideID, fare: ride.Fare}
+}
+
+func (r *arrivedRide) evaluate(wantStatus int) {
+	r.user.env.t.Helper()
+	r.user.do(http.MethodPost, "/api/app/rides/"+r.rideID+"/evaluation", &appPostRideEvaluationRequest{Evaluation: 5}, wantStatus, nil)
+}
+
+func TestIntegrationRideLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+
+	owner := e.registerOwner("owner1")
+	chair := e.registerChair(owner, "chair1", testChairLocation)
+	user := e.registerUser("user1", nil)
+
+	user.do(http.MethodGet, "/api/app/regions", nil, http.StatusOK, nil)
+
+	nearby := &appGetNearbyChairsResponse{}
+	user.do(http.MethodGet, "/api/app/nearby-chairs?latitude=0&longitude=0&distance=10", nil, http.StatusOK, nearby)
+	if len(nearby.Chairs) != 1 || nearby.Chairs[0].ID != chair.ID {
+		t.Errorf("nearby chairs = %+v, want only %s", nearby.Chairs, chair.ID)
+	}
+
+	// 初回利用クーポンで3000円引きになる
+	estimated := &appPostRidesEstimatedFareResponse{}
+	user.do(http.MethodPost, "/api/app/rides/estimated-fare", &appPostRidesEstimatedFareRequest{
+		PickupCoordinate:      &testPickup,
+		DestinationCoordinate: &testDestination,
+	}, http.StatusOK, estimated)
+	if estimated.Fare != testFullFare-3000 || estimated.Discount != 3000 {
+		t.Errorf("estimated fare = %d, discount = %d, want %d, 3000", estimated.Fare, estimated.Discount, testFullFare-3000)
+	}
+
+	ride := user.requestRide(testPickup, testDestination)
+	if ride.Fare != estimated.Fare {
+		t.Errorf("fare = %d, want estimated fare %d", ride.Fare, estimated.Fare)
+	}
+	user.waitStatus(ride.RideID, "MATCHING")
+	if data := chair.notification(); data != nil {
+		t.Fatalf("chair was notified of %s before matching", data.RideID)
+	}
+
+	e.match()
+	chair.driveToArrival(ride.RideID, testPickup, testDestination)
+	arrived := user.waitStatus(ride.RideID, "ARRIVED")
+	if arrived.Chair == nil || arrived.Chair.ID != chair.ID {
+		t.Errorf("notified chair = %+v, want %s", arrived.Chair, chair.ID)
+	}
+
+	evaluation := &appPostRideEvaluationResponse{}
+	user.do(http.MethodPost, "/api/app/rides/"+ride.RideID+"/evaluation", &appPostRideEvaluationRequest{Evaluation: 5}, http.StatusOK, evaluation)
+	if evaluation.CompletedAt == 0 {
+		t.Error("completed_at is empty")
+	}
+	if got := e.payments.amounts(user.PaymentToken); !slices.Equal(got, []int{ride.Fare}) {
+		t.Errorf("payments = %v, want [%d]", got, ride.Fare)
+	}
+	user.waitStatus(ride.RideID, "COMPLETED")
+	chair.expectNotification(ride.RideID, "COMPLETED")
+
+	rides := &getAppRidesResponse{}
+	user.do(http.MethodGet, "/api/app/rides", nil, http.StatusOK, rides)
+	if len(rides.Rides) != 1 {
+		t.Fatalf("rides = %+v, want 1 ride", rides.Rides)
+	}
+	if got := rides.Rides[0]; got.ID != ride.RideID || got.Fare != ride.Fare || got.Evaluation != 5 || got.Chair.ID != chair.ID || got.Chair.Owner != "owner1" {
+		t.Errorf("ride = %+v", got)
+	}
+	user.do(http.MethodGet, "/api/app/rides/"+ride.RideID+"/route", nil, http.StatusOK, nil)
+
+	// 売上は割引前の運賃で計上する
+	sales := &ownerGetSalesResponse{}
+	owner.do(http.MethodGet, "/api/owner/sales", nil, http.StatusOK, sales)
+	if sales.TotalSales != testFullFare {
+		t.Errorf("total sales = %d, want %d", sales.TotalSales, testFullFare)
+	}
+
+	// (0, 0) → (10, 10) → (20, 30) と移動した
+	chairs := &ownerGetChairResponse{}
+	owner.do(http.MethodGet, "/api/owner/chairs", nil, http.StatusOK, chairs)
+	if len(chairs.Chairs) != 1 || chairs.Chairs[0].TotalDistance != 50 {
+		t.Errorf("chairs = %+v, want 1 chair with total distance 50", chairs.Chairs)
+	}
+	owner.do(http.MethodGet, "/api/owner/chairs/"+chair.ID+"/activities", nil, http.StatusOK, nil)
+	owner.do(http.MethodGet, "/api/owner/rides/"+ride.RideID+"/route", nil, http.StatusOK, nil)
+	owner.do(http.MethodGet, "/api/owner/movement-flags", nil, http.StatusOK, nil)
+
+	// 完了したので次のライドを要求できる
+	next := user.requestRide(testPickup, testDestination)
+	if next.Fare != testFullFare {
+		t.Errorf("second ride fare = %d, want %d", next.Fare, testFullFare)
+	}
+}
+
+func TestIntegrationInvitation(t *testing.T) {
+	e := newTestEnv(t)
+
+	inviter := e.registerUser("inviter", nil)
+	invitees := []*testUser{}
+	for i := range 3 {
+		invitees = append(invitees, e.registerUser(fmt.Sprintf("invitee%d", i), &inviter.InvitationCode))
+	}
+
+	for _, invitee := range invitees {
+		if got, want := e.couponCodes(invitee.ID), []string{"CP_NEW2024", "INV_" + inviter.InvitationCode}; !slices.Equal(got, want) {
+			t.Errorf("coupons of %s = %v, want %v", invitee.ID, got, want)
+		}
+	}
+	rewards := 0
+	for _, code := range e.couponCodes(inviter.ID) {
+		if strings.HasPrefix(code, "RWD_"+inviter.InvitationCode+"_") {
+			rewards++
+		}
+	}
+	if rewards != 3 {
+		t.Errorf("inviter has %d reward coupons, want 3", rewards)
+	}
+
+	// 招待できるのは3人まで
+	e.newClient().do(http.MethodPost, "/api/app/users", &appPostUsersRequest{
+		Username:       "invitee3",
+		FirstName:      "invitee3",
+		LastName:       "test",
+		DateOfBirth:    "2000-01-01",
+		InvitationCode: &inviter.InvitationCode,
+	}, http.StatusBadRequest, nil)
+
+	unknown := "unknown"
+	e.newClient().do(http.MethodPost, "/api/app/users", &appPostUsersRequest{
+		Username:       "stranger",
+		FirstName:      "stranger",
+		LastName:       "test",
+		DateOfBirth:    "2000-01-01",
+		InvitationCode: &unknown,
+	}, http.StatusBadRequest, nil)
+
+	// 初回のライドでは招待クーポンより初回利用クーポンを使う
+	ride := invitees[0].requestRide(testPickup, testDestination)
+	if ride.Fare != testFullFare-3000 {
+		t.Errorf("fare = %d, want %d", ride.Fare, testFullFare-3000)
+	}
+	usedBy := ""
+	if err := e.db.Get(&usedBy, "SELECT code FROM coupons WHERE used_by = ?", ride.RideID); err != nil {
+		t.Fatal(err)
+	}
+	if usedBy != "CP_NEW2024" {
+		t.Errorf("used coupon = %s, want CP_NEW2024", usedBy)
+	}
+}
+
+func TestIntegrationPayment(t *testing.T) {
+	t.Run("決済サーバーがエラーを返したら再送する", func(t *testing.T) {
+		e := newTestEnv(t)
+		r := newArrivedRide(e)
+		e.payments.failNextPayments(1, false)
+
+		r.evaluate(http.StatusOK)
+		if got := e.payments.amounts(r.user.PaymentToken); !slices.Equal(got, []int{r.fare}) {
+			t.Errorf("payments = %v, want [%d]", got, r.fare)
+		}
+		if e.payments.posts() != 2 {
+			t.Errorf("payment was requested %d times, want 2", e.payments.posts())
+		}
+	})
+
+	t.Run("エラーが返っても決済済みなら再送しない", func(t *testing.T) {
+		e := newTestEnv(t)
+		r := newArrivedRide(e)
+		e.payments.failNextPayments(1, true)
+
+		r.evaluate(http.StatusOK)
+		if got := e.payments.amounts(r.user.PaymentToken); !slices.Equal(got, []int{r.fare}) {
+			t.Errorf("payments = %v, want [%d]", got, r.fare)
+		}
+		if e.payments.posts() != 1 {
+			t.Errorf("payment was requested %d times, want 1", e.payments.posts())
+		}
+	})
+
+	t.Run("決済に失敗したらライドを完了しない", func(t *testing.T) {
+		e := newTestEnv(t)
+		r := newArrivedRide(e)
+		e.payments.failNextPayments(paymentGatewayConfig.MaxRetries+1, false)
+
+		r.evaluate(http.StatusBadGateway)
+		status := ""
+		if err := e.db.Get(&status, "SELECT status FROM ride_statuses WHERE ride_id = ? ORDER BY created_at DESC LIMIT 1", r.rideID); err != nil {
+			t.Fatal(err)
+		}
+		if status != "ARRIVED" {
+			t.Errorf("status = %s, want ARRIVED", status)
+		}
+
+		// 決済サーバーが回復すれば評価し直せる
+		r.evaluate(http.StatusOK)
+	})
+
+	t.Run("決済トークンが無い", func(t *testing.T) {
+		e := newTestEnv(t)
+		r := newArrivedRide(e)
+		if _, err := e.db.Exec("DELETE FROM payment_tokens WHERE user_id = ?", r.user.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		r.evaluate(http.StatusBadRequest)
+		if got := e.payments.amounts(r.user.PaymentToken); len(got) != 0 {
+			t.Errorf("payments = %v, want none", got)
+		}
+	})
+}
+
+func TestIntegrationConcurrentMatching(t *testing.T) {
+	// 同時に実行されたマッチングが、同じ椅子に複数のライドを割り当てないこと
+	for _, chairCount := range []int{1, 2} {
+		t.Run(fmt.Sprintf("%d chairs", chairCount), func(t *testing.T) {
+			e := newTestEnv(t)
+			owner := e.registerOwner("owner1")
+			for i := range chairCount {
+				e.registerChair(owner, fmt.Sprintf("chair%d", i), testChairLocation)
+			}
+			rideIDs := []string{}
+			for i := range 3 {
+				user := e.registerUser(fmt.Sprintf("user%d", i), nil)
+				rideIDs = append(rideIDs, user.requestRide(testPickup, testDestination).RideID)
+			}
+
+			wg := sync.WaitGroup{}
+			errs := make(chan error, 10)
+			for range 10 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					status, err := e.newClient().status(http.MethodGet, "/api/internal/matching", nil)
+					if err == nil && status != http.StatusNoContent {
+						err = fmt.Errorf("status = %d, want %d", status, http.StatusNoContent)
+					}
+					if err != nil {
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Errorf("matching failed: %v", err)
+			}
+
+			assigned := map[string][]string{}
+			for _, rideID := range rideIDs {
+				if chairID := e.assignedChairID(rideID); chairID != "" {
+					assigned[chairID] = append(assigned[chairID], rideID)
+				}
+			}
+			if len(assigned) == 0 {
+				t.Error("no ride was matched")
+			}
+			for chairID, rides := range assigned {
+				if len(rides) > 1 {
+					t.Errorf("chair %s was matched with %d rides: %v", chairID, len(rides), rides)
+				}
+			}
+		})
+	}
+}
+
+func TestIntegrationOwnerSettings(t *testing.T) {
+	e := newTestEnv(t)
+	owner := e.registerOwner("owner1")
+	chair := e.registerChair(owner, "chair1", testChairLocation)
+
+	schedules := &ownerPutChairSchedulesRequest{Schedules: []ownerChairSchedule{
+		{DayOfWeek: 1, StartMinute: 9 * 60, EndMinute: 17 * 60},
+	}}
+	owner.do(http.MethodPut, "/api/owner/chairs/"+chair.ID+"/schedules", schedules, http.StatusNoContent, nil)
+	got := &ownerGetChairSchedulesResponse{}
+	owner.do(http.MethodGet, "/api/owner/chairs/"+chair.ID+"/schedules", nil, http.StatusOK, got)
+	if !slices.Equal(got.Schedules, schedules.Schedules) {
+		t.Errorf("schedules = %+v, want %+v", got.Schedules, schedules.Schedules)
+	}
+
+	other := e.registerOwner("owner2")
+	other.do(http.MethodGet, "/api/owner/chairs/"+chair.ID+"/schedules", nil, http.StatusNotFound, nil)
+
+	created := &ownerPostAPIKeysResponse{}
+	owner.do(http.MethodPost, "/api/owner/api-keys", &ownerPostAPIKeysRequest{Name: "sales", Scopes: []string{"sales:read"}}, http.StatusCreated, created)
+	owner.do(http.MethodGet, "/api/owner/api-keys", nil, http.StatusOK, nil)
+
+	apiClient := e.newClient()
+	apiClient.bearer = created.Key
+	apiClient.do(http.MethodGet, "/api/owner/sales", nil, http.StatusOK, &ownerGetSalesResponse{})
+	// APIキーではAPIキーを管理できない
+	apiClient.do(http.MethodGet, "/api/owner/api-keys", nil, http.StatusForbidden, nil)
+
+	owner.do(http.MethodDelete, "/api/owner/api-keys/"+created.ID, nil, http.StatusNoContent, nil)
+	owner.do(http.MethodDelete, "/api/owner/api-keys/"+created.ID, nil, http.StatusNotFound, nil)
+}
+
+func TestIntegrationSession(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.registerUser("user1", nil)
+
+	rotated := &postSessionResponse{}
+	user.do(http.MethodPost, "/api/app/session/rotate", nil, http.StatusOK, rotated)
+	if rotated.ExpiresAt == 0 {
+		t.Error("expires_at is empty")
+	}
+	// 新しいアクセストークンで引き続き利用できる
+	user.do(http.MethodGet, "/api/app/rides", nil, http.StatusOK, &getAppRidesResponse{})
+
+	user.do(http.MethodPost, "/api/app/logout", nil, http.StatusNoContent, nil)
+	user.do(http.MethodPost, "/api/app/logout", nil, http.StatusUnauthorized, nil)
+}
+
+func TestIntegrationDrainCount(t *testing.T) {
+	e := newTestEnv(t)
+	count := func() int {
+		t.Helper()
+		n, err := countInFlightRides(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	initial := count()
+
+	ride := newArrivedRide(e)
+	if got := count(); got != initial+1 {
+		t.Errorf("in-flight rides = %d, want %d", got, initial+1)
+	}
+
+	// 空いている椅子が無いので、マッチングされないまま残る
+	waiting := e.registerUser("user2", nil)
+	waiting.requestRide(testPickup, testDestination)
+	e.match()
+	if got := count(); got != initial+1 {
+		t.Errorf("in-flight rides with a stale MATCHING ride = %d, want %d", got, initial+1)
+	}
+
+	// 椅子に完了を通知するまでは進行中として数える
+	ride.evaluate(http.StatusOK)
+	if got := count(); got != initial+1 {
+		t.Errorf("in-flight rides before notifying the chair = %d, want %d", got, initial+1)
+	}
+	ride.chair.expectNotification(ride.rideID, "COMPLETED")
+	if got := count(); got != initial {
+		t.Errorf("in-flight rides after completion = %d, want %d", got, initial)
+	}
+}
+
+func TestIntegrationAdmin(t *testing.T) {
+	e := newTestEnv(t)
+	owner := e.registerOwner("owner1")
+	chair := e.registerChair(owner, "chair1", testChairLocation)
+	user := e.registerUser("user1", nil)
+	admin := e.newClient()
+	admin.bearer = testAdminToken
+
+	e.newClient().do(http.MethodGet, "/api/admin/rides", nil, http.StatusUnauthorized, nil)
+
+	// 手動で割り当てたライドをキャンセルすると、椅子は次のライドを担当できる
+	first := user.requestRide(testPickup, testDestination)
+	admin.do(http.MethodPost, "/api/admin/rides/"+first.RideID+"/match", &adminPostRideMatchRequest{ChairID: chair.ID}, http.StatusOK, nil)
+	chair.expectNotification(first.RideID, "MATCHING")
+
+	second := e.registerUser("user2", nil).requestRide(testPickup, testDestination)
+	admin.do(http.MethodPost, "/api/admin/rides/"+second.RideID+"/match", &adminPostRideMatchRequest{ChairID: chair.ID}, http.StatusConflict, nil)
+
+	canceled := &adminRideDetail{}
+	admin.do(http.MethodPost, "/api/admin/rides/"+first.RideID+"/cancel", &adminPostRideActionRequest{Reason: "test"}, http.StatusOK, canceled)
+	if canceled.Status != "CANCELED" {
+		t.Errorf("status = %s, want CANCELED", canceled.Status)
+	}
+	user.waitStatus(first.RideID, "CANCELED")
+	chair.expectNotification(first.RideID, "CANCELED")
+
+	admin.do(http.MethodPost, "/api/admin/rides/"+second.RideID+"/match", &adminPostRideMatchRequest{ChairID: chair.ID}, http.StatusOK, nil)
+	admin.do(http.MethodPost, "/api/admin/rides/"+first.RideID+"/complete", nil, http.StatusBadRequest, nil)
+
+	rides := &adminGetRidesResponse{}
+	admin.do(http.MethodGet, "/api/admin/rides?chair_id="+chair.ID+"&status=MATCHING", nil, http.StatusOK, rides)
+	if len(rides.Rides) != 1 || rides.Rides[0].ID != second.RideID {
+		t.Errorf("rides = %+v, want only %s", rides.Rides, second.RideID)
+	}
+}
+
+func TestIntegrationInitialize(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.registerUser("user1", nil)
+	paymentServer := ""
+	if err := e.db.Get(&paymentServer, "SELECT value FROM settings WHERE name = 'payment_gateway_url'"); err != nil {
+		t.Fatal(err)
+	}
+
+	client := e.newClient()
+	client.do(http.MethodPost, "/api/initialize", &postInitializeRequest{PaymentServer: paymentServer, Dataset: "missing"}, http.StatusBadRequest, nil)
+	// データセットが無い場合はテーブルを作り直さない
+	user.do(http.MethodGet, "/api/app/rides", nil, http.StatusOK, nil)
+
+	res := &postInitializeResponse{}
+	client.do(http.MethodPost, "/api/initialize", &postInitializeRequest{PaymentServer: paymentServer}, http.StatusOK, res)
+	if res.Language != "go" || res.Dataset != "default" || res.Timings == nil {
+		t.Errorf("response = %+v, want go with timings of default dataset", res)
+	}
+
+	var userCount int
+	if err := e.db.Get(&userCount, "SELECT COUNT(*) FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if userCount == 0 {
+		t.Error("initial data was not loaded")
+	}
+	// 初期化前に登録した利用者は消え、キャッシュされたセッションも使えない
+	user.do(http.MethodGet, "/api/app/rides", nil, http.StatusUnauthorized, nil)
+}
+
+func TestIntegrationAccount(t *testing.T) {
+	e := newTestEnv(t)
+	r := newArrivedRide(e)
+
+	// 進行中のライドがある間は退会できない
+	r.user.do(http.MethodDelete, "/api/app/me", nil, http.StatusConflict, nil)
+	r.evaluate(http.StatusOK)
+
+	r.user.do(http.MethodPatch, "/api/app/me", map[string]string{"username": "owner1"}, http.StatusOK, nil)
+	e.registerUser("user2", nil)
+	r.user.do(http.MethodPatch, "/api/app/me", map[string]string{"username": "user2"}, http.StatusConflict, nil)
+
+	export := &appGetMeExportResponse{}
+	r.user.do(http.MethodGet, "/api/app/me/export", nil, http.StatusOK, export)
+	if len(export.Rides) != 1 || len(export.Payments) != 1 || export.Payments[0].Amount != r.fare {
+		t.Errorf("export = %+v, want a completed ride paid %d", export, r.fare)
+	}
+
+	r.user.do(http.MethodDelete, "/api/app/me", nil, http.StatusNoContent, nil)
+	r.user.do(http.MethodGet, "/api/app/me", nil, http.StatusUnauthorized, nil)
+	// 退会したユーザー名は他のユーザーが使える
+	e.registerUser("owner1", nil)
+
+	// 退会してもオーナーの売上は変わらない
+	sales := &ownerGetSalesResponse{}
+	r.owner.do(http.MethodGet, "/api/owner/sales", nil, http.StatusOK, sales)
+	if sales.TotalSales != testFullFare {
+		t.Errorf("total sales = %d, want %d", sales.TotalSales, testFullFare)
+	}
+}
+
+func TestIntegrationPlaces(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.registerUser("user1", nil)
+
+	home := &appPlace{}
+	user.do(http.MethodPost, "/api/app/places", &appPostPlaceRequest{Kind: placeKindHome, Coordinate: &testDestination}, http.StatusCreated, home)
+	user.do(http.MethodPost, "/api/app/places", &appPostPlaceRequest{Kind: placeKindHome, Coordinate: &testPickup}, http.StatusConflict, nil)
+
+	// 保存した場所を目的地にしてライドを要求できる
+	ride := &appPostRidesResponse{}
+	user.do(http.MethodPost, "/api/app/rides", &appPostRidesRequest{PickupCoordinate: &testPickup, DestinationPlaceID: &home.ID}, http.StatusAccepted, ride)
+
+	recent := &appGetRecentDestinationsResponse{}
+	user.do(http.MethodGet, "/api/app/recent-destinations", nil, http.StatusOK, recent)
+	if len(recent.Destinations) != 1 || recent.Destinations[0].Coordinate != testDestination || recent.Destinations[0].PlaceID == nil || *recent.Destinations[0].PlaceID != home.ID {
+		t.Errorf("recent destinations = %+v, want home", recent.Destinations)
+	}
+
+	user.do(http.MethodDelete, "/api/app/places/"+home.ID, nil, http.StatusNoContent, nil)
+	user.do(http.MethodPost, "/api/app/rides/estimated-fare", &appPostRidesEstimatedFareRequest{PickupCoordinate: &testPickup, DestinationPlaceID: &home.ID}, http.StatusNotFound, nil)
+}
+
+func TestIntegrationChairPreferences(t *testing.T) {
+	e := newTestEnv(t)
+	owner := e.registerOwner("owner1")
+	chair := e.registerChair(owner, "chair1", testChairLocation)
+	user := e.registerUser("user1", nil)
+	admin := e.newClient()
+	admin.bearer = testAdminToken
+
+	// 椅子のモデルより速い椅子を希望すると、条件を外すまでマッチングしない
+	minSpeed := 3
+	ride := &appPostRidesResponse{}
+	user.do(http.MethodPost, "/api/app/rides", &appPostRidesRequest{
+		PickupCoordinate:      &testPickup,
+		DestinationCoordinate: &testDestination,
+		ChairPreferences:      &appRideChairPreferences{MinSpeed: &minSpeed},
+	}, http.StatusAccepted, ride)
+	e.match()
+	if chairID := e.assignedChairID(ride.RideID); chairID != "" {
+		t.Fatalf("chair %s was assigned, want no chairs satisfying the preferences", chairID)
+	}
+
+	admin.do(http.MethodPut, "/api/admin/settings/ride_preference_timeout_seconds", &adminPutSettingRequest{Value: "0"}, http.StatusOK, nil)
+	e.match()
+	if chairID := e.assignedChairID(ride.RideID); chairID != chair.ID {
+		t.Errorf("assigned chair = %s, want %s after relaxing the preferences", chairID, chair.ID)
+	}
+}
+
+func TestIntegrationWebhooks(t *testing.T) {
+	e := newTestEnv(t)
+	r := newArrivedRide(e)
+	receiver, received := newWebhookReceiver(t)
+
+	webhook := &ownerPostWebhookResponse{}
+	r.owner.do(http.MethodPost, "/api/owner/webhooks", &ownerPostWebhookRequest{
+		URL:        receiver.URL,
+		EventTypes: []string{webhookEventRideCompleted, webhookEventChairDeactivated},
+	}, http.StatusCreated, webhook)
+
+	r.evaluate(http.StatusOK)
+	r.chair.do(http.MethodPost, "/api/chair/activity", &postChairActivityRequest{IsActive: false}, http.StatusNoContent, nil)
+	// 停止済みの椅子をもう一度停止しても通知しない
+	r.chair.do(http.MethodPost, "/api/chair/activity", &postChairActivityRequest{IsActive: false}, http.StatusNoContent, nil)
+	if err := deliverDueWebhooks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	events := []string{}
+	for _, webhook := range received() {
+		events = append(events, webhook.header.Get("X-Isuride-Event"))
+	}
+	if strings.Join(events, ",") != "ride.completed,chair.deactivated" {
+		t.Errorf("received events = %v, want ride.completed and chair.deactivated", events)
+	}
+
+	deliveries := &ownerGetWebhookDeliveriesResponse{}
+	r.owner.do(http.MethodGet, "/api/owner/webhooks/"+webhook.ID+"/deliveries", nil, http.StatusOK, deliveries)
+	if len(deliveries.Deliveries) != 2 || deliveries.Deliveries[0].Status != "SUCCEEDED" || deliveries.Deliveries[1].Status != "SUCCEEDED" {
+		t.Errorf("deliveries = %+v, want 2 succeeded deliveries", deliveries.Deliveries)
+	}
+}
@@ -1,53 +1,173 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"errors"
+	"log/slog"
 	"net/http"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
 )
 
 // このAPIをインスタンス内から一定間隔で叩かせることで、椅子とライドをマッチングさせる
 func internalGetMatching(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	// MEMO: 一旦最も待たせているリクエストに適当な空いている椅子マッチさせる実装とする。おそらくもっといい方法があるはず…
-	ride := &Ride{}
-	if err := db.GetContext(ctx, ride, `SELECT * FROM rides WHERE chair_id IS NULL ORDER BY created_at LIMIT 1`); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
+	if err := matchRide(r.Context()); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ISUCON_MATCHING_INTERVALが設定されている場合は、APIを叩かせる代わりにアプリケーション内で定期的にマッチングさせる
+func runMatchingWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// 停止の途中でマッチングを中断しないよう、実行中のマッチングは最後まで行う
+			if err := matchRide(context.WithoutCancel(ctx)); err != nil {
+				slog.Error("failed to match ride", "error", err)
+			}
+		}
+	}
+}
+
+// 1回のマッチングで割り当てを試すライドの数
+const matchingRideCandidates = 10
+
+// マッチング待ちのライドが無い場合や、空いている椅子が見つからなかった場合は何もしない
+func matchRide(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "matchRide")
+	defer span.End()
+
+	// MEMO: 一旦最も待たせているリクエストに適当な空いている椅子マッチさせる実装とする。おそらくもっといい方法があるはず…
+	// 希望する椅子の条件があるライドは条件に合う椅子が空くまで待たせるので、その間は後ろのライドを先にマッチングさせる
+	rides, err := dataStore.Rides().ListWaiting(ctx, matchingRideCandidates)
+	if err != nil {
+		return err
+	}
+	if len(rides) == 0 {
+		return nil
+	}
+
+	timeout, err := getIntSetting(ctx, dataStore, "ride_preference_timeout_seconds")
+	if err != nil {
+		return err
+	}
+	// ライドの要求日時と比べるので、データベースの時刻を使う
+	now, err := dataStore.Now(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range rides {
+		ride := &rides[i]
+		preference, err := dataStore.Rides().GetChairPreference(ctx, ride.ID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		relaxed := preference != nil && preferenceRelaxed(ride, time.Duration(timeout)*time.Second, now)
+		if relaxed {
+			preference = nil
+		}
+
+		settled, err := matchRideWithChair(ctx, ride, preference)
+		if err != nil {
+			return err
+		}
+		if settled {
+			if relaxed {
+				audit(ctx, auditRidePreferencesRelaxed, slog.String("ride_id", ride.ID))
+			}
+			return nil
+		}
+		// 条件の無いライドに空いている椅子が無ければ、後ろのライドにも無い
+		if preference == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// matchRideWithChair は空いている椅子から希望する条件に合うものを選んでライドに割り当てる。preferenceがnilの場合はすべての椅子から選ぶ
+func matchRideWithChair(ctx context.Context, ride *Ride, preference *RideChairPreference) (bool, error) {
+	// 乗車位置と同じ地域を拠点とする椅子か、拠点が未定の椅子から選ぶ
+	filter := store.ChairFilter{}
+	if region, ok := findRegion(ride.PickupLatitude, ride.PickupLongitude); ok {
+		filter.RegionID = region.ID
+	}
+	if preference != nil {
+		filter.MinSpeed, filter.Models = preference.MinSpeed, preference.ModelList()
+	}
 
-	matched := &Chair{}
-	empty := false
 	for i := 0; i < 10; i++ {
-		if err := db.GetContext(ctx, matched, "SELECT * FROM chairs INNER JOIN (SELECT id FROM chairs WHERE is_active = TRUE ORDER BY RAND() LIMIT 1) AS tmp ON chairs.id = tmp.id LIMIT 1"); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				w.WriteHeader(http.StatusNoContent)
-				return
+		matched, err := dataStore.Chairs().FindAvailable(ctx, filter)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return false, nil
 			}
-			writeError(w, http.StatusInternalServerError, err)
+			return false, err
 		}
 
-		if err := db.GetContext(ctx, &empty, "SELECT COUNT(*) = 0 FROM (SELECT COUNT(chair_sent_at) = 6 AS completed FROM ride_statuses WHERE ride_id IN (SELECT id FROM rides WHERE chair_id = ?) GROUP BY ride_id) is_completed WHERE completed = FALSE", matched.ID); err != nil {
-			writeError(w, http.StatusInternalServerError, err)
-			return
+		// 平均評価はライドの履歴から求めるので、選んだ椅子ごとに確かめる
+		ok, err := satisfiesMinRating(ctx, dataStore, matched.ID, preference)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
 		}
-		if empty {
-			break
+
+		settled, err := assignChair(ctx, ride, matched.ID)
+		if err != nil {
+			return false, err
+		}
+		if settled {
+			return true, nil
 		}
 	}
-	if !empty {
-		w.WriteHeader(http.StatusNoContent)
-		return
+	return false, nil
+}
+
+// assignChair は椅子が空いていればライドに割り当てる。
+// ライドが割り当て済みになった場合は、他のマッチングで割り当てられた場合も含めてtrueを返す
+func assignChair(ctx context.Context, ride *Ride, chairID string) (bool, error) {
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		return false, err
 	}
+	defer tx.Rollback()
 
-	if _, err := db.ExecContext(ctx, "UPDATE rides SET chair_id = ? WHERE id = ?", matched.ID, ride.ID); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
+	// 同時に実行されたマッチングが同じ椅子を選んでも二重に割り当てないよう、椅子をロックしてから空きを確認する。
+	// ロックを取る前に読み込むと、待っている間に割り当てられたライドが見えないため、最初のクエリでロックを取る
+	if _, err := tx.Chairs().GetForUpdate(ctx, chairID); err != nil {
+		return false, err
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	busy, err := tx.Rides().HasUnfinishedForChair(ctx, chairID)
+	if err != nil {
+		return false, err
+	}
+	if busy {
+		return false, nil
+	}
+
+	assigned, err := tx.Rides().AssignChair(ctx, ride.ID, chairID)
+	if err != nil {
+		return false, err
+	}
+	if !assigned {
+		// 他のマッチングで割り当て済み
+		return true, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	recordRideMatched(ctx, ride, chairID)
+	return true, nil
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestMatchRide(t *testing.T) {
+	ctx := context.Background()
+	m := newTestStore(t)
+	m.AddChairModel(ChairModel{Name: "リラックスシート NEO", Speed: 5})
+	addTestOwner(t, m, "owner1", "busy", "free", "inactive")
+	for _, chairID := range []string{"busy", "free"} {
+		if _, err := m.Chairs().SetActive(ctx, chairID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user := addTestUser(t, m, "user1")
+
+	createRide := func(id, status string) {
+		t.Helper()
+		if err := m.Rides().Create(ctx, &Ride{ID: id, UserID: user.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Rides().CreateStatus(ctx, &RideStatus{ID: id + "-" + status, RideID: id, Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 椅子がまだ終えていないライド
+	createRide("carrying", "MATCHING")
+	if err := m.AssignChair("carrying", "busy"); err != nil {
+		t.Fatal(err)
+	}
+	// キャンセルされたライドは割り当てない
+	createRide("canceled", "CANCELED")
+	createRide("waiting", "MATCHING")
+
+	for range 5 {
+		if err := matchRide(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	canceled, err := m.Rides().Get(ctx, "canceled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canceled.ChairID.Valid {
+		t.Errorf("canceled ride was assigned to %s", canceled.ChairID.String)
+	}
+	waiting, err := m.Rides().Get(ctx, "waiting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waiting.ChairID.String != "free" {
+		t.Errorf("waiting ride chair = %q, want free", waiting.ChairID.String)
+	}
+}
+
+func TestAssignChair(t *testing.T) {
+	ctx := context.Background()
+	m := newTestStore(t)
+	addTestOwner(t, m, "owner1", "busy", "free", "other")
+	user := addTestUser(t, m, "user1")
+
+	createRide := func(id string) *Ride {
+		t.Helper()
+		if err := m.Rides().Create(ctx, &Ride{ID: id, UserID: user.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Rides().CreateStatus(ctx, &RideStatus{ID: id + "-MATCHING", RideID: id, Status: "MATCHING"}); err != nil {
+			t.Fatal(err)
+		}
+		ride, err := m.Rides().Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ride
+	}
+	chairOf := func(id string) string {
+		t.Helper()
+		ride, err := m.Rides().Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ride.ChairID.String
+	}
+
+	createRide("carrying")
+	if err := m.AssignChair("carrying", "busy"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 椅子が他のライドを終えていなければ割り当てず、別の椅子を探させる
+	waiting := createRide("waiting")
+	if settled, err := assignChair(ctx, waiting, "busy"); err != nil || settled {
+		t.Errorf("assignChair(busy) = %t, %v, want false", settled, err)
+	}
+	if got := chairOf("waiting"); got != "" {
+		t.Errorf("ride was assigned to busy chair %q", got)
+	}
+
+	// 同時に同じ椅子を選んだマッチングのうち、割り当てられるのは1件だけ
+	rides := []*Ride{waiting, createRide("rival1"), createRide("rival2")}
+	var wg sync.WaitGroup
+	for _, ride := range rides {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := assignChair(ctx, ride, "free"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	assigned := 0
+	for _, ride := range rides {
+		if chairOf(ride.ID) == "free" {
+			assigned++
+		}
+	}
+	if assigned != 1 {
+		t.Errorf("%d rides were assigned to the same chair, want 1", assigned)
+	}
+
+	// 他のマッチングで割り当て済みのライドは、椅子を付け替えずに割り当て済みとして扱う
+	taken := createRide("taken")
+	if err := m.AssignChair("taken", "busy"); err != nil {
+		t.Fatal(err)
+	}
+	if settled, err := assignChair(ctx, taken, "other"); err != nil || !settled {
+		t.Errorf("assignChair(already assigned) = %t, %v, want true", settled, err)
+	}
+	if got := chairOf("taken"); got != "busy" {
+		t.Errorf("assigned chair = %q, want busy", got)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+)
+
+// アクセスログと監査ログは alp や jq で集計できるよう、1行1件のJSONで出力する。
+// 出力先を分けない場合でも log 属性で区別できる
+var (
+	accessLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("log", "access")
+	auditLogger  = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("log", "audit")
+)
+
+// setupLogging は設定に従ってログの出力先を切り替え、開いたファイルを閉じる関数を返す
+func setupLogging(cfg *config.Config) (closeLogs func() error, err error) {
+	level, err := cfg.SlogLevel()
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.LogFormat == "text" {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, opts)))
+	} else {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, opts)))
+	}
+
+	var files []io.Closer
+	closeLogs = func() error {
+		var errs []error
+		for _, f := range files {
+			errs = append(errs, f.Close())
+		}
+		return errors.Join(errs...)
+	}
+	open := func(path string) (io.Writer, error) {
+		if path == "" {
+			return os.Stdout, nil
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return f, nil
+	}
+
+	accessLog, err := open(cfg.AccessLogPath)
+	if err != nil {
+		closeLogs()
+		return nil, err
+	}
+	auditLog, err := open(cfg.AuditLogPath)
+	if err != nil {
+		closeLogs()
+		return nil, err
+	}
+	accessLogger = slog.New(slog.NewJSONHandler(accessLog, nil)).With("log", "access")
+	auditLogger = slog.New(slog.NewJSONHandler(auditLog, nil)).With("log", "audit")
+	return closeLogs, nil
+}
+
+// リクエストごとの情報。監査ログに誰のどのリクエストによる操作かを記録するために使う
+type requestInfo struct {
+	requestID string
+	principal auth.Principal
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) (*requestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info, ok
+}
+
+// accessLogMiddleware はリクエストごとにアクセスログを出力する。
+// キーは alp のJSON形式の既定値 (uri, method, status, body_bytes, response_time) に合わせている
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{requestID: r.Header.Get(apierror.RequestIDHeader)}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		ctx = auth.WithPrincipalRecorder(ctx, &info.principal)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := ""
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			accessLogger.LogAttrs(ctx, slog.LevelInfo, "access",
+				slog.String("method", r.Method),
+				slog.String("uri", r.URL.RequestURI()),
+				slog.String("route", route),
+				slog.Int("status", status),
+				slog.Int("body_bytes", ww.BytesWritten()),
+				slog.Float64("response_time", time.Since(start).Seconds()),
+				slog.String("request_id", info.requestID),
+				slog.String("principal_role", string(info.principal.Role)),
+				slog.String("principal_id", info.principal.ID),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("user_agent", r.UserAgent()),
+			)
+		}()
+		next.ServeHTTP(ww, r.WithContext(ctx))
+	})
+}
+
+// 監査ログに記録する業務上のイベント
+const (
+	auditRideCreated       = "ride.created"
+	auditRideMatched       = "ride.matched"
+	auditRideStatusChanged = "ride.status_changed"
+	auditPaymentAttempted  = "payment.attempted"
+	auditCouponApplied     = "coupon.applied"
+	auditAdminRideFinished = "admin.ride_finished"
+	auditAdminRideMatched  = "admin.ride_matched"
+	auditSettingUpdated    = "setting.updated"
+	auditUserUpdated       = "user.updated"
+	auditUserDeleted       = "user.deleted"
+	// 希望する椅子の条件を満たす椅子が見つからず、条件を外してマッチングした
+	auditRidePreferencesRelaxed = "ride.chair_preferences_relaxed"
+)
+
+// audit は業務上のイベントを監査ログに記録する。
+// リクエストの中で呼ばれた場合はリクエストIDと操作した利用者を、そうでなければ system を操作者として記録する。
+// 認証の無いリクエストでは anonymous を操作者とする
+func audit(ctx context.Context, event string, attrs ...slog.Attr) {
+	actor := []slog.Attr{slog.String("actor_role", "system")}
+	if info, ok := requestInfoFrom(ctx); ok {
+		actor = []slog.Attr{slog.String("request_id", info.requestID), slog.String("actor_role", "anonymous")}
+		if info.principal.ID != "" {
+			actor = []slog.Attr{
+				slog.String("request_id", info.requestID),
+				slog.String("actor_role", string(info.principal.Role)),
+				slog.String("actor_id", info.principal.ID),
+			}
+		}
+	}
+	auditLogger.LogAttrs(ctx, slog.LevelInfo, event, append(actor, attrs...)...)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+// ログの出力先をバッファに差し替え、出力された1行1件のJSONを返す関数を返す
+func captureLogs(t *testing.T) (access, audits func() []map[string]any) {
+	t.Helper()
+	prevAccess, prevAudit := accessLogger, auditLogger
+	t.Cleanup(func() { accessLogger, auditLogger = prevAccess, prevAudit })
+
+	var accessBuf, auditBuf bytes.Buffer
+	accessLogger = slog.New(slog.NewJSONHandler(&accessBuf, nil))
+	auditLogger = slog.New(slog.NewJSONHandler(&auditBuf, nil))
+	parse := func(buf *bytes.Buffer) func() []map[string]any {
+		return func() []map[string]any {
+			var records []map[string]any
+			decoder := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+			for decoder.More() {
+				record := map[string]any{}
+				if err := decoder.Decode(&record); err != nil {
+					t.Fatal(err)
+				}
+				records = append(records, record)
+			}
+			return records
+		}
+	}
+	return parse(&accessBuf), parse(&auditBuf)
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	access, audits := captureLogs(t)
+
+	store := auth.NewMemoryStore()
+	store.AddUser(&model.User{ID: "user1"})
+	store.AddSession(&model.Session{Token: "user-token", Role: string(auth.RoleApp), PrincipalID: "user1", ExpiresAt: time.Now().Add(time.Hour)})
+	authenticator := auth.New(store, writeError)
+
+	mux := chi.NewRouter()
+	mux.Use(requestIDMiddleware)
+	mux.Use(accessLogMiddleware)
+	mux.With(authenticator.Middleware(auth.RoleApp)).HandleFunc("POST /api/app/rides/{ride_id}/evaluation", func(w http.ResponseWriter, r *http.Request) {
+		recordRideStatus(r.Context(), r.PathValue("ride_id"), "COMPLETED")
+		writeJSON(w, http.StatusOK, map[string]int{"completed_at": 0})
+	})
+	mux.HandleFunc("POST /api/app/users", func(w http.ResponseWriter, r *http.Request) {
+		audit(r.Context(), auditCouponApplied)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/app/rides/ride1/evaluation?debug=1", nil)
+	req.Header.Set("Authorization", "Bearer user-token")
+	req.Header.Set(apierror.RequestIDHeader, "request-1")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/app/users", nil))
+	// リクエストの外からの操作
+	recordRideStatus(context.Background(), "ride1", "MATCHING")
+
+	accessRecords := access()
+	if len(accessRecords) != 2 {
+		t.Fatalf("got %d access logs, want 2", len(accessRecords))
+	}
+	want := map[string]any{
+		"method":         "POST",
+		"uri":            "/api/app/rides/ride1/evaluation?debug=1",
+		"route":          "/api/app/rides/{ride_id}/evaluation",
+		"status":         float64(http.StatusOK),
+		"request_id":     "request-1",
+		"principal_role": "app",
+		"principal_id":   "user1",
+	}
+	for key, value := range want {
+		if accessRecords[0][key] != value {
+			t.Errorf("access log %s = %v, want %v", key, accessRecords[0][key], value)
+		}
+	}
+	if _, ok := accessRecords[0]["response_time"].(float64); !ok {
+		t.Errorf("access log has no response_time: %v", accessRecords[0])
+	}
+	if n, _ := accessRecords[0]["body_bytes"].(float64); n == 0 {
+		t.Errorf("access log body_bytes = %v", accessRecords[0]["body_bytes"])
+	}
+
+	auditRecords := audits()
+	if len(auditRecords) != 3 {
+		t.Fatalf("got %d audit logs, want 3", len(auditRecords))
+	}
+	wantAudits := []map[string]any{
+		{"msg": auditRideStatusChanged, "request_id": "request-1", "actor_role": "app", "actor_id": "user1", "ride_id": "ride1", "status": "COMPLETED"},
+		{"msg": auditCouponApplied, "actor_role": "anonymous"},
+		{"msg": auditRideStatusChanged, "actor_role": "system", "ride_id": "ride1", "status": "MATCHING"},
+	}
+	for i, want := range wantAudits {
+		for key, value := range want {
+			if auditRecords[i][key] != value {
+				t.Errorf("audit log %d %s = %v, want %v", i, key, auditRecords[i][key], value)
+			}
+		}
+	}
+	if _, ok := auditRecords[2]["request_id"]; ok {
+		t.Error("audit log outside of a request should not have request_id")
+	}
+}
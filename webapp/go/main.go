@@ -1,107 +1,245 @@
 package main
 
 import (
+	"context"
 	crand "crypto/rand"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
-	"net"
 	"net/http"
 	"os"
-	"os/exec"
-	"strconv"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-sql-driver/mysql"
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/ratelimit"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/sqlload"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/telemetry"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/validation"
+	"go.opentelemetry.io/otel"
 )
 
 var db *sqlx.DB
 
-func main() {
-	mux := setup()
-	slog.Info("Listening on :8080")
-	http.ListenAndServe(":8080", mux)
-}
+const telemetryShutdownTimeout = 5 * time.Second
 
-func setup() http.Handler {
-	host := os.Getenv("ISUCON_DB_HOST")
-	if host == "" {
-		host = "127.0.0.1"
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	port := os.Getenv("ISUCON_DB_PORT")
-	if port == "" {
-		port = "3306"
+	closeLogs, err := setupLogging(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	_, err := strconv.Atoi(port)
+	slog.Info("configuration loaded", "config", cfg)
+
+	shutdownTelemetry, err := telemetry.Setup(context.Background(), cfg.Telemetry.ServiceName, cfg.Telemetry.Exporter)
 	if err != nil {
-		panic(fmt.Sprintf("failed to convert DB port number from ISUCON_DB_PORT environment variable into int: %v", err))
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	user := os.Getenv("ISUCON_DB_USER")
-	if user == "" {
-		user = "isucon"
+
+	mux := setup(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	workers := &sync.WaitGroup{}
+	startWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workerCtx)
+		}()
 	}
-	password := os.Getenv("ISUCON_DB_PASSWORD")
-	if password == "" {
-		password = "isucon"
+	startWorker(func(ctx context.Context) { runChairScheduler(ctx, chairSchedulerInterval) })
+	startWorker(func(ctx context.Context) { runChairLocationCompactor(ctx, chairLocationCompactionInterval) })
+	if replicaDB != nil {
+		startWorker(func(ctx context.Context) { runReplicaLagMonitor(ctx, replicaLagCheckInterval, cfg.DB.ReplicaMaxLag) })
 	}
-	dbname := os.Getenv("ISUCON_DB_NAME")
-	if dbname == "" {
-		dbname = "isuride"
+	if cfg.Matching.Interval > 0 {
+		startWorker(func(ctx context.Context) { runMatchingWorker(ctx, cfg.Matching.Interval) })
 	}
+	startWorker(func(ctx context.Context) { runWebhookWorker(ctx, cfg.Webhook.Interval) })
+
+	server := newServer(cfg.ListenAddr, cfg.Server, mux)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	slog.Info("Listening on " + cfg.ListenAddr)
 
-	dbConfig := mysql.NewConfig()
-	dbConfig.User = user
-	dbConfig.Passwd = password
-	dbConfig.Addr = net.JoinHostPort(host, port)
-	dbConfig.Net = "tcp"
-	dbConfig.DBName = dbname
-	dbConfig.ParseTime = true
+	select {
+	case err := <-serverErr:
+		slog.Error("failed to serve", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	// 2回目のシグナルでは即座に終了させる
+	stop()
 
-	_db, err := sqlx.Connect("mysql", dbConfig.FormatDSN())
+	slog.Info("shutting down", "drain_timeout", cfg.DrainTimeout)
+	drain(cfg.DrainTimeout)
+	shutdownServer(server, cfg.Server.ShutdownTimeout)
+	stopWorkers()
+	workers.Wait()
+	if err := db.Close(); err != nil {
+		slog.Error("failed to close database", "error", err)
+	}
+	if replicaDB != nil {
+		if err := replicaDB.Close(); err != nil {
+			slog.Error("failed to close replica database", "error", err)
+		}
+	}
+	// 最後に送信しきれていないトレースとメトリクスを送る
+	telemetryCtx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+	defer cancel()
+	if err := shutdownTelemetry(telemetryCtx); err != nil {
+		slog.Error("failed to shutdown telemetry", "error", err)
+	}
+	slog.Info("shutdown completed")
+	if err := closeLogs(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func setup(cfg *config.Config) http.Handler {
+	sqlDB, err := telemetry.OpenDB("mysql", cfg.DB.DSN())
 	if err != nil {
 		panic(err)
 	}
+	_db := sqlx.NewDb(sqlDB, "mysql")
+	if err := _db.Ping(); err != nil {
+		panic(err)
+	}
+	_db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	_db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	_db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
+	_db.SetConnMaxIdleTime(cfg.DB.ConnMaxIdleTime)
 	db = _db
+	dataStore = store.NewMySQL(_db)
+	if err := connectReplica(context.Background(), &cfg.DB); err != nil {
+		panic(err)
+	}
+
+	paymentGatewayConfig = cfg.Payment
+	webhookConfig = cfg.Webhook
+	chairMoveInterval = cfg.Chair.MoveInterval
+	initializeConfig = cfg.Initialize
+
+	if err := loadRegions(context.Background()); err != nil {
+		panic(err)
+	}
+	if err := loadRateLimits(context.Background()); err != nil {
+		panic(err)
+	}
+	trustedProxies, err := ratelimit.ParseTrustedProxies(cfg.RateLimit.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	rateLimiter.SetTrustedProxies(trustedProxies)
+
+	validator, err := validation.New(cfg.OpenAPI.Path, cfg.OpenAPI.Validation)
+	if err != nil {
+		panic(err)
+	}
+	telemetryMiddleware, err := telemetry.NewMiddleware(otel.GetTracerProvider(), otel.GetMeterProvider())
+	if err != nil {
+		panic(err)
+	}
 
 	mux := chi.NewRouter()
-	mux.Use(middleware.Logger)
+	mux.Use(requestIDMiddleware)
+	mux.Use(telemetryMiddleware)
+	mux.Use(accessLogMiddleware)
 	mux.Use(middleware.Recoverer)
+	mux.Use(rateLimiter.Middleware)
+	mux.Use(validator.Middleware)
+	mux.HandleFunc("GET /healthz", getHealthz)
+	mux.HandleFunc("GET /readyz", getReadyz)
 	mux.HandleFunc("POST /api/initialize", postInitialize)
 
 	// app handlers
 	{
 		mux.HandleFunc("POST /api/app/users", appPostUsers)
+		mux.HandleFunc("GET /api/app/regions", appGetRegions)
 
-		authedMux := mux.With(appAuthMiddleware)
+		authedMux := mux.With(authenticator.Middleware(auth.RoleApp), rateLimiter.PrincipalMiddleware)
 		authedMux.HandleFunc("POST /api/app/payment-methods", appPostPaymentMethods)
 		authedMux.HandleFunc("GET /api/app/rides", appGetRides)
 		authedMux.HandleFunc("POST /api/app/rides", appPostRides)
 		authedMux.HandleFunc("POST /api/app/rides/estimated-fare", appPostRidesEstimatedFare)
 		authedMux.HandleFunc("POST /api/app/rides/{ride_id}/evaluation", appPostRideEvaluatation)
+		authedMux.HandleFunc("GET /api/app/rides/{ride_id}/route", appGetRideRoute)
 		authedMux.HandleFunc("GET /api/app/notification", appGetNotification)
 		authedMux.HandleFunc("GET /api/app/nearby-chairs", appGetNearbyChairs)
+		authedMux.HandleFunc("POST /api/app/login", appPostLogin)
+		authedMux.HandleFunc("POST /api/app/logout", appPostLogout)
+		authedMux.HandleFunc("POST /api/app/session/rotate", appPostSessionRotate)
+		authedMux.HandleFunc("GET /api/app/me", appGetMe)
+		authedMux.HandleFunc("PATCH /api/app/me", appPatchMe)
+		authedMux.HandleFunc("DELETE /api/app/me", appDeleteMe)
+		authedMux.HandleFunc("GET /api/app/me/export", appGetMeExport)
+		authedMux.HandleFunc("GET /api/app/places", appGetPlaces)
+		authedMux.HandleFunc("POST /api/app/places", appPostPlace)
+		authedMux.HandleFunc("PUT /api/app/places/{place_id}", appPutPlace)
+		authedMux.HandleFunc("DELETE /api/app/places/{place_id}", appDeletePlace)
+		authedMux.HandleFunc("GET /api/app/recent-destinations", appGetRecentDestinations)
 	}
 
 	// owner handlers
 	{
 		mux.HandleFunc("POST /api/owner/owners", ownerPostOwners)
 
-		authedMux := mux.With(ownerAuthMiddleware)
-		authedMux.HandleFunc("GET /api/owner/sales", ownerGetSales)
-		authedMux.HandleFunc("GET /api/owner/chairs", ownerGetChairs)
+		authedMux := mux.With(authenticator.Middleware(auth.RoleOwner), rateLimiter.PrincipalMiddleware)
+		authedMux.With(authenticator.RequireScope("sales:read")).HandleFunc("GET /api/owner/sales", ownerGetSales)
+		authedMux.With(authenticator.RequireScope("chairs:read")).HandleFunc("GET /api/owner/chairs", ownerGetChairs)
+		authedMux.With(authenticator.RequireScope("chairs:read")).HandleFunc("GET /api/owner/chairs/{chair_id}/schedules", ownerGetChairSchedules)
+		authedMux.With(authenticator.RequireScope("chairs:write")).HandleFunc("PUT /api/owner/chairs/{chair_id}/schedules", ownerPutChairSchedules)
+		authedMux.With(authenticator.RequireScope("chairs:read")).HandleFunc("GET /api/owner/chairs/{chair_id}/activities", ownerGetChairActivities)
+		authedMux.With(authenticator.RequireScope("chairs:read")).HandleFunc("GET /api/owner/rides/{ride_id}/route", ownerGetRideRoute)
+		authedMux.With(authenticator.RequireScope("chairs:read")).HandleFunc("GET /api/owner/movement-flags", ownerGetMovementFlags)
+		authedMux.With(authenticator.RequireScope("webhooks:read")).HandleFunc("GET /api/owner/webhooks", ownerGetWebhooks)
+		authedMux.With(authenticator.RequireScope("webhooks:write")).HandleFunc("POST /api/owner/webhooks", ownerPostWebhook)
+		authedMux.With(authenticator.RequireScope("webhooks:write")).HandleFunc("DELETE /api/owner/webhooks/{webhook_id}", ownerDeleteWebhook)
+		authedMux.With(authenticator.RequireScope("webhooks:read")).HandleFunc("GET /api/owner/webhooks/{webhook_id}/deliveries", ownerGetWebhookDeliveries)
+
+		sessionMux := authedMux.With(authenticator.RequireSession)
+		sessionMux.HandleFunc("POST /api/owner/login", ownerPostLogin)
+		sessionMux.HandleFunc("POST /api/owner/logout", ownerPostLogout)
+		sessionMux.HandleFunc("POST /api/owner/session/rotate", ownerPostSessionRotate)
+		sessionMux.HandleFunc("POST /api/owner/api-keys", ownerPostAPIKeys)
+		sessionMux.HandleFunc("GET /api/owner/api-keys", ownerGetAPIKeys)
+		sessionMux.HandleFunc("DELETE /api/owner/api-keys/{api_key_id}", ownerDeleteAPIKey)
 	}
 
 	// chair handlers
 	{
 		mux.HandleFunc("POST /api/chair/chairs", chairPostChairs)
 
-		authedMux := mux.With(chairAuthMiddleware)
+		authedMux := mux.With(authenticator.Middleware(auth.RoleChair), rateLimiter.PrincipalMiddleware)
 		authedMux.HandleFunc("POST /api/chair/activity", chairPostActivity)
 		authedMux.HandleFunc("POST /api/chair/coordinate", chairPostCoordinate)
 		authedMux.HandleFunc("GET /api/chair/notification", chairGetNotification)
 		authedMux.HandleFunc("POST /api/chair/rides/{ride_id}/status", chairPostRideStatus)
+		authedMux.HandleFunc("POST /api/chair/login", chairPostLogin)
+		authedMux.HandleFunc("POST /api/chair/logout", chairPostLogout)
+		authedMux.HandleFunc("POST /api/chair/session/rotate", chairPostSessionRotate)
 	}
 
 	// internal handlers
@@ -109,36 +247,60 @@ func setup() http.Handler {
 		mux.HandleFunc("GET /api/internal/matching", internalGetMatching)
 	}
 
+	// admin handlers
+	// 管理用トークンが設定されていない場合は管理APIを公開しない
+	if cfg.Admin.Token != "" {
+		adminMux := mux.With(authenticator.AdminMiddleware(cfg.Admin.Token))
+		adminMux.HandleFunc("GET /api/admin/users", adminGetUsers)
+		adminMux.HandleFunc("GET /api/admin/owners", adminGetOwners)
+		adminMux.HandleFunc("GET /api/admin/chairs", adminGetChairs)
+		adminMux.HandleFunc("GET /api/admin/rides", adminGetRides)
+		adminMux.HandleFunc("GET /api/admin/rides/{ride_id}", adminGetRide)
+		adminMux.HandleFunc("POST /api/admin/rides/{ride_id}/complete", adminPostRideComplete)
+		adminMux.HandleFunc("POST /api/admin/rides/{ride_id}/cancel", adminPostRideCancel)
+		adminMux.HandleFunc("POST /api/admin/rides/{ride_id}/match", adminPostRideMatch)
+		adminMux.HandleFunc("GET /api/admin/settings", adminGetSettings)
+		adminMux.HandleFunc("PUT /api/admin/settings/{name}", adminPutSetting)
+		// リクエスト数の制限の状況などを含むので、管理用トークンを知っている運営にだけ公開する
+		adminMux.Handle("GET /api/admin/metrics", expvar.Handler())
+	}
+
 	return mux
 }
 
 type postInitializeRequest struct {
 	PaymentServer string `json:"payment_server"`
+	// 読み込むデータセット。省略した場合は設定の initialize.dataset を使う
+	Dataset string `json:"dataset"`
 }
 
 type postInitializeResponse struct {
-	Language string `json:"language"`
+	Language string             `json:"language"`
+	Dataset  string             `json:"dataset"`
+	Timings  *initializeTimings `json:"timings"`
 }
 
 func postInitialize(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
 	req := &postInitializeRequest{}
 	if err := bindJSON(r, req); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-
-	if out, err := exec.Command("../sql/init.sh").CombinedOutput(); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to initialize: %s: %w", string(out), err))
-		return
+	if req.Dataset == "" {
+		req.Dataset = initializeConfig.Dataset
 	}
 
-	if _, err := db.ExecContext(ctx, "UPDATE settings SET value = ? WHERE name = 'payment_gateway_url'", req.PaymentServer); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+	timings, err := initializeData(r.Context(), req.Dataset, req.PaymentServer)
+	if err != nil {
+		if errors.Is(err, sqlload.ErrDatasetNotFound) {
+			writeError(w, http.StatusBadRequest, errDatasetNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to initialize: %w", err))
 		return
 	}
 
-	writeJSON(w, http.StatusOK, postInitializeResponse{Language: "go"})
+	writeJSON(w, http.StatusOK, postInitializeResponse{Language: "go", Dataset: req.Dataset, Timings: timings})
 }
 
 type Coordinate struct {
@@ -162,17 +324,7 @@ func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
 }
 
 func writeError(w http.ResponseWriter, statusCode int, err error) {
-	w.Header().Set("Content-Type", "application/json;charset=utf-8")
-	w.WriteHeader(statusCode)
-	buf, marshalError := json.Marshal(map[string]string{"message": err.Error()})
-	if marshalError != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":"marshaling error failed"}`))
-		return
-	}
-	w.Write(buf)
-
-	slog.Error("error response wrote", err)
+	apierror.Write(w, statusCode, err)
 }
 
 func secureRandomStr(b int) string {
@@ -1,82 +1,25 @@
 package main
 
 import (
-	"context"
-	"database/sql"
-	"errors"
 	"net/http"
-)
-
-func appAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		c, err := r.Cookie("app_session")
-		if errors.Is(err, http.ErrNoCookie) || c.Value == "" {
-			writeError(w, http.StatusUnauthorized, errors.New("app_session cookie is required"))
-			return
-		}
-		accessToken := c.Value
-		user := &User{}
-		err = db.GetContext(ctx, user, "SELECT * FROM users WHERE access_token = ?", accessToken)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				writeError(w, http.StatusUnauthorized, errors.New("invalid access token"))
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err)
-			return
-		}
-
-		ctx = context.WithValue(ctx, "user", user)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
+	"regexp"
 
-func ownerAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		c, err := r.Cookie("owner_session")
-		if errors.Is(err, http.ErrNoCookie) || c.Value == "" {
-			writeError(w, http.StatusUnauthorized, errors.New("owner_session cookie is required"))
-			return
-		}
-		accessToken := c.Value
-		owner := &Owner{}
-		if err := db.GetContext(ctx, owner, "SELECT * FROM owners WHERE access_token = ?", accessToken); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				writeError(w, http.StatusUnauthorized, errors.New("invalid access token"))
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err)
-			return
-		}
+	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+)
 
-		ctx = context.WithValue(ctx, "owner", owner)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
+// クライアントから渡されたリクエストIDは、ログを汚さないよう安全な文字だけで構成されている場合に使う
+var requestIDPattern = regexp.MustCompile(`^[0-9A-Za-z._-]{1,64}$`)
 
-func chairAuthMiddleware(next http.Handler) http.Handler {
+// リクエストIDをレスポンスヘッダーに設定する。エラーレスポンスにも同じリクエストIDを含める
+func requestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		c, err := r.Cookie("chair_session")
-		if errors.Is(err, http.ErrNoCookie) || c.Value == "" {
-			writeError(w, http.StatusUnauthorized, errors.New("chair_session cookie is required"))
-			return
+		requestID := r.Header.Get(apierror.RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = ulid.Make().String()
+			r.Header.Set(apierror.RequestIDHeader, requestID)
 		}
-		accessToken := c.Value
-		chair := &Chair{}
-		err = db.GetContext(ctx, chair, "SELECT * FROM chairs WHERE access_token = ?", accessToken)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				writeError(w, http.StatusUnauthorized, errors.New("invalid access token"))
-				return
-			}
-			writeError(w, http.StatusInternalServerError, err)
-			return
-		}
-
-		ctx = context.WithValue(ctx, "chair", chair)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		w.Header().Set(apierror.RequestIDHeader, requestID)
+		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,247 @@
+package model
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Chair struct {
+	ID          string    `db:"id"`
+	OwnerID     string    `db:"owner_id"`
+	Name        string    `db:"name"`
+	Model       string    `db:"model"`
+	IsActive    bool      `db:"is_active"`
+	AccessToken string    `db:"access_token"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+type ChairModel struct {
+	Name  string `db:"name"`
+	Speed int    `db:"speed"`
+}
+
+type ChairWithTotalDistance struct {
+	ID                     string       `db:"id"`
+	OwnerID                string       `db:"owner_id"`
+	Name                   string       `db:"name"`
+	AccessToken            string       `db:"access_token"`
+	Model                  string       `db:"model"`
+	IsActive               bool         `db:"is_active"`
+	CreatedAt              time.Time    `db:"created_at"`
+	UpdatedAt              time.Time    `db:"updated_at"`
+	TotalDistance          int          `db:"total_distance"`
+	TotalDistanceUpdatedAt sql.NullTime `db:"total_distance_updated_at"`
+}
+
+type ChairLocation struct {
+	ID        string    `db:"id"`
+	ChairID   string    `db:"chair_id"`
+	Latitude  int       `db:"latitude"`
+	Longitude int       `db:"longitude"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type ChairSchedule struct {
+	ID          string    `db:"id"`
+	ChairID     string    `db:"chair_id"`
+	DayOfWeek   int       `db:"day_of_week"`
+	StartMinute int       `db:"start_minute"`
+	EndMinute   int       `db:"end_minute"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+type ChairActivityPeriod struct {
+	ID        string     `db:"id"`
+	ChairID   string     `db:"chair_id"`
+	StartedAt time.Time  `db:"started_at"`
+	EndedAt   *time.Time `db:"ended_at"`
+	EndReason *string    `db:"end_reason"`
+}
+
+type ChairMovementFlag struct {
+	ID              string         `db:"id"`
+	ChairID         string         `db:"chair_id"`
+	RideID          sql.NullString `db:"ride_id"`
+	Kind            string         `db:"kind"`
+	Distance        int            `db:"distance"`
+	AllowedDistance int            `db:"allowed_distance"`
+	CreatedAt       time.Time      `db:"created_at"`
+}
+
+type Region struct {
+	ID              string         `db:"id"`
+	Name            string         `db:"name"`
+	MinLatitude     int            `db:"min_latitude"`
+	MaxLatitude     int            `db:"max_latitude"`
+	MinLongitude    int            `db:"min_longitude"`
+	MaxLongitude    int            `db:"max_longitude"`
+	Polygon         sql.NullString `db:"polygon"`
+	InitialFare     int            `db:"initial_fare"`
+	FarePerDistance int            `db:"fare_per_distance"`
+	CreatedAt       time.Time      `db:"created_at"`
+}
+
+type PaymentToken struct {
+	UserID    string    `db:"user_id"`
+	Token     string    `db:"token"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type Ride struct {
+	ID                   string         `db:"id"`
+	UserID               string         `db:"user_id"`
+	ChairID              sql.NullString `db:"chair_id"`
+	PickupLatitude       int            `db:"pickup_latitude"`
+	PickupLongitude      int            `db:"pickup_longitude"`
+	DestinationLatitude  int            `db:"destination_latitude"`
+	DestinationLongitude int            `db:"destination_longitude"`
+	Evaluation           *int           `db:"evaluation"`
+	CreatedAt            time.Time      `db:"created_at"`
+	UpdatedAt            time.Time      `db:"updated_at"`
+}
+
+type RideRoute struct {
+	RideID    string    `db:"ride_id"`
+	ChairID   string    `db:"chair_id"`
+	Polyline  string    `db:"polyline"`
+	Distance  int       `db:"distance"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type RideStatus struct {
+	ID          string     `db:"id"`
+	RideID      string     `db:"ride_id"`
+	Status      string     `db:"status"`
+	CreatedAt   time.Time  `db:"created_at"`
+	AppSentAt   *time.Time `db:"app_sent_at"`
+	ChairSentAt *time.Time `db:"chair_sent_at"`
+}
+
+type Coupon struct {
+	UserID    string    `db:"user_id"`
+	Code      string    `db:"code"`
+	Discount  int       `db:"discount"`
+	CreatedAt time.Time `db:"created_at"`
+	UsedBy    *string   `db:"used_by"`
+}
+
+type User struct {
+	ID             string    `db:"id"`
+	Username       string    `db:"username"`
+	Firstname      string    `db:"firstname"`
+	Lastname       string    `db:"lastname"`
+	DateOfBirth    string    `db:"date_of_birth"`
+	AccessToken    string    `db:"access_token"`
+	InvitationCode string    `db:"invitation_code"`
+	CreatedAt      time.Time `db:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at"`
+}
+
+type Owner struct {
+	ID                 string    `db:"id"`
+	Name               string    `db:"name"`
+	AccessToken        string    `db:"access_token"`
+	ChairRegisterToken string    `db:"chair_register_token"`
+	CreatedAt          time.Time `db:"created_at"`
+	UpdatedAt          time.Time `db:"updated_at"`
+}
+
+type Session struct {
+	Token       string     `db:"token"`
+	Role        string     `db:"role"`
+	PrincipalID string     `db:"principal_id"`
+	ExpiresAt   time.Time  `db:"expires_at"`
+	RevokedAt   *time.Time `db:"revoked_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+}
+
+type Setting struct {
+	Name  string `db:"name"`
+	Value string `db:"value"`
+}
+
+type RideChairPreference struct {
+	RideID    string    `db:"ride_id"`
+	MinSpeed  int       `db:"min_speed"`
+	Models    string    `db:"models"`
+	MinRating *float64  `db:"min_rating"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (p *RideChairPreference) ModelList() []string {
+	if p.Models == "" {
+		return []string{}
+	}
+	return strings.Split(p.Models, ",")
+}
+
+type SavedPlace struct {
+	ID        string    `db:"id"`
+	UserID    string    `db:"user_id"`
+	Kind      string    `db:"kind"`
+	Label     string    `db:"label"`
+	Latitude  int       `db:"latitude"`
+	Longitude int       `db:"longitude"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type OwnerAPIKey struct {
+	ID        string     `db:"id"`
+	OwnerID   string     `db:"owner_id"`
+	Name      string     `db:"name"`
+	KeyHash   string     `db:"key_hash"`
+	Scopes    string     `db:"scopes"`
+	CreatedAt time.Time  `db:"created_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+func (k *OwnerAPIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+func (k *OwnerAPIKey) HasScope(scope string) bool {
+	return slices.Contains(k.ScopeList(), scope)
+}
+
+type WebhookSubscription struct {
+	ID         string    `db:"id"`
+	OwnerID    string    `db:"owner_id"`
+	URL        string    `db:"url"`
+	Secret     string    `db:"secret"`
+	EventTypes string    `db:"event_types"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func (s *WebhookSubscription) EventTypeList() []string {
+	if s.EventTypes == "" {
+		return []string{}
+	}
+	return strings.Split(s.EventTypes, ",")
+}
+
+func (s *WebhookSubscription) Subscribes(eventType string) bool {
+	return slices.Contains(s.EventTypeList(), eventType)
+}
+
+type WebhookDelivery struct {
+	ID             string     `db:"id"`
+	SubscriptionID string     `db:"subscription_id"`
+	EventID        string     `db:"event_id"`
+	EventType      string     `db:"event_type"`
+	Payload        string     `db:"payload"`
+	Status         string     `db:"status"`
+	Attempts       int        `db:"attempts"`
+	NextAttemptAt  *time.Time `db:"next_attempt_at"`
+	LastStatusCode *int       `db:"last_status_code"`
+	LastError      *string    `db:"last_error"`
+	CreatedAt      time.Time  `db:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at"`
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+// allowedChairMovement は速度speedの椅子がelapsedの間に移動できる距離を返す。
+// 移動はchairMoveIntervalごとに行われるので、経過時間に端数があれば1回分を足し、間隔より短くても1回分は許す
+func allowedChairMovement(speed int, elapsed time.Duration) int {
+	moves := max(int((elapsed+chairMoveInterval-1)/chairMoveInterval), 1)
+	return speed * moves
+}
+
+// allowedRideDistance は最短距離directのライドで許す移動距離を返す
+func allowedRideDistance(direct, thresholdPercent int) int {
+	return direct * (100 + thresholdPercent) / 100
+}
+
+// 前回記録された位置からの移動距離が、モデルの速度で経過時間内に移動できる距離を超えていれば記録する
+func checkChairMovement(ctx context.Context, tx store.Queries, chair *Chair, prev, current *ChairLocation) error {
+	chairModel, err := tx.Chairs().GetModel(ctx, chair.Model)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	allowed := allowedChairMovement(chairModel.Speed, current.CreatedAt.Sub(prev.CreatedAt))
+	distance := calculateDistance(prev.Latitude, prev.Longitude, current.Latitude, current.Longitude)
+	if distance <= allowed {
+		return nil
+	}
+
+	return tx.Chairs().CreateMovementFlag(ctx, &ChairMovementFlag{
+		ID:              ulid.Make().String(),
+		ChairID:         chair.ID,
+		Kind:            "TELEPORT",
+		Distance:        distance,
+		AllowedDistance: allowed,
+	})
+}
+
+// 目的地に到着したライドの移動距離が最短距離を閾値以上超えていれば記録する
+func checkRideDetour(ctx context.Context, tx store.Queries, ride *Ride) error {
+	threshold, err := getIntSetting(ctx, tx, "detour_flag_threshold_percent")
+	if err != nil {
+		return err
+	}
+	if threshold <= 0 {
+		return nil
+	}
+
+	route, err := buildRideRoute(ctx, tx, ride)
+	if err != nil {
+		return err
+	}
+	allowed := allowedRideDistance(route.DirectDistance, threshold)
+	if route.TravelledDistance <= allowed {
+		return nil
+	}
+
+	return tx.Chairs().CreateMovementFlag(ctx, &ChairMovementFlag{
+		ID:              ulid.Make().String(),
+		ChairID:         ride.ChairID.String,
+		RideID:          sql.NullString{String: ride.ID, Valid: true},
+		Kind:            "DETOUR",
+		Distance:        route.TravelledDistance,
+		AllowedDistance: allowed,
+	})
+}
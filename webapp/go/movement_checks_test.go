@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowedChairMovement(t *testing.T) {
+	prev := chairMoveInterval
+	chairMoveInterval = 30 * time.Millisecond
+	t.Cleanup(func() { chairMoveInterval = prev })
+
+	tests := []struct {
+		elapsed time.Duration
+		want    int
+	}{
+		{0, 5},
+		{10 * time.Millisecond, 5},
+		{30 * time.Millisecond, 5},
+		{31 * time.Millisecond, 10},
+		{90 * time.Millisecond, 15},
+		// 1秒あれば34回移動できる
+		{time.Second, 170},
+	}
+	for _, tt := range tests {
+		if got := allowedChairMovement(5, tt.elapsed); got != tt.want {
+			t.Errorf("allowedChairMovement(5, %s) = %d, want %d", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedRideDistance(t *testing.T) {
+	tests := []struct {
+		direct, threshold, want int
+	}{
+		{100, 50, 150},
+		{100, 0, 100},
+		// 端数は切り捨てる
+		{15, 50, 22},
+		{0, 50, 0},
+	}
+	for _, tt := range tests {
+		if got := allowedRideDistance(tt.direct, tt.threshold); got != tt.want {
+			t.Errorf("allowedRideDistance(%d, %d) = %d, want %d", tt.direct, tt.threshold, got, tt.want)
+		}
+	}
+}
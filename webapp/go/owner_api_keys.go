@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+)
+
+var ownerAPIKeyScopes = []string{
+	"sales:read",
+	"chairs:read",
+	"chairs:write",
+	"webhooks:read",
+	"webhooks:write",
+}
+
+type ownerPostAPIKeysRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type ownerPostAPIKeysResponse struct {
+	ID     string   `json:"id"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+}
+
+func ownerPostAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	req := &ownerPostAPIKeysRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Name == "" || len(req.Scopes) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("some of required fields(name, scopes) are empty"))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(ownerAPIKeyScopes, scope) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown scope: %s", scope))
+			return
+		}
+	}
+	scopes := slices.Compact(slices.Sorted(slices.Values(req.Scopes)))
+
+	apiKeyID := ulid.Make().String()
+	key := auth.OwnerAPIKeyPrefix + secureRandomStr(32)
+	if err := dataStore.OwnerAPIKeys().Create(ctx, &OwnerAPIKey{
+		ID:      apiKeyID,
+		OwnerID: owner.ID,
+		Name:    req.Name,
+		KeyHash: auth.HashOwnerAPIKey(key),
+		Scopes:  strings.Join(scopes, ","),
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// APIキーを返すのは発行時の一度だけ
+	writeJSON(w, http.StatusCreated, &ownerPostAPIKeysResponse{
+		ID:     apiKeyID,
+		Key:    key,
+		Scopes: scopes,
+	})
+}
+
+type ownerGetAPIKeysResponse struct {
+	APIKeys []ownerGetAPIKeysResponseAPIKey `json:"api_keys"`
+}
+
+type ownerGetAPIKeysResponseAPIKey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"created_at"`
+	RevokedAt *int64   `json:"revoked_at,omitempty"`
+}
+
+func ownerGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	apiKeys, err := dataStore.OwnerAPIKeys().ListByOwner(ctx, owner.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := ownerGetAPIKeysResponse{APIKeys: []ownerGetAPIKeysResponseAPIKey{}}
+	for _, apiKey := range apiKeys {
+		item := ownerGetAPIKeysResponseAPIKey{
+			ID:        apiKey.ID,
+			Name:      apiKey.Name,
+			Scopes:    apiKey.ScopeList(),
+			CreatedAt: apiKey.CreatedAt.UnixMilli(),
+		}
+		if apiKey.RevokedAt != nil {
+			revokedAt := apiKey.RevokedAt.UnixMilli()
+			item.RevokedAt = &revokedAt
+		}
+		res.APIKeys = append(res.APIKeys, item)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func ownerDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+	apiKeyID := r.PathValue("api_key_id")
+
+	revoked, err := dataStore.OwnerAPIKeys().Revoke(ctx, owner.ID, apiKeyID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !revoked {
+		writeError(w, http.StatusNotFound, errAPIKeyNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
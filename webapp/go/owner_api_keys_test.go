@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+)
+
+func TestOwnerAPIKeys(t *testing.T) {
+	m := newTestStore(t)
+	owner := addTestOwner(t, m, "owner1")
+	other := addTestOwner(t, m, "owner2")
+
+	rec := serveAsOwner(t, ownerPostAPIKeys, owner, http.MethodPost, "/api/owner/api-keys", nil, &ownerPostAPIKeysRequest{
+		Name:   "sales",
+		Scopes: []string{"sales:read", "chairs:read", "sales:read"},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	created := decodeBody[ownerPostAPIKeysResponse](t, rec)
+
+	// 保存するのはハッシュだけで、認証ではハッシュから引く
+	apiKey, err := (&authStore{}).GetOwnerAPIKeyByHash(context.Background(), auth.HashOwnerAPIKey(created.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey.ID != created.ID || apiKey.OwnerID != owner.ID || apiKey.Scopes != "chairs:read,sales:read" {
+		t.Errorf("api key = %+v, want the created key with sorted scopes", apiKey)
+	}
+
+	pathValues := map[string]string{"api_key_id": created.ID}
+	rec = serveAsOwner(t, ownerDeleteAPIKey, other, http.MethodDelete, "/api/owner/api-keys/"+created.ID, pathValues, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("other owner: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec = serveAsOwner(t, ownerDeleteAPIKey, owner, http.MethodDelete, "/api/owner/api-keys/"+created.ID, pathValues, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	rec = serveAsOwner(t, ownerDeleteAPIKey, owner, http.MethodDelete, "/api/owner/api-keys/"+created.ID, pathValues, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("revoked twice: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = serveAsOwner(t, ownerGetAPIKeys, owner, http.MethodGet, "/api/owner/api-keys", nil, nil)
+	res := decodeBody[ownerGetAPIKeysResponse](t, rec)
+	if len(res.APIKeys) != 1 || res.APIKeys[0].RevokedAt == nil {
+		t.Errorf("api keys = %+v, want the revoked key", res.APIKeys)
+	}
+}
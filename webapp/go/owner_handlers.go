@@ -1,13 +1,15 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
 )
 
 const (
@@ -40,21 +42,35 @@ func ownerPostOwners(w http.ResponseWriter, r *http.Request) {
 	accessToken := secureRandomStr(32)
 	chairRegisterToken := secureRandomStr(32)
 
-	_, err := db.ExecContext(
-		ctx,
-		"INSERT INTO owners (id, name, access_token, chair_register_token) VALUES (?, ?, ?, ?)",
-		ownerID, req.Name, accessToken, chairRegisterToken,
-	)
+	tx, err := dataStore.Begin(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	defer tx.Rollback()
 
-	http.SetCookie(w, &http.Cookie{
-		Path:  "/",
-		Name:  "owner_session",
-		Value: accessToken,
-	})
+	if err := tx.Owners().Create(ctx, &Owner{
+		ID:                 ownerID,
+		Name:               req.Name,
+		AccessToken:        accessToken,
+		ChairRegisterToken: chairRegisterToken,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	session, err := createSession(ctx, tx.Sessions(), accessToken, auth.RoleOwner, ownerID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	setSessionCookie(w, r, session)
 
 	writeJSON(w, http.StatusCreated, &ownerPostOwnersResponse{
 		ID:                 ownerID,
@@ -100,17 +116,21 @@ func ownerGetSales(w http.ResponseWriter, r *http.Request) {
 		until = time.UnixMilli(parsed)
 	}
 
-	owner := r.Context().Value("owner").(*Owner)
+	owner, ok := auth.OwnerFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
 
-	tx, err := db.Beginx()
+	tx, err := readStore().BeginRead(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 	defer tx.Rollback()
 
-	chairs := []Chair{}
-	if err := tx.SelectContext(ctx, &chairs, "SELECT * FROM chairs WHERE owner_id = ?", owner.ID); err != nil {
+	chairs, err := tx.Chairs().ListByOwner(ctx, owner.ID)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -121,8 +141,8 @@ func ownerGetSales(w http.ResponseWriter, r *http.Request) {
 
 	modelSalesByModel := map[string]int{}
 	for _, chair := range chairs {
-		rides := []Ride{}
-		if err := tx.SelectContext(ctx, &rides, "SELECT rides.* FROM rides JOIN ride_statuses ON rides.id = ride_statuses.ride_id WHERE chair_id = ? AND status = 'COMPLETED' AND updated_at BETWEEN ? AND ? + INTERVAL 999 MICROSECOND", chair.ID, since, until); err != nil {
+		rides, err := tx.Rides().ListCompletedByChair(ctx, chair.ID, since, until)
+		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -163,19 +183,6 @@ func calculateSale(ride Ride) int {
 	return calculateFare(ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude)
 }
 
-type chairWithDetail struct {
-	ID                     string       `db:"id"`
-	OwnerID                string       `db:"owner_id"`
-	Name                   string       `db:"name"`
-	AccessToken            string       `db:"access_token"`
-	Model                  string       `db:"model"`
-	IsActive               bool         `db:"is_active"`
-	CreatedAt              time.Time    `db:"created_at"`
-	UpdatedAt              time.Time    `db:"updated_at"`
-	TotalDistance          int          `db:"total_distance"`
-	TotalDistanceUpdatedAt sql.NullTime `db:"total_distance_updated_at"`
-}
-
 type ownerGetChairResponse struct {
 	Chairs []ownerGetChairResponseChair `json:"chairs"`
 }
@@ -192,31 +199,14 @@ type ownerGetChairResponseChair struct {
 
 func ownerGetChairs(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	owner := ctx.Value("owner").(*Owner)
-
-	chairs := []chairWithDetail{}
-	if err := db.SelectContext(ctx, &chairs, `SELECT id,
-       owner_id,
-       name,
-       access_token,
-       model,
-       is_active,
-       created_at,
-       updated_at,
-       IFNULL(total_distance, 0) AS total_distance,
-       total_distance_updated_at
-FROM chairs
-       LEFT JOIN (SELECT chair_id,
-                          SUM(IFNULL(distance, 0)) AS total_distance,
-                          MAX(created_at)          AS total_distance_updated_at
-                   FROM (SELECT chair_id,
-                                created_at,
-                                ABS(latitude - LAG(latitude) OVER (PARTITION BY chair_id ORDER BY created_at)) +
-                                ABS(longitude - LAG(longitude) OVER (PARTITION BY chair_id ORDER BY created_at)) AS distance
-                         FROM chair_locations) tmp
-                   GROUP BY chair_id) distance_table ON distance_table.chair_id = chairs.id
-WHERE owner_id = ?
-`, owner.ID); err != nil {
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	chairs, err := readStore().Chairs().ListWithTotalDistanceByOwner(ctx, owner.ID)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -239,3 +229,344 @@ WHERE owner_id = ?
 	}
 	writeJSON(w, http.StatusOK, res)
 }
+
+func getOwnerChair(ctx context.Context, q store.Queries, ownerID, chairID string) (*Chair, error) {
+	chair, err := q.Chairs().Get(ctx, chairID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errChairNotFound
+		}
+		return nil, err
+	}
+	if chair.OwnerID != ownerID {
+		return nil, errChairNotFound
+	}
+	return chair, nil
+}
+
+type ownerChairSchedule struct {
+	DayOfWeek   int `json:"day_of_week"`
+	StartMinute int `json:"start_minute"`
+	EndMinute   int `json:"end_minute"`
+}
+
+type ownerGetChairSchedulesResponse struct {
+	Schedules []ownerChairSchedule `json:"schedules"`
+}
+
+func ownerGetChairSchedules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+	chairID := r.PathValue("chair_id")
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := getOwnerChair(ctx, tx, owner.ID, chairID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	schedules, err := tx.Chairs().ListSchedules(ctx, chairID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := ownerGetChairSchedulesResponse{Schedules: []ownerChairSchedule{}}
+	for _, s := range schedules {
+		res.Schedules = append(res.Schedules, ownerChairSchedule{
+			DayOfWeek:   s.DayOfWeek,
+			StartMinute: s.StartMinute,
+			EndMinute:   s.EndMinute,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type ownerPutChairSchedulesRequest struct {
+	Schedules []ownerChairSchedule `json:"schedules"`
+}
+
+func ownerPutChairSchedules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+	chairID := r.PathValue("chair_id")
+
+	req := &ownerPutChairSchedulesRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, s := range req.Schedules {
+		if s.DayOfWeek < 0 || s.DayOfWeek > 6 {
+			writeError(w, http.StatusBadRequest, errors.New("day_of_week must be between 0 and 6"))
+			return
+		}
+		if s.StartMinute < 0 || s.EndMinute > 24*60 || s.StartMinute >= s.EndMinute {
+			writeError(w, http.StatusBadRequest, errors.New("start_minute must be less than end_minute and both must be between 0 and 1440"))
+			return
+		}
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := getOwnerChair(ctx, tx, owner.ID, chairID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	schedules := []ChairSchedule{}
+	for _, s := range req.Schedules {
+		schedules = append(schedules, ChairSchedule{
+			ID:          ulid.Make().String(),
+			DayOfWeek:   s.DayOfWeek,
+			StartMinute: s.StartMinute,
+			EndMinute:   s.EndMinute,
+		})
+	}
+	if err := tx.Chairs().ReplaceSchedules(ctx, chairID, schedules); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ownerGetChairActivitiesResponse struct {
+	Periods       []ownerChairActivityPeriod `json:"periods"`
+	TotalActiveMs int64                      `json:"total_active_ms"`
+}
+
+type ownerChairActivityPeriod struct {
+	StartedAt int64   `json:"started_at"`
+	EndedAt   *int64  `json:"ended_at,omitempty"`
+	EndReason *string `json:"end_reason,omitempty"`
+}
+
+func ownerGetChairActivities(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+	chairID := r.PathValue("chair_id")
+
+	since := time.Unix(0, 0)
+	until := time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+	if r.URL.Query().Get("since") != "" {
+		parsed, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		since = time.UnixMilli(parsed)
+	}
+	if r.URL.Query().Get("until") != "" {
+		parsed, err := strconv.ParseInt(r.URL.Query().Get("until"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		until = time.UnixMilli(parsed)
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := getOwnerChair(ctx, tx, owner.ID, chairID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	periods, err := tx.Chairs().ListActivityPeriods(ctx, chairID, since, until)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	res := ownerGetChairActivitiesResponse{Periods: []ownerChairActivityPeriod{}}
+	for _, p := range periods {
+		item := ownerChairActivityPeriod{
+			StartedAt: p.StartedAt.UnixMilli(),
+			EndReason: p.EndReason,
+		}
+		end := now
+		if p.EndedAt != nil {
+			t := p.EndedAt.UnixMilli()
+			item.EndedAt = &t
+			end = *p.EndedAt
+		}
+		res.Periods = append(res.Periods, item)
+
+		// 集計期間からはみ出した部分は稼働時間に含めない
+		start := p.StartedAt
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(until) {
+			end = until
+		}
+		if end.After(start) {
+			res.TotalActiveMs += end.Sub(start).Milliseconds()
+		}
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func ownerGetRideRoute(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+	rideID := r.PathValue("ride_id")
+
+	tx, err := dataStore.BeginRead(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	ride, err := tx.Rides().Get(ctx, rideID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ride.ChairID.Valid {
+		writeError(w, http.StatusNotFound, errRideNotFound)
+		return
+	}
+	if _, err := getOwnerChair(ctx, tx, owner.ID, ride.ChairID.String); err != nil {
+		if errors.Is(err, errChairNotFound) {
+			writeError(w, http.StatusNotFound, errRideNotFound)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res, err := buildRideRoute(ctx, tx, ride)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+type ownerGetMovementFlagsResponse struct {
+	Flags  []ownerGetMovementFlagsResponseFlag  `json:"flags"`
+	Chairs []ownerGetMovementFlagsResponseChair `json:"chairs"`
+}
+
+type ownerGetMovementFlagsResponseFlag struct {
+	ID              string  `json:"id"`
+	ChairID         string  `json:"chair_id"`
+	RideID          *string `json:"ride_id,omitempty"`
+	Kind            string  `json:"kind"`
+	Distance        int     `json:"distance"`
+	AllowedDistance int     `json:"allowed_distance"`
+	CreatedAt       int64   `json:"created_at"`
+}
+
+type ownerGetMovementFlagsResponseChair struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	TeleportCount int    `json:"teleport_count"`
+	DetourCount   int    `json:"detour_count"`
+}
+
+func ownerGetMovementFlags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	chairs, err := dataStore.Chairs().ListByOwner(ctx, owner.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := ownerGetMovementFlagsResponse{
+		Flags:  []ownerGetMovementFlagsResponseFlag{},
+		Chairs: []ownerGetMovementFlagsResponseChair{},
+	}
+	for _, chair := range chairs {
+		flags, err := dataStore.Chairs().ListMovementFlags(ctx, chair.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(flags) == 0 {
+			continue
+		}
+
+		summary := ownerGetMovementFlagsResponseChair{
+			ID:   chair.ID,
+			Name: chair.Name,
+		}
+		for _, flag := range flags {
+			item := ownerGetMovementFlagsResponseFlag{
+				ID:              flag.ID,
+				ChairID:         flag.ChairID,
+				Kind:            flag.Kind,
+				Distance:        flag.Distance,
+				AllowedDistance: flag.AllowedDistance,
+				CreatedAt:       flag.CreatedAt.UnixMilli(),
+			}
+			if flag.RideID.Valid {
+				item.RideID = &flag.RideID.String
+			}
+			res.Flags = append(res.Flags, item)
+
+			switch flag.Kind {
+			case "TELEPORT":
+				summary.TeleportCount++
+			case "DETOUR":
+				summary.DetourCount++
+			}
+		}
+		res.Chairs = append(res.Chairs, summary)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOwnerPutChairSchedules(t *testing.T) {
+	m := newTestStore(t)
+	owner := addTestOwner(t, m, "owner1", "chair1")
+	other := addTestOwner(t, m, "owner2")
+	pathValues := map[string]string{"chair_id": "chair1"}
+
+	req := &ownerPutChairSchedulesRequest{Schedules: []ownerChairSchedule{
+		{DayOfWeek: 2, StartMinute: 9 * 60, EndMinute: 17 * 60},
+		{DayOfWeek: 1, StartMinute: 13 * 60, EndMinute: 18 * 60},
+		{DayOfWeek: 1, StartMinute: 8 * 60, EndMinute: 12 * 60},
+	}}
+	rec := serveAsOwner(t, ownerPutChairSchedules, owner, http.MethodPut, "/api/owner/chairs/chair1/schedules", pathValues, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+
+	// 置き換えた後は、新しいスケジュールだけが曜日と開始時刻の順に返る
+	req = &ownerPutChairSchedulesRequest{Schedules: req.Schedules[1:]}
+	rec = serveAsOwner(t, ownerPutChairSchedules, owner, http.MethodPut, "/api/owner/chairs/chair1/schedules", pathValues, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	rec = serveAsOwner(t, ownerGetChairSchedules, owner, http.MethodGet, "/api/owner/chairs/chair1/schedules", pathValues, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	res := decodeBody[ownerGetChairSchedulesResponse](t, rec)
+	if len(res.Schedules) != 2 || res.Schedules[0].StartMinute != 8*60 || res.Schedules[1].StartMinute != 13*60 {
+		t.Errorf("schedules = %+v, want the two Monday schedules in start order", res.Schedules)
+	}
+
+	// 他のオーナーの椅子は存在しないものとして扱う
+	for _, handler := range []http.HandlerFunc{ownerGetChairSchedules, ownerPutChairSchedules, ownerGetChairActivities} {
+		rec := serveAsOwner(t, handler, other, http.MethodGet, "/api/owner/chairs/chair1/schedules", pathValues, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusNotFound, rec.Body.String())
+		}
+	}
+}
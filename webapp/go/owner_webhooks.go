@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+const (
+	maxWebhookURLLength = 2048
+	// 秘密鍵を返すのは登録時の一度だけなので、他の値と区別できるよう接頭辞を付ける
+	webhookSecretPrefix = "whsec_"
+
+	webhookDeliveriesDefaultLimit = 20
+	webhookDeliveriesMaxLimit     = 100
+)
+
+type ownerPostWebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+type ownerWebhook struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+type ownerPostWebhookResponse struct {
+	ownerWebhook
+	Secret string `json:"secret"`
+}
+
+func newOwnerWebhook(subscription *WebhookSubscription) ownerWebhook {
+	return ownerWebhook{
+		ID:         subscription.ID,
+		URL:        subscription.URL,
+		EventTypes: subscription.EventTypeList(),
+		CreatedAt:  subscription.CreatedAt.UnixMilli(),
+	}
+}
+
+func validateWebhookURL(rawURL string) error {
+	if len(rawURL) > maxWebhookURLLength {
+		return fmt.Errorf("url must be at most %d characters", maxWebhookURLLength)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	// ホスト名は送信時に名前解決したアドレスで確認するので、ここではIPアドレスで指定されたものだけを確認する
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !isWebhookAddressAllowed(addr) {
+		return errors.New("url must not point to a private network")
+	}
+	return nil
+}
+
+func ownerPostWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	req := &ownerPostWebhookRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.URL == "" || len(req.EventTypes) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("some of required fields(url, event_types) are empty"))
+		return
+	}
+	if err := validateWebhookURL(req.URL); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, eventType := range req.EventTypes {
+		if !slices.Contains(webhookEventTypes, eventType) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown event type: %s", eventType))
+			return
+		}
+	}
+	eventTypes := slices.Compact(slices.Sorted(slices.Values(req.EventTypes)))
+
+	subscription := &WebhookSubscription{
+		ID:         ulid.Make().String(),
+		OwnerID:    owner.ID,
+		URL:        req.URL,
+		Secret:     webhookSecretPrefix + secureRandomStr(32),
+		EventTypes: strings.Join(eventTypes, ","),
+	}
+	if err := dataStore.Webhooks().CreateSubscription(ctx, subscription); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	created, err := dataStore.Webhooks().GetSubscription(ctx, subscription.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 秘密鍵を返すのは登録時の一度だけ
+	writeJSON(w, http.StatusCreated, &ownerPostWebhookResponse{
+		ownerWebhook: newOwnerWebhook(created),
+		Secret:       created.Secret,
+	})
+}
+
+type ownerGetWebhooksResponse struct {
+	Webhooks []ownerWebhook `json:"webhooks"`
+}
+
+func ownerGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	subscriptions, err := dataStore.Webhooks().ListSubscriptionsByOwner(ctx, owner.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := ownerGetWebhooksResponse{Webhooks: []ownerWebhook{}}
+	for _, subscription := range subscriptions {
+		res.Webhooks = append(res.Webhooks, newOwnerWebhook(&subscription))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// getOwnerWebhook はオーナーのWebhookを返す。他のオーナーのWebhookは存在しないものとして扱う
+func getOwnerWebhook(r *http.Request, q store.Queries, ownerID string) (*WebhookSubscription, error) {
+	subscription, err := q.Webhooks().GetSubscription(r.Context(), r.PathValue("webhook_id"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errWebhookNotFound
+		}
+		return nil, err
+	}
+	if subscription.OwnerID != ownerID {
+		return nil, errWebhookNotFound
+	}
+	return subscription, nil
+}
+
+func ownerDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	subscription, err := getOwnerWebhook(r, tx, owner.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Webhooks().DeleteSubscription(ctx, subscription.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ownerGetWebhookDeliveriesResponse struct {
+	Deliveries []ownerWebhookDelivery `json:"deliveries"`
+}
+
+type ownerWebhookDelivery struct {
+	ID             string          `json:"id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *int64          `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	CreatedAt      int64           `json:"created_at"`
+	UpdatedAt      int64           `json:"updated_at"`
+}
+
+// 配送履歴を新しい順に返す
+func ownerGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := auth.OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	limit := webhookDeliveriesDefaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > webhookDeliveriesMaxLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be an integer between 1 and %d", webhookDeliveriesMaxLimit))
+			return
+		}
+		limit = l
+	}
+
+	subscription, err := getOwnerWebhook(r, dataStore, owner.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	deliveries, err := dataStore.Webhooks().ListDeliveriesBySubscription(ctx, subscription.ID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := ownerGetWebhookDeliveriesResponse{Deliveries: []ownerWebhookDelivery{}}
+	for _, delivery := range deliveries {
+		item := ownerWebhookDelivery{
+			ID:             delivery.ID,
+			EventID:        delivery.EventID,
+			EventType:      delivery.EventType,
+			Payload:        json.RawMessage(delivery.Payload),
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			CreatedAt:      delivery.CreatedAt.UnixMilli(),
+			UpdatedAt:      delivery.UpdatedAt.UnixMilli(),
+		}
+		if delivery.NextAttemptAt != nil {
+			nextAttemptAt := delivery.NextAttemptAt.UnixMilli()
+			item.NextAttemptAt = &nextAttemptAt
+		}
+		res.Deliveries = append(res.Deliveries, item)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
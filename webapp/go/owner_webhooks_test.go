@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+func serveAsOwner(t *testing.T, handler http.HandlerFunc, owner *Owner, method, target string, pathValues map[string]string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveAs(t, handler, func(ctx context.Context) context.Context { return auth.WithOwner(ctx, owner) }, method, target, pathValues, body)
+}
+
+func addTestOwner(t *testing.T, m *store.Memory, id string, chairIDs ...string) *Owner {
+	t.Helper()
+	ctx := context.Background()
+	owner := &Owner{ID: id, Name: id, AccessToken: id, ChairRegisterToken: id}
+	if err := m.Owners().Create(ctx, owner); err != nil {
+		t.Fatal(err)
+	}
+	for _, chairID := range chairIDs {
+		if err := m.Chairs().Create(ctx, &Chair{ID: chairID, OwnerID: id, Name: chairID, Model: "リラックスシート NEO", AccessToken: chairID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return owner
+}
+
+func TestOwnerPostWebhook(t *testing.T) {
+	m := newTestStore(t)
+	owner := addTestOwner(t, m, "owner1")
+
+	tests := []struct {
+		name string
+		req  *ownerPostWebhookRequest
+	}{
+		{"URLが無い", &ownerPostWebhookRequest{EventTypes: []string{webhookEventRideCompleted}}},
+		{"相対URL", &ownerPostWebhookRequest{URL: "/webhook", EventTypes: []string{webhookEventRideCompleted}}},
+		{"http以外のURL", &ownerPostWebhookRequest{URL: "ftp://example.com/webhook", EventTypes: []string{webhookEventRideCompleted}}},
+		{"ループバックのURL", &ownerPostWebhookRequest{URL: "http://127.0.0.1:8080/webhook", EventTypes: []string{webhookEventRideCompleted}}},
+		{"メタデータサーバーのURL", &ownerPostWebhookRequest{URL: "http://169.254.169.254/latest/meta-data", EventTypes: []string{webhookEventRideCompleted}}},
+		{"プライベートネットワークのURL", &ownerPostWebhookRequest{URL: "http://[fd00::1]/webhook", EventTypes: []string{webhookEventRideCompleted}}},
+		{"イベントが無い", &ownerPostWebhookRequest{URL: "https://example.com/webhook"}},
+		{"存在しないイベント", &ownerPostWebhookRequest{URL: "https://example.com/webhook", EventTypes: []string{"ride.created"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAsOwner(t, ownerPostWebhook, owner, http.MethodPost, "/api/owner/webhooks", nil, tt.req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+		})
+	}
+
+	rec := serveAsOwner(t, ownerPostWebhook, owner, http.MethodPost, "/api/owner/webhooks", nil, &ownerPostWebhookRequest{
+		URL:        "https://example.com/webhook",
+		EventTypes: []string{webhookEventPaymentFailed, webhookEventRideCompleted, webhookEventPaymentFailed},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	created := decodeBody[ownerPostWebhookResponse](t, rec)
+	if !strings.HasPrefix(created.Secret, webhookSecretPrefix) {
+		t.Errorf("secret = %s, want prefix %s", created.Secret, webhookSecretPrefix)
+	}
+	if strings.Join(created.EventTypes, ",") != "payment.failed,ride.completed" {
+		t.Errorf("event types = %v, want sorted and deduplicated", created.EventTypes)
+	}
+
+	// 秘密鍵は登録時以外には返さない
+	rec = serveAsOwner(t, ownerGetWebhooks, owner, http.MethodGet, "/api/owner/webhooks", nil, nil)
+	if strings.Contains(rec.Body.String(), created.Secret) {
+		t.Errorf("response leaked the secret: %s", rec.Body.String())
+	}
+	if res := decodeBody[ownerGetWebhooksResponse](t, rec); len(res.Webhooks) != 1 || res.Webhooks[0].ID != created.ID {
+		t.Errorf("webhooks = %+v, want the created webhook", res.Webhooks)
+	}
+}
+
+func TestOwnerDeleteWebhook(t *testing.T) {
+	m := newTestStore(t)
+	owner := addTestOwner(t, m, "owner1", "chair1")
+	other := addTestOwner(t, m, "owner2")
+	ctx := context.Background()
+	if err := m.Webhooks().CreateSubscription(ctx, &WebhookSubscription{ID: "webhook1", OwnerID: owner.ID, URL: "https://example.com", Secret: "secret", EventTypes: webhookEventChairDeactivated}); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishChairWebhookEvent(ctx, m, "chair1", webhookEventChairDeactivated, &webhookChairDeactivatedData{ChairID: "chair1", Reason: "CHAIR"}); err != nil {
+		t.Fatal(err)
+	}
+	pathValues := map[string]string{"webhook_id": "webhook1"}
+
+	// 他のオーナーのWebhookは見えない
+	rec := serveAsOwner(t, ownerGetWebhookDeliveries, other, http.MethodGet, "/api/owner/webhooks/webhook1/deliveries", pathValues, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("other owner: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec = serveAsOwner(t, ownerDeleteWebhook, other, http.MethodDelete, "/api/owner/webhooks/webhook1", pathValues, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("other owner: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = serveAsOwner(t, ownerGetWebhookDeliveries, owner, http.MethodGet, "/api/owner/webhooks/webhook1/deliveries", pathValues, nil)
+	if res := decodeBody[ownerGetWebhookDeliveriesResponse](t, rec); len(res.Deliveries) != 1 || res.Deliveries[0].Status != "PENDING" {
+		t.Errorf("deliveries = %+v, want a pending delivery", res.Deliveries)
+	}
+
+	rec = serveAsOwner(t, ownerDeleteWebhook, owner, http.MethodDelete, "/api/owner/webhooks/webhook1", pathValues, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	if deliveries, err := m.Webhooks().ListDeliveriesBySubscription(ctx, "webhook1", webhookDeliveriesMaxLimit); err != nil || len(deliveries) != 0 {
+		t.Errorf("deliveries = %+v (err = %v), want none after deletion", deliveries, err)
+	}
+}
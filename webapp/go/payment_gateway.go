@@ -8,10 +8,21 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 var erroredUpstream = errors.New("errored upstream")
 
+// 起動時に設定の値で上書きされる
+var paymentGatewayConfig = config.Default().Payment
+
+// リクエストごとにスパンを作成し、トレースコンテキストを社内決済マイクロサービスに伝搬する
+var paymentGatewayClient = &http.Client{Transport: telemetry.NewTransport(http.DefaultTransport)}
+
 type paymentGatewayPostPaymentRequest struct {
 	Amount int `json:"amount"`
 }
@@ -21,7 +32,18 @@ type paymentGatewayGetPaymentsResponseOne struct {
 	Status string `json:"status"`
 }
 
-func requestPaymentGatewayPostPayment(ctx context.Context, paymentGatewayURL string, token string, param *paymentGatewayPostPaymentRequest, retrieveRidesOrderByCreatedAtAsc func() ([]Ride, error)) error {
+func requestPaymentGatewayPostPayment(ctx context.Context, paymentGatewayURL string, token string, param *paymentGatewayPostPaymentRequest, retrieveRidesOrderByCreatedAtAsc func() ([]Ride, error)) (err error) {
+	ctx, span := tracer.Start(ctx, "requestPaymentGatewayPostPayment")
+	retry := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("payment.retries", retry))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	b, err := json.Marshal(param)
 	if err != nil {
 		return err
@@ -29,9 +51,11 @@ func requestPaymentGatewayPostPayment(ctx context.Context, paymentGatewayURL str
 
 	// 失敗したらとりあえずリトライ
 	// FIXME: 社内決済マイクロサービスのインフラに異常が発生していて、同時にたくさんリクエストすると変なことになる可能性あり
-	retry := 0
 	for {
 		err := func() error {
+			ctx, cancel := context.WithTimeout(ctx, paymentGatewayConfig.Timeout)
+			defer cancel()
+
 			req, err := http.NewRequestWithContext(ctx, http.MethodPost, paymentGatewayURL+"/payments", bytes.NewBuffer(b))
 			if err != nil {
 				return err
@@ -39,7 +63,7 @@ func requestPaymentGatewayPostPayment(ctx context.Context, paymentGatewayURL str
 			req.Header.Set("Content-Type", "application/json")
 			req.Header.Set("Authorization", "Bearer "+token)
 
-			res, err := http.DefaultClient.Do(req)
+			res, err := paymentGatewayClient.Do(req)
 			if err != nil {
 				return err
 			}
@@ -53,7 +77,7 @@ func requestPaymentGatewayPostPayment(ctx context.Context, paymentGatewayURL str
 				}
 				getReq.Header.Set("Authorization", "Bearer "+token)
 
-				getRes, err := http.DefaultClient.Do(getReq)
+				getRes, err := paymentGatewayClient.Do(getReq)
 				if err != nil {
 					return err
 				}
@@ -82,9 +106,10 @@ func requestPaymentGatewayPostPayment(ctx context.Context, paymentGatewayURL str
 			return nil
 		}()
 		if err != nil {
-			if retry < 5 {
+			if retry < paymentGatewayConfig.MaxRetries {
 				retry++
-				time.Sleep(100 * time.Millisecond)
+				paymentRetryCounter.Add(ctx, 1)
+				time.Sleep(paymentGatewayConfig.RetryInterval)
 				continue
 			} else {
 				return err
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/ratelimit"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+var rateLimiter = ratelimit.New()
+
+// settingsのrate_limitsから、エンドポイントごとのリクエスト数の制限を読み込む。
+// 値は {"POST /api/chair/coordinate": {"rate": 100, "burst": 200, "ip_rate": 10000, "ip_burst": 20000}} のような形式で、設定が無い場合は制限しない
+func loadRateLimits(ctx context.Context) error {
+	value, err := dataStore.Settings().Get(ctx, "rate_limits")
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		value = "{}"
+	}
+
+	limits, err := parseRateLimits(value)
+	if err != nil {
+		return err
+	}
+	return rateLimiter.SetLimits(limits)
+}
+
+func parseRateLimits(value string) (map[string]ratelimit.Limit, error) {
+	limits := map[string]ratelimit.Limit{}
+	if err := json.Unmarshal([]byte(value), &limits); err != nil {
+		return nil, fmt.Errorf("failed to parse rate_limits setting: %w", err)
+	}
+	return limits, nil
+}
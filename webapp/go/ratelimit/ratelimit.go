@@ -0,0 +1,296 @@
+// Package ratelimit はエンドポイントごとにIPアドレス単位と、認証済みの利用者単位でリクエスト数を制限するミドルウェアを提供する。
+package ratelimit
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+)
+
+// 使われなくなったバケツを削除する間隔
+const sweepInterval = 1 * time.Minute
+
+// エンドポイントごとの許可・拒否したリクエスト数
+var metrics = expvar.NewMap("rate_limit")
+
+// Limit は1秒あたりに補充するトークン数と、バケツの容量。
+// IPRate と IPBurst はIPアドレスごとの制限で、指定しない場合は Rate と Burst を使う
+type Limit struct {
+	Rate    float64 `json:"rate"`
+	Burst   int     `json:"burst"`
+	IPRate  float64 `json:"ip_rate,omitempty"`
+	IPBurst int     `json:"ip_burst,omitempty"`
+}
+
+func (l Limit) perIP() Limit {
+	if l.IPRate == 0 && l.IPBurst == 0 {
+		return Limit{Rate: l.Rate, Burst: l.Burst}
+	}
+	return Limit{Rate: l.IPRate, Burst: l.IPBurst}
+}
+
+func (l Limit) perPrincipal() Limit {
+	return Limit{Rate: l.Rate, Burst: l.Burst}
+}
+
+type rule struct {
+	route    string
+	method   string
+	segments []string
+	limit    Limit
+}
+
+type bucket struct {
+	limit     Limit
+	tokens    float64
+	updatedAt time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updatedAt).Seconds()*b.limit.Rate)
+	b.updatedAt = now
+}
+
+type Limiter struct {
+	mu        sync.Mutex
+	rules     []rule
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+	// X-Real-IP と X-Forwarded-For を信用するプロキシ。それ以外からの接続ではヘッダーを無視する
+	trustedProxies []netip.Prefix
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// ParseTrustedProxies は "10.0.0.0/8" のようなCIDR表記か、単一のIPアドレスの一覧を解釈する
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: expected an IP address or CIDR", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// SetTrustedProxies はクライアントのIPアドレスを示すヘッダーを信用するプロキシを置き換える
+func (l *Limiter) SetTrustedProxies(proxies []netip.Prefix) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trustedProxies = proxies
+}
+
+// SetLimits は制限を置き換える。キーは "POST /api/chair/coordinate" のような形式で、パスには {ride_id} のようなパラメーターを含められる
+func (l *Limiter) SetLimits(limits map[string]Limit) error {
+	rules := make([]rule, 0, len(limits))
+	for route, limit := range limits {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid route %q: expected \"METHOD /path\"", route)
+		}
+		if limit.Rate <= 0 || limit.Burst <= 0 {
+			return fmt.Errorf("invalid limit for %q: rate and burst must be positive", route)
+		}
+		if ip := limit.perIP(); ip.Rate <= 0 || ip.Burst <= 0 {
+			return fmt.Errorf("invalid limit for %q: ip_rate and ip_burst must be both positive or both omitted", route)
+		}
+		rules = append(rules, rule{
+			route:    route,
+			method:   method,
+			segments: strings.Split(path, "/"),
+			limit:    limit,
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = rules
+	l.buckets = map[string]*bucket{}
+	return nil
+}
+
+// Middleware はIPアドレスごとに制限する。アクセストークンは詐称して制限を回避できるので、認証の有無によらず常に適用する
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.serve(w, r, next, Limit.perIP, func() (string, bool) {
+			return "ip:" + l.clientIP(r), true
+		})
+	})
+}
+
+// PrincipalMiddleware は認証済みの利用者ごとに制限する。認証のミドルウェアより内側に置く
+func (l *Limiter) PrincipalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.serve(w, r, next, Limit.perPrincipal, func() (string, bool) {
+			return principalKey(r)
+		})
+	})
+}
+
+func (l *Limiter) serve(w http.ResponseWriter, r *http.Request, next http.Handler, limitOf func(Limit) Limit, clientKey func() (string, bool)) {
+	route, retryAfter, ok := l.allow(r, limitOf, clientKey)
+	if route == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+	if !ok {
+		metrics.Add(route+" rejected", 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		apierror.Write(w, http.StatusTooManyRequests, errors.New("too many requests"))
+		return
+	}
+	metrics.Add(route+" allowed", 1)
+	next.ServeHTTP(w, r)
+}
+
+// allow はリクエストを通してよいかを判定する。制限の無いエンドポイントや、クライアントを識別できない場合はrouteが空になる
+func (l *Limiter) allow(r *http.Request, limitOf func(Limit) Limit, clientKey func() (string, bool)) (route string, retryAfter time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	matched, found := l.match(r)
+	if !found {
+		return "", 0, true
+	}
+	client, found := clientKey()
+	if !found {
+		return "", 0, true
+	}
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	limit := limitOf(matched.limit)
+	key := matched.route + "\x00" + client
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens < 1 {
+		return matched.route, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return matched.route, 0, true
+}
+
+func (l *Limiter) match(r *http.Request) (rule, bool) {
+	segments := strings.Split(r.URL.Path, "/")
+	for _, rule := range l.rules {
+		if rule.method != r.Method || len(rule.segments) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, segment := range rule.segments {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				continue
+			}
+			if segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rule, true
+		}
+	}
+	return rule{}, false
+}
+
+// 満タンまで補充されたバケツは、削除しても次のリクエストで同じ状態から始まるので削除する
+func (l *Limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// principalKey は認証済みの利用者を識別する。オーナーはAPIキーごとに別の利用者として数える
+func principalKey(r *http.Request) (string, bool) {
+	ctx := r.Context()
+	if apiKey, ok := auth.OwnerAPIKeyFrom(ctx); ok {
+		return "api_key:" + apiKey.ID, true
+	}
+	if user, ok := auth.UserFrom(ctx); ok {
+		return "user:" + user.ID, true
+	}
+	if owner, ok := auth.OwnerFrom(ctx); ok {
+		return "owner:" + owner.ID, true
+	}
+	if chair, ok := auth.ChairFrom(ctx); ok {
+		return "chair:" + chair.ID, true
+	}
+	return "", false
+}
+
+// 信用するプロキシ (nginxなど) を経由している場合のみ、プロキシが設定したヘッダーからIPアドレスを得る。
+// それ以外の接続ではヘッダーを詐称して制限を回避できてしまうので、接続元のアドレスを使う
+func (l *Limiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !l.isTrustedProxy(remote) {
+		return host
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	// X-Forwarded-For はプロキシを経由するたびに末尾に追加されるので、末尾から信用するプロキシを読み飛ばす
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		ips := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if ip == "" {
+				break
+			}
+			addr, err := netip.ParseAddr(ip)
+			if err != nil || !l.isTrustedProxy(addr) || i == 0 {
+				return ip
+			}
+		}
+	}
+	return host
+}
+
+func (l *Limiter) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range l.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiter(t *testing.T, limits map[string]Limit) (*Limiter, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)}
+	l := New()
+	l.now = clock.Now
+	if err := l.SetLimits(limits); err != nil {
+		t.Fatal(err)
+	}
+	return l, clock
+}
+
+func serve(handler http.Handler, method, path string, modify func(*http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if modify != nil {
+		modify(req)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// withChair は認証済みの椅子からのリクエストにする
+func withChair(chairID, remoteAddr string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.RemoteAddr = remoteAddr
+		*r = *r.WithContext(auth.WithChair(r.Context(), &model.Chair{ID: chairID}))
+	}
+}
+
+func withRemoteAddr(remoteAddr string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.RemoteAddr = remoteAddr
+	}
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+})
+
+func TestLimiter_TokenBucket(t *testing.T) {
+	l, clock := newTestLimiter(t, map[string]Limit{
+		"POST /api/chair/coordinate": {Rate: 2, Burst: 3},
+	})
+	handler := l.PrincipalMiddleware(ok)
+
+	for i := range 3 {
+		if rec := serve(handler, http.MethodPost, "/api/chair/coordinate", withChair("chair1", "198.51.100.1:1234")); rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, http.StatusNoContent)
+		}
+	}
+
+	rec := serve(handler, http.MethodPost, "/api/chair/coordinate", withChair("chair1", "198.51.100.1:1234"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+
+	// 他のクライアントは影響を受けない
+	if rec := serve(handler, http.MethodPost, "/api/chair/coordinate", withChair("chair2", "198.51.100.1:1234")); rec.Code != http.StatusNoContent {
+		t.Errorf("another client: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	// 0.5秒で1トークン補充される
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	if rec := serve(handler, http.MethodPost, "/api/chair/coordinate", withChair("chair1", "198.51.100.1:1234")); rec.Code != http.StatusNoContent {
+		t.Errorf("after refill: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := serve(handler, http.MethodPost, "/api/chair/coordinate", withChair("chair1", "198.51.100.1:1234")); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("after refill: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestLimiter_Routes(t *testing.T) {
+	l, _ := newTestLimiter(t, map[string]Limit{
+		"POST /api/app/rides/{ride_id}/evaluation": {Rate: 1, Burst: 1},
+	})
+	handler := l.Middleware(ok)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		modify     func(*http.Request)
+		wantStatus int
+	}{
+		{name: "first request", method: http.MethodPost, path: "/api/app/rides/ride1/evaluation", modify: withRemoteAddr("198.51.100.1:1234"), wantStatus: http.StatusNoContent},
+		{name: "same route with another path parameter", method: http.MethodPost, path: "/api/app/rides/ride2/evaluation", modify: withRemoteAddr("198.51.100.1:1234"), wantStatus: http.StatusTooManyRequests},
+		{name: "same ip with another port", method: http.MethodPost, path: "/api/app/rides/ride1/evaluation", modify: withRemoteAddr("198.51.100.1:5678"), wantStatus: http.StatusTooManyRequests},
+		{name: "random token does not get a new bucket", method: http.MethodPost, path: "/api/app/rides/ride1/evaluation", modify: func(r *http.Request) {
+			r.RemoteAddr = "198.51.100.1:1234"
+			r.Header.Set("Authorization", "Bearer random")
+		}, wantStatus: http.StatusTooManyRequests},
+		{name: "unlimited method", method: http.MethodGet, path: "/api/app/rides/ride1/evaluation", modify: withRemoteAddr("198.51.100.1:1234"), wantStatus: http.StatusNoContent},
+		{name: "unlimited route", method: http.MethodPost, path: "/api/app/rides", modify: withRemoteAddr("198.51.100.1:1234"), wantStatus: http.StatusNoContent},
+		{name: "another ip", method: http.MethodPost, path: "/api/app/rides/ride1/evaluation", modify: withRemoteAddr("198.51.100.2:1234"), wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if rec := serve(handler, tt.method, tt.path, tt.modify); rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestLimiter_IPAndPrincipal(t *testing.T) {
+	l, _ := newTestLimiter(t, map[string]Limit{
+		"POST /api/chair/coordinate": {Rate: 1, Burst: 1, IPRate: 1, IPBurst: 2},
+	})
+	handler := l.Middleware(l.PrincipalMiddleware(ok))
+
+	tests := []struct {
+		name       string
+		modify     func(*http.Request)
+		wantStatus int
+	}{
+		{name: "first request", modify: withChair("chair1", "198.51.100.1:1234"), wantStatus: http.StatusNoContent},
+		{name: "same chair from another ip", modify: withChair("chair1", "198.51.100.2:1234"), wantStatus: http.StatusTooManyRequests},
+		{name: "another chair from the same ip", modify: withChair("chair2", "198.51.100.1:1234"), wantStatus: http.StatusNoContent},
+		{name: "ip limit is shared by chairs", modify: withChair("chair3", "198.51.100.1:1234"), wantStatus: http.StatusTooManyRequests},
+		{name: "owner authenticated with an api key", modify: func(r *http.Request) {
+			r.RemoteAddr = "198.51.100.3:1234"
+			ctx := auth.WithOwner(r.Context(), &model.Owner{ID: "owner1"})
+			*r = *r.WithContext(auth.WithOwnerAPIKey(ctx, &model.OwnerAPIKey{ID: "key1"}))
+		}, wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if rec := serve(handler, http.MethodPost, "/api/chair/coordinate", tt.modify); rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestLimiter_ClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New()
+	l.SetTrustedProxies(trusted)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "198.51.100.1:1234", want: "198.51.100.1"},
+		{name: "spoofed header from untrusted client", remoteAddr: "198.51.100.1:1234", header: map[string]string{"X-Real-IP": "192.0.2.1", "X-Forwarded-For": "192.0.2.2"}, want: "198.51.100.1"},
+		{name: "X-Real-IP from trusted proxy", remoteAddr: "127.0.0.1:1234", header: map[string]string{"X-Real-IP": "192.0.2.1"}, want: "192.0.2.1"},
+		{name: "X-Forwarded-For skips trusted proxies", remoteAddr: "127.0.0.1:1234", header: map[string]string{"X-Forwarded-For": "192.0.2.9, 192.0.2.1, 10.1.2.3"}, want: "192.0.2.1"},
+		{name: "X-Forwarded-For with only trusted proxies", remoteAddr: "127.0.0.1:1234", header: map[string]string{"X-Forwarded-For": "10.0.0.2, 10.1.2.3"}, want: "10.0.0.2"},
+		{name: "trusted proxy without headers", remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "ipv4-mapped trusted proxy", remoteAddr: "[::ffff:127.0.0.1]:1234", header: map[string]string{"X-Real-IP": "192.0.2.1"}, want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+		if got := l.clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, value := range []string{"", "localhost", "10.0.0.0/33", "10.0.0.1:80"} {
+		if _, err := ParseTrustedProxies([]string{value}); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) should fail", value)
+		}
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	l, clock := newTestLimiter(t, map[string]Limit{
+		"POST /api/chair/coordinate": {Rate: 1, Burst: 1},
+	})
+	handler := l.Middleware(ok)
+	serve(handler, http.MethodPost, "/api/chair/coordinate", withRemoteAddr("198.51.100.1:1234"))
+
+	clock.now = clock.now.Add(2 * sweepInterval)
+	serve(handler, http.MethodPost, "/api/chair/coordinate", withRemoteAddr("198.51.100.2:1234"))
+
+	if len(l.buckets) != 1 {
+		t.Errorf("buckets = %d, want 1", len(l.buckets))
+	}
+}
+
+func TestLimiter_SetLimits(t *testing.T) {
+	for _, limits := range []map[string]Limit{
+		{"/api/chair/coordinate": {Rate: 1, Burst: 1}},
+		{"POST api/chair/coordinate": {Rate: 1, Burst: 1}},
+		{"POST /api/chair/coordinate": {Rate: 0, Burst: 1}},
+		{"POST /api/chair/coordinate": {Rate: 1, Burst: 0}},
+		{"POST /api/chair/coordinate": {Rate: 1, Burst: 1, IPRate: 1}},
+	} {
+		if err := New().SetLimits(limits); err == nil {
+			t.Errorf("SetLimits(%v) should fail", limits)
+		}
+	}
+}
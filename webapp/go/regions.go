@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// 地域はマスターデータなので、起動時と初期化時に読み込んだものを使い回す
+var (
+	regions     []Region
+	regionsLock sync.RWMutex
+)
+
+func loadRegions(ctx context.Context) error {
+	rows, err := dataStore.Regions().List(ctx)
+	if err != nil {
+		return err
+	}
+	loaded := make([]Region, 0, len(rows))
+	for _, row := range rows {
+		region := Region{Region: row}
+		if row.Polygon.Valid {
+			if err := json.Unmarshal([]byte(row.Polygon.String), &region.polygon); err != nil {
+				return fmt.Errorf("invalid polygon of region %s: %w", row.ID, err)
+			}
+		}
+		loaded = append(loaded, region)
+	}
+
+	regionsLock.Lock()
+	defer regionsLock.Unlock()
+	regions = loaded
+	return nil
+}
+
+// 拠点の地域が決まった椅子のID。拠点は一度決まると変わらないので、決まった椅子については位置情報を受け取るたびに書き込まない
+var chairsWithRegion sync.Map
+
+func getRegions() []Region {
+	regionsLock.RLock()
+	defer regionsLock.RUnlock()
+	return regions
+}
+
+// 座標を含む地域を返す。地域が1つも定義されていない場合は全域をサービス提供範囲とみなす
+func findRegion(latitude, longitude int) (*Region, bool) {
+	rs := getRegions()
+	for i := range rs {
+		if rs[i].contains(latitude, longitude) {
+			return &rs[i], true
+		}
+	}
+	return nil, false
+}
+
+func isInServiceArea(latitude, longitude int) bool {
+	if len(getRegions()) == 0 {
+		return true
+	}
+	_, ok := findRegion(latitude, longitude)
+	return ok
+}
+
+func (r *Region) contains(latitude, longitude int) bool {
+	if latitude < r.MinLatitude || r.MaxLatitude < latitude || longitude < r.MinLongitude || r.MaxLongitude < longitude {
+		return false
+	}
+	if r.polygon == nil {
+		return true
+	}
+	return polygonContains(r.polygon, latitude, longitude)
+}
+
+// 点が多角形の内部にあるかを判定する (ray casting)
+func polygonContains(polygon []Coordinate, latitude, longitude int) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Longitude > longitude) != (b.Longitude > longitude) {
+			x := float64(b.Latitude-a.Latitude)*float64(longitude-a.Longitude)/float64(b.Longitude-a.Longitude) + float64(a.Latitude)
+			if float64(latitude) < x {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// 乗車位置の地域の運賃を返す。どの地域にも含まれない場合は標準の運賃
+func fareRates(pickupLatitude, pickupLongitude int) (int, int) {
+	if region, ok := findRegion(pickupLatitude, pickupLongitude); ok {
+		return region.InitialFare, region.FarePerDistance
+	}
+	return initialFare, farePerDistance
+}
+
+type appGetRegionsResponse struct {
+	Regions []appGetRegionsResponseRegion `json:"regions"`
+}
+
+type appGetRegionsResponseRegion struct {
+	ID              string       `json:"id"`
+	Name            string       `json:"name"`
+	MinCoordinate   Coordinate   `json:"min_coordinate"`
+	MaxCoordinate   Coordinate   `json:"max_coordinate"`
+	Polygon         []Coordinate `json:"polygon,omitempty"`
+	InitialFare     int          `json:"initial_fare"`
+	FarePerDistance int          `json:"fare_per_distance"`
+}
+
+func appGetRegions(w http.ResponseWriter, r *http.Request) {
+	res := appGetRegionsResponse{Regions: []appGetRegionsResponseRegion{}}
+	for _, region := range getRegions() {
+		res.Regions = append(res.Regions, appGetRegionsResponseRegion{
+			ID:              region.ID,
+			Name:            region.Name,
+			MinCoordinate:   Coordinate{Latitude: region.MinLatitude, Longitude: region.MinLongitude},
+			MaxCoordinate:   Coordinate{Latitude: region.MaxLatitude, Longitude: region.MaxLongitude},
+			Polygon:         region.polygon,
+			InitialFare:     region.InitialFare,
+			FarePerDistance: region.FarePerDistance,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
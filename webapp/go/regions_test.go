@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+// 0-100の正方形と、200-300の範囲にある直角三角形の地域
+var testRegions = []model.Region{
+	{ID: "square", Name: "square", MinLatitude: 0, MaxLatitude: 100, MinLongitude: 0, MaxLongitude: 100, InitialFare: 1000, FarePerDistance: 200},
+	{
+		ID: "triangle", Name: "triangle", MinLatitude: 200, MaxLatitude: 300, MinLongitude: 200, MaxLongitude: 300,
+		Polygon:     sql.NullString{String: `[{"latitude":200,"longitude":200},{"latitude":300,"longitude":200},{"latitude":200,"longitude":300}]`, Valid: true},
+		InitialFare: 700, FarePerDistance: 50,
+	},
+}
+
+func setTestRegions(t *testing.T, m *store.Memory, rs ...model.Region) {
+	t.Helper()
+	prev := getRegions()
+	for _, region := range rs {
+		m.AddRegion(region)
+	}
+	if err := loadRegions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		regionsLock.Lock()
+		defer regionsLock.Unlock()
+		regions = prev
+	})
+}
+
+func TestLoadRegions_InvalidPolygon(t *testing.T) {
+	m := newTestStore(t)
+	m.AddRegion(model.Region{ID: "broken", Polygon: sql.NullString{String: "[{", Valid: true}})
+	if err := loadRegions(context.Background()); err == nil {
+		t.Error("loadRegions should fail on an invalid polygon")
+	}
+}
+
+func TestFareRates(t *testing.T) {
+	m := newTestStore(t)
+	setTestRegions(t, m, testRegions...)
+
+	tests := []struct {
+		name                  string
+		latitude, longitude   int
+		wantInitial, wantRate int
+		wantInArea            bool
+	}{
+		{name: "inside square", latitude: 50, longitude: 50, wantInitial: 1000, wantRate: 200, wantInArea: true},
+		{name: "on the edge of square", latitude: 100, longitude: 0, wantInitial: 1000, wantRate: 200, wantInArea: true},
+		{name: "inside triangle", latitude: 210, longitude: 210, wantInitial: 700, wantRate: 50, wantInArea: true},
+		{name: "inside triangle bounds but outside polygon", latitude: 290, longitude: 290, wantInitial: initialFare, wantRate: farePerDistance},
+		{name: "outside all regions", latitude: -50, longitude: -50, wantInitial: initialFare, wantRate: farePerDistance},
+	}
+	for _, tt := range tests {
+		gotInitial, gotRate := fareRates(tt.latitude, tt.longitude)
+		if gotInitial != tt.wantInitial || gotRate != tt.wantRate {
+			t.Errorf("%s: fareRates = (%d, %d), want (%d, %d)", tt.name, gotInitial, gotRate, tt.wantInitial, tt.wantRate)
+		}
+		if got := isInServiceArea(tt.latitude, tt.longitude); got != tt.wantInArea {
+			t.Errorf("%s: isInServiceArea = %t, want %t", tt.name, got, tt.wantInArea)
+		}
+	}
+
+	// 運賃は乗車位置の地域で決まる
+	if got := calculateFare(0, 0, 30, 20); got != 1000+200*50 {
+		t.Errorf("calculateFare = %d, want %d", got, 1000+200*50)
+	}
+}
+
+func TestAppPostRidesServiceArea(t *testing.T) {
+	m := newTestStore(t)
+	setTestRegions(t, m, testRegions...)
+	user := addTestUser(t, m, "user1")
+
+	outside := &Coordinate{Latitude: 500, Longitude: 500}
+	rec := serveAsUser(t, appPostRides, user, http.MethodPost, "/api/app/rides", &appPostRidesRequest{
+		PickupCoordinate:      outside,
+		DestinationCoordinate: testRideRequest.DestinationCoordinate,
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("ride outside service area: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = serveAsUser(t, appPostRidesEstimatedFare, user, http.MethodPost, "/api/app/rides/estimated-fare", &appPostRidesEstimatedFareRequest{
+		PickupCoordinate:      outside,
+		DestinationCoordinate: testRideRequest.DestinationCoordinate,
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("estimation outside service area: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// 乗車位置が地域内であれば、その地域の運賃で配車を受け付ける
+	ride := postTestRide(t, user)
+	if ride.Fare != 1000+200*50 {
+		t.Errorf("fare = %d, want %d", ride.Fare, 1000+200*50)
+	}
+}
+
+func TestChairPostCoordinateHomeRegion(t *testing.T) {
+	m := newTestStore(t)
+	setTestRegions(t, m, testRegions...)
+	addTestOwner(t, m, "owner1", "chair1")
+	chair, err := m.Chairs().Get(context.Background(), "chair1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(latitude, longitude int) {
+		t.Helper()
+		rec := serveAsChair(t, chairPostCoordinate, chair, http.MethodPost, "/api/chair/coordinate", &Coordinate{Latitude: latitude, Longitude: longitude})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+
+	// 地域の外では拠点は決まらない
+	post(500, 500)
+	if regionID, ok := m.ChairRegion(chair.ID); ok {
+		t.Errorf("home region = %s, want none", regionID)
+	}
+	if _, ok := chairsWithRegion.Load(chair.ID); ok {
+		t.Error("chair without a home region should not be cached")
+	}
+
+	// 拠点が決まった後は、他の地域に移動しても書き込まない
+	post(50, 50)
+	post(210, 210)
+	if regionID, _ := m.ChairRegion(chair.ID); regionID != "square" {
+		t.Errorf("home region = %q, want square", regionID)
+	}
+	if _, ok := chairsWithRegion.Load(chair.ID); !ok {
+		t.Error("chair with a home region should be cached")
+	}
+}
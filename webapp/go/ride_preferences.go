@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+// 希望できる椅子のモデルの数
+const maxPreferredChairModels = 20
+
+// appRideChairPreferences はユーザーが希望する椅子の条件。
+// マッチングでは ride_preference_timeout_seconds の間だけ条件を満たす椅子を探し、見つからなければ条件を外す
+type appRideChairPreferences struct {
+	// 椅子のモデルの最低速度
+	MinSpeed *int `json:"min_speed,omitempty"`
+	// 希望する椅子のモデル。いずれかのモデルの椅子を割り当てる
+	Models []string `json:"models,omitempty"`
+	// getChairStats で求める椅子の平均評価の最低値。評価されたことが無い椅子は満たさない
+	MinRating *float64 `json:"min_rating,omitempty"`
+}
+
+func invalidChairPreferences(format string, args ...any) error {
+	return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "chair_preferences: "+fmt.Sprintf(format, args...))
+}
+
+// newRideChairPreference は希望する椅子の条件を検証して、保存する形にする。条件が無い場合はnilを返す
+func newRideChairPreference(ctx context.Context, q store.Queries, rideID string, req *appRideChairPreferences) (*RideChairPreference, error) {
+	if req == nil {
+		return nil, nil
+	}
+	preference := &RideChairPreference{RideID: rideID, MinRating: req.MinRating}
+	if req.MinSpeed != nil {
+		if *req.MinSpeed < 1 {
+			return nil, invalidChairPreferences("min_speed must be positive")
+		}
+		preference.MinSpeed = *req.MinSpeed
+	}
+	if req.MinRating != nil && (*req.MinRating < 1 || *req.MinRating > 5) {
+		return nil, invalidChairPreferences("min_rating must be between 1 and 5")
+	}
+
+	models := []string{}
+	for _, name := range req.Models {
+		if slices.Contains(models, name) {
+			continue
+		}
+		// モデル名はカンマ区切りで保存するので、カンマを含む名前は存在しないモデルとして扱う
+		if _, err := q.Chairs().GetModel(ctx, name); errors.Is(err, store.ErrNotFound) || strings.Contains(name, ",") {
+			return nil, invalidChairPreferences("unknown model: %s", name)
+		} else if err != nil {
+			return nil, err
+		}
+		models = append(models, name)
+	}
+	if len(models) > maxPreferredChairModels {
+		return nil, invalidChairPreferences("up to %d models can be specified", maxPreferredChairModels)
+	}
+	preference.Models = strings.Join(models, ",")
+
+	if preference.MinSpeed == 0 && preference.Models == "" && preference.MinRating == nil {
+		return nil, nil
+	}
+	return preference, nil
+}
+
+// preferenceRelaxed はライドの要求から時間が経ち、希望する椅子の条件を外してマッチングするかを返す
+func preferenceRelaxed(ride *Ride, timeout time.Duration, now time.Time) bool {
+	return !now.Before(ride.CreatedAt.Add(timeout))
+}
+
+// satisfiesMinRating は椅子の平均評価が希望する最低値以上かを返す
+func satisfiesMinRating(ctx context.Context, q store.Queries, chairID string, preference *RideChairPreference) (bool, error) {
+	if preference == nil || preference.MinRating == nil {
+		return true, nil
+	}
+	stats, err := getChairStats(ctx, q, chairID)
+	if err != nil {
+		return false, err
+	}
+	return stats.TotalRidesCount > 0 && stats.TotalEvaluationAvg >= *preference.MinRating, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAppPostRidesChairPreferences(t *testing.T) {
+	m := newTestStore(t)
+	captureLogs(t)
+	m.AddChairModel(ChairModel{Name: "リラックスシート NEO", Speed: 2})
+	m.AddChairModel(ChairModel{Name: "エアシェル ライト", Speed: 7})
+	user := addTestUser(t, m, "user1")
+
+	minSpeed, zero := 5, 0
+	rating, tooHigh := 4.5, 5.5
+	tests := []struct {
+		name        string
+		preferences *appRideChairPreferences
+		wantStatus  int
+	}{
+		{"存在しないモデル", &appRideChairPreferences{Models: []string{"存在しない椅子"}}, http.StatusBadRequest},
+		{"最低速度が0", &appRideChairPreferences{MinSpeed: &zero}, http.StatusBadRequest},
+		{"最低評価が範囲外", &appRideChairPreferences{MinRating: &tooHigh}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := *testRideRequest
+			req.ChairPreferences = tt.preferences
+			rec := serveAsUser(t, appPostRides, user, http.MethodPost, "/api/app/rides", &req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	req := *testRideRequest
+	req.ChairPreferences = &appRideChairPreferences{
+		MinSpeed:  &minSpeed,
+		Models:    []string{"エアシェル ライト", "リラックスシート NEO", "エアシェル ライト"},
+		MinRating: &rating,
+	}
+	rec := serveAsUser(t, appPostRides, user, http.MethodPost, "/api/app/rides", &req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body.String())
+	}
+	ride := decodeBody[appPostRidesResponse](t, rec)
+	preference, err := m.Rides().GetChairPreference(context.Background(), ride.RideID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preference.MinSpeed != minSpeed || preference.Models != "エアシェル ライト,リラックスシート NEO" || preference.MinRating == nil || *preference.MinRating != rating {
+		t.Errorf("preference = %+v, want the requested preferences without duplicated models", preference)
+	}
+}
+
+func TestPreferenceRelaxed(t *testing.T) {
+	createdAt := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	ride := &Ride{CreatedAt: createdAt}
+	if preferenceRelaxed(ride, 30*time.Second, createdAt.Add(29*time.Second)) {
+		t.Error("relaxed before the timeout")
+	}
+	if !preferenceRelaxed(ride, 30*time.Second, createdAt.Add(30*time.Second)) {
+		t.Error("not relaxed after the timeout")
+	}
+	// 0秒の場合は最初から条件を外す
+	if !preferenceRelaxed(ride, 0, createdAt) {
+		t.Error("not relaxed with zero timeout")
+	}
+}
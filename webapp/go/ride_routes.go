@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+type getRideRouteResponse struct {
+	RideID            string       `json:"ride_id"`
+	Points            []Coordinate `json:"points"`
+	TravelledDistance int          `json:"travelled_distance"`
+	DirectDistance    int          `json:"direct_distance"`
+}
+
+// 椅子が乗車位置に向かい始めてから目的地に到着するまでの移動経路を組み立てる。
+// 保持期間を過ぎて集計済みの位置と、まだ残っている位置情報を記録順に連結する。
+func buildRideRoute(ctx context.Context, tx store.Queries, ride *Ride) (*getRideRouteResponse, error) {
+	res := &getRideRouteResponse{
+		RideID: ride.ID,
+		Points: []Coordinate{},
+	}
+	if !ride.ChairID.Valid {
+		return res, nil
+	}
+
+	start, end, err := getRideTravelPeriod(ctx, tx, ride.ID)
+	if err != nil {
+		return nil, err
+	}
+	if start == nil {
+		return res, nil
+	}
+	until := time.Now()
+	if end != nil {
+		until = *end
+	}
+
+	compacted, err := getRideRoute(ctx, tx, ride.ID)
+	if err != nil {
+		return nil, err
+	}
+	res.Points = append(res.Points, compacted...)
+
+	locations, err := tx.Chairs().ListLocations(ctx, ride.ChairID.String, *start, until)
+	if err != nil {
+		return nil, err
+	}
+	for _, location := range locations {
+		res.Points = append(res.Points, Coordinate{Latitude: location.Latitude, Longitude: location.Longitude})
+	}
+
+	if len(res.Points) == 0 {
+		return res, nil
+	}
+	res.TravelledDistance = calculatePathDistance(res.Points)
+	// 出発地点から乗車位置、乗車位置から目的地までを最短で移動した場合の距離
+	first := res.Points[0]
+	res.DirectDistance = calculateDistance(first.Latitude, first.Longitude, ride.PickupLatitude, ride.PickupLongitude) +
+		calculateDistance(ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude)
+
+	return res, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+)
+
+const (
+	drainPollInterval = 1 * time.Second
+	readinessTimeout  = 1 * time.Second
+)
+
+// SIGTERMを受け取ってから停止するまでの間trueになる。この間は新しいライドを受け付けず、readyzは失敗を返す
+var draining atomic.Bool
+
+func newServer(addr string, cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// drain は新しいライドの受付を止め、進行中のライドが完了するか drainTimeout が経過するまで待つ。
+// 待っている間も椅子からの位置情報やマッチングは受け付ける必要があるため、HTTPサーバーは止めない
+func drain(drainTimeout time.Duration) {
+	draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		count, err := countInFlightRides(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				slog.Warn("drain timed out")
+				return
+			}
+			slog.Error("failed to count in-flight rides", "error", err)
+		} else if count == 0 {
+			slog.Info("all rides completed")
+			return
+		} else {
+			slog.Info("waiting for in-flight rides", "count", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Warn("drain timed out", "in_flight_rides", count)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func countInFlightRides(ctx context.Context) (int, error) {
+	return dataStore.Rides().CountInProgress(ctx)
+}
+
+// shutdownServer は新しい接続の受付を止め、処理中のリクエストの完了を待つ。
+// 待ちきれなかった場合は接続を強制的に閉じる
+func shutdownServer(server *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("failed to shutdown gracefully", "error", err)
+		if err := server.Close(); err != nil {
+			slog.Error("failed to close server", "error", err)
+		}
+	}
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// プロセスが応答できるかだけを返す
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// リクエストを受け付けられるかを返す。停止処理中やDBに接続できない場合は503を返す
+func getReadyz(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		writeError(w, http.StatusServiceUnavailable, errShuttingDown)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+var authenticator = auth.New(&authStore{}, writeError)
+
+// authStore はauth.Storeの実装で、dataStoreを使う
+type authStore struct{}
+
+var _ auth.Store = (*authStore)(nil)
+
+// storeの ErrNotFound をauthパッケージのエラーに置き換える
+func authResult[T any](v *T, err error) (*T, error) {
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, auth.ErrNotFound
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *authStore) GetSession(ctx context.Context, token string) (*Session, error) {
+	return authResult(dataStore.Sessions().Get(ctx, token))
+}
+
+func (s *authStore) GetLegacyPrincipalID(ctx context.Context, role auth.Role, token string) (string, error) {
+	var principalID string
+	switch role {
+	case auth.RoleApp:
+		user, err := authResult(dataStore.Users().GetByAccessToken(ctx, token))
+		if err != nil {
+			return "", err
+		}
+		principalID = user.ID
+	case auth.RoleOwner:
+		owner, err := authResult(dataStore.Owners().GetByAccessToken(ctx, token))
+		if err != nil {
+			return "", err
+		}
+		principalID = owner.ID
+	case auth.RoleChair:
+		chair, err := authResult(dataStore.Chairs().GetByAccessToken(ctx, token))
+		if err != nil {
+			return "", err
+		}
+		principalID = chair.ID
+	default:
+		return "", auth.ErrNotFound
+	}
+	return principalID, nil
+}
+
+func (s *authStore) CreateSessionIfNotExists(ctx context.Context, session *Session) (*Session, error) {
+	// 同時に初回利用された場合に備えて、登録済みであれば既存のセッションを使う
+	if err := dataStore.Sessions().CreateIfNotExists(ctx, session); err != nil {
+		return nil, err
+	}
+	return s.GetSession(ctx, session.Token)
+}
+
+func (s *authStore) GetUser(ctx context.Context, id string) (*User, error) {
+	return authResult(dataStore.Users().Get(ctx, id))
+}
+
+func (s *authStore) GetOwner(ctx context.Context, id string) (*Owner, error) {
+	return authResult(dataStore.Owners().Get(ctx, id))
+}
+
+func (s *authStore) GetChair(ctx context.Context, id string) (*Chair, error) {
+	return authResult(dataStore.Chairs().Get(ctx, id))
+}
+
+func (s *authStore) GetOwnerAPIKeyByHash(ctx context.Context, keyHash string) (*OwnerAPIKey, error) {
+	return authResult(dataStore.OwnerAPIKeys().GetByHash(ctx, keyHash))
+}
+
+func createSession(ctx context.Context, sessions store.SessionStore, token string, role auth.Role, principalID string) (*Session, error) {
+	session := &Session{
+		Token:       token,
+		Role:        string(role),
+		PrincipalID: principalID,
+		ExpiresAt:   time.Now().Add(auth.SessionTTL),
+	}
+	if err := sessions.Create(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, session *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     auth.CookieName(auth.Role(session.Role)),
+		Value:    session.Token,
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request, role auth.Role) {
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     auth.CookieName(role),
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func appPostLogout(w http.ResponseWriter, r *http.Request) {
+	postLogout(w, r, auth.RoleApp)
+}
+
+func ownerPostLogout(w http.ResponseWriter, r *http.Request) {
+	postLogout(w, r, auth.RoleOwner)
+}
+
+func chairPostLogout(w http.ResponseWriter, r *http.Request) {
+	postLogout(w, r, auth.RoleChair)
+}
+
+func postLogout(w http.ResponseWriter, r *http.Request, role auth.Role) {
+	ctx := r.Context()
+	token, ok := auth.TokenFromRequest(r, role)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	if err := dataStore.Sessions().Revoke(ctx, token); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	authenticator.Forget(token)
+
+	clearSessionCookie(w, r, role)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type postSessionResponse struct {
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+func appPostLogin(w http.ResponseWriter, r *http.Request) {
+	postLogin(w, r, auth.RoleApp)
+}
+
+func ownerPostLogin(w http.ResponseWriter, r *http.Request) {
+	postLogin(w, r, auth.RoleOwner)
+}
+
+func chairPostLogin(w http.ResponseWriter, r *http.Request) {
+	postLogin(w, r, auth.RoleChair)
+}
+
+// Authorizationヘッダーで認証したアクセストークンをCookieに設定する。
+// Cookieは HttpOnly なので、ブラウザのスクリプトからは設定できない
+func postLogin(w http.ResponseWriter, r *http.Request, role auth.Role) {
+	ctx := r.Context()
+	token, ok := auth.TokenFromRequest(r, role)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	session, err := dataStore.Sessions().Get(ctx, token)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	setSessionCookie(w, r, session)
+	writeJSON(w, http.StatusOK, &postSessionResponse{
+		ExpiresAt: session.ExpiresAt.UnixMilli(),
+	})
+}
+
+func appPostSessionRotate(w http.ResponseWriter, r *http.Request) {
+	postSessionRotate(w, r, auth.RoleApp)
+}
+
+func ownerPostSessionRotate(w http.ResponseWriter, r *http.Request) {
+	postSessionRotate(w, r, auth.RoleOwner)
+}
+
+func chairPostSessionRotate(w http.ResponseWriter, r *http.Request) {
+	postSessionRotate(w, r, auth.RoleChair)
+}
+
+// 新しいアクセストークンを発行し、現在のアクセストークンを失効させる
+func postSessionRotate(w http.ResponseWriter, r *http.Request, role auth.Role) {
+	ctx := r.Context()
+	token, ok := auth.TokenFromRequest(r, role)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+		return
+	}
+
+	tx, err := dataStore.Begin(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	current, err := tx.Sessions().GetActiveForUpdate(ctx, token, string(role))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusUnauthorized, auth.ErrInvalidToken)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Sessions().Revoke(ctx, current.Token); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	session, err := createSession(ctx, tx.Sessions(), secureRandomStr(32), role, current.PrincipalID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	authenticator.Forget(current.Token)
+
+	setSessionCookie(w, r, session)
+	writeJSON(w, http.StatusOK, &postSessionResponse{
+		ExpiresAt: session.ExpiresAt.UnixMilli(),
+	})
+}
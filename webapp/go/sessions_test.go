@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/auth"
+)
+
+func TestAuthStore(t *testing.T) {
+	ctx := context.Background()
+	m := newTestStore(t)
+	addTestUser(t, m, "user1")
+	addTestOwner(t, m, "owner1", "chair1")
+	s := &authStore{}
+
+	tests := []struct {
+		role  auth.Role
+		token string
+		want  string
+	}{
+		{auth.RoleApp, "user1", "user1"},
+		{auth.RoleOwner, "owner1", "owner1"},
+		{auth.RoleChair, "chair1", "chair1"},
+	}
+	for _, tt := range tests {
+		if got, err := s.GetLegacyPrincipalID(ctx, tt.role, tt.token); err != nil || got != tt.want {
+			t.Errorf("GetLegacyPrincipalID(%s, %s) = %q, %v, want %q", tt.role, tt.token, got, err, tt.want)
+		}
+	}
+	// 他の役割のアクセストークンでは認証しない
+	if _, err := s.GetLegacyPrincipalID(ctx, auth.RoleApp, "owner1"); !errors.Is(err, auth.ErrNotFound) {
+		t.Errorf("GetLegacyPrincipalID with an owner token = %v, want auth.ErrNotFound", err)
+	}
+
+	// 同じトークンで同時に作られた場合は、先に登録されたセッションを返す
+	first := &Session{Token: "token1", Role: string(auth.RoleApp), PrincipalID: "user1", ExpiresAt: time.Now().Add(time.Hour)}
+	second := &Session{Token: "token1", Role: string(auth.RoleApp), PrincipalID: "user2", ExpiresAt: time.Now().Add(time.Hour)}
+	for _, session := range []*Session{first, second} {
+		got, err := s.CreateSessionIfNotExists(ctx, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.PrincipalID != "user1" {
+			t.Errorf("session principal = %s, want user1", got.PrincipalID)
+		}
+	}
+	if _, err := s.GetSession(ctx, "unknown"); !errors.Is(err, auth.ErrNotFound) {
+		t.Errorf("GetSession(unknown) = %v, want auth.ErrNotFound", err)
+	}
+}
+
+func serveWithSession(handler http.HandlerFunc, token string, secure bool) *http.Cookie {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(&http.Cookie{Name: auth.CookieName(auth.RoleApp), Value: token})
+	if secure {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == auth.CookieName(auth.RoleApp) {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestSessionRotateAndLogout(t *testing.T) {
+	ctx := context.Background()
+	m := newTestStore(t)
+	addTestUser(t, m, "user1")
+	authenticator.Clear()
+	t.Cleanup(authenticator.Clear)
+
+	if _, err := createSession(ctx, m.Sessions(), "token1", auth.RoleApp, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	// キャッシュに載せておき、失効時にキャッシュからも取り除かれることを確かめる
+	if _, err := authenticator.Authenticate(ctx, auth.RoleApp, "token1"); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := serveWithSession(appPostSessionRotate, "token1", true)
+	if rotated == nil || rotated.Value == "" || rotated.Value == "token1" {
+		t.Fatalf("rotated cookie = %+v, want a new token", rotated)
+	}
+	if !rotated.HttpOnly || !rotated.Secure || rotated.SameSite != http.SameSiteLaxMode || rotated.Path != "/" {
+		t.Errorf("rotated cookie attributes = %+v", rotated)
+	}
+	if d := time.Until(rotated.Expires); d < auth.SessionTTL-time.Minute || d > auth.SessionTTL {
+		t.Errorf("rotated cookie expires in %s, want %s", d, auth.SessionTTL)
+	}
+	if _, err := authenticator.Authenticate(ctx, auth.RoleApp, "token1"); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Authenticate(old token) = %v, want ErrInvalidToken", err)
+	}
+	if _, err := authenticator.Authenticate(ctx, auth.RoleApp, rotated.Value); err != nil {
+		t.Errorf("Authenticate(new token) = %v", err)
+	}
+
+	cleared := serveWithSession(appPostLogout, rotated.Value, false)
+	if cleared == nil || cleared.Value != "" || cleared.MaxAge >= 0 {
+		t.Fatalf("logout cookie = %+v, want a cleared cookie", cleared)
+	}
+	if !cleared.HttpOnly || cleared.Secure || cleared.SameSite != http.SameSiteLaxMode {
+		t.Errorf("logout cookie attributes = %+v", cleared)
+	}
+	if _, err := authenticator.Authenticate(ctx, auth.RoleApp, rotated.Value); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Authenticate after logout = %v, want ErrInvalidToken", err)
+	}
+	// 失効したアクセストークンでは再発行できない
+	if cookie := serveWithSession(appPostSessionRotate, rotated.Value, false); cookie != nil {
+		t.Errorf("rotate with a revoked token set cookie %+v", cookie)
+	}
+}
+
+func TestSessionLogin(t *testing.T) {
+	ctx := context.Background()
+	m := newTestStore(t)
+	addTestUser(t, m, "user1")
+	if _, err := createSession(ctx, m.Sessions(), "token1", auth.RoleApp, "user1"); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/app/login", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		appPostLogin(rec, req)
+		return rec
+	}
+
+	// ブラウザからは HttpOnly のCookieを設定できないので、サーバーが設定する
+	rec := login("token1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "app_session" || cookies[0].Value != "token1" || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookies = %+v, want an HttpOnly app_session cookie", cookies)
+	}
+
+	if rec := login("unknown"); rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("unknown token: status = %d, cookies = %+v", rec.Code, rec.Result().Cookies())
+	}
+}
@@ -0,0 +1,298 @@
+// Package sqlload はmysqlコマンドを使わずに、スキーマやmysqldumpで作ったSQLファイルをデータベースに読み込む。
+// DELIMITERによる区切り文字の変更には対応しない。
+package sqlload
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Execer は文を実行する。セッション変数を使うSQLファイルのため、1つのコネクションで実行すること
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Exec はrに含まれる文を順に実行し、実行した文の数を返す。
+// USE文は実行しない。接続先のデータベースに読み込むので、ファイルに書かれたデータベース名には依存しない
+func Exec(ctx context.Context, conn Execer, r io.Reader) (int, error) {
+	scanner := NewScanner(r)
+	count := 0
+	for scanner.Scan() {
+		stmt := scanner.Statement()
+		if isUse(stmt) {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return count, fmt.Errorf("failed to execute statement %d (%s): %w", count+1, abbreviate(stmt), err)
+		}
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// ExecFile はファイルの文を実行する。拡張子が.gzのファイルは展開しながら読み込む
+func ExecFile(ctx context.Context, conn Execer, path string) (int, error) {
+	r, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	count, err := Exec(ctx, conn, r)
+	if err != nil {
+		return count, fmt.Errorf("%s: %w", path, err)
+	}
+	return count, nil
+}
+
+// Open はSQLファイルを開く。拡張子が.gzのファイルは展開しながら読み込む
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &gzipFile{Reader: gz, file: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	return errors.Join(f.Reader.Close(), f.file.Close())
+}
+
+var usePattern = regexp.MustCompile(`(?i)^USE\s`)
+
+func isUse(stmt string) bool {
+	return usePattern.MatchString(stmt)
+}
+
+// エラーメッセージに含める文は先頭だけにする。初期データのINSERT文は1MB近くある
+func abbreviate(stmt string) string {
+	const maxLen = 80
+	if len(stmt) <= maxLen {
+		return stmt
+	}
+	return stmt[:maxLen] + "..."
+}
+
+// ErrDatasetNotFound はデータセットが存在しないことを表す
+var ErrDatasetNotFound = errors.New("dataset not found")
+
+// DefaultDataset はベンチマーカーが使う初期データ
+const DefaultDataset = "default"
+
+var datasetNamePattern = regexp.MustCompile(`^[0-9A-Za-z_-]{1,64}$`)
+
+// ValidDatasetName はデータセット名にファイルパスとして使えない文字が含まれていないかを返す
+func ValidDatasetName(name string) bool {
+	return datasetNamePattern.MatchString(name)
+}
+
+// DatasetPath はデータセット名からSQLファイルのパスを返す。
+// default はdir直下の3-initial-data.sql.gzで、それ以外はdir/datasets/<name>.sql.gz か dir/datasets/<name>.sql を探す
+func DatasetPath(dir, name string) (string, error) {
+	if name == DefaultDataset {
+		return filepath.Join(dir, "3-initial-data.sql.gz"), nil
+	}
+	if !ValidDatasetName(name) {
+		return "", fmt.Errorf("%w: %q", ErrDatasetNotFound, name)
+	}
+	for _, ext := range []string{".sql.gz", ".sql"} {
+		path := filepath.Join(dir, "datasets", name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrDatasetNotFound, name)
+}
+
+// Scanner はSQLファイルを;で区切られた文に分割する。
+// 文字列や識別子の中の;は区切りとみなさず、コメントは取り除く。
+// ただし /*! */ で書かれたバージョン付きのコメントはMySQLが実行するので残す
+type Scanner struct {
+	r    *bufio.Reader
+	stmt string
+	err  error
+}
+
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Scan は次の文を読み込む。文が無くなるかエラーが起きた場合はfalseを返す
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	var buf bytes.Buffer
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return s.emit(&buf)
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+
+		switch {
+		case c == ';':
+			if s.emit(&buf) {
+				return true
+			}
+		case c == '\'' || c == '"' || c == '`':
+			buf.WriteByte(c)
+			if err := s.readQuoted(&buf, c); err != nil {
+				s.err = err
+				return false
+			}
+		case c == '#':
+			if err := s.skipLine(); err != nil {
+				s.err = err
+				return false
+			}
+		case c == '-' && s.startsLineComment():
+			if err := s.skipLine(); err != nil {
+				s.err = err
+				return false
+			}
+		case c == '/' && s.peekIs('*'):
+			if err := s.readBlockComment(&buf); err != nil {
+				s.err = err
+				return false
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// emit は空白だけでなければ文として返す
+func (s *Scanner) emit(buf *bytes.Buffer) bool {
+	stmt := strings.TrimSpace(buf.String())
+	buf.Reset()
+	if stmt == "" {
+		return false
+	}
+	s.stmt = stmt
+	return true
+}
+
+func (s *Scanner) Statement() string {
+	return s.stmt
+}
+
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+func (s *Scanner) peekIs(c byte) bool {
+	b, err := s.r.Peek(1)
+	return err == nil && b[0] == c
+}
+
+// MySQLでは -- の後に空白か制御文字がある場合だけ行コメントになる
+func (s *Scanner) startsLineComment() bool {
+	b, err := s.r.Peek(2)
+	if len(b) == 0 || b[0] != '-' {
+		return false
+	}
+	if err != nil {
+		// "--" でファイルが終わっている
+		return len(b) == 1
+	}
+	return b[1] <= ' '
+}
+
+func (s *Scanner) skipLine() error {
+	_, err := s.r.ReadBytes('\n')
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// readQuoted は開始のquoteを読んだ後から、対応する終了のquoteまでをbufに書き込む
+func (s *Scanner) readQuoted(buf *bytes.Buffer, quote byte) error {
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return fmt.Errorf("unterminated %c quote", quote)
+		}
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+		switch {
+		case c == '\\' && quote != '`':
+			// エスケープされた文字はそのまま書き込む
+			next, err := s.r.ReadByte()
+			if err == io.EOF {
+				return fmt.Errorf("unterminated %c quote", quote)
+			}
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(next)
+		case c == quote:
+			// 2つ続くquoteはquote自体を表す
+			if !s.peekIs(quote) {
+				return nil
+			}
+			next, _ := s.r.ReadByte()
+			buf.WriteByte(next)
+		}
+	}
+}
+
+// readBlockComment は / を読んだ後から */ までを読み込み、/*! のコメントだけをbufに書き込む
+func (s *Scanner) readBlockComment(buf *bytes.Buffer) error {
+	s.r.ReadByte() // *
+	keep := s.peekIs('!')
+	if keep {
+		buf.WriteString("/*")
+	}
+	prev := byte(0)
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return errors.New("unterminated comment")
+		}
+		if err != nil {
+			return err
+		}
+		if keep {
+			buf.WriteByte(c)
+		}
+		if prev == '*' && c == '/' {
+			if !keep {
+				// コメントの前後の語がつながらないようにする
+				buf.WriteByte(' ')
+			}
+			return nil
+		}
+		prev = c
+	}
+}
@@ -0,0 +1,179 @@
+package sqlload
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "文に分割する",
+			input: "SELECT 1;\n\nSELECT 2;\nSELECT 3",
+			want:  []string{"SELECT 1", "SELECT 2", "SELECT 3"},
+		},
+		{
+			name:  "文字列や識別子の中の;では区切らない",
+			input: "INSERT INTO `a;b` VALUES ('x;y', \"z;\");",
+			want:  []string{"INSERT INTO `a;b` VALUES ('x;y', \"z;\")"},
+		},
+		{
+			name:  "エスケープされたquoteは文字列を終わらせない",
+			input: `INSERT INTO t VALUES ('it\'s;', 'a''b;');SELECT 1;`,
+			want:  []string{`INSERT INTO t VALUES ('it\'s;', 'a''b;')`, "SELECT 1"},
+		},
+		{
+			name:  "コメントを取り除く",
+			input: "-- comment;\n# comment;\nSELECT /* ; */ 1; -- trailing\n",
+			want:  []string{"SELECT   1"},
+		},
+		{
+			name:  "空白が続かない--はコメントではない",
+			input: "SELECT 1--1;",
+			want:  []string{"SELECT 1--1"},
+		},
+		{
+			name:  "バージョン付きのコメントは残す",
+			input: "/*!40101 SET NAMES utf8mb4 */;",
+			want:  []string{"/*!40101 SET NAMES utf8mb4 */"},
+		},
+		{
+			name:  "文字列の中のコメントは残す",
+			input: "INSERT INTO t VALUES ('-- /* # */');",
+			want:  []string{"INSERT INTO t VALUES ('-- /* # */')"},
+		},
+		{
+			name:    "閉じていない文字列",
+			input:   "SELECT 'abc;",
+			wantErr: true,
+		},
+		{
+			name:    "閉じていないコメント",
+			input:   "SELECT 1 /* abc;",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := NewScanner(strings.NewReader(tt.input))
+			got := []string{}
+			for scanner.Scan() {
+				got = append(got, scanner.Statement())
+			}
+			if err := scanner.Err(); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("statements = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type recordingExecer struct {
+	stmts []string
+	// この文字列を含む文でエラーを返す
+	failOn string
+}
+
+func (e *recordingExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if e.failOn != "" && strings.Contains(query, e.failOn) {
+		return nil, errors.New("failed")
+	}
+	e.stmts = append(e.stmts, query)
+	return nil, nil
+}
+
+func TestExec(t *testing.T) {
+	conn := &recordingExecer{}
+	count, err := Exec(context.Background(), conn, strings.NewReader("USE isuride;\nuse `isuride`;\nINSERT INTO t VALUES (1);\nUSERS_TABLE;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"INSERT INTO t VALUES (1)", "USERS_TABLE"}; count != 2 || !slices.Equal(conn.stmts, want) {
+		t.Errorf("executed %d statements %q, want %q", count, conn.stmts, want)
+	}
+
+	conn = &recordingExecer{failOn: "2"}
+	count, err = Exec(context.Background(), conn, strings.NewReader("SELECT 1; SELECT 2; SELECT 3;"))
+	if err == nil || !strings.Contains(err.Error(), "statement 2") {
+		t.Errorf("err = %v, want error of statement 2", err)
+	}
+	if count != 1 {
+		t.Errorf("count = %d, want 1", count)
+	}
+}
+
+// リポジトリのSQLファイルをすべて文に分割できること
+func TestExecFileRepositoryData(t *testing.T) {
+	for _, file := range []string{"1-schema.sql", "2-master-data.sql", "3-initial-data.sql.gz"} {
+		t.Run(file, func(t *testing.T) {
+			conn := &recordingExecer{}
+			count, err := ExecFile(context.Background(), conn, filepath.Join("..", "..", "sql", file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count == 0 {
+				t.Error("no statement was executed")
+			}
+			for _, stmt := range conn.stmts {
+				if strings.HasPrefix(stmt, "--") || strings.HasPrefix(stmt, "USE") {
+					t.Errorf("unexpected statement: %.80s", stmt)
+				}
+			}
+		})
+	}
+}
+
+func TestDatasetPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "datasets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"small.sql", "large.sql.gz"} {
+		if err := os.WriteFile(filepath.Join(dir, "datasets", name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", want: filepath.Join(dir, "3-initial-data.sql.gz")},
+		{name: "small", want: filepath.Join(dir, "datasets", "small.sql")},
+		{name: "large", want: filepath.Join(dir, "datasets", "large.sql.gz")},
+		{name: "missing", wantErr: true},
+		{name: "../datasets/small", wantErr: true},
+		{name: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DatasetPath(dir, tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, ErrDatasetNotFound) {
+					t.Errorf("err = %v, want ErrDatasetNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("path = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
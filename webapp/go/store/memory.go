@@ -0,0 +1,1349 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+// Memory はStoreのインメモリによる実装で、ハンドラーのテストで使う。
+// トランザクションは開始からCommitまたはRollbackまでストア全体をロックするので、
+// トランザクション中に同じストアをトランザクションの外から使うとデッドロックする
+type Memory struct {
+	memoryQueries
+	mu   sync.Mutex
+	data *memoryData
+	// 作成日時が同じにならないよう、最後に払い出した時刻を覚えておく
+	lastNow time.Time
+}
+
+var _ Store = (*Memory)(nil)
+
+type memoryData struct {
+	users          map[string]model.User
+	owners         map[string]model.Owner
+	chairs         map[string]model.Chair
+	chairModels    map[string]model.ChairModel
+	chairLocations []model.ChairLocation
+	// 保持期間を過ぎて削除した位置情報の総移動距離
+	locationSummaries map[string]int
+	chairRegions      map[string]string
+	chairSchedules    []model.ChairSchedule
+	activityPeriods   []model.ChairActivityPeriod
+	movementFlags     []model.ChairMovementFlag
+	rides             map[string]model.Ride
+	rideStatuses      []model.RideStatus
+	coupons           []model.Coupon
+	paymentTokens     map[string]model.PaymentToken
+	sessions          map[string]model.Session
+	apiKeys           map[string]model.OwnerAPIKey
+	settings          map[string]string
+	savedPlaces       map[string]model.SavedPlace
+	preferences       map[string]model.RideChairPreference
+	rideRoutes        map[string]model.RideRoute
+	webhooks          map[string]model.WebhookSubscription
+	deliveries        map[string]model.WebhookDelivery
+	regions           []model.Region
+}
+
+// ポインタのフィールドは更新時に差し替えるので、浅いコピーで十分
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		users:             maps.Clone(d.users),
+		owners:            maps.Clone(d.owners),
+		chairs:            maps.Clone(d.chairs),
+		chairModels:       maps.Clone(d.chairModels),
+		chairLocations:    slices.Clone(d.chairLocations),
+		locationSummaries: maps.Clone(d.locationSummaries),
+		chairRegions:      maps.Clone(d.chairRegions),
+		chairSchedules:    slices.Clone(d.chairSchedules),
+		activityPeriods:   slices.Clone(d.activityPeriods),
+		movementFlags:     slices.Clone(d.movementFlags),
+		rides:             maps.Clone(d.rides),
+		rideStatuses:      slices.Clone(d.rideStatuses),
+		coupons:           slices.Clone(d.coupons),
+		paymentTokens:     maps.Clone(d.paymentTokens),
+		sessions:          maps.Clone(d.sessions),
+		apiKeys:           maps.Clone(d.apiKeys),
+		settings:          maps.Clone(d.settings),
+		savedPlaces:       maps.Clone(d.savedPlaces),
+		preferences:       maps.Clone(d.preferences),
+		rideRoutes:        maps.Clone(d.rideRoutes),
+		webhooks:          maps.Clone(d.webhooks),
+		deliveries:        maps.Clone(d.deliveries),
+		regions:           slices.Clone(d.regions),
+	}
+}
+
+func NewMemory() *Memory {
+	m := &Memory{
+		data: &memoryData{
+			users:             map[string]model.User{},
+			owners:            map[string]model.Owner{},
+			chairs:            map[string]model.Chair{},
+			chairModels:       map[string]model.ChairModel{},
+			locationSummaries: map[string]int{},
+			chairRegions:      map[string]string{},
+			rides:             map[string]model.Ride{},
+			paymentTokens:     map[string]model.PaymentToken{},
+			sessions:          map[string]model.Session{},
+			apiKeys:           map[string]model.OwnerAPIKey{},
+			settings:          map[string]string{},
+			savedPlaces:       map[string]model.SavedPlace{},
+			preferences:       map[string]model.RideChairPreference{},
+			rideRoutes:        map[string]model.RideRoute{},
+			webhooks:          map[string]model.WebhookSubscription{},
+			deliveries:        map[string]model.WebhookDelivery{},
+		},
+	}
+	m.memoryQueries = memoryQueries{m: m}
+	return m
+}
+
+func (m *Memory) Begin(ctx context.Context) (Tx, error) {
+	m.mu.Lock()
+	return &memoryTx{memoryQueries: memoryQueries{m: m, inTx: true}, snapshot: m.data.clone()}, nil
+}
+
+func (m *Memory) BeginRead(ctx context.Context) (Tx, error) {
+	return m.Begin(ctx)
+}
+
+// now は呼び出すたびに必ず進む現在時刻を返す。ロックを取った状態で呼ぶ
+func (m *Memory) now() time.Time {
+	now := time.Now()
+	if !now.After(m.lastNow) {
+		now = m.lastNow.Add(time.Microsecond)
+	}
+	m.lastNow = now
+	return now
+}
+
+// AddChairModel はテスト用に椅子のモデルを登録する
+func (m *Memory) AddChairModel(chairModel model.ChairModel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.chairModels[chairModel.Name] = chairModel
+}
+
+// AddChairLocation はテスト用に椅子の位置情報を記録する
+func (m *Memory) AddChairLocation(id, chairID string, latitude, longitude int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.chairLocations = append(m.data.chairLocations, model.ChairLocation{
+		ID:        id,
+		ChairID:   chairID,
+		Latitude:  latitude,
+		Longitude: longitude,
+		CreatedAt: m.now(),
+	})
+}
+
+// AssignChair はテスト用にライドへ椅子を割り当てる
+func (m *Memory) AssignChair(rideID, chairID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ride, ok := m.data.rides[rideID]
+	if !ok {
+		return ErrNotFound
+	}
+	ride.ChairID = sql.NullString{String: chairID, Valid: true}
+	ride.UpdatedAt = m.now()
+	m.data.rides[rideID] = ride
+	return nil
+}
+
+// AddRegion はテスト用に地域を登録する
+func (m *Memory) AddRegion(region model.Region) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.regions = append(m.data.regions, region)
+}
+
+// ChairRegion はテスト用に椅子の拠点の地域を返す
+func (m *Memory) ChairRegion(chairID string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	regionID, ok := m.data.chairRegions[chairID]
+	return regionID, ok
+}
+
+// SetSetting はテスト用に設定値を登録する
+func (m *Memory) SetSetting(name, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.settings[name] = value
+}
+
+type memoryTx struct {
+	memoryQueries
+	snapshot *memoryData
+	done     bool
+}
+
+func (t *memoryTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.m.mu.Unlock()
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.m.data = t.snapshot
+	t.m.mu.Unlock()
+	return nil
+}
+
+// memoryQueries はトランザクションの外ではクエリごとにロックを取る
+type memoryQueries struct {
+	m    *Memory
+	inTx bool
+}
+
+func (q memoryQueries) lock() func() {
+	if q.inTx {
+		return func() {}
+	}
+	q.m.mu.Lock()
+	return q.m.mu.Unlock
+}
+
+func (q memoryQueries) Users() UserStore                 { return memoryUsers{q} }
+func (q memoryQueries) Owners() OwnerStore               { return memoryOwners{q} }
+func (q memoryQueries) Chairs() ChairStore               { return memoryChairs{q} }
+func (q memoryQueries) Rides() RideStore                 { return memoryRides{q} }
+func (q memoryQueries) Coupons() CouponStore             { return memoryCoupons{q} }
+func (q memoryQueries) PaymentTokens() PaymentTokenStore { return memoryPaymentTokens{q} }
+func (q memoryQueries) Sessions() SessionStore           { return memorySessions{q} }
+func (q memoryQueries) OwnerAPIKeys() OwnerAPIKeyStore   { return memoryOwnerAPIKeys{q} }
+func (q memoryQueries) Settings() SettingStore           { return memorySettings{q} }
+func (q memoryQueries) SavedPlaces() SavedPlaceStore     { return memorySavedPlaces{q} }
+func (q memoryQueries) Webhooks() WebhookStore           { return memoryWebhooks{q} }
+func (q memoryQueries) Regions() RegionStore             { return memoryRegions{q} }
+
+func (q memoryQueries) Now(ctx context.Context) (time.Time, error) {
+	defer q.lock()()
+	return q.m.now(), nil
+}
+
+func findOne[T any](items []T, match func(T) bool) (*T, error) {
+	for _, item := range items {
+		if match(item) {
+			return &item, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func filter[T any](items []T, match func(T) bool) []T {
+	matched := []T{}
+	for _, item := range items {
+		if match(item) {
+			matched = append(matched, item)
+		}
+	}
+	return matched
+}
+
+// newest は新しい順に並べたitemsのうち、先頭からlimit件を返す
+func newest[T any](items []T, limit int) []T {
+	slices.Reverse(items)
+	return items[:min(limit, len(items))]
+}
+
+// matchesSearch はIDが一致するか、名前がqueryで始まるかを返す
+func matchesSearch(query, id, name string) bool {
+	return query == "" || id == query || strings.HasPrefix(name, query)
+}
+
+func sortedValues[K comparable, V any](m map[K]V, compare func(a, b V) int) []V {
+	values := slices.Collect(maps.Values(m))
+	slices.SortStableFunc(values, compare)
+	return values
+}
+
+type memoryUsers struct{ memoryQueries }
+
+func (s memoryUsers) Create(ctx context.Context, user *model.User) error {
+	defer s.lock()()
+	if _, ok := s.m.data.users[user.ID]; ok {
+		return ErrDuplicate
+	}
+	created := *user
+	created.CreatedAt = s.m.now()
+	created.UpdatedAt = created.CreatedAt
+	s.m.data.users[user.ID] = created
+	return nil
+}
+
+func (s memoryUsers) Update(ctx context.Context, user *model.User) error {
+	defer s.lock()()
+	current, ok := s.m.data.users[user.ID]
+	if !ok {
+		return nil
+	}
+	for _, other := range s.m.data.users {
+		if other.ID != user.ID && other.Username == user.Username {
+			return ErrDuplicate
+		}
+	}
+	current.Username = user.Username
+	current.Firstname = user.Firstname
+	current.Lastname = user.Lastname
+	current.DateOfBirth = user.DateOfBirth
+	current.AccessToken = user.AccessToken
+	current.InvitationCode = user.InvitationCode
+	current.UpdatedAt = s.m.now()
+	s.m.data.users[user.ID] = current
+	return nil
+}
+
+func (s memoryUsers) Get(ctx context.Context, id string) (*model.User, error) {
+	defer s.lock()()
+	user, ok := s.m.data.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s memoryUsers) GetByInvitationCode(ctx context.Context, invitationCode string) (*model.User, error) {
+	defer s.lock()()
+	return findOne(slices.Collect(maps.Values(s.m.data.users)), func(u model.User) bool { return u.InvitationCode == invitationCode })
+}
+
+func (s memoryUsers) GetByAccessToken(ctx context.Context, accessToken string) (*model.User, error) {
+	defer s.lock()()
+	return findOne(slices.Collect(maps.Values(s.m.data.users)), func(u model.User) bool { return u.AccessToken == accessToken })
+}
+
+func (s memoryUsers) Search(ctx context.Context, query string, limit int) ([]model.User, error) {
+	defer s.lock()()
+	users := sortedValues(s.m.data.users, func(a, b model.User) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return newest(filter(users, func(u model.User) bool { return matchesSearch(query, u.ID, u.Username) }), limit), nil
+}
+
+type memoryOwners struct{ memoryQueries }
+
+func (s memoryOwners) Create(ctx context.Context, owner *model.Owner) error {
+	defer s.lock()()
+	if _, ok := s.m.data.owners[owner.ID]; ok {
+		return ErrDuplicate
+	}
+	created := *owner
+	created.CreatedAt = s.m.now()
+	created.UpdatedAt = created.CreatedAt
+	s.m.data.owners[owner.ID] = created
+	return nil
+}
+
+func (s memoryOwners) Get(ctx context.Context, id string) (*model.Owner, error) {
+	defer s.lock()()
+	owner, ok := s.m.data.owners[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &owner, nil
+}
+
+func (s memoryOwners) GetByChairRegisterToken(ctx context.Context, token string) (*model.Owner, error) {
+	defer s.lock()()
+	return findOne(slices.Collect(maps.Values(s.m.data.owners)), func(o model.Owner) bool { return o.ChairRegisterToken == token })
+}
+
+func (s memoryOwners) GetByAccessToken(ctx context.Context, accessToken string) (*model.Owner, error) {
+	defer s.lock()()
+	return findOne(slices.Collect(maps.Values(s.m.data.owners)), func(o model.Owner) bool { return o.AccessToken == accessToken })
+}
+
+func (s memoryOwners) Search(ctx context.Context, query string, limit int) ([]model.Owner, error) {
+	defer s.lock()()
+	owners := sortedValues(s.m.data.owners, func(a, b model.Owner) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return newest(filter(owners, func(o model.Owner) bool { return matchesSearch(query, o.ID, o.Name) }), limit), nil
+}
+
+type memoryChairs struct{ memoryQueries }
+
+func compareChairs(a, b model.Chair) int {
+	return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+}
+
+func (s memoryChairs) Create(ctx context.Context, chair *model.Chair) error {
+	defer s.lock()()
+	if _, ok := s.m.data.chairs[chair.ID]; ok {
+		return ErrDuplicate
+	}
+	created := *chair
+	created.CreatedAt = s.m.now()
+	created.UpdatedAt = created.CreatedAt
+	s.m.data.chairs[chair.ID] = created
+	return nil
+}
+
+func (s memoryChairs) Get(ctx context.Context, id string) (*model.Chair, error) {
+	defer s.lock()()
+	chair, ok := s.m.data.chairs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &chair, nil
+}
+
+func (s memoryChairs) GetForUpdate(ctx context.Context, id string) (*model.Chair, error) {
+	return s.Get(ctx, id)
+}
+
+func (s memoryChairs) GetByAccessToken(ctx context.Context, accessToken string) (*model.Chair, error) {
+	defer s.lock()()
+	return findOne(slices.Collect(maps.Values(s.m.data.chairs)), func(c model.Chair) bool { return c.AccessToken == accessToken })
+}
+
+func (s memoryChairs) List(ctx context.Context) ([]model.Chair, error) {
+	defer s.lock()()
+	return sortedValues(s.m.data.chairs, compareChairs), nil
+}
+
+func (s memoryChairs) FindAvailable(ctx context.Context, f ChairFilter) (*model.Chair, error) {
+	defer s.lock()()
+	chairs := filter(sortedValues(s.m.data.chairs, compareChairs), func(c model.Chair) bool {
+		if !c.IsActive {
+			return false
+		}
+		if regionID, ok := s.m.data.chairRegions[c.ID]; f.RegionID != "" && ok && regionID != f.RegionID {
+			return false
+		}
+		if s.m.data.chairModels[c.Model].Speed < f.MinSpeed {
+			return false
+		}
+		return len(f.Models) == 0 || slices.Contains(f.Models, c.Model)
+	})
+	if len(chairs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &chairs[rand.IntN(len(chairs))], nil
+}
+
+func (s memoryChairs) ListByOwner(ctx context.Context, ownerID string) ([]model.Chair, error) {
+	defer s.lock()()
+	return filter(sortedValues(s.m.data.chairs, compareChairs), func(c model.Chair) bool { return c.OwnerID == ownerID }), nil
+}
+
+func (s memoryChairs) ListWithTotalDistanceByOwner(ctx context.Context, ownerID string) ([]model.ChairWithTotalDistance, error) {
+	defer s.lock()()
+	result := []model.ChairWithTotalDistance{}
+	for _, chair := range filter(sortedValues(s.m.data.chairs, compareChairs), func(c model.Chair) bool { return c.OwnerID == ownerID }) {
+		detail := model.ChairWithTotalDistance{
+			ID:          chair.ID,
+			OwnerID:     chair.OwnerID,
+			Name:        chair.Name,
+			AccessToken: chair.AccessToken,
+			Model:       chair.Model,
+			IsActive:    chair.IsActive,
+			CreatedAt:   chair.CreatedAt,
+			UpdatedAt:   chair.UpdatedAt,
+		}
+		detail.TotalDistance = s.m.data.locationSummaries[chair.ID]
+		locations := filter(s.m.data.chairLocations, func(l model.ChairLocation) bool { return l.ChairID == chair.ID })
+		for i, location := range locations {
+			if i > 0 {
+				prev := locations[i-1]
+				detail.TotalDistance += abs(location.Latitude-prev.Latitude) + abs(location.Longitude-prev.Longitude)
+			}
+			detail.TotalDistanceUpdatedAt = sql.NullTime{Time: location.CreatedAt, Valid: true}
+		}
+		result = append(result, detail)
+	}
+	return result, nil
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func (s memoryChairs) GetModel(ctx context.Context, name string) (*model.ChairModel, error) {
+	defer s.lock()()
+	chairModel, ok := s.m.data.chairModels[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &chairModel, nil
+}
+
+func (s memoryChairs) GetLatestLocation(ctx context.Context, chairID string) (*model.ChairLocation, error) {
+	defer s.lock()()
+	locations := filter(s.m.data.chairLocations, func(l model.ChairLocation) bool { return l.ChairID == chairID })
+	if len(locations) == 0 {
+		return nil, ErrNotFound
+	}
+	return &locations[len(locations)-1], nil
+}
+
+func (s memoryChairs) Search(ctx context.Context, query, ownerID string, limit int) ([]model.Chair, error) {
+	defer s.lock()()
+	chairs := sortedValues(s.m.data.chairs, func(a, b model.Chair) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return newest(filter(chairs, func(c model.Chair) bool {
+		return matchesSearch(query, c.ID, c.Name) && (ownerID == "" || c.OwnerID == ownerID)
+	}), limit), nil
+}
+
+func (s memoryChairs) SetActive(ctx context.Context, id string, isActive bool) (bool, error) {
+	defer s.lock()()
+	chair, ok := s.m.data.chairs[id]
+	if !ok || chair.IsActive == isActive {
+		return false, nil
+	}
+	chair.IsActive = isActive
+	chair.UpdatedAt = s.m.now()
+	s.m.data.chairs[id] = chair
+	return true, nil
+}
+
+func (s memoryChairs) SetRegion(ctx context.Context, chairID, regionID string) error {
+	defer s.lock()()
+	if _, ok := s.m.data.chairRegions[chairID]; !ok {
+		s.m.data.chairRegions[chairID] = regionID
+	}
+	return nil
+}
+
+func (s memoryChairs) CreateLocation(ctx context.Context, location *model.ChairLocation) error {
+	defer s.lock()()
+	if slices.ContainsFunc(s.m.data.chairLocations, func(l model.ChairLocation) bool { return l.ID == location.ID }) {
+		return ErrDuplicate
+	}
+	created := *location
+	created.CreatedAt = s.m.now()
+	s.m.data.chairLocations = append(s.m.data.chairLocations, created)
+	return nil
+}
+
+func (s memoryChairs) GetLocation(ctx context.Context, id string) (*model.ChairLocation, error) {
+	defer s.lock()()
+	return findOne(s.m.data.chairLocations, func(l model.ChairLocation) bool { return l.ID == id })
+}
+
+// 位置情報は記録した順に追加されるので、並べ替えなくても古い順になっている
+func (s memoryChairs) ListLocations(ctx context.Context, chairID string, since, until time.Time) ([]model.ChairLocation, error) {
+	defer s.lock()()
+	return filter(s.m.data.chairLocations, func(l model.ChairLocation) bool {
+		return l.ChairID == chairID && !l.CreatedAt.Before(since) && !l.CreatedAt.After(until)
+	}), nil
+}
+
+func (s memoryChairs) ListIDsWithLocationsBefore(ctx context.Context, before time.Time) ([]string, error) {
+	defer s.lock()()
+	chairIDs := []string{}
+	for _, location := range s.m.data.chairLocations {
+		if location.CreatedAt.Before(before) && !slices.Contains(chairIDs, location.ChairID) {
+			chairIDs = append(chairIDs, location.ChairID)
+		}
+	}
+	return chairIDs, nil
+}
+
+func (s memoryChairs) ListLocationsBeforeForUpdate(ctx context.Context, chairID string, before time.Time) ([]model.ChairLocation, error) {
+	defer s.lock()()
+	return filter(s.m.data.chairLocations, func(l model.ChairLocation) bool { return l.ChairID == chairID && l.CreatedAt.Before(before) }), nil
+}
+
+func (s memoryChairs) DeleteLocationsBefore(ctx context.Context, chairID string, before time.Time, keepID string) error {
+	defer s.lock()()
+	s.m.data.chairLocations = filter(s.m.data.chairLocations, func(l model.ChairLocation) bool {
+		return l.ChairID != chairID || !l.CreatedAt.Before(before) || l.ID == keepID
+	})
+	return nil
+}
+
+func (s memoryChairs) AddTotalDistance(ctx context.Context, chairID string, distance int) error {
+	defer s.lock()()
+	s.m.data.locationSummaries[chairID] += distance
+	return nil
+}
+
+func (s memoryChairs) ListIDsWithSchedules(ctx context.Context) ([]string, error) {
+	defer s.lock()()
+	chairIDs := []string{}
+	for _, schedule := range s.m.data.chairSchedules {
+		if !slices.Contains(chairIDs, schedule.ChairID) {
+			chairIDs = append(chairIDs, schedule.ChairID)
+		}
+	}
+	return chairIDs, nil
+}
+
+func (s memoryChairs) ListSchedules(ctx context.Context, chairID string) ([]model.ChairSchedule, error) {
+	defer s.lock()()
+	schedules := filter(s.m.data.chairSchedules, func(schedule model.ChairSchedule) bool { return schedule.ChairID == chairID })
+	slices.SortStableFunc(schedules, func(a, b model.ChairSchedule) int {
+		return cmp.Or(cmp.Compare(a.DayOfWeek, b.DayOfWeek), cmp.Compare(a.StartMinute, b.StartMinute))
+	})
+	return schedules, nil
+}
+
+func (s memoryChairs) ReplaceSchedules(ctx context.Context, chairID string, schedules []model.ChairSchedule) error {
+	defer s.lock()()
+	s.m.data.chairSchedules = filter(s.m.data.chairSchedules, func(schedule model.ChairSchedule) bool { return schedule.ChairID != chairID })
+	for _, schedule := range schedules {
+		created := schedule
+		created.ChairID = chairID
+		created.CreatedAt = s.m.now()
+		s.m.data.chairSchedules = append(s.m.data.chairSchedules, created)
+	}
+	return nil
+}
+
+// 配車受付期間は始めた順に追加されるので、最後に見つかったものが最新
+func (s memoryChairs) GetLatestActivityPeriod(ctx context.Context, chairID string) (*model.ChairActivityPeriod, error) {
+	defer s.lock()()
+	periods := filter(s.m.data.activityPeriods, func(p model.ChairActivityPeriod) bool { return p.ChairID == chairID })
+	if len(periods) == 0 {
+		return nil, ErrNotFound
+	}
+	return &periods[len(periods)-1], nil
+}
+
+func (s memoryChairs) ListActivityPeriods(ctx context.Context, chairID string, since, until time.Time) ([]model.ChairActivityPeriod, error) {
+	defer s.lock()()
+	return filter(s.m.data.activityPeriods, func(p model.ChairActivityPeriod) bool {
+		return p.ChairID == chairID && !p.StartedAt.After(until) && (p.EndedAt == nil || !p.EndedAt.Before(since))
+	}), nil
+}
+
+func (s memoryChairs) StartActivityPeriod(ctx context.Context, id, chairID string) error {
+	defer s.lock()()
+	if slices.ContainsFunc(s.m.data.activityPeriods, func(p model.ChairActivityPeriod) bool { return p.ChairID == chairID && p.EndedAt == nil }) {
+		return nil
+	}
+	s.m.data.activityPeriods = append(s.m.data.activityPeriods, model.ChairActivityPeriod{
+		ID:        id,
+		ChairID:   chairID,
+		StartedAt: s.m.now(),
+	})
+	return nil
+}
+
+func (s memoryChairs) EndActivityPeriod(ctx context.Context, chairID, reason string) error {
+	defer s.lock()()
+	for i, p := range s.m.data.activityPeriods {
+		if p.ChairID == chairID && p.EndedAt == nil {
+			now := s.m.now()
+			s.m.data.activityPeriods[i].EndedAt = &now
+			s.m.data.activityPeriods[i].EndReason = &reason
+		}
+	}
+	return nil
+}
+
+func (s memoryChairs) CreateMovementFlag(ctx context.Context, flag *model.ChairMovementFlag) error {
+	defer s.lock()()
+	created := *flag
+	created.CreatedAt = s.m.now()
+	s.m.data.movementFlags = append(s.m.data.movementFlags, created)
+	return nil
+}
+
+func (s memoryChairs) ListMovementFlags(ctx context.Context, chairID string) ([]model.ChairMovementFlag, error) {
+	defer s.lock()()
+	flags := filter(s.m.data.movementFlags, func(f model.ChairMovementFlag) bool { return f.ChairID == chairID })
+	slices.Reverse(flags)
+	return flags, nil
+}
+
+type memoryRides struct{ memoryQueries }
+
+func (s memoryRides) sorted(compare func(a, b model.Ride) int) []model.Ride {
+	return sortedValues(s.m.data.rides, func(a, b model.Ride) int {
+		return cmp.Or(compare(a, b), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+func byCreatedAt(a, b model.Ride) int { return a.CreatedAt.Compare(b.CreatedAt) }
+func byUpdatedAt(a, b model.Ride) int { return a.UpdatedAt.Compare(b.UpdatedAt) }
+
+func (s memoryRides) Create(ctx context.Context, ride *model.Ride) error {
+	defer s.lock()()
+	if _, ok := s.m.data.rides[ride.ID]; ok {
+		return ErrDuplicate
+	}
+	created := *ride
+	created.CreatedAt = s.m.now()
+	created.UpdatedAt = created.CreatedAt
+	s.m.data.rides[ride.ID] = created
+	return nil
+}
+
+func (s memoryRides) Get(ctx context.Context, id string) (*model.Ride, error) {
+	defer s.lock()()
+	ride, ok := s.m.data.rides[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &ride, nil
+}
+
+func (s memoryRides) GetForUpdate(ctx context.Context, id string) (*model.Ride, error) {
+	return s.Get(ctx, id)
+}
+
+func (s memoryRides) ListByUser(ctx context.Context, userID string) ([]model.Ride, error) {
+	defer s.lock()()
+	return filter(s.sorted(byCreatedAt), func(r model.Ride) bool { return r.UserID == userID }), nil
+}
+
+func (s memoryRides) CountByUser(ctx context.Context, userID string) (int, error) {
+	rides, err := s.ListByUser(ctx, userID)
+	return len(rides), err
+}
+
+func (s memoryRides) GetLatestByUser(ctx context.Context, userID string) (*model.Ride, error) {
+	rides, err := s.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rides) == 0 {
+		return nil, ErrNotFound
+	}
+	return &rides[len(rides)-1], nil
+}
+
+func (s memoryRides) ListByChair(ctx context.Context, chairID string) ([]model.Ride, error) {
+	defer s.lock()()
+	rides := filter(s.sorted(byCreatedAt), func(r model.Ride) bool { return r.ChairID.Valid && r.ChairID.String == chairID })
+	slices.Reverse(rides)
+	return rides, nil
+}
+
+func (s memoryRides) GetLatestByChair(ctx context.Context, chairID string) (*model.Ride, error) {
+	defer s.lock()()
+	rides := filter(s.sorted(byUpdatedAt), func(r model.Ride) bool { return r.ChairID.Valid && r.ChairID.String == chairID })
+	if len(rides) == 0 {
+		return nil, ErrNotFound
+	}
+	return &rides[len(rides)-1], nil
+}
+
+func (s memoryRides) ListCompletedByChair(ctx context.Context, chairID string, since, until time.Time) ([]model.Ride, error) {
+	defer s.lock()()
+	until = until.Add(999 * time.Microsecond)
+	return filter(s.sorted(byCreatedAt), func(r model.Ride) bool {
+		if !r.ChairID.Valid || r.ChairID.String != chairID || r.UpdatedAt.Before(since) || r.UpdatedAt.After(until) {
+			return false
+		}
+		return slices.ContainsFunc(s.m.data.rideStatuses, func(status model.RideStatus) bool {
+			return status.RideID == r.ID && status.Status == "COMPLETED"
+		})
+	}), nil
+}
+
+func (s memoryRides) ListWaiting(ctx context.Context, limit int) ([]model.Ride, error) {
+	defer s.lock()()
+	rides := filter(s.sorted(byCreatedAt), func(r model.Ride) bool {
+		return !r.ChairID.Valid && !slices.ContainsFunc(s.statuses(r.ID), func(status model.RideStatus) bool { return status.Status == "CANCELED" })
+	})
+	return rides[:min(limit, len(rides))], nil
+}
+
+func (s memoryRides) AssignChair(ctx context.Context, rideID, chairID string) (bool, error) {
+	defer s.lock()()
+	ride, ok := s.m.data.rides[rideID]
+	if !ok || ride.ChairID.Valid {
+		return false, nil
+	}
+	ride.ChairID = sql.NullString{String: chairID, Valid: true}
+	ride.UpdatedAt = s.m.now()
+	s.m.data.rides[rideID] = ride
+	return true, nil
+}
+
+// 状態が1つも無いライドは、まだ椅子に割り当てられていないものとして数えない
+func (s memoryRides) HasUnfinishedForChair(ctx context.Context, chairID string) (bool, error) {
+	defer s.lock()()
+	for _, ride := range s.m.data.rides {
+		if !ride.ChairID.Valid || ride.ChairID.String != chairID {
+			continue
+		}
+		statuses := s.statuses(ride.ID)
+		finished := slices.ContainsFunc(statuses, func(status model.RideStatus) bool {
+			return (status.Status == "COMPLETED" || status.Status == "CANCELED") && status.ChairSentAt != nil
+		})
+		if len(statuses) > 0 && !finished {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s memoryRides) CountInProgress(ctx context.Context) (int, error) {
+	defer s.lock()()
+	count := 0
+	for _, ride := range s.m.data.rides {
+		if !ride.ChairID.Valid {
+			continue
+		}
+		finished := slices.ContainsFunc(s.statuses(ride.ID), func(status model.RideStatus) bool {
+			return (status.Status == "COMPLETED" || status.Status == "CANCELED") && status.ChairSentAt != nil
+		})
+		if !finished {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s memoryRides) ListCompletedByChairSince(ctx context.Context, chairID string, since time.Time) ([]model.Ride, error) {
+	defer s.lock()()
+	return filter(s.sorted(byCreatedAt), func(r model.Ride) bool {
+		if !r.ChairID.Valid || r.ChairID.String != chairID {
+			return false
+		}
+		return slices.ContainsFunc(s.m.data.rideStatuses, func(status model.RideStatus) bool {
+			return status.RideID == r.ID && status.Status == "COMPLETED" && !status.CreatedAt.Before(since)
+		})
+	}), nil
+}
+
+func (s memoryRides) ListByChairCreatedBefore(ctx context.Context, chairID string, before time.Time) ([]model.Ride, error) {
+	defer s.lock()()
+	return filter(s.sorted(byCreatedAt), func(r model.Ride) bool {
+		return r.ChairID.Valid && r.ChairID.String == chairID && r.CreatedAt.Before(before)
+	}), nil
+}
+
+func (s memoryRides) UpdateEvaluation(ctx context.Context, id string, evaluation int) error {
+	defer s.lock()()
+	ride, ok := s.m.data.rides[id]
+	if !ok {
+		return ErrNotFound
+	}
+	ride.Evaluation = &evaluation
+	ride.UpdatedAt = s.m.now()
+	s.m.data.rides[id] = ride
+	return nil
+}
+
+func (s memoryRides) Search(ctx context.Context, f RideFilter, limit int) ([]model.Ride, error) {
+	defer s.lock()()
+	rides := sortedValues(s.m.data.rides, func(a, b model.Ride) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return newest(filter(rides, func(r model.Ride) bool {
+		if f.UserID != "" && r.UserID != f.UserID {
+			return false
+		}
+		if f.ChairID != "" && r.ChairID.String != f.ChairID {
+			return false
+		}
+		if f.Status != "" {
+			statuses := s.statuses(r.ID)
+			return len(statuses) > 0 && statuses[len(statuses)-1].Status == f.Status
+		}
+		return true
+	}), limit), nil
+}
+
+func (s memoryRides) CreateStatus(ctx context.Context, status *model.RideStatus) error {
+	defer s.lock()()
+	created := *status
+	created.CreatedAt = s.m.now()
+	s.m.data.rideStatuses = append(s.m.data.rideStatuses, created)
+	return nil
+}
+
+func (s memoryRides) statuses(rideID string) []model.RideStatus {
+	return filter(s.m.data.rideStatuses, func(status model.RideStatus) bool { return status.RideID == rideID })
+}
+
+func (s memoryRides) GetLatestStatus(ctx context.Context, rideID string) (string, error) {
+	defer s.lock()()
+	statuses := s.statuses(rideID)
+	if len(statuses) == 0 {
+		return "", ErrNotFound
+	}
+	return statuses[len(statuses)-1].Status, nil
+}
+
+func (s memoryRides) ListStatuses(ctx context.Context, rideID string) ([]model.RideStatus, error) {
+	defer s.lock()()
+	return s.statuses(rideID), nil
+}
+
+func (s memoryRides) GetUnsentAppStatus(ctx context.Context, rideID string) (*model.RideStatus, error) {
+	defer s.lock()()
+	return findOne(s.statuses(rideID), func(status model.RideStatus) bool { return status.AppSentAt == nil })
+}
+
+func (s memoryRides) GetUnsentChairStatus(ctx context.Context, rideID string) (*model.RideStatus, error) {
+	defer s.lock()()
+	return findOne(s.statuses(rideID), func(status model.RideStatus) bool { return status.ChairSentAt == nil })
+}
+
+func (s memoryRides) markSent(id string, mark func(status *model.RideStatus, now time.Time)) {
+	for i := range s.m.data.rideStatuses {
+		if s.m.data.rideStatuses[i].ID == id {
+			mark(&s.m.data.rideStatuses[i], s.m.now())
+		}
+	}
+}
+
+func (s memoryRides) MarkStatusSentToApp(ctx context.Context, id string) error {
+	defer s.lock()()
+	s.markSent(id, func(status *model.RideStatus, now time.Time) { status.AppSentAt = &now })
+	return nil
+}
+
+func (s memoryRides) MarkStatusSentToChair(ctx context.Context, id string) error {
+	defer s.lock()()
+	s.markSent(id, func(status *model.RideStatus, now time.Time) { status.ChairSentAt = &now })
+	return nil
+}
+
+func (s memoryRides) CreateChairPreference(ctx context.Context, preference *model.RideChairPreference) error {
+	defer s.lock()()
+	if _, ok := s.m.data.preferences[preference.RideID]; ok {
+		return ErrDuplicate
+	}
+	created := *preference
+	created.CreatedAt = s.m.now()
+	s.m.data.preferences[preference.RideID] = created
+	return nil
+}
+
+func (s memoryRides) GetChairPreference(ctx context.Context, rideID string) (*model.RideChairPreference, error) {
+	defer s.lock()()
+	preference, ok := s.m.data.preferences[rideID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &preference, nil
+}
+
+func (s memoryRides) GetRoute(ctx context.Context, rideID string) (*model.RideRoute, error) {
+	defer s.lock()()
+	route, ok := s.m.data.rideRoutes[rideID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &route, nil
+}
+
+func (s memoryRides) SaveRoute(ctx context.Context, route *model.RideRoute) error {
+	defer s.lock()()
+	saved, ok := s.m.data.rideRoutes[route.RideID]
+	if !ok {
+		saved = model.RideRoute{RideID: route.RideID, ChairID: route.ChairID, CreatedAt: s.m.now()}
+	}
+	saved.Polyline = route.Polyline
+	saved.Distance = route.Distance
+	saved.UpdatedAt = s.m.now()
+	s.m.data.rideRoutes[route.RideID] = saved
+	return nil
+}
+
+type memoryCoupons struct{ memoryQueries }
+
+func (s memoryCoupons) Create(ctx context.Context, coupon *model.Coupon) error {
+	defer s.lock()()
+	if slices.ContainsFunc(s.m.data.coupons, func(c model.Coupon) bool { return c.UserID == coupon.UserID && c.Code == coupon.Code }) {
+		return ErrDuplicate
+	}
+	created := *coupon
+	created.CreatedAt = s.m.now()
+	s.m.data.coupons = append(s.m.data.coupons, created)
+	return nil
+}
+
+func (s memoryCoupons) ListByCodeForUpdate(ctx context.Context, code string) ([]model.Coupon, error) {
+	defer s.lock()()
+	return filter(s.m.data.coupons, func(c model.Coupon) bool { return c.Code == code }), nil
+}
+
+func (s memoryCoupons) GetUnused(ctx context.Context, userID, code string) (*model.Coupon, error) {
+	defer s.lock()()
+	return findOne(s.m.data.coupons, func(c model.Coupon) bool { return c.UserID == userID && c.Code == code && c.UsedBy == nil })
+}
+
+func (s memoryCoupons) GetUnusedForUpdate(ctx context.Context, userID, code string) (*model.Coupon, error) {
+	return s.GetUnused(ctx, userID, code)
+}
+
+// クーポンは付与された順に追加されるので、最初に見つかったものが最も古い
+func (s memoryCoupons) GetOldestUnused(ctx context.Context, userID string) (*model.Coupon, error) {
+	defer s.lock()()
+	return findOne(s.m.data.coupons, func(c model.Coupon) bool { return c.UserID == userID && c.UsedBy == nil })
+}
+
+func (s memoryCoupons) GetOldestUnusedForUpdate(ctx context.Context, userID string) (*model.Coupon, error) {
+	return s.GetOldestUnused(ctx, userID)
+}
+
+func (s memoryCoupons) GetUsedBy(ctx context.Context, rideID string) (*model.Coupon, error) {
+	defer s.lock()()
+	return findOne(s.m.data.coupons, func(c model.Coupon) bool { return c.UsedBy != nil && *c.UsedBy == rideID })
+}
+
+func (s memoryCoupons) Use(ctx context.Context, userID, code, rideID string) error {
+	defer s.lock()()
+	for i, c := range s.m.data.coupons {
+		if c.UserID == userID && c.Code == code {
+			s.m.data.coupons[i].UsedBy = &rideID
+		}
+	}
+	return nil
+}
+
+func (s memoryCoupons) ListByUser(ctx context.Context, userID string) ([]model.Coupon, error) {
+	defer s.lock()()
+	return filter(s.m.data.coupons, func(c model.Coupon) bool { return c.UserID == userID }), nil
+}
+
+func (s memoryCoupons) DeleteUnused(ctx context.Context, userID string) error {
+	defer s.lock()()
+	s.m.data.coupons = filter(s.m.data.coupons, func(c model.Coupon) bool { return c.UserID != userID || c.UsedBy != nil })
+	return nil
+}
+
+type memoryPaymentTokens struct{ memoryQueries }
+
+func (s memoryPaymentTokens) Create(ctx context.Context, token *model.PaymentToken) error {
+	defer s.lock()()
+	if _, ok := s.m.data.paymentTokens[token.UserID]; ok {
+		return ErrDuplicate
+	}
+	created := *token
+	created.CreatedAt = s.m.now()
+	s.m.data.paymentTokens[token.UserID] = created
+	return nil
+}
+
+func (s memoryPaymentTokens) Get(ctx context.Context, userID string) (*model.PaymentToken, error) {
+	defer s.lock()()
+	token, ok := s.m.data.paymentTokens[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+func (s memoryPaymentTokens) Delete(ctx context.Context, userID string) error {
+	defer s.lock()()
+	delete(s.m.data.paymentTokens, userID)
+	return nil
+}
+
+type memorySessions struct{ memoryQueries }
+
+func (s memorySessions) Create(ctx context.Context, session *model.Session) error {
+	defer s.lock()()
+	if _, ok := s.m.data.sessions[session.Token]; ok {
+		return ErrDuplicate
+	}
+	created := *session
+	created.CreatedAt = s.m.now()
+	s.m.data.sessions[session.Token] = created
+	return nil
+}
+
+func (s memorySessions) CreateIfNotExists(ctx context.Context, session *model.Session) error {
+	if err := s.Create(ctx, session); err != nil && !errors.Is(err, ErrDuplicate) {
+		return err
+	}
+	return nil
+}
+
+func (s memorySessions) Get(ctx context.Context, token string) (*model.Session, error) {
+	defer s.lock()()
+	session, ok := s.m.data.sessions[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &session, nil
+}
+
+func (s memorySessions) GetActiveForUpdate(ctx context.Context, token, role string) (*model.Session, error) {
+	defer s.lock()()
+	session, ok := s.m.data.sessions[token]
+	if !ok || session.Role != role || session.RevokedAt != nil {
+		return nil, ErrNotFound
+	}
+	return &session, nil
+}
+
+func (s memorySessions) Revoke(ctx context.Context, token string) error {
+	defer s.lock()()
+	session, ok := s.m.data.sessions[token]
+	if !ok || session.RevokedAt != nil {
+		return nil
+	}
+	now := s.m.now()
+	session.RevokedAt = &now
+	s.m.data.sessions[token] = session
+	return nil
+}
+
+func (s memorySessions) ListActive(ctx context.Context, role, principalID string) ([]model.Session, error) {
+	defer s.lock()()
+	return filter(slices.Collect(maps.Values(s.m.data.sessions)), func(session model.Session) bool {
+		return session.Role == role && session.PrincipalID == principalID && session.RevokedAt == nil
+	}), nil
+}
+
+type memoryOwnerAPIKeys struct{ memoryQueries }
+
+func (s memoryOwnerAPIKeys) Create(ctx context.Context, apiKey *model.OwnerAPIKey) error {
+	defer s.lock()()
+	if _, ok := s.m.data.apiKeys[apiKey.ID]; ok {
+		return ErrDuplicate
+	}
+	created := *apiKey
+	created.CreatedAt = s.m.now()
+	s.m.data.apiKeys[apiKey.ID] = created
+	return nil
+}
+
+func (s memoryOwnerAPIKeys) GetByHash(ctx context.Context, keyHash string) (*model.OwnerAPIKey, error) {
+	defer s.lock()()
+	return findOne(slices.Collect(maps.Values(s.m.data.apiKeys)), func(k model.OwnerAPIKey) bool { return k.KeyHash == keyHash })
+}
+
+func (s memoryOwnerAPIKeys) ListByOwner(ctx context.Context, ownerID string) ([]model.OwnerAPIKey, error) {
+	defer s.lock()()
+	apiKeys := sortedValues(s.m.data.apiKeys, func(a, b model.OwnerAPIKey) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return filter(apiKeys, func(k model.OwnerAPIKey) bool { return k.OwnerID == ownerID }), nil
+}
+
+func (s memoryOwnerAPIKeys) Revoke(ctx context.Context, ownerID, id string) (bool, error) {
+	defer s.lock()()
+	apiKey, ok := s.m.data.apiKeys[id]
+	if !ok || apiKey.OwnerID != ownerID || apiKey.RevokedAt != nil {
+		return false, nil
+	}
+	now := s.m.now()
+	apiKey.RevokedAt = &now
+	s.m.data.apiKeys[id] = apiKey
+	return true, nil
+}
+
+type memoryRegions struct{ memoryQueries }
+
+func (s memoryRegions) List(ctx context.Context) ([]model.Region, error) {
+	defer s.lock()()
+	return append([]model.Region{}, s.m.data.regions...), nil
+}
+
+type memorySettings struct{ memoryQueries }
+
+func (s memorySettings) Get(ctx context.Context, name string) (string, error) {
+	defer s.lock()()
+	value, ok := s.m.data.settings[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (s memorySettings) List(ctx context.Context) ([]model.Setting, error) {
+	defer s.lock()()
+	settings := []model.Setting{}
+	for _, name := range slices.Sorted(maps.Keys(s.m.data.settings)) {
+		settings = append(settings, model.Setting{Name: name, Value: s.m.data.settings[name]})
+	}
+	return settings, nil
+}
+
+func (s memorySettings) Set(ctx context.Context, name, value string) error {
+	defer s.lock()()
+	s.m.data.settings[name] = value
+	return nil
+}
+
+type memorySavedPlaces struct{ memoryQueries }
+
+// labelTaken はユーザーが同じ名前の別の場所を保存しているかを返す。ロックを取った状態で呼ぶ
+func (s memorySavedPlaces) labelTaken(place *model.SavedPlace) bool {
+	for _, p := range s.m.data.savedPlaces {
+		if p.UserID == place.UserID && p.Label == place.Label && p.ID != place.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s memorySavedPlaces) Create(ctx context.Context, place *model.SavedPlace) error {
+	defer s.lock()()
+	if _, ok := s.m.data.savedPlaces[place.ID]; ok || s.labelTaken(place) {
+		return ErrDuplicate
+	}
+	created := *place
+	created.CreatedAt = s.m.now()
+	created.UpdatedAt = created.CreatedAt
+	s.m.data.savedPlaces[place.ID] = created
+	return nil
+}
+
+func (s memorySavedPlaces) Get(ctx context.Context, userID, id string) (*model.SavedPlace, error) {
+	defer s.lock()()
+	place, ok := s.m.data.savedPlaces[id]
+	if !ok || place.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return &place, nil
+}
+
+func (s memorySavedPlaces) ListByUser(ctx context.Context, userID string) ([]model.SavedPlace, error) {
+	defer s.lock()()
+	places := sortedValues(s.m.data.savedPlaces, func(a, b model.SavedPlace) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return filter(places, func(place model.SavedPlace) bool { return place.UserID == userID }), nil
+}
+
+func (s memorySavedPlaces) Update(ctx context.Context, place *model.SavedPlace) error {
+	defer s.lock()()
+	current, ok := s.m.data.savedPlaces[place.ID]
+	if !ok || current.UserID != place.UserID {
+		return nil
+	}
+	if s.labelTaken(place) {
+		return ErrDuplicate
+	}
+	current.Label = place.Label
+	current.Latitude = place.Latitude
+	current.Longitude = place.Longitude
+	current.UpdatedAt = s.m.now()
+	s.m.data.savedPlaces[place.ID] = current
+	return nil
+}
+
+func (s memorySavedPlaces) Delete(ctx context.Context, userID, id string) error {
+	defer s.lock()()
+	if place, ok := s.m.data.savedPlaces[id]; ok && place.UserID == userID {
+		delete(s.m.data.savedPlaces, id)
+	}
+	return nil
+}
+
+func (s memorySavedPlaces) DeleteByUser(ctx context.Context, userID string) error {
+	defer s.lock()()
+	maps.DeleteFunc(s.m.data.savedPlaces, func(id string, place model.SavedPlace) bool { return place.UserID == userID })
+	return nil
+}
+
+type memoryWebhooks struct{ memoryQueries }
+
+func (s memoryWebhooks) CreateSubscription(ctx context.Context, subscription *model.WebhookSubscription) error {
+	defer s.lock()()
+	if _, ok := s.m.data.webhooks[subscription.ID]; ok {
+		return ErrDuplicate
+	}
+	created := *subscription
+	created.CreatedAt = s.m.now()
+	s.m.data.webhooks[subscription.ID] = created
+	return nil
+}
+
+func (s memoryWebhooks) GetSubscription(ctx context.Context, id string) (*model.WebhookSubscription, error) {
+	defer s.lock()()
+	subscription, ok := s.m.data.webhooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &subscription, nil
+}
+
+func (s memoryWebhooks) ListSubscriptionsByOwner(ctx context.Context, ownerID string) ([]model.WebhookSubscription, error) {
+	defer s.lock()()
+	subscriptions := sortedValues(s.m.data.webhooks, func(a, b model.WebhookSubscription) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return filter(subscriptions, func(subscription model.WebhookSubscription) bool { return subscription.OwnerID == ownerID }), nil
+}
+
+func (s memoryWebhooks) DeleteSubscription(ctx context.Context, id string) error {
+	defer s.lock()()
+	maps.DeleteFunc(s.m.data.deliveries, func(_ string, delivery model.WebhookDelivery) bool { return delivery.SubscriptionID == id })
+	delete(s.m.data.webhooks, id)
+	return nil
+}
+
+func (s memoryWebhooks) CreateDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	defer s.lock()()
+	if _, ok := s.m.data.deliveries[delivery.ID]; ok {
+		return ErrDuplicate
+	}
+	created := *delivery
+	created.Status = "PENDING"
+	created.Attempts = 0
+	created.CreatedAt = s.m.now()
+	created.UpdatedAt = created.CreatedAt
+	created.NextAttemptAt = &created.CreatedAt
+	s.m.data.deliveries[delivery.ID] = created
+	return nil
+}
+
+// due は配送が未配送で、送信日時がnow以前かを返す
+func (s memoryWebhooks) due(delivery model.WebhookDelivery, now time.Time) bool {
+	return delivery.Status == "PENDING" && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now)
+}
+
+func (s memoryWebhooks) ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	defer s.lock()()
+	due := filter(slices.Collect(maps.Values(s.m.data.deliveries)), func(delivery model.WebhookDelivery) bool { return s.due(delivery, now) })
+	slices.SortStableFunc(due, func(a, b model.WebhookDelivery) int { return a.NextAttemptAt.Compare(*b.NextAttemptAt) })
+	return due[:min(limit, len(due))], nil
+}
+
+func (s memoryWebhooks) ClaimDelivery(ctx context.Context, id string, now, leaseUntil time.Time) (bool, error) {
+	defer s.lock()()
+	delivery, ok := s.m.data.deliveries[id]
+	if !ok || !s.due(delivery, now) {
+		return false, nil
+	}
+	delivery.NextAttemptAt = &leaseUntil
+	delivery.UpdatedAt = s.m.now()
+	s.m.data.deliveries[id] = delivery
+	return true, nil
+}
+
+func (s memoryWebhooks) RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery) error {
+	defer s.lock()()
+	current, ok := s.m.data.deliveries[delivery.ID]
+	if !ok {
+		return nil
+	}
+	current.Status = delivery.Status
+	current.Attempts = delivery.Attempts
+	current.NextAttemptAt = delivery.NextAttemptAt
+	current.LastStatusCode = delivery.LastStatusCode
+	current.LastError = delivery.LastError
+	current.UpdatedAt = s.m.now()
+	s.m.data.deliveries[delivery.ID] = current
+	return nil
+}
+
+func (s memoryWebhooks) ListDeliveriesBySubscription(ctx context.Context, subscriptionID string, limit int) ([]model.WebhookDelivery, error) {
+	defer s.lock()()
+	deliveries := sortedValues(s.m.data.deliveries, func(a, b model.WebhookDelivery) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return newest(filter(deliveries, func(delivery model.WebhookDelivery) bool { return delivery.SubscriptionID == subscriptionID }), limit), nil
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+func TestMemoryRollback(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	if err := m.Users().Create(ctx, &model.User{ID: "user1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := m.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Users().Create(ctx, &model.User{ID: "user2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Coupons().Create(ctx, &model.Coupon{UserID: "user1", Code: "CP_NEW2024", Discount: 3000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Users().Get(ctx, "user2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("user2 remains after rollback: %v", err)
+	}
+	if _, err := m.Coupons().GetUnused(ctx, "user1", "CP_NEW2024"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("coupon remains after rollback: %v", err)
+	}
+	if _, err := m.Users().Get(ctx, "user1"); err != nil {
+		t.Errorf("user1 was lost by rollback: %v", err)
+	}
+	if err := tx.Commit(); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("Commit after Rollback = %v, want ErrTxDone", err)
+	}
+}
+
+func TestMemoryCommit(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	tx, err := m.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Users().Create(ctx, &model.User{ID: "user1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Commit後のRollbackは何もしない
+	tx.Rollback()
+
+	if _, err := m.Users().Get(ctx, "user1"); err != nil {
+		t.Errorf("user1 was not committed: %v", err)
+	}
+	if err := m.Users().Create(ctx, &model.User{ID: "user1"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("duplicate Create = %v, want ErrDuplicate", err)
+	}
+}
+
+func TestMemoryCountInProgress(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	for _, id := range []string{"stale", "assigned", "completed"} {
+		if err := m.Rides().Create(ctx, &model.Ride{ID: id, UserID: "user1"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Rides().CreateStatus(ctx, &model.RideStatus{ID: id + "-MATCHING", RideID: id, Status: "MATCHING"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"assigned", "completed"} {
+		if _, err := m.Rides().AssignChair(ctx, id, "chair-"+id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Rides().CreateStatus(ctx, &model.RideStatus{ID: "completed-COMPLETED", RideID: "completed", Status: "COMPLETED"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 完了を椅子に通知するまでは、椅子はまだライドを終えていない
+	if count, err := m.Rides().CountInProgress(ctx); err != nil || count != 2 {
+		t.Errorf("CountInProgress = %d, %v, want 2", count, err)
+	}
+	if err := m.Rides().MarkStatusSentToChair(ctx, "completed-COMPLETED"); err != nil {
+		t.Fatal(err)
+	}
+	// 椅子が割り当てられないまま放置されたライドは数えない
+	if count, err := m.Rides().CountInProgress(ctx); err != nil || count != 1 {
+		t.Errorf("CountInProgress = %d, %v, want 1", count, err)
+	}
+}
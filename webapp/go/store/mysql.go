@@ -0,0 +1,856 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+// ER_DUP_ENTRY
+const mysqlErrDuplicateEntry = 1062
+
+// MySQL はStoreのMySQLによる実装
+type MySQL struct {
+	mysqlQueries
+	db *sqlx.DB
+}
+
+var _ Store = (*MySQL)(nil)
+
+func NewMySQL(db *sqlx.DB) *MySQL {
+	return &MySQL{mysqlQueries: mysqlQueries{q: db}, db: db}
+}
+
+func (s *MySQL) Begin(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlTx{mysqlQueries: mysqlQueries{q: tx}, tx: tx}, nil
+}
+
+func (s *MySQL) BeginRead(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlTx{mysqlQueries: mysqlQueries{q: tx}, tx: tx}, nil
+}
+
+type mysqlTx struct {
+	mysqlQueries
+	tx *sqlx.Tx
+}
+
+func (t *mysqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *mysqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// mysqlQueries は *sqlx.DB と *sqlx.Tx のどちらに対してもクエリを実行する
+type mysqlQueries struct {
+	q sqlx.ExtContext
+}
+
+func (m mysqlQueries) Users() UserStore                 { return mysqlUsers{m} }
+func (m mysqlQueries) Owners() OwnerStore               { return mysqlOwners{m} }
+func (m mysqlQueries) Chairs() ChairStore               { return mysqlChairs{m} }
+func (m mysqlQueries) Rides() RideStore                 { return mysqlRides{m} }
+func (m mysqlQueries) Coupons() CouponStore             { return mysqlCoupons{m} }
+func (m mysqlQueries) PaymentTokens() PaymentTokenStore { return mysqlPaymentTokens{m} }
+func (m mysqlQueries) Sessions() SessionStore           { return mysqlSessions{m} }
+func (m mysqlQueries) OwnerAPIKeys() OwnerAPIKeyStore   { return mysqlOwnerAPIKeys{m} }
+func (m mysqlQueries) Settings() SettingStore           { return mysqlSettings{m} }
+func (m mysqlQueries) SavedPlaces() SavedPlaceStore     { return mysqlSavedPlaces{m} }
+func (m mysqlQueries) Webhooks() WebhookStore           { return mysqlWebhooks{m} }
+func (m mysqlQueries) Regions() RegionStore             { return mysqlRegions{m} }
+
+func (m mysqlQueries) Now(ctx context.Context) (time.Time, error) {
+	now := time.Time{}
+	if err := m.get(ctx, &now, "SELECT CURRENT_TIMESTAMP(6)"); err != nil {
+		return time.Time{}, err
+	}
+	return now, nil
+}
+
+// get は結果が無い場合に ErrNotFound を返す
+func (m mysqlQueries) get(ctx context.Context, dest any, query string, args ...any) error {
+	if err := sqlx.GetContext(ctx, m.q, dest, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (m mysqlQueries) selectAll(ctx context.Context, dest any, query string, args ...any) error {
+	return sqlx.SelectContext(ctx, m.q, dest, query, args...)
+}
+
+// 一意制約に違反した場合は ErrDuplicate を返す
+func (m mysqlQueries) exec(ctx context.Context, query string, args ...any) error {
+	if _, err := m.q.ExecContext(ctx, query, args...); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return fmt.Errorf("%w: %w", ErrDuplicate, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// likePrefix はLIKEで前方一致させるパターンを返す
+func likePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
+
+func getOne[T any](ctx context.Context, m mysqlQueries, query string, args ...any) (*T, error) {
+	v := new(T)
+	if err := m.get(ctx, v, query, args...); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func selectAll[T any](ctx context.Context, m mysqlQueries, query string, args ...any) ([]T, error) {
+	v := []T{}
+	if err := m.selectAll(ctx, &v, query, args...); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+type mysqlUsers struct{ mysqlQueries }
+
+func (s mysqlUsers) Create(ctx context.Context, user *model.User) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO users (id, username, firstname, lastname, date_of_birth, access_token, invitation_code) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		user.ID, user.Username, user.Firstname, user.Lastname, user.DateOfBirth, user.AccessToken, user.InvitationCode,
+	)
+}
+
+func (s mysqlUsers) Update(ctx context.Context, user *model.User) error {
+	return s.exec(
+		ctx,
+		"UPDATE users SET username = ?, firstname = ?, lastname = ?, date_of_birth = ?, access_token = ?, invitation_code = ? WHERE id = ?",
+		user.Username, user.Firstname, user.Lastname, user.DateOfBirth, user.AccessToken, user.InvitationCode, user.ID,
+	)
+}
+
+func (s mysqlUsers) Get(ctx context.Context, id string) (*model.User, error) {
+	return getOne[model.User](ctx, s.mysqlQueries, "SELECT * FROM users WHERE id = ?", id)
+}
+
+func (s mysqlUsers) GetByInvitationCode(ctx context.Context, invitationCode string) (*model.User, error) {
+	return getOne[model.User](ctx, s.mysqlQueries, "SELECT * FROM users WHERE invitation_code = ?", invitationCode)
+}
+
+func (s mysqlUsers) GetByAccessToken(ctx context.Context, accessToken string) (*model.User, error) {
+	return getOne[model.User](ctx, s.mysqlQueries, "SELECT * FROM users WHERE access_token = ?", accessToken)
+}
+
+func (s mysqlUsers) Search(ctx context.Context, query string, limit int) ([]model.User, error) {
+	return selectAll[model.User](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM users WHERE ? = '' OR id = ? OR username LIKE ? ORDER BY created_at DESC, id LIMIT ?",
+		query, query, likePrefix(query), limit,
+	)
+}
+
+type mysqlOwners struct{ mysqlQueries }
+
+func (s mysqlOwners) Create(ctx context.Context, owner *model.Owner) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO owners (id, name, access_token, chair_register_token) VALUES (?, ?, ?, ?)",
+		owner.ID, owner.Name, owner.AccessToken, owner.ChairRegisterToken,
+	)
+}
+
+func (s mysqlOwners) Get(ctx context.Context, id string) (*model.Owner, error) {
+	return getOne[model.Owner](ctx, s.mysqlQueries, "SELECT * FROM owners WHERE id = ?", id)
+}
+
+func (s mysqlOwners) GetByChairRegisterToken(ctx context.Context, token string) (*model.Owner, error) {
+	return getOne[model.Owner](ctx, s.mysqlQueries, "SELECT * FROM owners WHERE chair_register_token = ?", token)
+}
+
+func (s mysqlOwners) GetByAccessToken(ctx context.Context, accessToken string) (*model.Owner, error) {
+	return getOne[model.Owner](ctx, s.mysqlQueries, "SELECT * FROM owners WHERE access_token = ?", accessToken)
+}
+
+func (s mysqlOwners) Search(ctx context.Context, query string, limit int) ([]model.Owner, error) {
+	return selectAll[model.Owner](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM owners WHERE ? = '' OR id = ? OR name LIKE ? ORDER BY created_at DESC, id LIMIT ?",
+		query, query, likePrefix(query), limit,
+	)
+}
+
+type mysqlChairs struct{ mysqlQueries }
+
+func (s mysqlChairs) Create(ctx context.Context, chair *model.Chair) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO chairs (id, owner_id, name, model, is_active, access_token) VALUES (?, ?, ?, ?, ?, ?)",
+		chair.ID, chair.OwnerID, chair.Name, chair.Model, chair.IsActive, chair.AccessToken,
+	)
+}
+
+func (s mysqlChairs) Get(ctx context.Context, id string) (*model.Chair, error) {
+	return getOne[model.Chair](ctx, s.mysqlQueries, "SELECT * FROM chairs WHERE id = ?", id)
+}
+
+func (s mysqlChairs) GetForUpdate(ctx context.Context, id string) (*model.Chair, error) {
+	return getOne[model.Chair](ctx, s.mysqlQueries, "SELECT * FROM chairs WHERE id = ? FOR UPDATE", id)
+}
+
+func (s mysqlChairs) GetByAccessToken(ctx context.Context, accessToken string) (*model.Chair, error) {
+	return getOne[model.Chair](ctx, s.mysqlQueries, "SELECT * FROM chairs WHERE access_token = ?", accessToken)
+}
+
+func (s mysqlChairs) List(ctx context.Context) ([]model.Chair, error) {
+	return selectAll[model.Chair](ctx, s.mysqlQueries, "SELECT * FROM chairs")
+}
+
+func (s mysqlChairs) FindAvailable(ctx context.Context, filter ChairFilter) (*model.Chair, error) {
+	models := strings.Join(filter.Models, ",")
+	return getOne[model.Chair](
+		ctx, s.mysqlQueries,
+		`SELECT * FROM chairs INNER JOIN (
+	SELECT chairs.id FROM chairs LEFT JOIN chair_models ON chair_models.name = chairs.model
+	WHERE chairs.is_active = TRUE
+	AND (? = '' OR chairs.id NOT IN (SELECT chair_id FROM chair_regions WHERE region_id != ?))
+	AND COALESCE(chair_models.speed, 0) >= ?
+	AND (? = '' OR FIND_IN_SET(chairs.model, ?) > 0)
+	ORDER BY RAND() LIMIT 1
+) AS tmp ON chairs.id = tmp.id LIMIT 1`,
+		filter.RegionID, filter.RegionID, filter.MinSpeed, models, models,
+	)
+}
+
+func (s mysqlChairs) ListByOwner(ctx context.Context, ownerID string) ([]model.Chair, error) {
+	return selectAll[model.Chair](ctx, s.mysqlQueries, "SELECT * FROM chairs WHERE owner_id = ?", ownerID)
+}
+
+func (s mysqlChairs) ListWithTotalDistanceByOwner(ctx context.Context, ownerID string) ([]model.ChairWithTotalDistance, error) {
+	return selectAll[model.ChairWithTotalDistance](ctx, s.mysqlQueries, `SELECT id,
+       owner_id,
+       name,
+       access_token,
+       model,
+       is_active,
+       created_at,
+       chairs.updated_at,
+       IFNULL(distance_table.total_distance, 0) + IFNULL(chair_location_summaries.total_distance, 0) AS total_distance,
+       total_distance_updated_at
+FROM chairs
+       LEFT JOIN chair_location_summaries ON chair_location_summaries.chair_id = chairs.id
+       LEFT JOIN (SELECT chair_id,
+                          SUM(IFNULL(distance, 0)) AS total_distance,
+                          MAX(created_at)          AS total_distance_updated_at
+                   FROM (SELECT chair_id,
+                                created_at,
+                                ABS(latitude - LAG(latitude) OVER (PARTITION BY chair_id ORDER BY created_at)) +
+                                ABS(longitude - LAG(longitude) OVER (PARTITION BY chair_id ORDER BY created_at)) AS distance
+                         FROM chair_locations) tmp
+                   GROUP BY chair_id) distance_table ON distance_table.chair_id = chairs.id
+WHERE owner_id = ?
+`, ownerID)
+}
+
+func (s mysqlChairs) GetModel(ctx context.Context, name string) (*model.ChairModel, error) {
+	return getOne[model.ChairModel](ctx, s.mysqlQueries, "SELECT * FROM chair_models WHERE name = ?", name)
+}
+
+func (s mysqlChairs) GetLatestLocation(ctx context.Context, chairID string) (*model.ChairLocation, error) {
+	return getOne[model.ChairLocation](ctx, s.mysqlQueries, "SELECT * FROM chair_locations WHERE chair_id = ? ORDER BY created_at DESC LIMIT 1", chairID)
+}
+
+func (s mysqlChairs) Search(ctx context.Context, query, ownerID string, limit int) ([]model.Chair, error) {
+	return selectAll[model.Chair](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM chairs WHERE (? = '' OR id = ? OR name LIKE ?) AND (? = '' OR owner_id = ?) ORDER BY created_at DESC, id LIMIT ?",
+		query, query, likePrefix(query), ownerID, ownerID, limit,
+	)
+}
+
+func (s mysqlChairs) SetActive(ctx context.Context, id string, isActive bool) (bool, error) {
+	result, err := s.q.ExecContext(ctx, "UPDATE chairs SET is_active = ? WHERE id = ? AND is_active != ?", isActive, id, isActive)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s mysqlChairs) SetRegion(ctx context.Context, chairID, regionID string) error {
+	return s.exec(ctx, "INSERT IGNORE INTO chair_regions (chair_id, region_id) VALUES (?, ?)", chairID, regionID)
+}
+
+func (s mysqlChairs) CreateLocation(ctx context.Context, location *model.ChairLocation) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO chair_locations (id, chair_id, latitude, longitude) VALUES (?, ?, ?, ?)",
+		location.ID, location.ChairID, location.Latitude, location.Longitude,
+	)
+}
+
+func (s mysqlChairs) GetLocation(ctx context.Context, id string) (*model.ChairLocation, error) {
+	return getOne[model.ChairLocation](ctx, s.mysqlQueries, "SELECT * FROM chair_locations WHERE id = ?", id)
+}
+
+func (s mysqlChairs) ListLocations(ctx context.Context, chairID string, since, until time.Time) ([]model.ChairLocation, error) {
+	return selectAll[model.ChairLocation](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM chair_locations WHERE chair_id = ? AND created_at BETWEEN ? AND ? ORDER BY created_at",
+		chairID, since, until,
+	)
+}
+
+func (s mysqlChairs) ListIDsWithLocationsBefore(ctx context.Context, before time.Time) ([]string, error) {
+	return selectAll[string](ctx, s.mysqlQueries, "SELECT DISTINCT chair_id FROM chair_locations WHERE created_at < ?", before)
+}
+
+func (s mysqlChairs) ListLocationsBeforeForUpdate(ctx context.Context, chairID string, before time.Time) ([]model.ChairLocation, error) {
+	return selectAll[model.ChairLocation](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM chair_locations WHERE chair_id = ? AND created_at < ? ORDER BY created_at FOR UPDATE",
+		chairID, before,
+	)
+}
+
+func (s mysqlChairs) DeleteLocationsBefore(ctx context.Context, chairID string, before time.Time, keepID string) error {
+	return s.exec(ctx, "DELETE FROM chair_locations WHERE chair_id = ? AND created_at < ? AND id != ?", chairID, before, keepID)
+}
+
+func (s mysqlChairs) AddTotalDistance(ctx context.Context, chairID string, distance int) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO chair_location_summaries (chair_id, total_distance) VALUES (?, ?) ON DUPLICATE KEY UPDATE total_distance = total_distance + VALUES(total_distance)",
+		chairID, distance,
+	)
+}
+
+func (s mysqlChairs) ListIDsWithSchedules(ctx context.Context) ([]string, error) {
+	return selectAll[string](ctx, s.mysqlQueries, "SELECT DISTINCT chair_id FROM chair_schedules")
+}
+
+func (s mysqlChairs) ListSchedules(ctx context.Context, chairID string) ([]model.ChairSchedule, error) {
+	return selectAll[model.ChairSchedule](ctx, s.mysqlQueries, "SELECT * FROM chair_schedules WHERE chair_id = ? ORDER BY day_of_week, start_minute", chairID)
+}
+
+func (s mysqlChairs) ReplaceSchedules(ctx context.Context, chairID string, schedules []model.ChairSchedule) error {
+	if err := s.exec(ctx, "DELETE FROM chair_schedules WHERE chair_id = ?", chairID); err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		if err := s.exec(
+			ctx,
+			"INSERT INTO chair_schedules (id, chair_id, day_of_week, start_minute, end_minute) VALUES (?, ?, ?, ?, ?)",
+			schedule.ID, chairID, schedule.DayOfWeek, schedule.StartMinute, schedule.EndMinute,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s mysqlChairs) GetLatestActivityPeriod(ctx context.Context, chairID string) (*model.ChairActivityPeriod, error) {
+	return getOne[model.ChairActivityPeriod](ctx, s.mysqlQueries, "SELECT * FROM chair_activity_periods WHERE chair_id = ? ORDER BY started_at DESC LIMIT 1", chairID)
+}
+
+func (s mysqlChairs) ListActivityPeriods(ctx context.Context, chairID string, since, until time.Time) ([]model.ChairActivityPeriod, error) {
+	return selectAll[model.ChairActivityPeriod](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM chair_activity_periods WHERE chair_id = ? AND started_at <= ? AND (ended_at IS NULL OR ended_at >= ?) ORDER BY started_at",
+		chairID, until, since,
+	)
+}
+
+func (s mysqlChairs) StartActivityPeriod(ctx context.Context, id, chairID string) error {
+	opened := 0
+	if err := s.get(ctx, &opened, "SELECT COUNT(*) FROM chair_activity_periods WHERE chair_id = ? AND ended_at IS NULL", chairID); err != nil {
+		return err
+	}
+	if opened > 0 {
+		return nil
+	}
+	return s.exec(ctx, "INSERT INTO chair_activity_periods (id, chair_id) VALUES (?, ?)", id, chairID)
+}
+
+func (s mysqlChairs) EndActivityPeriod(ctx context.Context, chairID, reason string) error {
+	return s.exec(ctx, "UPDATE chair_activity_periods SET ended_at = CURRENT_TIMESTAMP(6), end_reason = ? WHERE chair_id = ? AND ended_at IS NULL", reason, chairID)
+}
+
+func (s mysqlChairs) CreateMovementFlag(ctx context.Context, flag *model.ChairMovementFlag) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO chair_movement_flags (id, chair_id, ride_id, kind, distance, allowed_distance) VALUES (?, ?, ?, ?, ?, ?)",
+		flag.ID, flag.ChairID, flag.RideID, flag.Kind, flag.Distance, flag.AllowedDistance,
+	)
+}
+
+func (s mysqlChairs) ListMovementFlags(ctx context.Context, chairID string) ([]model.ChairMovementFlag, error) {
+	return selectAll[model.ChairMovementFlag](ctx, s.mysqlQueries, "SELECT * FROM chair_movement_flags WHERE chair_id = ? ORDER BY created_at DESC", chairID)
+}
+
+type mysqlRides struct{ mysqlQueries }
+
+func (s mysqlRides) Create(ctx context.Context, ride *model.Ride) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO rides (id, user_id, pickup_latitude, pickup_longitude, destination_latitude, destination_longitude) VALUES (?, ?, ?, ?, ?, ?)",
+		ride.ID, ride.UserID, ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude,
+	)
+}
+
+func (s mysqlRides) Get(ctx context.Context, id string) (*model.Ride, error) {
+	return getOne[model.Ride](ctx, s.mysqlQueries, "SELECT * FROM rides WHERE id = ?", id)
+}
+
+func (s mysqlRides) GetForUpdate(ctx context.Context, id string) (*model.Ride, error) {
+	return getOne[model.Ride](ctx, s.mysqlQueries, "SELECT * FROM rides WHERE id = ? FOR UPDATE", id)
+}
+
+func (s mysqlRides) ListByUser(ctx context.Context, userID string) ([]model.Ride, error) {
+	return selectAll[model.Ride](ctx, s.mysqlQueries, "SELECT * FROM rides WHERE user_id = ? ORDER BY created_at ASC", userID)
+}
+
+func (s mysqlRides) CountByUser(ctx context.Context, userID string) (int, error) {
+	count := 0
+	if err := s.get(ctx, &count, "SELECT COUNT(*) FROM rides WHERE user_id = ?", userID); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s mysqlRides) GetLatestByUser(ctx context.Context, userID string) (*model.Ride, error) {
+	return getOne[model.Ride](ctx, s.mysqlQueries, "SELECT * FROM rides WHERE user_id = ? ORDER BY created_at DESC LIMIT 1", userID)
+}
+
+func (s mysqlRides) ListByChair(ctx context.Context, chairID string) ([]model.Ride, error) {
+	return selectAll[model.Ride](ctx, s.mysqlQueries, "SELECT * FROM rides WHERE chair_id = ? ORDER BY created_at DESC", chairID)
+}
+
+func (s mysqlRides) GetLatestByChair(ctx context.Context, chairID string) (*model.Ride, error) {
+	return getOne[model.Ride](ctx, s.mysqlQueries, "SELECT * FROM rides WHERE chair_id = ? ORDER BY updated_at DESC LIMIT 1", chairID)
+}
+
+func (s mysqlRides) ListCompletedByChair(ctx context.Context, chairID string, since, until time.Time) ([]model.Ride, error) {
+	return selectAll[model.Ride](
+		ctx, s.mysqlQueries,
+		"SELECT rides.* FROM rides JOIN ride_statuses ON rides.id = ride_statuses.ride_id WHERE chair_id = ? AND status = 'COMPLETED' AND updated_at BETWEEN ? AND ? + INTERVAL 999 MICROSECOND",
+		chairID, since, until,
+	)
+}
+
+func (s mysqlRides) ListWaiting(ctx context.Context, limit int) ([]model.Ride, error) {
+	return selectAll[model.Ride](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM rides WHERE chair_id IS NULL AND NOT EXISTS (SELECT 1 FROM ride_statuses WHERE ride_statuses.ride_id = rides.id AND ride_statuses.status = 'CANCELED') ORDER BY created_at LIMIT ?",
+		limit,
+	)
+}
+
+func (s mysqlRides) AssignChair(ctx context.Context, rideID, chairID string) (bool, error) {
+	result, err := s.q.ExecContext(ctx, "UPDATE rides SET chair_id = ? WHERE id = ? AND chair_id IS NULL", chairID, rideID)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// 椅子に通知する状態は古い順に送られるので、完了かキャンセルを通知済みであれば椅子はそのライドを終えている
+func (s mysqlRides) HasUnfinishedForChair(ctx context.Context, chairID string) (bool, error) {
+	unfinished := false
+	if err := s.get(
+		ctx, &unfinished,
+		"SELECT COUNT(*) > 0 FROM (SELECT COUNT(CASE WHEN status IN ('COMPLETED', 'CANCELED') THEN chair_sent_at END) > 0 AS completed FROM ride_statuses WHERE ride_id IN (SELECT id FROM rides WHERE chair_id = ?) GROUP BY ride_id) is_completed WHERE completed = FALSE",
+		chairID,
+	); err != nil {
+		return false, err
+	}
+	return unfinished, nil
+}
+
+func (s mysqlRides) CountInProgress(ctx context.Context) (int, error) {
+	count := 0
+	if err := s.get(
+		ctx, &count,
+		"SELECT COUNT(*) FROM rides WHERE chair_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM ride_statuses WHERE ride_id = rides.id AND status IN ('COMPLETED', 'CANCELED') AND chair_sent_at IS NOT NULL)",
+	); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s mysqlRides) ListCompletedByChairSince(ctx context.Context, chairID string, since time.Time) ([]model.Ride, error) {
+	return selectAll[model.Ride](
+		ctx, s.mysqlQueries,
+		"SELECT rides.* FROM rides JOIN ride_statuses ON rides.id = ride_statuses.ride_id WHERE chair_id = ? AND status = 'COMPLETED' AND ride_statuses.created_at >= ?",
+		chairID, since,
+	)
+}
+
+func (s mysqlRides) ListByChairCreatedBefore(ctx context.Context, chairID string, before time.Time) ([]model.Ride, error) {
+	return selectAll[model.Ride](ctx, s.mysqlQueries, "SELECT * FROM rides WHERE chair_id = ? AND created_at < ? ORDER BY created_at", chairID, before)
+}
+
+func (s mysqlRides) UpdateEvaluation(ctx context.Context, id string, evaluation int) error {
+	result, err := s.q.ExecContext(ctx, "UPDATE rides SET evaluation = ? WHERE id = ?", evaluation, id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s mysqlRides) Search(ctx context.Context, filter RideFilter, limit int) ([]model.Ride, error) {
+	return selectAll[model.Ride](
+		ctx, s.mysqlQueries,
+		`SELECT * FROM rides
+WHERE (? = '' OR user_id = ?)
+  AND (? = '' OR chair_id = ?)
+  AND (? = '' OR (SELECT status FROM ride_statuses WHERE ride_id = rides.id ORDER BY created_at DESC LIMIT 1) = ?)
+ORDER BY created_at DESC, id
+LIMIT ?`,
+		filter.UserID, filter.UserID, filter.ChairID, filter.ChairID, filter.Status, filter.Status, limit,
+	)
+}
+
+func (s mysqlRides) CreateStatus(ctx context.Context, status *model.RideStatus) error {
+	return s.exec(ctx, "INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)", status.ID, status.RideID, status.Status)
+}
+
+func (s mysqlRides) GetLatestStatus(ctx context.Context, rideID string) (string, error) {
+	status := ""
+	if err := s.get(ctx, &status, "SELECT status FROM ride_statuses WHERE ride_id = ? ORDER BY created_at DESC LIMIT 1", rideID); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+func (s mysqlRides) ListStatuses(ctx context.Context, rideID string) ([]model.RideStatus, error) {
+	return selectAll[model.RideStatus](ctx, s.mysqlQueries, "SELECT * FROM ride_statuses WHERE ride_id = ? ORDER BY created_at", rideID)
+}
+
+func (s mysqlRides) GetUnsentAppStatus(ctx context.Context, rideID string) (*model.RideStatus, error) {
+	return getOne[model.RideStatus](ctx, s.mysqlQueries, "SELECT * FROM ride_statuses WHERE ride_id = ? AND app_sent_at IS NULL ORDER BY created_at ASC LIMIT 1", rideID)
+}
+
+func (s mysqlRides) GetUnsentChairStatus(ctx context.Context, rideID string) (*model.RideStatus, error) {
+	return getOne[model.RideStatus](ctx, s.mysqlQueries, "SELECT * FROM ride_statuses WHERE ride_id = ? AND chair_sent_at IS NULL ORDER BY created_at ASC LIMIT 1", rideID)
+}
+
+func (s mysqlRides) MarkStatusSentToApp(ctx context.Context, id string) error {
+	return s.exec(ctx, "UPDATE ride_statuses SET app_sent_at = CURRENT_TIMESTAMP(6) WHERE id = ?", id)
+}
+
+func (s mysqlRides) MarkStatusSentToChair(ctx context.Context, id string) error {
+	return s.exec(ctx, "UPDATE ride_statuses SET chair_sent_at = CURRENT_TIMESTAMP(6) WHERE id = ?", id)
+}
+
+func (s mysqlRides) CreateChairPreference(ctx context.Context, preference *model.RideChairPreference) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO ride_chair_preferences (ride_id, min_speed, models, min_rating) VALUES (?, ?, ?, ?)",
+		preference.RideID, preference.MinSpeed, preference.Models, preference.MinRating,
+	)
+}
+
+func (s mysqlRides) GetChairPreference(ctx context.Context, rideID string) (*model.RideChairPreference, error) {
+	return getOne[model.RideChairPreference](ctx, s.mysqlQueries, "SELECT * FROM ride_chair_preferences WHERE ride_id = ?", rideID)
+}
+
+func (s mysqlRides) GetRoute(ctx context.Context, rideID string) (*model.RideRoute, error) {
+	return getOne[model.RideRoute](ctx, s.mysqlQueries, "SELECT * FROM ride_routes WHERE ride_id = ?", rideID)
+}
+
+func (s mysqlRides) SaveRoute(ctx context.Context, route *model.RideRoute) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO ride_routes (ride_id, chair_id, polyline, distance) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE polyline = VALUES(polyline), distance = VALUES(distance)",
+		route.RideID, route.ChairID, route.Polyline, route.Distance,
+	)
+}
+
+type mysqlCoupons struct{ mysqlQueries }
+
+func (s mysqlCoupons) Create(ctx context.Context, coupon *model.Coupon) error {
+	return s.exec(ctx, "INSERT INTO coupons (user_id, code, discount) VALUES (?, ?, ?)", coupon.UserID, coupon.Code, coupon.Discount)
+}
+
+func (s mysqlCoupons) ListByCodeForUpdate(ctx context.Context, code string) ([]model.Coupon, error) {
+	return selectAll[model.Coupon](ctx, s.mysqlQueries, "SELECT * FROM coupons WHERE code = ? FOR UPDATE", code)
+}
+
+func (s mysqlCoupons) GetUnused(ctx context.Context, userID, code string) (*model.Coupon, error) {
+	return getOne[model.Coupon](ctx, s.mysqlQueries, "SELECT * FROM coupons WHERE user_id = ? AND code = ? AND used_by IS NULL", userID, code)
+}
+
+func (s mysqlCoupons) GetUnusedForUpdate(ctx context.Context, userID, code string) (*model.Coupon, error) {
+	return getOne[model.Coupon](ctx, s.mysqlQueries, "SELECT * FROM coupons WHERE user_id = ? AND code = ? AND used_by IS NULL FOR UPDATE", userID, code)
+}
+
+func (s mysqlCoupons) GetOldestUnused(ctx context.Context, userID string) (*model.Coupon, error) {
+	return getOne[model.Coupon](ctx, s.mysqlQueries, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1", userID)
+}
+
+func (s mysqlCoupons) GetOldestUnusedForUpdate(ctx context.Context, userID string) (*model.Coupon, error) {
+	return getOne[model.Coupon](ctx, s.mysqlQueries, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1 FOR UPDATE", userID)
+}
+
+func (s mysqlCoupons) GetUsedBy(ctx context.Context, rideID string) (*model.Coupon, error) {
+	return getOne[model.Coupon](ctx, s.mysqlQueries, "SELECT * FROM coupons WHERE used_by = ?", rideID)
+}
+
+func (s mysqlCoupons) Use(ctx context.Context, userID, code, rideID string) error {
+	return s.exec(ctx, "UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = ?", rideID, userID, code)
+}
+
+func (s mysqlCoupons) ListByUser(ctx context.Context, userID string) ([]model.Coupon, error) {
+	return selectAll[model.Coupon](ctx, s.mysqlQueries, "SELECT * FROM coupons WHERE user_id = ? ORDER BY created_at", userID)
+}
+
+func (s mysqlCoupons) DeleteUnused(ctx context.Context, userID string) error {
+	return s.exec(ctx, "DELETE FROM coupons WHERE user_id = ? AND used_by IS NULL", userID)
+}
+
+type mysqlPaymentTokens struct{ mysqlQueries }
+
+func (s mysqlPaymentTokens) Create(ctx context.Context, token *model.PaymentToken) error {
+	return s.exec(ctx, "INSERT INTO payment_tokens (user_id, token) VALUES (?, ?)", token.UserID, token.Token)
+}
+
+func (s mysqlPaymentTokens) Get(ctx context.Context, userID string) (*model.PaymentToken, error) {
+	return getOne[model.PaymentToken](ctx, s.mysqlQueries, "SELECT * FROM payment_tokens WHERE user_id = ?", userID)
+}
+
+func (s mysqlPaymentTokens) Delete(ctx context.Context, userID string) error {
+	return s.exec(ctx, "DELETE FROM payment_tokens WHERE user_id = ?", userID)
+}
+
+type mysqlSessions struct{ mysqlQueries }
+
+func (s mysqlSessions) Create(ctx context.Context, session *model.Session) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO sessions (token, role, principal_id, expires_at) VALUES (?, ?, ?, ?)",
+		session.Token, session.Role, session.PrincipalID, session.ExpiresAt,
+	)
+}
+
+func (s mysqlSessions) CreateIfNotExists(ctx context.Context, session *model.Session) error {
+	return s.exec(
+		ctx,
+		"INSERT IGNORE INTO sessions (token, role, principal_id, expires_at) VALUES (?, ?, ?, ?)",
+		session.Token, session.Role, session.PrincipalID, session.ExpiresAt,
+	)
+}
+
+func (s mysqlSessions) Get(ctx context.Context, token string) (*model.Session, error) {
+	return getOne[model.Session](ctx, s.mysqlQueries, "SELECT * FROM sessions WHERE token = ?", token)
+}
+
+func (s mysqlSessions) GetActiveForUpdate(ctx context.Context, token, role string) (*model.Session, error) {
+	return getOne[model.Session](ctx, s.mysqlQueries, "SELECT * FROM sessions WHERE token = ? AND role = ? AND revoked_at IS NULL FOR UPDATE", token, role)
+}
+
+func (s mysqlSessions) Revoke(ctx context.Context, token string) error {
+	return s.exec(ctx, "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP(6) WHERE token = ? AND revoked_at IS NULL", token)
+}
+
+func (s mysqlSessions) ListActive(ctx context.Context, role, principalID string) ([]model.Session, error) {
+	return selectAll[model.Session](ctx, s.mysqlQueries, "SELECT * FROM sessions WHERE role = ? AND principal_id = ? AND revoked_at IS NULL", role, principalID)
+}
+
+type mysqlOwnerAPIKeys struct{ mysqlQueries }
+
+func (s mysqlOwnerAPIKeys) Create(ctx context.Context, apiKey *model.OwnerAPIKey) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO owner_api_keys (id, owner_id, name, key_hash, scopes) VALUES (?, ?, ?, ?, ?)",
+		apiKey.ID, apiKey.OwnerID, apiKey.Name, apiKey.KeyHash, apiKey.Scopes,
+	)
+}
+
+func (s mysqlOwnerAPIKeys) GetByHash(ctx context.Context, keyHash string) (*model.OwnerAPIKey, error) {
+	return getOne[model.OwnerAPIKey](ctx, s.mysqlQueries, "SELECT * FROM owner_api_keys WHERE key_hash = ?", keyHash)
+}
+
+func (s mysqlOwnerAPIKeys) ListByOwner(ctx context.Context, ownerID string) ([]model.OwnerAPIKey, error) {
+	return selectAll[model.OwnerAPIKey](ctx, s.mysqlQueries, "SELECT * FROM owner_api_keys WHERE owner_id = ? ORDER BY created_at DESC", ownerID)
+}
+
+func (s mysqlOwnerAPIKeys) Revoke(ctx context.Context, ownerID, id string) (bool, error) {
+	result, err := s.q.ExecContext(
+		ctx,
+		"UPDATE owner_api_keys SET revoked_at = CURRENT_TIMESTAMP(6) WHERE id = ? AND owner_id = ? AND revoked_at IS NULL",
+		id, ownerID,
+	)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+type mysqlRegions struct{ mysqlQueries }
+
+func (s mysqlRegions) List(ctx context.Context) ([]model.Region, error) {
+	return selectAll[model.Region](ctx, s.mysqlQueries, "SELECT * FROM regions ORDER BY created_at, id")
+}
+
+type mysqlSettings struct{ mysqlQueries }
+
+func (s mysqlSettings) Get(ctx context.Context, name string) (string, error) {
+	value := ""
+	if err := s.get(ctx, &value, "SELECT value FROM settings WHERE name = ?", name); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func (s mysqlSettings) List(ctx context.Context) ([]model.Setting, error) {
+	return selectAll[model.Setting](ctx, s.mysqlQueries, "SELECT * FROM settings ORDER BY name")
+}
+
+func (s mysqlSettings) Set(ctx context.Context, name, value string) error {
+	return s.exec(ctx, "INSERT INTO settings (name, value) VALUES (?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value)", name, value)
+}
+
+type mysqlSavedPlaces struct{ mysqlQueries }
+
+func (s mysqlSavedPlaces) Create(ctx context.Context, place *model.SavedPlace) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO saved_places (id, user_id, kind, label, latitude, longitude) VALUES (?, ?, ?, ?, ?, ?)",
+		place.ID, place.UserID, place.Kind, place.Label, place.Latitude, place.Longitude,
+	)
+}
+
+func (s mysqlSavedPlaces) Get(ctx context.Context, userID, id string) (*model.SavedPlace, error) {
+	return getOne[model.SavedPlace](ctx, s.mysqlQueries, "SELECT * FROM saved_places WHERE id = ? AND user_id = ?", id, userID)
+}
+
+func (s mysqlSavedPlaces) ListByUser(ctx context.Context, userID string) ([]model.SavedPlace, error) {
+	return selectAll[model.SavedPlace](ctx, s.mysqlQueries, "SELECT * FROM saved_places WHERE user_id = ? ORDER BY created_at", userID)
+}
+
+func (s mysqlSavedPlaces) Update(ctx context.Context, place *model.SavedPlace) error {
+	return s.exec(
+		ctx,
+		"UPDATE saved_places SET label = ?, latitude = ?, longitude = ? WHERE id = ? AND user_id = ?",
+		place.Label, place.Latitude, place.Longitude, place.ID, place.UserID,
+	)
+}
+
+func (s mysqlSavedPlaces) Delete(ctx context.Context, userID, id string) error {
+	return s.exec(ctx, "DELETE FROM saved_places WHERE id = ? AND user_id = ?", id, userID)
+}
+
+func (s mysqlSavedPlaces) DeleteByUser(ctx context.Context, userID string) error {
+	return s.exec(ctx, "DELETE FROM saved_places WHERE user_id = ?", userID)
+}
+
+type mysqlWebhooks struct{ mysqlQueries }
+
+func (s mysqlWebhooks) CreateSubscription(ctx context.Context, subscription *model.WebhookSubscription) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO webhook_subscriptions (id, owner_id, url, secret, event_types) VALUES (?, ?, ?, ?, ?)",
+		subscription.ID, subscription.OwnerID, subscription.URL, subscription.Secret, subscription.EventTypes,
+	)
+}
+
+func (s mysqlWebhooks) GetSubscription(ctx context.Context, id string) (*model.WebhookSubscription, error) {
+	return getOne[model.WebhookSubscription](ctx, s.mysqlQueries, "SELECT * FROM webhook_subscriptions WHERE id = ?", id)
+}
+
+func (s mysqlWebhooks) ListSubscriptionsByOwner(ctx context.Context, ownerID string) ([]model.WebhookSubscription, error) {
+	return selectAll[model.WebhookSubscription](ctx, s.mysqlQueries, "SELECT * FROM webhook_subscriptions WHERE owner_id = ? ORDER BY created_at", ownerID)
+}
+
+func (s mysqlWebhooks) DeleteSubscription(ctx context.Context, id string) error {
+	if err := s.exec(ctx, "DELETE FROM webhook_deliveries WHERE subscription_id = ?", id); err != nil {
+		return err
+	}
+	return s.exec(ctx, "DELETE FROM webhook_subscriptions WHERE id = ?", id)
+}
+
+func (s mysqlWebhooks) CreateDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	return s.exec(
+		ctx,
+		"INSERT INTO webhook_deliveries (id, subscription_id, event_id, event_type, payload, next_attempt_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP(6))",
+		delivery.ID, delivery.SubscriptionID, delivery.EventID, delivery.EventType, delivery.Payload,
+	)
+}
+
+func (s mysqlWebhooks) ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	return selectAll[model.WebhookDelivery](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM webhook_deliveries WHERE status = 'PENDING' AND next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ?",
+		now, limit,
+	)
+}
+
+func (s mysqlWebhooks) ClaimDelivery(ctx context.Context, id string, now, leaseUntil time.Time) (bool, error) {
+	result, err := s.q.ExecContext(
+		ctx,
+		"UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ? AND status = 'PENDING' AND next_attempt_at <= ?",
+		leaseUntil, id, now,
+	)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s mysqlWebhooks) RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery) error {
+	return s.exec(
+		ctx,
+		"UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?, last_error = ? WHERE id = ?",
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastStatusCode, delivery.LastError, delivery.ID,
+	)
+}
+
+func (s mysqlWebhooks) ListDeliveriesBySubscription(ctx context.Context, subscriptionID string, limit int) ([]model.WebhookDelivery, error) {
+	return selectAll[model.WebhookDelivery](
+		ctx, s.mysqlQueries,
+		"SELECT * FROM webhook_deliveries WHERE subscription_id = ? ORDER BY created_at DESC, id DESC LIMIT ?",
+		subscriptionID, limit,
+	)
+}
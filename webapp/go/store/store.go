@@ -0,0 +1,296 @@
+// Package store はハンドラーからデータベースへのアクセスを抽象化する。
+// MySQLによる実装と、ハンドラーのテストで使うインメモリの実装を提供する。
+//
+// アプリケーションのデータの読み書きはすべてこのパッケージを通す。
+// 初期化でのSQLファイルの読み込み、レプリカの遅延の確認、ヘルスチェックのPingはMySQL自体を扱う処理なので対象外とし、*sqlx.DB を直接使う。
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/model"
+)
+
+var (
+	// ErrNotFound は対象のレコードが存在しないことを表す
+	ErrNotFound = errors.New("store: not found")
+	// ErrDuplicate は一意であるべき値が既に登録されていることを表す
+	ErrDuplicate = errors.New("store: duplicate entry")
+)
+
+type Store interface {
+	Queries
+	// Begin はトランザクションを開始する
+	Begin(ctx context.Context) (Tx, error)
+	// BeginRead は読み取り専用のトランザクションを開始する
+	BeginRead(ctx context.Context) (Tx, error)
+}
+
+// Tx はトランザクション内で実行するクエリを提供する。
+// Commitされなかったトランザクションは、Rollbackで変更を破棄する
+type Tx interface {
+	Queries
+	Commit() error
+	Rollback() error
+}
+
+type Queries interface {
+	Users() UserStore
+	Owners() OwnerStore
+	Chairs() ChairStore
+	Rides() RideStore
+	Coupons() CouponStore
+	PaymentTokens() PaymentTokenStore
+	Sessions() SessionStore
+	OwnerAPIKeys() OwnerAPIKeyStore
+	Settings() SettingStore
+	SavedPlaces() SavedPlaceStore
+	Webhooks() WebhookStore
+	Regions() RegionStore
+	// Now はデータベースの現在時刻を返す
+	Now(ctx context.Context) (time.Time, error)
+}
+
+type UserStore interface {
+	Create(ctx context.Context, user *model.User) error
+	Get(ctx context.Context, id string) (*model.User, error)
+	GetByInvitationCode(ctx context.Context, invitationCode string) (*model.User, error)
+	// GetByAccessToken はセッションを導入する前に発行したアクセストークンでユーザーを探す
+	GetByAccessToken(ctx context.Context, accessToken string) (*model.User, error)
+	// Update はユーザー名、氏名、生年月日、アクセストークン、招待コードを更新する。ユーザー名が重複する場合は ErrDuplicate を返す
+	Update(ctx context.Context, user *model.User) error
+	// Search はIDが一致するか、ユーザー名がqueryで始まるユーザーを新しい順にlimit件まで返す。queryが空の場合はすべてのユーザーが対象
+	Search(ctx context.Context, query string, limit int) ([]model.User, error)
+}
+
+type OwnerStore interface {
+	Create(ctx context.Context, owner *model.Owner) error
+	Get(ctx context.Context, id string) (*model.Owner, error)
+	GetByChairRegisterToken(ctx context.Context, token string) (*model.Owner, error)
+	// GetByAccessToken はセッションを導入する前に発行したアクセストークンでオーナーを探す
+	GetByAccessToken(ctx context.Context, accessToken string) (*model.Owner, error)
+	// Search はIDが一致するか、名前がqueryで始まるオーナーを新しい順にlimit件まで返す。queryが空の場合はすべてのオーナーが対象
+	Search(ctx context.Context, query string, limit int) ([]model.Owner, error)
+}
+
+type ChairStore interface {
+	Create(ctx context.Context, chair *model.Chair) error
+	Get(ctx context.Context, id string) (*model.Chair, error)
+	// GetForUpdate は更新する椅子を取得し、トランザクションが終わるまでロックする
+	GetForUpdate(ctx context.Context, id string) (*model.Chair, error)
+	// GetByAccessToken はセッションを導入する前に発行したアクセストークンで椅子を探す
+	GetByAccessToken(ctx context.Context, accessToken string) (*model.Chair, error)
+	List(ctx context.Context) ([]model.Chair, error)
+	// FindAvailable は配車を受け付けている椅子から条件に合うものを無作為に1つ返す。見つからない場合は ErrNotFound を返す
+	FindAvailable(ctx context.Context, filter ChairFilter) (*model.Chair, error)
+	ListByOwner(ctx context.Context, ownerID string) ([]model.Chair, error)
+	// ListWithTotalDistanceByOwner は記録された位置情報から求めた総移動距離とあわせて椅子を返す
+	ListWithTotalDistanceByOwner(ctx context.Context, ownerID string) ([]model.ChairWithTotalDistance, error)
+	GetModel(ctx context.Context, name string) (*model.ChairModel, error)
+	GetLatestLocation(ctx context.Context, chairID string) (*model.ChairLocation, error)
+	// Search はIDが一致するか、名前がqueryで始まる椅子を新しい順にlimit件まで返す。ownerIDを指定した場合はそのオーナーの椅子に絞り込む
+	Search(ctx context.Context, query, ownerID string, limit int) ([]model.Chair, error)
+	// SetActive は配車受付状態を更新し、状態が変わったかを返す
+	SetActive(ctx context.Context, id string, isActive bool) (bool, error)
+	// SetRegion は椅子の拠点の地域を記録する。拠点が決まっている場合は何もしない
+	SetRegion(ctx context.Context, chairID, regionID string) error
+
+	CreateLocation(ctx context.Context, location *model.ChairLocation) error
+	GetLocation(ctx context.Context, id string) (*model.ChairLocation, error)
+	// ListLocations は記録日時がsinceからuntilまでの位置情報を古い順に返す
+	ListLocations(ctx context.Context, chairID string, since, until time.Time) ([]model.ChairLocation, error)
+	// ListIDsWithLocationsBefore はbeforeより前に記録された位置情報がある椅子のIDを返す
+	ListIDsWithLocationsBefore(ctx context.Context, before time.Time) ([]string, error)
+	// ListLocationsBeforeForUpdate はbeforeより前に記録された位置情報を古い順に取得し、トランザクションが終わるまでロックする
+	ListLocationsBeforeForUpdate(ctx context.Context, chairID string, before time.Time) ([]model.ChairLocation, error)
+	// DeleteLocationsBefore はbeforeより前に記録された位置情報のうち、keepID以外を削除する
+	DeleteLocationsBefore(ctx context.Context, chairID string, before time.Time, keepID string) error
+	// AddTotalDistance は削除した位置情報の移動距離を、椅子の総移動距離の集計に加える
+	AddTotalDistance(ctx context.Context, chairID string, distance int) error
+
+	// ListIDsWithSchedules は稼働スケジュールが設定されている椅子のIDを返す
+	ListIDsWithSchedules(ctx context.Context) ([]string, error)
+	// ListSchedules は椅子の稼働スケジュールを曜日と開始時刻の順に返す
+	ListSchedules(ctx context.Context, chairID string) ([]model.ChairSchedule, error)
+	// ReplaceSchedules は椅子の稼働スケジュールをすべて置き換える
+	ReplaceSchedules(ctx context.Context, chairID string, schedules []model.ChairSchedule) error
+
+	// GetLatestActivityPeriod は最後に始まった配車受付期間を返す
+	GetLatestActivityPeriod(ctx context.Context, chairID string) (*model.ChairActivityPeriod, error)
+	// ListActivityPeriods はsinceからuntilまでの間に受付中だった期間を古い順に返す
+	ListActivityPeriods(ctx context.Context, chairID string, since, until time.Time) ([]model.ChairActivityPeriod, error)
+	// StartActivityPeriod は配車受付期間を始める。終わっていない期間がある場合は何もしない
+	StartActivityPeriod(ctx context.Context, id, chairID string) error
+	// EndActivityPeriod は終わっていない配車受付期間を理由とともに終える
+	EndActivityPeriod(ctx context.Context, chairID, reason string) error
+
+	CreateMovementFlag(ctx context.Context, flag *model.ChairMovementFlag) error
+	// ListMovementFlags は椅子の不審な移動の記録を新しい順に返す
+	ListMovementFlags(ctx context.Context, chairID string) ([]model.ChairMovementFlag, error)
+}
+
+type RideStore interface {
+	Create(ctx context.Context, ride *model.Ride) error
+	Get(ctx context.Context, id string) (*model.Ride, error)
+	// GetForUpdate は更新するライドを取得し、トランザクションが終わるまでロックする
+	GetForUpdate(ctx context.Context, id string) (*model.Ride, error)
+	// ListByUser はユーザーのライドを要求日時の古い順に返す
+	ListByUser(ctx context.Context, userID string) ([]model.Ride, error)
+	CountByUser(ctx context.Context, userID string) (int, error)
+	// GetLatestByUser はユーザーが最後に要求したライドを返す
+	GetLatestByUser(ctx context.Context, userID string) (*model.Ride, error)
+	// ListByChair は椅子に割り当てられたライドを要求日時の新しい順に返す
+	ListByChair(ctx context.Context, chairID string) ([]model.Ride, error)
+	// GetLatestByChair は椅子に割り当てられたライドのうち、最後に更新されたものを返す
+	GetLatestByChair(ctx context.Context, chairID string) (*model.Ride, error)
+	// ListCompletedByChair は状態更新日時がsinceからuntilまでの完了したライドを返す
+	ListCompletedByChair(ctx context.Context, chairID string, since, until time.Time) ([]model.Ride, error)
+	// ListWaiting は椅子が割り当てられていない、キャンセルされていないライドを要求日時の古い順にlimit件まで返す
+	ListWaiting(ctx context.Context, limit int) ([]model.Ride, error)
+	// AssignChair はライドに椅子を割り当てる。既に割り当て済みの場合はfalseを返す
+	AssignChair(ctx context.Context, rideID, chairID string) (bool, error)
+	// HasUnfinishedForChair は椅子に割り当てられたライドのうち、完了かキャンセルをまだ椅子に通知していないものがあるかを返す
+	HasUnfinishedForChair(ctx context.Context, chairID string) (bool, error)
+	// CountInProgress は椅子に割り当てられたライドのうち、完了かキャンセルをまだ椅子に通知していないものの数を返す。
+	// 椅子が割り当てられていないライドは、放置されたものも含めて数えない
+	CountInProgress(ctx context.Context) (int, error)
+	// ListCompletedByChairSince はsince以降に完了した椅子のライドを返す
+	ListCompletedByChairSince(ctx context.Context, chairID string, since time.Time) ([]model.Ride, error)
+	// ListByChairCreatedBefore は椅子に割り当てられたライドのうち、beforeより前に要求されたものを古い順に返す
+	ListByChairCreatedBefore(ctx context.Context, chairID string, before time.Time) ([]model.Ride, error)
+	UpdateEvaluation(ctx context.Context, id string, evaluation int) error
+	// Search は条件に一致するライドを要求日時の新しい順にlimit件まで返す
+	Search(ctx context.Context, filter RideFilter, limit int) ([]model.Ride, error)
+
+	CreateStatus(ctx context.Context, status *model.RideStatus) error
+	GetLatestStatus(ctx context.Context, rideID string) (string, error)
+	// ListStatuses はライドの状態の変更履歴を古い順に返す
+	ListStatuses(ctx context.Context, rideID string) ([]model.RideStatus, error)
+	// GetUnsentAppStatus はユーザーにまだ通知していない最も古い状態を返す
+	GetUnsentAppStatus(ctx context.Context, rideID string) (*model.RideStatus, error)
+	// GetUnsentChairStatus は椅子にまだ通知していない最も古い状態を返す
+	GetUnsentChairStatus(ctx context.Context, rideID string) (*model.RideStatus, error)
+	MarkStatusSentToApp(ctx context.Context, id string) error
+	MarkStatusSentToChair(ctx context.Context, id string) error
+
+	CreateChairPreference(ctx context.Context, preference *model.RideChairPreference) error
+	// GetChairPreference はライドで希望する椅子の条件を返す。条件が無い場合は ErrNotFound を返す
+	GetChairPreference(ctx context.Context, rideID string) (*model.RideChairPreference, error)
+
+	// GetRoute は位置情報を集計したときに残したライドの移動経路を返す
+	GetRoute(ctx context.Context, rideID string) (*model.RideRoute, error)
+	// SaveRoute はライドの移動経路を保存する。保存済みの場合は経路と距離を置き換える
+	SaveRoute(ctx context.Context, route *model.RideRoute) error
+}
+
+// ChairFilter は配車する椅子の条件。空のフィールドは条件に含めない
+type ChairFilter struct {
+	// 指定した地域を拠点とする椅子か、拠点が未定の椅子
+	RegionID string
+	// 椅子のモデルの最低速度
+	MinSpeed int
+	Models   []string
+}
+
+// RideFilter はライドを検索する条件。空のフィールドは条件に含めない
+type RideFilter struct {
+	UserID  string
+	ChairID string
+	// 最新の状態
+	Status string
+}
+
+type CouponStore interface {
+	Create(ctx context.Context, coupon *model.Coupon) error
+	// ListByCodeForUpdate は同じコードのクーポンをすべて取得し、トランザクションが終わるまでロックする
+	ListByCodeForUpdate(ctx context.Context, code string) ([]model.Coupon, error)
+	GetUnused(ctx context.Context, userID, code string) (*model.Coupon, error)
+	GetUnusedForUpdate(ctx context.Context, userID, code string) (*model.Coupon, error)
+	// GetOldestUnused は未使用のクーポンのうち、最も古く付与されたものを返す
+	GetOldestUnused(ctx context.Context, userID string) (*model.Coupon, error)
+	GetOldestUnusedForUpdate(ctx context.Context, userID string) (*model.Coupon, error)
+	GetUsedBy(ctx context.Context, rideID string) (*model.Coupon, error)
+	Use(ctx context.Context, userID, code, rideID string) error
+	// ListByUser はユーザーのクーポンを付与された古い順に返す
+	ListByUser(ctx context.Context, userID string) ([]model.Coupon, error)
+	// DeleteUnused はユーザーの未使用のクーポンを削除する。使用済みのクーポンはライドの運賃の計算に使うので残す
+	DeleteUnused(ctx context.Context, userID string) error
+}
+
+type PaymentTokenStore interface {
+	Create(ctx context.Context, token *model.PaymentToken) error
+	Get(ctx context.Context, userID string) (*model.PaymentToken, error)
+	Delete(ctx context.Context, userID string) error
+}
+
+type SessionStore interface {
+	Create(ctx context.Context, session *model.Session) error
+	// CreateIfNotExists はセッションを登録する。同じトークンのセッションが登録済みの場合は何もしない
+	CreateIfNotExists(ctx context.Context, session *model.Session) error
+	// Get は失効したものも含めてセッションを返す
+	Get(ctx context.Context, token string) (*model.Session, error)
+	// GetActiveForUpdate は失効していないセッションを取得し、トランザクションが終わるまでロックする
+	GetActiveForUpdate(ctx context.Context, token, role string) (*model.Session, error)
+	// Revoke はセッションを失効させる。失効済みの場合は何もしない
+	Revoke(ctx context.Context, token string) error
+	// ListActive は失効していないセッションをすべて返す
+	ListActive(ctx context.Context, role, principalID string) ([]model.Session, error)
+}
+
+type OwnerAPIKeyStore interface {
+	Create(ctx context.Context, apiKey *model.OwnerAPIKey) error
+	GetByHash(ctx context.Context, keyHash string) (*model.OwnerAPIKey, error)
+	// ListByOwner はオーナーのAPIキーを失効したものも含めて新しい順に返す
+	ListByOwner(ctx context.Context, ownerID string) ([]model.OwnerAPIKey, error)
+	// Revoke はオーナーのAPIキーを失効させる。失効させるキーが無かった場合はfalseを返す
+	Revoke(ctx context.Context, ownerID, id string) (bool, error)
+}
+
+type RegionStore interface {
+	// List はすべての地域を登録日時の古い順に返す
+	List(ctx context.Context) ([]model.Region, error)
+}
+
+type SettingStore interface {
+	Get(ctx context.Context, name string) (string, error)
+	// List はすべての設定を名前順に返す
+	List(ctx context.Context) ([]model.Setting, error)
+	// Set は設定の値を保存する。設定が存在しない場合は追加する
+	Set(ctx context.Context, name, value string) error
+}
+
+type SavedPlaceStore interface {
+	// Create は場所を保存する。ユーザーが同じ名前の場所を保存済みの場合は ErrDuplicate を返す
+	Create(ctx context.Context, place *model.SavedPlace) error
+	// Get はユーザーが保存した場所を返す。他のユーザーの場所は ErrNotFound になる
+	Get(ctx context.Context, userID, id string) (*model.SavedPlace, error)
+	// ListByUser はユーザーが保存した場所を登録日時の古い順に返す
+	ListByUser(ctx context.Context, userID string) ([]model.SavedPlace, error)
+	// Update は場所の名前と座標を更新する。同じ名前の場所がある場合は ErrDuplicate を返す
+	Update(ctx context.Context, place *model.SavedPlace) error
+	Delete(ctx context.Context, userID, id string) error
+	// DeleteByUser はユーザーが保存した場所をすべて削除する
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+type WebhookStore interface {
+	CreateSubscription(ctx context.Context, subscription *model.WebhookSubscription) error
+	GetSubscription(ctx context.Context, id string) (*model.WebhookSubscription, error)
+	// ListSubscriptionsByOwner はオーナーのWebhookを登録日時の古い順に返す
+	ListSubscriptionsByOwner(ctx context.Context, ownerID string) ([]model.WebhookSubscription, error)
+	// DeleteSubscription はWebhookと配送履歴を削除する
+	DeleteSubscription(ctx context.Context, id string) error
+	// CreateDelivery は配送を登録し、すぐに送信する対象にする
+	CreateDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+	// ListDueDeliveries は送信日時がnow以前の未配送の配送を、送信日時の古い順にlimit件まで返す
+	ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error)
+	// ClaimDelivery は送信日時がnow以前の未配送の配送の送信日時をleaseUntilに延ばし、このインスタンスで送信する。
+	// 他のインスタンスが先に送信を始めていた場合はfalseを返す
+	ClaimDelivery(ctx context.Context, id string, now, leaseUntil time.Time) (bool, error)
+	// RecordAttempt は送信した結果で配送状態、送信回数、次の送信日時、最後の結果を更新する
+	RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery) error
+	// ListDeliveriesBySubscription はWebhookの配送履歴を新しい順にlimit件まで返す
+	ListDeliveriesBySubscription(ctx context.Context, subscriptionID string, limit int) ([]model.WebhookDelivery, error)
+}
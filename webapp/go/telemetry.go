@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// グローバルのプロバイダーは後から設定されたものに委譲されるので、起動前に作っておいてよい
+var (
+	tracer = otel.Tracer(telemetry.InstrumentationName)
+	meter  = otel.Meter(telemetry.InstrumentationName)
+
+	rideStatusCounter = must(meter.Int64Counter("isuride.ride.status_changes",
+		metric.WithDescription("ライドの状態の変更回数"),
+	))
+	matchLatencyHistogram = must(meter.Float64Histogram("isuride.ride.match_latency",
+		metric.WithDescription("ライドが作成されてから椅子が割り当てられるまでの時間"),
+		metric.WithUnit("s"),
+	))
+	paymentRetryCounter = must(meter.Int64Counter("isuride.payment.retries",
+		metric.WithDescription("決済マイクロサービスへのリクエストのリトライ回数"),
+	))
+)
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// ライドの状態の変更をメトリクスと監査ログに記録する。ロールバックされた変更を数えないよう、コミットした後に呼ぶ
+func recordRideStatus(ctx context.Context, rideID, status string) {
+	rideStatusCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("status", status)))
+	audit(ctx, auditRideStatusChanged, slog.String("ride_id", rideID), slog.String("status", status))
+}
+
+// ライドに椅子を割り当てたことをメトリクスと監査ログに記録する
+func recordRideMatched(ctx context.Context, ride *Ride, chairID string) {
+	latency := time.Since(ride.CreatedAt)
+	matchLatencyHistogram.Record(ctx, latency.Seconds())
+	audit(ctx, auditRideMatched, slog.String("ride_id", ride.ID), slog.String("chair_id", chairID), slog.Duration("latency", latency))
+}
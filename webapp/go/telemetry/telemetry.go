@@ -0,0 +1,132 @@
+// Package telemetry はOpenTelemetryによるトレースとメトリクスの送信を設定する。
+// OTEL_EXPORTER_OTLP_ENDPOINT が設定されていればOTLPで送信し、そうでなければ標準出力に書き出すか何もしない。
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// InstrumentationName は計装したスパンとメトリクスのスコープ名
+const InstrumentationName = "github.com/yuta-otsubo/isucon-sutra/webapp/go"
+
+type Exporter string
+
+const (
+	// ExporterAuto は OTEL_EXPORTER_OTLP_ENDPOINT が設定されていればOTLPで送信し、そうでなければ何もしない
+	ExporterAuto Exporter = "auto"
+	// ExporterOTLP はOTLP/HTTPで送信する。送信先は OTEL_EXPORTER_OTLP_* 環境変数で指定する
+	ExporterOTLP Exporter = "otlp"
+	// ExporterStdout は標準出力に書き出す。ローカルでの確認用
+	ExporterStdout Exporter = "stdout"
+	// ExporterNone は何も送信しない
+	ExporterNone Exporter = "none"
+)
+
+func ParseExporter(s string) (Exporter, error) {
+	switch exporter := Exporter(s); exporter {
+	case ExporterAuto, ExporterOTLP, ExporterStdout, ExporterNone:
+		return exporter, nil
+	}
+	return "", fmt.Errorf("unknown exporter: %q (expected auto, otlp, stdout or none)", s)
+}
+
+// メトリクスを送信する間隔
+const metricInterval = 10 * time.Second
+
+// Setup はグローバルな TracerProvider と MeterProvider を設定し、終了時に未送信のデータを送り出す関数を返す
+func Setup(ctx context.Context, serviceName string, exporter Exporter) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if exporter == ExporterAuto {
+		exporter = ExporterNone
+		if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+			exporter = ExporterOTLP
+		}
+	}
+
+	var (
+		spanExporter   sdktrace.SpanExporter
+		metricExporter sdkmetric.Exporter
+	)
+	switch exporter {
+	case ExporterNone:
+		// グローバルのプロバイダーはデフォルトでno-opになっている
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		if spanExporter, err = otlptracehttp.New(ctx); err != nil {
+			return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		if metricExporter, err = otlpmetrichttp.New(ctx); err != nil {
+			return nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+		}
+	case ExporterStdout:
+		if spanExporter, err = stdouttrace.New(); err != nil {
+			return nil, fmt.Errorf("failed to create stdout trace exporter: %w", err)
+		}
+		if metricExporter, err = stdoutmetric.New(); err != nil {
+			return nil, fmt.Errorf("failed to create stdout metric exporter: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown exporter: %q", exporter)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(metricInterval))),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+
+	return func(ctx context.Context) error {
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}, nil
+}
+
+// OpenDB はクエリごとにスパンを作成し、コネクションプールの統計をメトリクスとして送信する *sql.DB を開く
+func OpenDB(driverName, dsn string) (*sql.DB, error) {
+	attrs := otelsql.WithAttributes(semconv.DBSystemMySQL)
+	db, err := otelsql.Open(driverName, dsn, attrs, otelsql.WithSpanOptions(otelsql.SpanOptions{
+		// 行の読み出しやコネクションのリセットごとのスパンは多すぎるので作らない
+		OmitRows:             true,
+		OmitConnResetSession: true,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if err := otelsql.RegisterDBStatsMetrics(db, attrs); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,147 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestProviders(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter, *sdkmetric.MeterProvider, *sdkmetric.ManualReader) {
+	t.Helper()
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() {
+		tp.Shutdown(context.Background())
+		mp.Shutdown(context.Background())
+	})
+	return tp, spans, mp, reader
+}
+
+func spanAttr(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestParseExporter(t *testing.T) {
+	for _, s := range []string{"auto", "otlp", "stdout", "none"} {
+		if _, err := ParseExporter(s); err != nil {
+			t.Errorf("ParseExporter(%q) = %v", s, err)
+		}
+	}
+	if _, err := ParseExporter("jaeger"); err == nil {
+		t.Error("ParseExporter(\"jaeger\") should fail")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tp, spans, mp, reader := newTestProviders(t)
+	middleware, err := NewMiddleware(tp, mp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := chi.NewRouter()
+	mux.Use(middleware)
+	mux.HandleFunc("GET /api/app/rides/{ride_id}", func(w http.ResponseWriter, r *http.Request) {
+		if !trace.SpanContextFromContext(r.Context()).IsValid() {
+			t.Error("handler context has no span")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	// 呼び出し元のトレースを引き継ぐ
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/app/rides/01J", nil)
+	propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(context.Background(), parent), propagation.HeaderCarrier(req.Header))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	stubs := spans.GetSpans()
+	if len(stubs) != 1 {
+		t.Fatalf("got %d spans, want 1", len(stubs))
+	}
+	span := stubs[0]
+	if span.Name != "GET /api/app/rides/{ride_id}" {
+		t.Errorf("span name = %q", span.Name)
+	}
+	if span.Parent.TraceID() != parent.TraceID() {
+		t.Errorf("span trace ID = %s, want %s", span.Parent.TraceID(), parent.TraceID())
+	}
+	if got := spanAttr(span, "http.response.status_code").AsInt64(); got != http.StatusInternalServerError {
+		t.Errorf("status code attribute = %d", got)
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("span status = %v, want Error", span.Status.Code)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	hist := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64])
+	if len(hist.DataPoints) != 1 || hist.DataPoints[0].Count != 1 {
+		t.Fatalf("unexpected data points: %+v", hist.DataPoints)
+	}
+	if route, _ := hist.DataPoints[0].Attributes.Value("http.route"); route.AsString() != "/api/app/rides/{ride_id}" {
+		t.Errorf("route attribute = %q", route.AsString())
+	}
+}
+
+func TestTransport(t *testing.T) {
+	tp, spans, _, _ := newTestProviders(t)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{Base: http.DefaultTransport, TracerProvider: tp}}
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/payments", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	stubs := spans.GetSpans()
+	if len(stubs) != 1 {
+		t.Fatalf("got %d spans, want 1", len(stubs))
+	}
+	want := propagation.TraceContext{}
+	carrier := propagation.HeaderCarrier{}
+	want.Inject(trace.ContextWithSpanContext(context.Background(), stubs[0].SpanContext), carrier)
+	if traceparent != carrier.Get("traceparent") {
+		t.Errorf("traceparent = %q, want %q", traceparent, carrier.Get("traceparent"))
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Error("Transport modified the original request")
+	}
+}
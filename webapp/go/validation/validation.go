@@ -0,0 +1,275 @@
+// Package validation は openapi.yaml に基づいてリクエストとレスポンスを検証するミドルウェアを提供する。
+package validation
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+)
+
+//go:generate cp ../../openapi.yaml openapi.yaml
+
+// embeddedSpec は ../../openapi.yaml の写し。起動時に仕様のファイルが無くても検証できるように埋め込む
+//
+//go:embed openapi.yaml
+var embeddedSpec []byte
+
+type Mode string
+
+const (
+	// ModeOff は検証を行わない
+	ModeOff Mode = "off"
+	// ModeRequest はリクエストだけを検証する
+	ModeRequest Mode = "request"
+	// ModeStrict はレスポンスも検証し、仕様と異なるレスポンスを500エラーに置き換える。テストで利用する
+	ModeStrict Mode = "strict"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeOff, ModeRequest, ModeStrict:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown validation mode: %q (expected off, request or strict)", s)
+}
+
+type Validator struct {
+	router routers.Router
+	mode   Mode
+}
+
+// New はspecPathの仕様を読み込んでValidatorを作成する。specPathが空の場合は埋め込んだ仕様を使う
+func New(specPath string, mode Mode) (*Validator, error) {
+	if mode == ModeOff {
+		return &Validator{mode: mode}, nil
+	}
+
+	var doc *openapi3.T
+	var err error
+	if specPath == "" {
+		specPath = "embedded openapi.yaml"
+		doc, err = openapi3.NewLoader().LoadFromData(embeddedSpec)
+	} else {
+		doc, err = openapi3.NewLoader().LoadFromFile(specPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", specPath, err)
+	}
+	// 仕様のserversはローカル環境のURLなので、ホストを問わずパスだけで照合する
+	doc.Servers = openapi3.Servers{{URL: "/api"}}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid api specification %s: %w", specPath, err)
+	}
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Validator{router: router, mode: mode}, nil
+}
+
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	if v.mode == ModeOff {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := v.router.FindRoute(r)
+		if err != nil {
+			// 仕様に記載されていないエンドポイントは検証しない
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			apierror.Write(w, http.StatusBadRequest, &apierror.Error{
+				Status:  http.StatusBadRequest,
+				Code:    apierror.CodeValidationFailed,
+				Message: "request does not match the api specification",
+				Fields:  collectFieldErrors(err, ""),
+			})
+			return
+		}
+
+		// イベントストリームはレスポンスを保持すると届かなくなるので検証しない
+		if v.mode != ModeStrict || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &responseRecorder{w: w, header: http.Header{}}
+		next.ServeHTTP(rec, r)
+		if rec.streaming {
+			// Flushされたレスポンスは送信済みなので検証できない
+			return
+		}
+		if rec.statusCode == 0 {
+			rec.statusCode = http.StatusOK
+		}
+
+		responseInput := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rec.statusCode,
+			Header:                 rec.header,
+			Options:                &openapi3filter.Options{MultiError: true, IncludeResponseStatus: true},
+		}
+		responseInput.SetBodyBytes(rec.body.Bytes())
+		if err := openapi3filter.ValidateResponse(r.Context(), responseInput); err != nil {
+			// テストで原因を特定できるよう、違反した箇所もレスポンスに含める
+			apierror.Write(w, http.StatusInternalServerError, &apierror.Error{
+				Status:  http.StatusInternalServerError,
+				Code:    apierror.CodeInternal,
+				Message: "response does not match the api specification",
+				Fields:  collectFieldErrors(err, "response"),
+				Err:     fmt.Errorf("%s %s responded %d: %w", r.Method, r.URL.Path, rec.statusCode, err),
+			})
+			return
+		}
+		rec.flush(w)
+	})
+}
+
+// collectFieldErrors はkin-openapiの検証エラーを、違反した箇所ごとのエラーに変換する
+func collectFieldErrors(err error, in string) []apierror.FieldError {
+	if multi, ok := err.(openapi3.MultiError); ok {
+		fieldErrors := []apierror.FieldError{}
+		for _, e := range multi {
+			fieldErrors = append(fieldErrors, collectFieldErrors(e, in)...)
+		}
+		return fieldErrors
+	}
+
+	var requestError *openapi3filter.RequestError
+	if errors.As(err, &requestError) {
+		switch {
+		case requestError.Parameter != nil:
+			return prefixFieldErrors(collectFieldErrors(requestError.Err, requestError.Parameter.In), requestError.Parameter.Name, requestError.Error())
+		case requestError.RequestBody != nil:
+			if requestError.Err == nil {
+				return []apierror.FieldError{{In: "body", Message: requestError.Reason}}
+			}
+			return collectFieldErrors(requestError.Err, "body")
+		}
+	}
+
+	var responseError *openapi3filter.ResponseError
+	if errors.As(err, &responseError) && responseError.Err != nil {
+		return collectFieldErrors(responseError.Err, "response")
+	}
+
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		fieldErrors := []apierror.FieldError{}
+		for _, e := range multi {
+			fieldErrors = append(fieldErrors, collectFieldErrors(e, in)...)
+		}
+		return fieldErrors
+	}
+
+	var schemaError *openapi3.SchemaError
+	if errors.As(err, &schemaError) {
+		return []apierror.FieldError{{
+			In:      in,
+			Field:   strings.Join(schemaError.JSONPointer(), "."),
+			Message: schemaError.Reason,
+		}}
+	}
+
+	var parseError *openapi3filter.ParseError
+	if errors.As(err, &parseError) {
+		path := []string{}
+		for _, p := range parseError.Path() {
+			path = append(path, fmt.Sprint(p))
+		}
+		return []apierror.FieldError{{In: in, Field: strings.Join(path, "."), Message: parseError.Error()}}
+	}
+
+	return []apierror.FieldError{{In: in, Message: err.Error()}}
+}
+
+// パラメーターのエラーには、パラメーター名をフィールドのパスに含める
+func prefixFieldErrors(fieldErrors []apierror.FieldError, name, message string) []apierror.FieldError {
+	for i := range fieldErrors {
+		if fieldErrors[i].Field == "" {
+			fieldErrors[i].Field = name
+		} else {
+			fieldErrors[i].Field = name + "." + fieldErrors[i].Field
+		}
+		if fieldErrors[i].Message == "" {
+			fieldErrors[i].Message = message
+		}
+	}
+	return fieldErrors
+}
+
+// responseRecorder はレスポンスを検証するまでクライアントに返さずに保持する。
+// ハンドラーがFlushした場合は保持をやめ、以降の書き込みはそのままクライアントに返す
+type responseRecorder struct {
+	w          http.ResponseWriter
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+	streaming  bool
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	if r.streaming {
+		return r.w.Write(b)
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if !r.streaming {
+		if r.statusCode == 0 {
+			r.statusCode = http.StatusOK
+		}
+		r.streaming = true
+		r.flush(r.w)
+	}
+	_ = http.NewResponseController(r.w).Flush()
+}
+
+// Unwrap はhttp.ResponseControllerが元のResponseWriterを使えるようにする
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.w
+}
+
+func (r *responseRecorder) flush(w http.ResponseWriter) {
+	for key, values := range r.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(r.statusCode)
+	_, _ = io.Copy(w, &r.body)
+}
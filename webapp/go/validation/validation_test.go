@@ -0,0 +1,243 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/apierror"
+)
+
+const specPath = "../../openapi.yaml"
+
+func newTestHandler(t *testing.T, mode Mode, handler http.HandlerFunc) http.Handler {
+	t.Helper()
+	v, err := New("", mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.Middleware(handler)
+}
+
+func serve(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+type errorResponse struct {
+	Code   string                `json:"code"`
+	Errors []apierror.FieldError `json:"errors"`
+}
+
+func decodeErrors(t *testing.T, rec *httptest.ResponseRecorder) errorResponse {
+	t.Helper()
+	res := errorResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode error response %q: %v", rec.Body.String(), err)
+	}
+	return res
+}
+
+func hasFieldError(res errorResponse, in, field string) bool {
+	for _, e := range res.Errors {
+		if e.In == in && e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func noContent(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestMiddleware_Request(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantIn     string
+		wantField  string
+	}{
+		{name: "valid coordinate", method: http.MethodPost, path: "/api/chair/coordinate", body: `{"latitude":1,"longitude":2}`, wantStatus: http.StatusNoContent},
+		{name: "missing longitude", method: http.MethodPost, path: "/api/chair/coordinate", body: `{"latitude":1}`, wantStatus: http.StatusBadRequest, wantIn: "body", wantField: "longitude"},
+		{name: "coordinate is not an integer", method: http.MethodPost, path: "/api/chair/coordinate", body: `{"latitude":"north","longitude":2}`, wantStatus: http.StatusBadRequest, wantIn: "body", wantField: "latitude"},
+		{name: "evaluation out of range", method: http.MethodPost, path: "/api/app/rides/01JDFEDF00B09BNMV8MP0RB34G/evaluation", body: `{"evaluation":6}`, wantStatus: http.StatusBadRequest, wantIn: "body", wantField: "evaluation"},
+		{name: "nested coordinate", method: http.MethodPost, path: "/api/app/rides", body: `{"pickup_coordinate":{"latitude":0,"longitude":0},"destination_coordinate":{"latitude":0}}`, wantStatus: http.StatusBadRequest, wantIn: "body", wantField: "destination_coordinate.longitude"},
+		{name: "invalid query parameter", method: http.MethodGet, path: "/api/owner/sales?since=yesterday", wantStatus: http.StatusBadRequest, wantIn: "query", wantField: "since"},
+		{name: "undocumented endpoint", method: http.MethodPost, path: "/api/unknown", body: `{}`, wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestHandler(t, ModeRequest, noContent)
+			rec := serve(handler, tt.method, tt.path, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantField == "" {
+				return
+			}
+			res := decodeErrors(t, rec)
+			if res.Code != string(apierror.CodeValidationFailed) {
+				t.Errorf("code = %q, want %q", res.Code, apierror.CodeValidationFailed)
+			}
+			if !hasFieldError(res, tt.wantIn, tt.wantField) {
+				t.Errorf("errors = %+v, want %s.%s", res.Errors, tt.wantIn, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestMiddleware_RequestBodyIsReadable(t *testing.T) {
+	handler := newTestHandler(t, ModeRequest, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]int{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["latitude"] != 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if rec := serve(handler, http.MethodPost, "/api/chair/coordinate", `{"latitude":1,"longitude":2}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
+
+func TestMiddleware_StrictResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       Mode
+		handler    http.HandlerFunc
+		wantStatus int
+	}{
+		{
+			name: "valid response",
+			mode: ModeStrict,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json;charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`{"recorded_at":1733560208672}`))
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "missing required field",
+			mode: ModeStrict,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json;charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`{}`))
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "responses are not validated in request mode",
+			mode: ModeRequest,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json;charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`{}`))
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestHandler(t, tt.mode, tt.handler)
+			rec := serve(handler, http.MethodPost, "/api/chair/coordinate", `{"latitude":1,"longitude":2}`)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code == http.StatusInternalServerError && !hasFieldError(decodeErrors(t, rec), "response", "recorded_at") {
+				t.Errorf("body = %s, want error for response.recorded_at", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, s := range []string{"off", "request", "strict"} {
+		if _, err := ParseMode(s); err != nil {
+			t.Errorf("ParseMode(%q) = %v", s, err)
+		}
+	}
+	if _, err := ParseMode("on"); err == nil {
+		t.Error("ParseMode(\"on\") should fail")
+	}
+}
+
+func TestMiddleware_MultipleErrors(t *testing.T) {
+	handler := newTestHandler(t, ModeRequest, noContent)
+	rec := serve(handler, http.MethodPost, "/api/app/rides", `{"pickup_coordinate":{"latitude":"a","longitude":0},"destination_coordinate":{"latitude":0}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	res := decodeErrors(t, rec)
+	for _, field := range []string{"pickup_coordinate.latitude", "destination_coordinate.longitude"} {
+		if !hasFieldError(res, "body", field) {
+			t.Errorf("errors = %+v, want body.%s", res.Errors, field)
+		}
+	}
+}
+
+func TestEmbeddedSpecIsUpToDate(t *testing.T) {
+	spec, err := os.ReadFile(specPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(spec, embeddedSpec) {
+		t.Errorf("embedded openapi.yaml differs from %s; run go generate ./validation", specPath)
+	}
+}
+
+func TestNew_SpecPath(t *testing.T) {
+	if _, err := New(specPath, ModeRequest); err != nil {
+		t.Errorf("New(%q) = %v, want nil", specPath, err)
+	}
+	if _, err := New("no-such-file.yaml", ModeRequest); err == nil {
+		t.Error("New with a missing spec file should fail")
+	}
+}
+
+func TestMiddleware_StrictStreaming(t *testing.T) {
+	invalidBody := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json;charset=utf-8")
+		w.Write([]byte(`{}`))
+	}
+
+	t.Run("flushed response is passed through", func(t *testing.T) {
+		handler := newTestHandler(t, ModeStrict, func(w http.ResponseWriter, r *http.Request) {
+			invalidBody(w, r)
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("failed to flush: %v", err)
+			}
+			w.Write([]byte("\n"))
+		})
+		rec := serve(handler, http.MethodPost, "/api/chair/coordinate", `{"latitude":1,"longitude":2}`)
+		if rec.Code != http.StatusOK || rec.Body.String() != "{}\n" || !rec.Flushed {
+			t.Errorf("status = %d, body = %q, flushed = %t, want flushed 200", rec.Code, rec.Body.String(), rec.Flushed)
+		}
+	})
+
+	t.Run("event stream is not validated", func(t *testing.T) {
+		handler := newTestHandler(t, ModeStrict, invalidBody)
+		req := httptest.NewRequest(http.MethodPost, "/api/chair/coordinate", strings.NewReader(`{"latitude":1,"longitude":2}`))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		req.Header.Set("Accept", "text/event-stream")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d (body: %s)", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/config"
+	"github.com/yuta-otsubo/isucon-sutra/webapp/go/store"
+)
+
+const (
+	webhookEventRideCompleted    = "ride.completed"
+	webhookEventPaymentFailed    = "payment.failed"
+	webhookEventChairDeactivated = "chair.deactivated"
+)
+
+var webhookEventTypes = []string{
+	webhookEventRideCompleted,
+	webhookEventPaymentFailed,
+	webhookEventChairDeactivated,
+}
+
+// 1回の送信で確認する未配送のWebhookの数
+const webhookDeliveryBatchSize = 100
+
+// 同時に送信するWebhookの数
+const webhookDeliveryConcurrency = 8
+
+// 配送履歴に残すエラーの長さ
+const maxWebhookErrorLength = 1024
+
+// 起動時に設定の値で上書きされる
+var webhookConfig = config.Default().Webhook
+
+// 通知先は外部のサーバーなので、社内決済マイクロサービスと違ってトレースコンテキストを伝搬しない
+var webhookClient = newWebhookClient()
+
+// isWebhookAddressAllowed は通知先として接続してよいアドレスかを返す。
+// テストではループバックで起動した受信サーバーに送るために差し替える
+var isWebhookAddressAllowed = isPublicAddress
+
+// isPublicAddress はインターネット上のアドレスかを返す。
+// メタデータサーバー(169.254.169.254)のようなリンクローカルや、ループバック、プライベートネットワークのアドレスは許可しない
+func isPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsUnspecified() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// キャリアグレードNAT用のアドレス(RFC 6598)
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// newWebhookClient は通知先に送信するクライアントを作る。
+// オーナーが登録したURLから内部のサーバーに送らせないよう、名前解決した後の接続先のアドレスを確認し、リダイレクトにも従わない
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isWebhookAddressAllowed(addrPort.Addr()) {
+				return fmt.Errorf("webhook destination %s is not allowed", addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// プロキシを経由すると接続先のアドレスを確認できない
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		// リダイレクトの応答はそのまま返し、2xx以外として失敗にする
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookEvent は通知先に送るJSON
+type webhookEvent struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	CreatedAt int64  `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+type webhookRideCompletedData struct {
+	RideID  string `json:"ride_id"`
+	ChairID string `json:"chair_id"`
+	// 運営が完了させたライドは決済しないので含めない
+	Fare *int `json:"fare,omitempty"`
+}
+
+type webhookPaymentFailedData struct {
+	RideID  string `json:"ride_id"`
+	ChairID string `json:"chair_id"`
+	Amount  int    `json:"amount"`
+}
+
+type webhookChairDeactivatedData struct {
+	ChairID string `json:"chair_id"`
+	// CHAIR: 椅子が停止した、SCHEDULE: 稼働時間外になった、BREAK: 休憩に入った
+	Reason string `json:"reason"`
+}
+
+// publishWebhookEvent はオーナーのWebhookのうち、イベントを購読しているものに配送を登録する。
+// 送信はrunWebhookWorkerが行うので、トランザクションの中で呼べばコミットされたイベントだけが届く
+func publishWebhookEvent(ctx context.Context, q store.Queries, ownerID, eventType string, data any) error {
+	subscriptions, err := q.Webhooks().ListSubscriptionsByOwner(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+
+	event := &webhookEvent{ID: ulid.Make().String(), Type: eventType, Data: data}
+	payload := []byte(nil)
+	for _, subscription := range subscriptions {
+		if !subscription.Subscribes(eventType) {
+			continue
+		}
+		if payload == nil {
+			now, err := q.Now(ctx)
+			if err != nil {
+				return err
+			}
+			event.CreatedAt = now.UnixMilli()
+			if payload, err = json.Marshal(event); err != nil {
+				return err
+			}
+		}
+		if err := q.Webhooks().CreateDelivery(ctx, &WebhookDelivery{
+			ID:             ulid.Make().String(),
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      eventType,
+			Payload:        string(payload),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishChairWebhookEvent は椅子のオーナーにイベントを通知する
+func publishChairWebhookEvent(ctx context.Context, q store.Queries, chairID, eventType string, data any) error {
+	chair, err := q.Chairs().Get(ctx, chairID)
+	if err != nil {
+		return err
+	}
+	return publishWebhookEvent(ctx, q, chair.OwnerID, eventType, data)
+}
+
+// signWebhookPayload は送信日時と本文をWebhookの秘密鍵で署名する。
+// 受信側は X-Isuride-Timestamp と本文を "." でつないだ文字列のHMAC-SHA256を X-Isuride-Signature と比べて検証する
+func signWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay は送信にattempts回失敗した配送を次に送るまでの間隔を返す
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookConfig.RetryInterval
+	for i := 1; i < attempts && delay < webhookConfig.MaxRetryInterval; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookConfig.MaxRetryInterval)
+}
+
+// 未配送のWebhookを定期的に送信する
+func runWebhookWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := deliverDueWebhooks(ctx); err != nil {
+				slog.Error("failed to deliver webhooks", "error", err)
+			}
+		}
+	}
+}
+
+// deliverDueWebhooks は送信日時を過ぎた未配送のWebhookを送信し、結果を配送履歴に記録する。
+// 応答の遅い通知先に他の配送が待たされないよう、webhookDeliveryConcurrency件ずつ並行して送信する
+func deliverDueWebhooks(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "deliverDueWebhooks")
+	defer span.End()
+
+	// 送信日時はデータベースの時刻で記録しているので、データベースの時刻で比べる
+	now, err := dataStore.Now(ctx)
+	if err != nil {
+		return err
+	}
+	deliveries, err := dataStore.Webhooks().ListDueDeliveries(ctx, now, webhookDeliveryBatchSize)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(deliveries))
+	sem := make(chan struct{}, webhookDeliveryConcurrency)
+	wg := &sync.WaitGroup{}
+	for i := range deliveries {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = deliverWebhook(ctx, &deliveries[i])
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// deliverWebhook は配送を1回送信し、結果を配送履歴に記録する
+func deliverWebhook(ctx context.Context, delivery *WebhookDelivery) error {
+	// 送信中に他のインスタンスが同じ配送を送らないよう、送信を終えるまでの間だけ送信日時を先に延ばしておく。
+	// 前の配送の送信に時間がかかっていても期限が短くならないよう、確保する時点の時刻から延ばす
+	claimedAt, err := dataStore.Now(ctx)
+	if err != nil {
+		return err
+	}
+	claimed, err := dataStore.Webhooks().ClaimDelivery(ctx, delivery.ID, claimedAt, claimedAt.Add(2*webhookConfig.Timeout))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+	subscription, err := dataStore.Webhooks().GetSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		// 送信を始める前にWebhookが削除された
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	statusCode, sendErr := sendWebhook(ctx, subscription, delivery)
+	// 再送の間隔は送信を終えた時点から数える
+	sentAt, err := dataStore.Now(ctx)
+	if err != nil {
+		return err
+	}
+	recordWebhookAttempt(delivery, statusCode, sendErr, sentAt)
+	if err := dataStore.Webhooks().RecordAttempt(ctx, delivery); err != nil {
+		return err
+	}
+	if sendErr != nil {
+		slog.Warn("failed to deliver webhook", "delivery_id", delivery.ID, "subscription_id", subscription.ID, "attempts", delivery.Attempts, "status", delivery.Status, "error", sendErr)
+	}
+	return nil
+}
+
+// recordWebhookAttempt は送信した結果から配送状態と次の送信日時を決める
+func recordWebhookAttempt(delivery *WebhookDelivery, statusCode int, sendErr error, now time.Time) {
+	delivery.Attempts++
+	delivery.LastStatusCode = nil
+	delivery.LastError = nil
+	if statusCode != 0 {
+		delivery.LastStatusCode = &statusCode
+	}
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = "SUCCEEDED"
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= webhookConfig.MaxAttempts:
+		delivery.Status = "FAILED"
+		delivery.NextAttemptAt = nil
+	default:
+		next := now.Add(webhookRetryDelay(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+	if sendErr != nil {
+		message := sendErr.Error()
+		if len(message) > maxWebhookErrorLength {
+			message = message[:maxWebhookErrorLength]
+		}
+		delivery.LastError = &message
+	}
+}
+
+// sendWebhook は配送を1回送信する。通知先が2xx以外を返した場合もエラーとし、ステータスコードをあわせて返す
+func sendWebhook(ctx context.Context, subscription *WebhookSubscription, delivery *WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookConfig.Timeout)
+	defer cancel()
+
+	payload := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Isuride-Event", delivery.EventType)
+	req.Header.Set("X-Isuride-Delivery", delivery.ID)
+	req.Header.Set("X-Isuride-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Isuride-Signature", signWebhookPayload(subscription.Secret, timestamp, payload))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// コネクションを再利用できるよう本文を読み捨てる
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status code (%d)", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
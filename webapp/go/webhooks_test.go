@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setWebhookConfig はテストの間だけWebhookの設定を差し替える
+func setWebhookConfig(t *testing.T, maxAttempts int, retryInterval time.Duration) {
+	t.Helper()
+	prev := webhookConfig
+	webhookConfig.MaxAttempts = maxAttempts
+	webhookConfig.RetryInterval = retryInterval
+	webhookConfig.MaxRetryInterval = max(retryInterval, prev.MaxRetryInterval)
+	t.Cleanup(func() { webhookConfig = prev })
+}
+
+func allowLoopbackWebhooks(t *testing.T) {
+	t.Helper()
+	prev := isWebhookAddressAllowed
+	isWebhookAddressAllowed = func(addr netip.Addr) bool { return addr.IsLoopback() || prev(addr) }
+	t.Cleanup(func() { isWebhookAddressAllowed = prev })
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver は受け取ったWebhookを記録し、statusesの順にステータスコードを返すサーバーを起動する。
+// サーバーはループバックで待ち受けるので、テストの間だけ通知先にループバックを許可する
+func newWebhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, func() []receivedWebhook) {
+	t.Helper()
+	allowLoopbackWebhooks(t)
+	received := make(chan receivedWebhook, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header.Clone(), body: body}
+		status := http.StatusNoContent
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []receivedWebhook {
+		webhooks := []receivedWebhook{}
+		for len(received) > 0 {
+			webhooks = append(webhooks, <-received)
+		}
+		return webhooks
+	}
+}
+
+func TestDeliverDueWebhooks(t *testing.T) {
+	m := newTestStore(t)
+	ctx := context.Background()
+	// 再送までの間隔を0にして、すぐに再送させる
+	setWebhookConfig(t, 3, 0)
+	addTestOwner(t, m, "owner1", "chair1")
+	server, received := newWebhookReceiver(t, http.StatusInternalServerError)
+	subscription := &WebhookSubscription{ID: "webhook1", OwnerID: "owner1", URL: server.URL, Secret: "whsec_test", EventTypes: webhookEventRideCompleted}
+	if err := m.Webhooks().CreateSubscription(ctx, subscription); err != nil {
+		t.Fatal(err)
+	}
+
+	fare := 1000
+	if err := publishChairWebhookEvent(ctx, m, "chair1", webhookEventRideCompleted, &webhookRideCompletedData{RideID: "ride1", ChairID: "chair1", Fare: &fare}); err != nil {
+		t.Fatal(err)
+	}
+	// 購読していないイベントは配送しない
+	if err := publishChairWebhookEvent(ctx, m, "chair1", webhookEventPaymentFailed, &webhookPaymentFailedData{RideID: "ride1", ChairID: "chair1", Amount: fare}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deliverDueWebhooks(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := m.Webhooks().ListDeliveriesBySubscription(ctx, subscription.ID, webhookDeliveriesMaxLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("deliveries = %+v, want only ride.completed", deliveries)
+	}
+	if d := deliveries[0]; d.Status != "PENDING" || d.Attempts != 1 || d.LastStatusCode == nil || *d.LastStatusCode != http.StatusInternalServerError || d.LastError == nil {
+		t.Errorf("delivery = %+v, want pending with the failed attempt recorded", d)
+	}
+
+	if err := deliverDueWebhooks(ctx); err != nil {
+		t.Fatal(err)
+	}
+	delivered, err := m.Webhooks().ListDeliveriesBySubscription(ctx, subscription.ID, webhookDeliveriesMaxLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := delivered[0]; d.Status != "SUCCEEDED" || d.Attempts != 2 || d.NextAttemptAt != nil || d.LastError != nil {
+		t.Errorf("delivery = %+v, want succeeded on the second attempt", d)
+	}
+
+	webhooks := received()
+	if len(webhooks) != 2 {
+		t.Fatalf("received %d webhooks, want 2", len(webhooks))
+	}
+	for _, webhook := range webhooks {
+		timestamp, err := strconv.ParseInt(webhook.header.Get("X-Isuride-Timestamp"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := webhook.header.Get("X-Isuride-Signature"), signWebhookPayload(subscription.Secret, timestamp, webhook.body); got != want {
+			t.Errorf("signature = %s, want %s", got, want)
+		}
+		if webhook.header.Get("X-Isuride-Event") != webhookEventRideCompleted || webhook.header.Get("X-Isuride-Delivery") != deliveries[0].ID {
+			t.Errorf("headers = %v, want event and delivery id", webhook.header)
+		}
+	}
+	event := struct {
+		Type string                   `json:"type"`
+		Data webhookRideCompletedData `json:"data"`
+	}{}
+	if err := json.Unmarshal(webhooks[0].body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != webhookEventRideCompleted || event.Data.RideID != "ride1" || event.Data.Fare == nil || *event.Data.Fare != fare {
+		t.Errorf("event = %+v, want ride.completed of ride1", event)
+	}
+}
+
+func TestDeliverDueWebhooksGivesUp(t *testing.T) {
+	m := newTestStore(t)
+	ctx := context.Background()
+	setWebhookConfig(t, 2, 0)
+	addTestOwner(t, m, "owner1", "chair1")
+	server, _ := newWebhookReceiver(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	if err := m.Webhooks().CreateSubscription(ctx, &WebhookSubscription{ID: "webhook1", OwnerID: "owner1", URL: server.URL, Secret: "whsec_test", EventTypes: webhookEventChairDeactivated}); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishChairWebhookEvent(ctx, m, "chair1", webhookEventChairDeactivated, &webhookChairDeactivatedData{ChairID: "chair1", Reason: "BREAK"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if err := deliverDueWebhooks(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliveries, err := m.Webhooks().ListDeliveriesBySubscription(ctx, "webhook1", webhookDeliveriesMaxLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := deliveries[0]; d.Status != "FAILED" || d.Attempts != 2 || d.NextAttemptAt != nil {
+		t.Errorf("delivery = %+v, want failed after max attempts", d)
+	}
+}
+
+func TestDeliverDueWebhooksInParallel(t *testing.T) {
+	m := newTestStore(t)
+	ctx := context.Background()
+	allowLoopbackWebhooks(t)
+	addTestOwner(t, m, "owner1", "chair1")
+	// 2件が同時に届くまで応答を返さず、同時に届かなければ失敗にする
+	arrived := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		deadline := time.After(time.Second)
+		for len(arrived) < 2 {
+			select {
+			case <-deadline:
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	if err := m.Webhooks().CreateSubscription(ctx, &WebhookSubscription{ID: "webhook1", OwnerID: "owner1", URL: server.URL, Secret: "whsec_test", EventTypes: webhookEventChairDeactivated}); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := publishChairWebhookEvent(ctx, m, "chair1", webhookEventChairDeactivated, &webhookChairDeactivatedData{ChairID: "chair1", Reason: "CHAIR"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := deliverDueWebhooks(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := m.Webhooks().ListDeliveriesBySubscription(ctx, "webhook1", webhookDeliveriesMaxLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range deliveries {
+		if d.Status != "SUCCEEDED" {
+			t.Errorf("delivery = %+v, want succeeded when sent in parallel", d)
+		}
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	prev := webhookConfig
+	t.Cleanup(func() { webhookConfig = prev })
+	webhookConfig.RetryInterval = 10 * time.Second
+	webhookConfig.MaxRetryInterval = time.Minute
+
+	for attempts, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		4:  time.Minute,
+		10: time.Minute,
+	} {
+		if got := webhookRetryDelay(attempts); got != want {
+			t.Errorf("webhookRetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestSendWebhookRejectsPrivateAddresses(t *testing.T) {
+	var received atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Store(true)
+	}))
+	t.Cleanup(server.Close)
+
+	subscription := &WebhookSubscription{ID: "webhook1", URL: server.URL, Secret: "whsec_test"}
+	delivery := &WebhookDelivery{ID: "delivery1", EventType: webhookEventRideCompleted, Payload: "{}"}
+	if _, err := sendWebhook(context.Background(), subscription, delivery); err == nil {
+		t.Error("sendWebhook to a loopback address succeeded, want error")
+	}
+	if received.Load() {
+		t.Error("loopback server received the webhook")
+	}
+}
+
+func TestSendWebhookDoesNotFollowRedirects(t *testing.T) {
+	allowLoopbackWebhooks(t)
+	var redirected atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		redirected.Store(true)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	subscription := &WebhookSubscription{ID: "webhook1", URL: server.URL + "/webhook", Secret: "whsec_test"}
+	delivery := &WebhookDelivery{ID: "delivery1", EventType: webhookEventRideCompleted, Payload: "{}"}
+	statusCode, err := sendWebhook(context.Background(), subscription, delivery)
+	if err == nil || statusCode != http.StatusTemporaryRedirect {
+		t.Errorf("sendWebhook = (%d, %v), want the redirect to be reported as an error", statusCode, err)
+	}
+	if redirected.Load() {
+		t.Error("webhook followed the redirect")
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":          true,
+		"2606:2800:220:1::248":   true,
+		"127.0.0.1":              false,
+		"::1":                    false,
+		"0.0.0.0":                false,
+		"10.0.0.1":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false,
+		"100.64.0.1":             false,
+		"fe80::1":                false,
+		"fd00::1":                false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:169.254.169.254": false,
+	} {
+		if got := isPublicAddress(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublicAddress(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
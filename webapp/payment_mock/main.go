@@ -45,12 +45,7 @@ func handlePostPayments(w http.ResponseWriter, r *http.Request) {
 
 	// モックサーバーは任意のトークンを受け付けて、決済を記録する
 	dataLock.Lock()
-	arr, ok := data[token]
-	if !ok {
-		arr = []int{}
-		data[token] = arr
-	}
-	arr = append(arr, req.Amount)
+	data[token] = append(data[token], req.Amount)
 	dataLock.Unlock()
 
 	slog.Info("決済完了", slog.String("token", token), slog.Int("amount", req.Amount))
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPayments(t *testing.T) {
+	t.Cleanup(func() { data = map[string][]int{} })
+
+	post := func(token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handlePostPayments(rec, req)
+		return rec.Code
+	}
+	get := func(token string) []ResponsePayment {
+		req := httptest.NewRequest(http.MethodGet, "/payments", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handleGetPayments(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /payments status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		res := []ResponsePayment{}
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, body := range []string{`{"amount":1000}`, `{"amount":2500}`} {
+		if code := post("token1", body); code != http.StatusNoContent {
+			t.Fatalf("POST /payments status = %d, want %d", code, http.StatusNoContent)
+		}
+	}
+	if code := post("token1", `{"amount":0}`); code != http.StatusBadRequest {
+		t.Errorf("invalid amount: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// webappは決済のリトライ前に決済済みかをこの一覧で確かめるので、記録した決済がすべて返る必要がある
+	payments := get("token1")
+	if len(payments) != 2 || payments[0].Amount != 1000 || payments[1].Amount != 2500 {
+		t.Errorf("payments = %+v, want 1000 and 2500", payments)
+	}
+	if payments := get("token2"); len(payments) != 0 {
+		t.Errorf("payments of another token = %+v, want none", payments)
+	}
+}
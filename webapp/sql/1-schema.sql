@@ -97,12 +97,12 @@ CREATE TABLE rides
 DROP TABLE IF EXISTS ride_statuses;
 CREATE TABLE ride_statuses
 (
-  id              VARCHAR(26)                                                                NOT NULL,
-  ride_id VARCHAR(26)                                                                        NOT NULL COMMENT 'ライドID',
-  status          ENUM ('MATCHING', 'ENROUTE', 'PICKUP', 'CARRYING', 'ARRIVED', 'COMPLETED') NOT NULL COMMENT '状態',
-  created_at      DATETIME(6)                                                                NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '状態変更日時',
-  app_sent_at     DATETIME(6)                                                                NULL COMMENT 'ユーザーへの状態通知日時',
-  chair_sent_at   DATETIME(6)                                                                NULL COMMENT '椅子への状態通知日時',
+  id              VARCHAR(26)                                                                            NOT NULL,
+  ride_id VARCHAR(26)                                                                                    NOT NULL COMMENT 'ライドID',
+  status          ENUM ('MATCHING', 'ENROUTE', 'PICKUP', 'CARRYING', 'ARRIVED', 'COMPLETED', 'CANCELED') NOT NULL COMMENT '状態',
+  created_at      DATETIME(6)                                                                            NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '状態変更日時',
+  app_sent_at     DATETIME(6)                                                                            NULL COMMENT 'ユーザーへの状態通知日時',
+  chair_sent_at   DATETIME(6)                                                                            NULL COMMENT '椅子への状態通知日時',
   PRIMARY KEY (id)
 )
   COMMENT = 'ライドステータスの変更履歴テーブル';
@@ -134,3 +134,189 @@ CREATE TABLE coupons
   PRIMARY KEY (user_id, code)
 )
   COMMENT 'クーポンテーブル';
+
+DROP TABLE IF EXISTS chair_schedules;
+CREATE TABLE chair_schedules
+(
+  id           VARCHAR(26) NOT NULL,
+  chair_id     VARCHAR(26) NOT NULL COMMENT '椅子ID',
+  day_of_week  TINYINT     NOT NULL COMMENT '曜日(0:日曜日 - 6:土曜日)',
+  start_minute INTEGER     NOT NULL COMMENT '稼働開始時刻(0時からの経過分)',
+  end_minute   INTEGER     NOT NULL COMMENT '稼働終了時刻(0時からの経過分)',
+  created_at   DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (id),
+  INDEX (chair_id)
+)
+  COMMENT = '椅子の稼働スケジュールテーブル';
+
+DROP TABLE IF EXISTS chair_activity_periods;
+CREATE TABLE chair_activity_periods
+(
+  id         VARCHAR(26)                           NOT NULL,
+  chair_id   VARCHAR(26)                           NOT NULL COMMENT '椅子ID',
+  started_at DATETIME(6)                           NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '配車受付開始日時',
+  ended_at   DATETIME(6)                           NULL COMMENT '配車受付終了日時',
+  end_reason ENUM ('CHAIR', 'SCHEDULE', 'BREAK')   NULL COMMENT '配車受付終了理由',
+  PRIMARY KEY (id),
+  INDEX (chair_id, started_at)
+)
+  COMMENT = '椅子の配車受付期間の履歴テーブル';
+
+DROP TABLE IF EXISTS regions;
+CREATE TABLE regions
+(
+  id                VARCHAR(26) NOT NULL COMMENT '地域ID',
+  name              VARCHAR(30) NOT NULL COMMENT '地域名',
+  min_latitude      INTEGER     NOT NULL COMMENT 'サービス提供範囲の最小経度',
+  max_latitude      INTEGER     NOT NULL COMMENT 'サービス提供範囲の最大経度',
+  min_longitude     INTEGER     NOT NULL COMMENT 'サービス提供範囲の最小緯度',
+  max_longitude     INTEGER     NOT NULL COMMENT 'サービス提供範囲の最大緯度',
+  polygon           TEXT        NULL COMMENT 'サービス提供範囲の多角形(座標のJSON配列)。NULLの場合は矩形',
+  initial_fare      INTEGER     NOT NULL COMMENT '初乗り運賃',
+  fare_per_distance INTEGER     NOT NULL COMMENT '距離あたりの運賃',
+  created_at        DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (id),
+  UNIQUE (name)
+)
+  COMMENT = 'サービス提供地域テーブル';
+
+DROP TABLE IF EXISTS chair_regions;
+CREATE TABLE chair_regions
+(
+  chair_id   VARCHAR(26) NOT NULL COMMENT '椅子ID',
+  region_id  VARCHAR(26) NOT NULL COMMENT '拠点とする地域ID',
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (chair_id)
+)
+  COMMENT = '椅子の拠点地域テーブル';
+
+DROP TABLE IF EXISTS chair_location_summaries;
+CREATE TABLE chair_location_summaries
+(
+  chair_id       VARCHAR(26) NOT NULL COMMENT '椅子ID',
+  total_distance INTEGER     NOT NULL COMMENT '削除済みの位置情報での総移動距離',
+  updated_at     DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6) COMMENT '更新日時',
+  PRIMARY KEY (chair_id)
+)
+  COMMENT = '保持期間を過ぎた椅子の位置情報の集計テーブル';
+
+DROP TABLE IF EXISTS ride_routes;
+CREATE TABLE ride_routes
+(
+  ride_id    VARCHAR(26) NOT NULL COMMENT 'ライドID',
+  chair_id   VARCHAR(26) NOT NULL COMMENT '椅子ID',
+  polyline   MEDIUMTEXT  NOT NULL COMMENT '移動経路(座標のJSON配列)',
+  distance   INTEGER     NOT NULL COMMENT '移動経路の距離',
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6) COMMENT '更新日時',
+  PRIMARY KEY (ride_id)
+)
+  COMMENT = '保持期間を過ぎた椅子の位置情報から作成したライドごとの移動経路テーブル';
+
+DROP TABLE IF EXISTS chair_movement_flags;
+CREATE TABLE chair_movement_flags
+(
+  id               VARCHAR(26)                NOT NULL,
+  chair_id         VARCHAR(26)                NOT NULL COMMENT '椅子ID',
+  ride_id          VARCHAR(26)                NULL COMMENT 'ライドID',
+  kind             ENUM ('TELEPORT', 'DETOUR') NOT NULL COMMENT '検知した不審な移動の種類',
+  distance         INTEGER                    NOT NULL COMMENT '実際の移動距離',
+  allowed_distance INTEGER                    NOT NULL COMMENT '許容される移動距離',
+  created_at       DATETIME(6)                NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '検知日時',
+  PRIMARY KEY (id),
+  INDEX (chair_id, created_at)
+)
+  COMMENT = '椅子の不審な移動の検知履歴テーブル';
+
+DROP TABLE IF EXISTS sessions;
+CREATE TABLE sessions
+(
+  token        VARCHAR(255)                   NOT NULL COMMENT 'アクセストークン',
+  role         ENUM ('app', 'owner', 'chair') NOT NULL COMMENT 'セッションの種類',
+  principal_id VARCHAR(26)                    NOT NULL COMMENT 'ユーザー・オーナー・椅子のID',
+  expires_at   DATETIME(6)                    NOT NULL COMMENT '有効期限',
+  revoked_at   DATETIME(6)                    NULL COMMENT '失効日時',
+  created_at   DATETIME(6)                    NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '発行日時',
+  PRIMARY KEY (token),
+  INDEX (role, principal_id)
+)
+  COMMENT = 'セッションテーブル';
+
+DROP TABLE IF EXISTS owner_api_keys;
+CREATE TABLE owner_api_keys
+(
+  id         VARCHAR(26)  NOT NULL COMMENT 'APIキーID',
+  owner_id   VARCHAR(26)  NOT NULL COMMENT 'オーナーID',
+  name       VARCHAR(50)  NOT NULL COMMENT 'APIキーの名前',
+  key_hash   VARCHAR(64)  NOT NULL COMMENT 'APIキーのSHA-256ハッシュ',
+  scopes     VARCHAR(255) NOT NULL COMMENT '許可されている操作(カンマ区切り)',
+  created_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '発行日時',
+  revoked_at DATETIME(6)  NULL COMMENT '失効日時',
+  PRIMARY KEY (id),
+  UNIQUE (key_hash),
+  INDEX (owner_id)
+)
+  COMMENT = 'オーナーの外部連携用APIキーテーブル';
+
+DROP TABLE IF EXISTS saved_places;
+CREATE TABLE saved_places
+(
+  id         VARCHAR(26)                     NOT NULL COMMENT '場所ID',
+  user_id    VARCHAR(26)                     NOT NULL COMMENT 'ユーザーID',
+  kind       ENUM ('home', 'work', 'custom') NOT NULL COMMENT '場所の種類',
+  label      VARCHAR(30)                     NOT NULL COMMENT '場所の名前(homeとworkは種類と同じ)',
+  latitude   INTEGER                         NOT NULL COMMENT '経度',
+  longitude  INTEGER                         NOT NULL COMMENT '緯度',
+  created_at DATETIME(6)                     NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  updated_at DATETIME(6)                     NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6) COMMENT '更新日時',
+  PRIMARY KEY (id),
+  UNIQUE (user_id, label)
+)
+  COMMENT = 'ユーザーが保存した場所テーブル';
+
+DROP TABLE IF EXISTS ride_chair_preferences;
+CREATE TABLE ride_chair_preferences
+(
+  ride_id    VARCHAR(26)   NOT NULL COMMENT 'ライドID',
+  min_speed  INTEGER       NOT NULL DEFAULT 0 COMMENT '椅子のモデルの最低速度(0は指定なし)',
+  models     VARCHAR(1024) NOT NULL DEFAULT '' COMMENT '希望する椅子のモデル(カンマ区切り、空は指定なし)',
+  min_rating DOUBLE        NULL COMMENT '椅子の平均評価の最低値',
+  created_at DATETIME(6)   NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (ride_id)
+)
+  COMMENT = 'ライドで希望する椅子の条件テーブル';
+
+DROP TABLE IF EXISTS webhook_subscriptions;
+CREATE TABLE webhook_subscriptions
+(
+  id          VARCHAR(26)   NOT NULL COMMENT 'WebhookID',
+  owner_id    VARCHAR(26)   NOT NULL COMMENT 'オーナーID',
+  url         VARCHAR(2048) NOT NULL COMMENT '通知先のURL',
+  secret      VARCHAR(64)   NOT NULL COMMENT '署名に使う秘密鍵',
+  event_types VARCHAR(255)  NOT NULL COMMENT '通知するイベントの種類(カンマ区切り)',
+  created_at  DATETIME(6)   NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (id),
+  INDEX (owner_id)
+)
+  COMMENT = 'オーナーのWebhookテーブル';
+
+DROP TABLE IF EXISTS webhook_deliveries;
+CREATE TABLE webhook_deliveries
+(
+  id               VARCHAR(26)                               NOT NULL COMMENT '配送ID',
+  subscription_id  VARCHAR(26)                               NOT NULL COMMENT 'WebhookID',
+  event_id         VARCHAR(26)                               NOT NULL COMMENT 'イベントID',
+  event_type       VARCHAR(50)                               NOT NULL COMMENT 'イベントの種類',
+  payload          TEXT                                      NOT NULL COMMENT '送信するJSON',
+  status           ENUM ('PENDING', 'SUCCEEDED', 'FAILED')   NOT NULL DEFAULT 'PENDING' COMMENT '配送状態',
+  attempts         INTEGER                                   NOT NULL DEFAULT 0 COMMENT '送信した回数',
+  next_attempt_at  DATETIME(6)                               NULL COMMENT '次に送信する日時(配送を終えた場合はNULL)',
+  last_status_code INTEGER                                   NULL COMMENT '最後の送信で返ったステータスコード',
+  last_error       VARCHAR(1024)                             NULL COMMENT '最後の送信のエラー',
+  created_at       DATETIME(6)                               NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  updated_at       DATETIME(6)                               NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6) COMMENT '更新日時',
+  PRIMARY KEY (id),
+  INDEX (subscription_id, created_at),
+  INDEX (status, next_attempt_at)
+)
+  COMMENT = 'Webhookの配送履歴テーブル';
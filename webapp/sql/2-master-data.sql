@@ -62,3 +62,22 @@ VALUES ('リラックスシート NEO', 2),
        ('タイタンフレーム ULTRA', 7),
        ('ヴァーチェア SUPREME', 7),
        ('オブシディアン PRIME', 7);
+
+INSERT INTO settings (name, value)
+VALUES ('chair_break_after_rides', '0'),
+       ('chair_break_after_distance', '0'),
+       ('chair_break_minutes', '10'),
+       ('ride_preference_timeout_seconds', '30');
+
+INSERT INTO regions (id, name, min_latitude, max_latitude, min_longitude, max_longitude, initial_fare, fare_per_distance)
+VALUES ('01JDFEDF00M9S346Q3D25VT4F5', 'チェアタウン', -50, 50, -50, 50, 500, 100),
+       ('01JDFEDF00V37E3S3E28JT97KB', 'コシカケシティ', 250, 350, 250, 350, 500, 100);
+
+INSERT INTO settings (name, value)
+VALUES ('chair_location_retention_seconds', '0');
+
+INSERT INTO settings (name, value)
+VALUES ('detour_flag_threshold_percent', '50');
+
+INSERT INTO settings (name, value)
+VALUES ('rate_limits', '{"POST /api/chair/coordinate": {"rate": 100, "burst": 200, "ip_rate": 10000, "ip_burst": 20000}, "POST /api/app/rides/estimated-fare": {"rate": 20, "burst": 40, "ip_rate": 2000, "ip_burst": 4000}}');
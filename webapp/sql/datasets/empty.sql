@@ -0,0 +1 @@
+-- 初期データを読み込まず、スキーマとマスターデータだけの状態にするデータセット